@@ -3,9 +3,11 @@ package app
 import (
 	"PatientManager/config"
 	"PatientManager/model"
+	"PatientManager/util/audit"
 	"os"
 	"time"
 
+	"github.com/google/uuid"
 	"go.uber.org/zap"
 	"gorm.io/driver/postgres"
 	"gorm.io/driver/sqlite"
@@ -19,6 +21,8 @@ func newDbConn() *gorm.DB {
 	db, err := gorm.Open(postgres.Open(config.AppConfig.DbConnection), &gorm.Config{
 		// NOTE: change LogMode if needed when debugging
 		Logger: NewGormZapLogger().LogMode(logger.Warn),
+		// unique violations are returned as gorm.ErrDuplicatedKey
+		TranslateError: true,
 	})
 	if err != nil {
 		zap.S().Errorf("failed to connect database err = %+v", err)
@@ -29,7 +33,8 @@ func newDbConn() *gorm.DB {
 
 func testDbConn() *gorm.DB {
 	db, err := gorm.Open(sqlite.Open("file:db?mode=memory&cache=shared"), &gorm.Config{
-		Logger: logger.Default.LogMode(logger.Silent),
+		Logger:         logger.Default.LogMode(logger.Silent),
+		TranslateError: true,
 	})
 	if err != nil {
 		zap.S().Errorf("failed to connect database err = %+v", err)
@@ -61,5 +66,54 @@ func dbSetup() {
 		zap.S().Panicf("Can't run AutoMigrate err = %+v", err)
 	}
 
-	Provide(dbConFunc)
+	if err = migrateMedicationLinks(db); err != nil {
+		zap.S().Panicf("Can't migrate medications of prescriptions err = %+v", err)
+	}
+
+	if err = audit.RegisterCallbacks(db); err != nil {
+		zap.S().Panicf("Can't register audit callbacks err = %+v", err)
+	}
+
+	// provide the configured connection, callbacks are registered on it
+	Provide(func() *gorm.DB { return db })
+}
+
+// migrateMedicationLinks moves medications linked to prescriptions by
+// medications.prescription_id, which let a medication be on only one
+// prescription, to prescription lines
+func migrateMedicationLinks(db *gorm.DB) error {
+	if !db.Migrator().HasColumn(&model.Medication{}, "prescription_id") {
+		return nil
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		var links []struct {
+			ID             uint
+			PrescriptionID uint
+		}
+		err := tx.Table("medications").
+			Select("id", "prescription_id").
+			Where("prescription_id IS NOT NULL").
+			Find(&links).Error
+		if err != nil {
+			return err
+		}
+
+		for _, link := range links {
+			line := model.PrescriptionLine{Uuid: uuid.New(), PrescriptionID: link.PrescriptionID, MedicationID: link.ID}
+			if err := tx.Create(&line).Error; err != nil {
+				return err
+			}
+		}
+		zap.S().Infof("Moved %d medications of prescriptions to prescription lines", len(links))
+
+		// the foreign key was created for the former Prescription.Medications
+		const constraint = "fk_prescriptions_medications"
+		if tx.Migrator().HasConstraint(&model.Medication{}, constraint) {
+			if err := tx.Migrator().DropConstraint(&model.Medication{}, constraint); err != nil {
+				return err
+			}
+		}
+		return tx.Migrator().DropColumn(&model.Medication{}, "prescription_id")
+	})
 }
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"PatientManager/app"
+	"PatientManager/service"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// runCommand runs a maintenance command instead of the server, e.g.
+//
+//	PatientManager rotate-keys
+//	PatientManager import-medications catalog.csv
+func runCommand(name string, args []string) error {
+	switch name {
+	case "rotate-keys":
+		return rotateKeys(args)
+	case "import-medications":
+		return importMedications(args)
+	default:
+		return fmt.Errorf("unknown command %q", name)
+	}
+}
+
+// rotateKeys generates a new encryption key and re-encrypts all data with
+// it, with -new-key=false it only re-encrypts data left by a failed rotation
+func rotateKeys(args []string) error {
+	flags := flag.NewFlagSet("rotate-keys", flag.ContinueOnError)
+	newKey := flags.Bool("new-key", true, "generate a new key before re-encrypting")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	var err error
+	app.Invoke(func(encryptionService service.IEncryptionService) {
+		err = encryptionService.Rotate(context.Background(), *newKey)
+	})
+	return err
+}
+
+// importMedications imports a medication catalog file, its format is taken
+// from the extension unless -format is set, see service.ReadMedicationCatalog
+func importMedications(args []string) error {
+	flags := flag.NewFlagSet("import-medications", flag.ContinueOnError)
+	format := flags.String("format", "", "catalog format, csv or json")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return errors.New("usage: import-medications [-format csv|json] <file>")
+	}
+
+	path := flags.Arg(0)
+	if *format == "" {
+		*format = strings.ToLower(strings.TrimPrefix(filepath.Ext(path), "."))
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	entries, err := service.ReadMedicationCatalog(file, *format)
+	if err != nil {
+		return fmt.Errorf("invalid catalog %s: %w", path, err)
+	}
+
+	app.Invoke(func(medicationService service.IMedicationService) {
+		_, err = medicationService.Import(entries)
+	})
+	return err
+}
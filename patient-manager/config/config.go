@@ -1,5 +1,7 @@
 package config
 
+import "time"
+
 const (
 	LOG_FILE                    = "ePrometna.log"
 	LOG_FILE_MAX_SIZE           = 2
@@ -16,14 +18,129 @@ type AppConfiguration struct {
 	Env                environment
 	Port               int
 	DbConnection       string
-	AccessKey          string
 	RefreshKey         string
+	SigningKeys        SigningKeys
+	Encryption         EncryptionConfig
 	MIOEndpoint        string
 	MIOAccessKeyID     string
 	MIOSecretAccessKey string
 	UseSSL             bool
+	Storage            StorageConfig
+	PasswordPolicy     PasswordPolicy
+	LoginPolicy        LoginPolicy
+	TwoFactor          TwoFactorPolicy
+	Notifier           string
+	NotifierFile       string
+	// DeletedRetention is how long soft deleted data is kept before it's
+	// permanently purged, 0 disables the purge
+	DeletedRetention time.Duration
+	Hl7              Hl7Config
+	Images           ImagePolicy
+}
+
+// StorageConfig selects the object storage of checkup images. The minio
+// driver uses the MINIO_* variables, local stores objects as files in
+// LocalDir and memory keeps them only until the program exits. Image rows
+// and objects are reconciled every ReconcileInterval, 0 disables it.
+// Presigned URLs of objects are valid for PresignExpiry. Objects are
+// encrypted with keys of EncryptionConfig unless Encrypt is false, their
+// presigned URLs are then served by this server instead of the driver.
+// URLs served by this server are signed with PresignKey, or a key derived
+// from RefreshKey when it's empty, all instances must share the key.
+type StorageConfig struct {
+	Driver            string
+	Bucket            string
+	LocalDir          string
+	ReconcileInterval time.Duration
+	PresignExpiry     time.Duration
+	Encrypt           bool
+	PresignKey        string
+}
+
+const (
+	StorageMinio  = "minio"
+	StorageLocal  = "local"
+	StorageMemory = "memory"
+)
+
+// ImagePolicy limits checkup image uploads. AllowedTypes are MIME types
+// detected from file content, sizes are in bytes. Thumbnails fit in a
+// ThumbnailSize x ThumbnailSize square.
+type ImagePolicy struct {
+	AllowedTypes   []string
+	MaxFileSize    int64
+	MaxRequestSize int64
+	ThumbnailSize  int
+}
+
+// Hl7Config configures ingestion of HL7 v2 messages. The MLLP listener is
+// started when MllpAddr is set, messages received over it are processed as
+// the user with MllpUser email. Application and Facility identify this
+// system in acknowledgements.
+type Hl7Config struct {
+	MllpAddr    string
+	MllpUser    string
+	Application string
+	Facility    string
+}
+
+// PasswordPolicy describes requirements that every new password must meet
+type PasswordPolicy struct {
+	MinLength     int
+	RequireUpper  bool
+	RequireLower  bool
+	RequireDigit  bool
+	RequireSymbol bool
+}
+
+// LoginPolicy limits failed logins. Every failure blocks further attempts of
+// the account and client IP with exponential backoff, when the number of
+// failures within FailureWindow reaches the limit they are locked out.
+type LoginPolicy struct {
+	MaxAccountFailures int
+	MaxIPFailures      int
+	FailureWindow      time.Duration
+	BackoffBase        time.Duration
+	BackoffMax         time.Duration
+	LockoutDuration    time.Duration
+}
+
+// TwoFactorPolicy lists roles (model.UserRole) that must use TOTP two-factor
+// authentication, users with other roles can enable it themselves
+type TwoFactorPolicy struct {
+	RequiredRoles []string
+	Issuer        string
+}
+
+// EncryptionConfig configures AES-256 keys that encrypt personal data of
+// patients and stored objects. Keys are files in KeyDir named <kid>.key, the
+// newest one encrypts and older ones only decrypt data that wasn't rotated
+// yet. The index.key file keys blind indexes and is never rotated.
+type EncryptionConfig struct {
+	KeyDir string
+}
+
+// SigningKeys configures asymmetric keys that sign access tokens. Keys are
+// PEM files in Dir named <kid>.pem, the newest one signs and a new one is
+// generated every RotationInterval (0 disables rotation). Replaced keys are
+// still accepted for GracePeriod and then removed.
+type SigningKeys struct {
+	Dir              string
+	Algorithm        string
+	RotationInterval time.Duration
+	GracePeriod      time.Duration
 }
 
+const (
+	AlgorithmRS256 = "RS256"
+	AlgorithmEdDSA = "EdDSA"
+)
+
+const (
+	NotifierLog  = "log"
+	NotifierFile = "file"
+)
+
 type environment = string
 
 const (
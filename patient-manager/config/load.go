@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"os"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
 	"go.uber.org/zap"
@@ -30,7 +32,6 @@ func loadData() error {
 	conf := &AppConfiguration{}
 	conf.Env = LoadEnv()
 	conf.DbConnection = loadString("DB_CONN")
-	conf.AccessKey = loadString("ACCESS_KEY")
 	conf.RefreshKey = loadString("REFRESH_KEY")
 	conf.Port = loadInt("PORT")
 
@@ -39,8 +40,90 @@ func loadData() error {
 	conf.MIOSecretAccessKey = loadString("MINIO_SECRET_ACCESS_KEY")
 	conf.UseSSL = loadBool("MINIO_USE_SSL")
 
-	if conf.AccessKey == "" {
-		return fmt.Errorf("ACCESS_KEY environment variable is required")
+	conf.Storage = StorageConfig{
+		Driver:   loadStringOr("STORAGE_DRIVER", StorageMinio),
+		Bucket:   loadStringOr("STORAGE_BUCKET", "checkup-images"),
+		LocalDir: loadStringOr("STORAGE_LOCAL_DIR", "./data/objects"),
+
+		ReconcileInterval: loadDurationOr("STORAGE_RECONCILE_INTERVAL", 6*time.Hour),
+		PresignExpiry:     loadDurationOr("STORAGE_PRESIGN_EXPIRY", 15*time.Minute),
+		Encrypt:           loadFlagOr("STORAGE_ENCRYPT", true),
+		PresignKey:        loadStringOr("STORAGE_PRESIGN_KEY", ""),
+	}
+	// the reconciler removes uploads that aren't completed within an hour
+	if conf.Storage.PresignExpiry <= 0 || conf.Storage.PresignExpiry > time.Hour {
+		return fmt.Errorf("STORAGE_PRESIGN_EXPIRY must be positive and at most 1h")
+	}
+	switch conf.Storage.Driver {
+	case StorageMinio:
+		if conf.MIOEndpoint == "" {
+			return fmt.Errorf("MINIO_ENDPOINT is required by the %s storage driver", StorageMinio)
+		}
+	case StorageLocal, StorageMemory:
+	default:
+		return fmt.Errorf("STORAGE_DRIVER must be %s, %s or %s", StorageMinio, StorageLocal, StorageMemory)
+	}
+
+	conf.PasswordPolicy = PasswordPolicy{
+		MinLength:     loadIntOr("PASSWORD_MIN_LENGTH", 8),
+		RequireUpper:  loadFlagOr("PASSWORD_REQUIRE_UPPER", true),
+		RequireLower:  loadFlagOr("PASSWORD_REQUIRE_LOWER", true),
+		RequireDigit:  loadFlagOr("PASSWORD_REQUIRE_DIGIT", true),
+		RequireSymbol: loadFlagOr("PASSWORD_REQUIRE_SYMBOL", false),
+	}
+
+	conf.LoginPolicy = LoginPolicy{
+		MaxAccountFailures: loadIntOr("LOGIN_MAX_ACCOUNT_FAILURES", 5),
+		MaxIPFailures:      loadIntOr("LOGIN_MAX_IP_FAILURES", 20),
+		FailureWindow:      loadDurationOr("LOGIN_FAILURE_WINDOW", 15*time.Minute),
+		BackoffBase:        loadDurationOr("LOGIN_BACKOFF_BASE", time.Second),
+		BackoffMax:         loadDurationOr("LOGIN_BACKOFF_MAX", time.Minute),
+		LockoutDuration:    loadDurationOr("LOGIN_LOCKOUT_DURATION", 15*time.Minute),
+	}
+
+	conf.TwoFactor = TwoFactorPolicy{
+		RequiredRoles: loadListOr("TOTP_REQUIRED_ROLES", nil),
+		Issuer:        loadStringOr("TOTP_ISSUER", "PatientManager"),
+	}
+
+	conf.SigningKeys = SigningKeys{
+		Dir:              loadStringOr("JWT_KEY_DIR", "./keys"),
+		Algorithm:        loadStringOr("JWT_ALGORITHM", AlgorithmRS256),
+		RotationInterval: loadDurationOr("JWT_KEY_ROTATION", 30*24*time.Hour),
+		GracePeriod:      loadDurationOr("JWT_KEY_GRACE", time.Hour),
+	}
+
+	conf.Encryption = EncryptionConfig{
+		KeyDir: loadStringOr("ENCRYPTION_KEY_DIR", "./keys/data"),
+	}
+
+	conf.Notifier = loadStringOr("NOTIFIER", NotifierLog)
+	conf.NotifierFile = loadStringOr("NOTIFIER_FILE", TMP_FOLDER+"/notifications.log")
+
+	conf.DeletedRetention = loadDurationOr("DELETED_RETENTION", 30*24*time.Hour)
+
+	conf.Images = ImagePolicy{
+		AllowedTypes:   loadListOr("IMAGE_ALLOWED_TYPES", []string{"image/jpeg", "image/png", "image/gif", "application/dicom"}),
+		MaxFileSize:    int64(loadIntOr("IMAGE_MAX_FILE_SIZE", 50<<20)),
+		MaxRequestSize: int64(loadIntOr("IMAGE_MAX_REQUEST_SIZE", 200<<20)),
+		ThumbnailSize:  loadIntOr("IMAGE_THUMBNAIL_SIZE", 256),
+	}
+	if conf.Images.MaxFileSize <= 0 || conf.Images.MaxRequestSize <= 0 || conf.Images.ThumbnailSize <= 0 {
+		return fmt.Errorf("IMAGE_MAX_FILE_SIZE, IMAGE_MAX_REQUEST_SIZE and IMAGE_THUMBNAIL_SIZE must be positive")
+	}
+
+	conf.Hl7 = Hl7Config{
+		MllpAddr:    loadStringOr("HL7_MLLP_ADDR", ""),
+		MllpUser:    loadStringOr("HL7_MLLP_USER", ""),
+		Application: loadStringOr("HL7_APPLICATION", "PatientManager"),
+		Facility:    loadStringOr("HL7_FACILITY", ""),
+	}
+	if conf.Hl7.MllpAddr != "" && conf.Hl7.MllpUser == "" {
+		return fmt.Errorf("HL7_MLLP_USER is required when HL7_MLLP_ADDR is set")
+	}
+
+	if conf.SigningKeys.Algorithm != AlgorithmRS256 && conf.SigningKeys.Algorithm != AlgorithmEdDSA {
+		return fmt.Errorf("JWT_ALGORITHM must be %s or %s", AlgorithmRS256, AlgorithmEdDSA)
 	}
 	if conf.RefreshKey == "" {
 		return fmt.Errorf("REFRESH_KEY environment variable is required")
@@ -64,6 +147,76 @@ func loadInt(name string) int {
 	return num
 }
 
+// loadIntOr returns def if the variable is not set
+func loadIntOr(name string, def int) int {
+	rez := os.Getenv(name)
+	if rez == "" {
+		return def
+	}
+	num, err := strconv.Atoi(rez)
+	if err != nil {
+		fmt.Printf("Failed to parse int %s = %s, will use default (%d)\n", name, rez, def)
+		return def
+	}
+
+	return num
+}
+
+// loadFlagOr parses a boolean variable ("true", "1", ...), returns def if it's not set
+func loadFlagOr(name string, def bool) bool {
+	rez := os.Getenv(name)
+	if rez == "" {
+		return def
+	}
+	flag, err := strconv.ParseBool(rez)
+	if err != nil {
+		fmt.Printf("Failed to parse bool %s = %s, will use default (%t)\n", name, rez, def)
+		return def
+	}
+
+	return flag
+}
+
+// loadDurationOr parses a duration variable ("15m", "1h", ...), returns def if it's not set
+func loadDurationOr(name string, def time.Duration) time.Duration {
+	rez := os.Getenv(name)
+	if rez == "" {
+		return def
+	}
+	duration, err := time.ParseDuration(rez)
+	if err != nil {
+		fmt.Printf("Failed to parse duration %s = %s, will use default (%s)\n", name, rez, def)
+		return def
+	}
+
+	return duration
+}
+
+// loadListOr parses a comma separated variable, returns def if it's not set
+func loadListOr(name string, def []string) []string {
+	rez := os.Getenv(name)
+	if rez == "" {
+		return def
+	}
+
+	var list []string
+	for _, item := range strings.Split(rez, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// loadStringOr returns def if the variable is not set
+func loadStringOr(name string, def string) string {
+	rez := os.Getenv(name)
+	if rez == "" {
+		return def
+	}
+	return rez
+}
+
 func loadString(name string) string {
 	rez := os.Getenv(name)
 	if rez == "" {
@@ -0,0 +1,40 @@
+package controller
+
+import (
+	"PatientManager/service"
+	"PatientManager/util/middleware"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+const actorKey = "actor"
+
+// getActor resolves the user making the request from claims stored by
+// middleware.Authorize, if it can't be resolved request is aborted with 401
+func getActor(c *gin.Context, accessService service.IAccessService) (*service.Actor, bool) {
+	if value, ok := c.Get(actorKey); ok {
+		if actor, ok := value.(*service.Actor); ok {
+			return actor, true
+		}
+	}
+
+	claims, err := middleware.GetClaims(c)
+	if err != nil {
+		zap.S().Errorf("Failed to get token claims, err = %+v", err)
+		abortWithStatus(c, http.StatusUnauthorized, err)
+		return nil, false
+	}
+
+	actor, err := accessService.ResolveActor(claims)
+	if err != nil {
+		zap.S().Errorf("Failed to resolve user from token claims, err = %+v", err)
+		abortWithStatus(c, http.StatusUnauthorized, err)
+		return nil, false
+	}
+	actor.ClientIP = c.ClientIP()
+
+	c.Set(actorKey, actor)
+	return actor, true
+}
@@ -0,0 +1,71 @@
+package controller
+
+import (
+	"PatientManager/app"
+	"PatientManager/dto"
+	"PatientManager/service"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+type AuditController struct {
+	auditService service.IAuditService
+	logger       *zap.SugaredLogger
+}
+
+func NewAuditController() *AuditController {
+	var controller *AuditController
+	app.Invoke(func(auditService service.IAuditService, logger *zap.SugaredLogger) {
+		controller = &AuditController{
+			auditService: auditService,
+			logger:       logger,
+		}
+	})
+	return controller
+}
+
+// RegisterEndpoints registers read-only audit routes, audit events can't be
+// created, changed or deleted through the API
+func (ac *AuditController) RegisterEndpoints(router *gin.RouterGroup) {
+	router.GET("/audit", ac.query)
+}
+
+// query godoc
+// @Summary		Query audit log
+// @Description	Returns audit events of access to clinical data, newest first
+// @Tags			audit
+// @Produce		json
+// @Param			actorUuid	query		string	false	"UUID of the user that made the request"
+// @Param			patientId	query		int		false	"ID of the patient the data belongs to"
+// @Param			from		query		string	false	"Start of time range (RFC3339)"
+// @Param			to			query		string	false	"End of time range (RFC3339)"
+// @Param			entityType	query		string	false	"Entity type"	Enums(patient, checkup, illness, prescription, user, ip)
+// @Param			action		query		string	false	"Action"		Enums(read, create, update, delete, lockout, unlock, export, erase)
+// @Param			limit		query		int		false	"Max number of events (default 100, max 1000)"
+// @Success		200			{array}		dto.AuditEventDto
+// @Failure		400			{object}	problem.Problem
+// @Failure		403
+// @Failure		500			{object}	problem.Problem
+// @Router			/audit [get]
+func (ac *AuditController) query(c *gin.Context) {
+	var filter dto.AuditQueryDto
+	if err := c.ShouldBindQuery(&filter); err != nil {
+		abortOnBindError(c, err)
+		return
+	}
+
+	events, err := ac.auditService.Query(filter)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	responseDtos := make([]*dto.AuditEventDto, 0, len(events))
+	for _, event := range events {
+		responseDtos = append(responseDtos, (&dto.AuditEventDto{}).FromModel(&event))
+	}
+
+	c.JSON(http.StatusOK, responseDtos)
+}
@@ -2,17 +2,17 @@ package controller
 
 import (
 	"PatientManager/app"
+	"PatientManager/config"
 	"PatientManager/dto"
 	"PatientManager/service"
+	"PatientManager/util/cerror"
 	"errors"
-	"io"
-	"mime"
+	"fmt"
 	"net/http"
-	"path/filepath"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
-	"github.com/minio/minio-go"
 	"go.uber.org/zap"
 	"gorm.io/gorm"
 )
@@ -21,16 +21,18 @@ type CheckupController struct {
 	checkupService service.ICheckupService
 	logger         *zap.SugaredLogger
 	bucketService  service.IbucketService
+	accessService  service.IAccessService
 }
 
 func NewCheckupController() *CheckupController {
 	var controller *CheckupController
 
-	app.Invoke(func(checkupService service.ICheckupService, logger *zap.SugaredLogger, bucketService service.IbucketService) {
+	app.Invoke(func(checkupService service.ICheckupService, logger *zap.SugaredLogger, bucketService service.IbucketService, accessService service.IAccessService) {
 		controller = &CheckupController{
 			checkupService: checkupService,
 			logger:         logger,
 			bucketService:  bucketService,
+			accessService:  accessService,
 		}
 	})
 
@@ -45,48 +47,64 @@ func (cc *CheckupController) RegisterEndpoints(router *gin.RouterGroup) {
 		checkupRoutes.PUT("/:uuid", cc.update)
 		checkupRoutes.DELETE("/:uuid", cc.delete)
 		checkupRoutes.POST("/:uuid/images", cc.addImages)
+		checkupRoutes.POST("/:uuid/uploads", cc.createUpload)
+		checkupRoutes.POST("/:uuid/uploads/:imageUuid/complete", cc.completeUpload)
 		checkupRoutes.GET("/image/:name", cc.GetImageByName)
+		checkupRoutes.GET("/image/:name/url", cc.getImageUrl)
 	}
 }
 
 // getAllByRecord godoc
 // @Summary		Get all checkups for a medical record
-// @Description	Retrieves a list of all checkups associated with a specific medical record UUID.
+// @Description	Retrieves a page of checkups associated with a specific medical record UUID, sortable by date and type.
 // @Tags			checkup
 // @Produce		json
-// @Success		200	{array}	dto.CheckupDto
+// @Param			filter	query	dto.CheckupQueryDto	false	"Pagination, sorting and filters"
+// @Success		200	{object}	dto.PageDto[dto.CheckupDto]
 // @Failure		400
+// @Failure		403
 // @Failure		404
 // @Failure		500
 // @Param			recordUuid	path	string	true	"UUID of the medical record"
 // @Router			/checkup/record/{recordUuid} [get]
 func (cc *CheckupController) getAllByRecord(c *gin.Context) {
+	actor, ok := getActor(c, cc.accessService)
+	if !ok {
+		return
+	}
+
 	recordUuid, err := uuid.Parse(c.Param("recordUuid"))
 	if err != nil {
 		cc.logger.Errorf("Error parsing record UUID '%s': %v", c.Param("recordUuid"), err)
-		c.AbortWithError(http.StatusBadRequest, errors.New("invalid UUID format"))
+		abortWithStatus(c, http.StatusBadRequest, errors.New("invalid UUID format"))
 		return
 	}
 
-	checkups, err := cc.checkupService.GetAll(recordUuid)
+	var filter dto.CheckupQueryDto
+	if err := c.ShouldBindQuery(&filter); err != nil {
+		abortOnBindError(c, err)
+		return
+	}
+
+	checkups, page, err := cc.checkupService.List(actor, recordUuid, filter)
 	if err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			cc.logger.Warnf("No medical record found for UUID %s", recordUuid)
-			c.AbortWithError(http.StatusNotFound, err)
+			abortWithError(c, err)
 			return
 		}
 		cc.logger.Errorf("Failed to get checkups for record UUID %s: %+v", recordUuid, err)
-		c.AbortWithError(http.StatusInternalServerError, err)
+		abortWithError(c, err)
 		return
 	}
 
-	var responseDtos []*dto.CheckupDto
+	responseDtos := make([]*dto.CheckupDto, 0, len(checkups))
 	for _, checkup := range checkups {
 		dto := (&dto.CheckupDto{}).FromModel(&checkup)
 		responseDtos = append(responseDtos, dto)
 	}
 
-	c.JSON(http.StatusOK, responseDtos)
+	c.JSON(http.StatusOK, dto.NewPageDto(responseDtos, page))
 }
 
 // create godoc
@@ -97,28 +115,35 @@ func (cc *CheckupController) getAllByRecord(c *gin.Context) {
 // @Produce		json
 // @Success		201	{object}	dto.CheckupDto
 // @Failure		400
+// @Failure		403
+// @Failure		404
 // @Failure		500
 // @Param			model	body	dto.CreateCheckupDto	true	"Data for creating a new checkup"
 // @Router			/checkup [post]
 func (cc *CheckupController) create(c *gin.Context) {
+	actor, ok := getActor(c, cc.accessService)
+	if !ok {
+		return
+	}
+
 	var createDto dto.CreateCheckupDto
 	if err := c.ShouldBindJSON(&createDto); err != nil {
 		cc.logger.Errorf("Error binding JSON for create checkup: %v", err)
-		c.AbortWithError(http.StatusBadRequest, err)
+		abortOnBindError(c, err)
 		return
 	}
 
 	checkupModel, err := createDto.ToModel()
 	if err != nil {
 		cc.logger.Errorf("Error converting DTO to model for create checkup: %v", err)
-		c.AbortWithError(http.StatusBadRequest, err)
+		abortWithStatus(c, http.StatusBadRequest, err)
 		return
 	}
 
-	createdCheckup, err := cc.checkupService.Create(checkupModel, createDto.MedicalRecordUuid)
+	createdCheckup, err := cc.checkupService.Create(actor, checkupModel, createDto.MedicalRecordUuid)
 	if err != nil {
 		cc.logger.Errorf("Failed to create checkup: %+v", err)
-		c.AbortWithError(http.StatusInternalServerError, err)
+		abortWithError(c, err)
 		return
 	}
 
@@ -134,42 +159,48 @@ func (cc *CheckupController) create(c *gin.Context) {
 // @Produce		json
 // @Success		200	{object}	dto.CheckupDto
 // @Failure		400
+// @Failure		403
 // @Failure		404
 // @Failure		500
 // @Param			uuid	path	string			true	"UUID of the checkup to be updated"
 // @Param			model	body	dto.CheckupDto	true	"Data for updating the checkup"
 // @Router			/checkup/{uuid} [put]
 func (cc *CheckupController) update(c *gin.Context) {
+	actor, ok := getActor(c, cc.accessService)
+	if !ok {
+		return
+	}
+
 	checkupUuid, err := uuid.Parse(c.Param("uuid"))
 	if err != nil {
 		cc.logger.Errorf("Error parsing UUID '%s': %v", c.Param("uuid"), err)
-		c.AbortWithError(http.StatusBadRequest, errors.New("invalid UUID format"))
+		abortWithStatus(c, http.StatusBadRequest, errors.New("invalid UUID format"))
 		return
 	}
 
 	var updateDto dto.CheckupDto
 	if err := c.ShouldBindJSON(&updateDto); err != nil {
 		cc.logger.Errorf("Error binding JSON for update checkup: %v", err)
-		c.AbortWithError(http.StatusBadRequest, err)
+		abortOnBindError(c, err)
 		return
 	}
 
 	updateData, err := updateDto.ToModel()
 	if err != nil {
 		cc.logger.Errorf("Error converting DTO to model for update checkup: %v", err)
-		c.AbortWithError(http.StatusBadRequest, err)
+		abortWithStatus(c, http.StatusBadRequest, err)
 		return
 	}
 
-	updatedCheckup, err := cc.checkupService.Update(checkupUuid, updateData)
+	updatedCheckup, err := cc.checkupService.Update(actor, checkupUuid, updateData)
 	if err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			cc.logger.Warnf("Checkup with UUID %s not found for update", checkupUuid)
-			c.AbortWithError(http.StatusNotFound, err)
+			abortWithError(c, err)
 			return
 		}
 		cc.logger.Errorf("Failed to update checkup with UUID %s: %+v", checkupUuid, err)
-		c.AbortWithError(http.StatusInternalServerError, err)
+		abortWithError(c, err)
 		return
 	}
 
@@ -183,27 +214,33 @@ func (cc *CheckupController) update(c *gin.Context) {
 // @Tags			checkup
 // @Success		204
 // @Failure		400
+// @Failure		403
 // @Failure		404
 // @Failure		500
 // @Param			uuid	path	string	true	"UUID of the checkup to be deleted"
 // @Router			/checkup/{uuid} [delete]
 func (cc *CheckupController) delete(c *gin.Context) {
+	actor, ok := getActor(c, cc.accessService)
+	if !ok {
+		return
+	}
+
 	checkupUuid, err := uuid.Parse(c.Param("uuid"))
 	if err != nil {
 		cc.logger.Errorf("Error parsing UUID '%s': %v", c.Param("uuid"), err)
-		c.AbortWithError(http.StatusBadRequest, errors.New("invalid UUID format"))
+		abortWithStatus(c, http.StatusBadRequest, errors.New("invalid UUID format"))
 		return
 	}
 
-	err = cc.checkupService.Delete(checkupUuid)
+	err = cc.checkupService.Delete(actor, checkupUuid)
 	if err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			cc.logger.Warnf("Checkup with UUID %s not found for deletion", checkupUuid)
-			c.AbortWithError(http.StatusNotFound, err)
+			abortWithError(c, err)
 			return
 		}
 		cc.logger.Errorf("Failed to delete checkup with UUID %s: %+v", checkupUuid, err)
-		c.AbortWithError(http.StatusInternalServerError, err)
+		abortWithError(c, err)
 		return
 	}
 
@@ -213,50 +250,76 @@ func (cc *CheckupController) delete(c *gin.Context) {
 // addImages godoc
 // @Summary		Add images to a checkup
 // @Description	Uploads and associates one or more images with a checkup.
+// @Description	DICOM files (.dcm) can be uploaded to CT, MR, X-ray and ultrasound checkups, their patient ID must be the OIB of the checkup's patient.
+// @Description	Study, series and instance UIDs, modality and acquisition time are stored with the image and a PNG preview is generated when the pixel data can be rendered.
 // @Tags			checkup
 // @Accept			mpfd
 // @Produce		json
 // @Param			uuid	path	string	true	"UUID of the checkup"
 // @Param			files	formData	file	true	"Image files to upload"
 // @Success		200		{object}	dto.CheckupDto
-// @Failure		400
+// @Failure		400		{object}	problem.Problem	"Invalid DICOM file or not an imaging checkup"
+// @Failure		403
+// @Failure		404
+// @Failure		413		{object}	problem.Problem	"File or request is too large"
+// @Failure		415		{object}	problem.Problem	"File type is not allowed"
+// @Failure		422		{object}	problem.Problem	"Patient ID of a DICOM file doesn't match"
 // @Failure		500
 // @Router			/checkup/{uuid}/images [post]
 func (cc *CheckupController) addImages(c *gin.Context) {
+	actor, ok := getActor(c, cc.accessService)
+	if !ok {
+		return
+	}
+
 	checkupUuid := c.Param("uuid")
 	if checkupUuid == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Checkup UUID is required"})
+		abortWithStatus(c, http.StatusBadRequest, errors.New("checkup UUID is required"))
 		return
 	}
 
-	form, err := c.MultipartForm()
+	parsedUuid, err := uuid.Parse(checkupUuid)
 	if err != nil {
-		cc.logger.Errorf("Error processing multipart form: %v", err)
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		abortWithStatus(c, http.StatusBadRequest, errors.New("invalid UUID format"))
 		return
 	}
-	files := form.File["files"]
 
-	if len(files) == 0 {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "No files uploaded"})
+	if err := cc.checkupService.CheckAccess(actor, parsedUuid); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			abortWithStatus(c, http.StatusNotFound, errors.New("checkup not found"))
+			return
+		}
+		if errors.Is(err, cerror.ErrForbidden) {
+			abortWithStatus(c, http.StatusForbidden, errors.New("access to checkup is forbidden"))
+			return
+		}
+		abortWithError(c, err)
 		return
 	}
 
-	uploadedPaths, err := cc.bucketService.UploadMany(files, checkupUuid)
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, config.AppConfig.Images.MaxRequestSize)
+	form, err := c.MultipartForm()
 	if err != nil {
-		cc.logger.Errorf("Failed to upload some or all files to bucket: %v", err)
-		if len(uploadedPaths) == 0 {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to upload images"})
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			abortWithStatus(c, http.StatusRequestEntityTooLarge, fmt.Errorf("upload is larger than %d bytes", maxBytesErr.Limit))
 			return
 		}
-		cc.logger.Warnf("Proceeding with a partial upload. Successful files: %d", len(uploadedPaths))
+		cc.logger.Errorf("Error processing multipart form: %v", err)
+		abortWithStatus(c, http.StatusBadRequest, err)
+		return
+	}
+	files := form.File["files"]
+
+	if len(files) == 0 {
+		abortWithStatus(c, http.StatusBadRequest, errors.New("no files uploaded"))
+		return
 	}
-	cc.logger.Debugf("Successfully uploaded %d files with new paths: %v", len(uploadedPaths), uploadedPaths)
 
-	updatedCheckup, err := cc.checkupService.AddImagesToCheckup(checkupUuid, uploadedPaths)
+	updatedCheckup, err := cc.checkupService.AddImages(c.Request.Context(), actor, parsedUuid, files)
 	if err != nil {
-		cc.logger.Errorf("Failed to add image paths to checkup: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to associate images with checkup"})
+		cc.logger.Errorf("Failed to add images to checkup: %v", err)
+		abortWithError(c, err)
 		return
 	}
 
@@ -267,47 +330,183 @@ func (cc *CheckupController) addImages(c *gin.Context) {
 // GetImageByName godoc
 // @Summary      Get an image by name
 // @Description  Retrieves and serves an image file from storage by its unique name.
+// @Description  Supports Range requests and conditional requests with If-None-Match, size=thumb serves the thumbnail.
 // @Tags         checkup
 // @Produce      image/png
 // @Produce      image/jpeg
 // @Produce      application/octet-stream
 // @Success      200  {file} file
+// @Success      206  {file} file
+// @Success      304
 // @Failure      400
+// @Failure      403
 // @Failure      404
 // @Failure      500
 // @Param        name path string true "The unique name of the image file (e.g., {checkupUuid}_{originalFilename})"
+// @Param        size query string false "original (default) or thumb" Enums(original, thumb)
 // @Router       /checkup/image/{name} [get]
 func (cc *CheckupController) GetImageByName(c *gin.Context) {
+	actor, ok := getActor(c, cc.accessService)
+	if !ok {
+		return
+	}
+
 	name := c.Param("name")
 
 	if name == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "empty name"})
+		abortWithStatus(c, http.StatusBadRequest, errors.New("empty name"))
 		return
 	}
 
-	reader, err := cc.bucketService.GetFile(name)
+	image, err := cc.checkupService.GetImage(actor, name)
 	if err != nil {
-		errResponse := minio.ToErrorResponse(err)
-		if errResponse.Code == "NoSuchKey" {
-			c.JSON(http.StatusNotFound, gin.H{"error": "Image not found"})
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			abortWithStatus(c, http.StatusNotFound, errors.New("image not found"))
+			return
+		}
+		if errors.Is(err, cerror.ErrForbidden) {
+			abortWithStatus(c, http.StatusForbidden, errors.New("access to image is forbidden"))
 			return
 		}
+		abortWithError(c, err)
+		return
+	}
+
+	serveImage(c, cc.bucketService, image, name)
+}
+
+// createUpload godoc
+// @Summary		Create a presigned image upload
+// @Description	Creates an image of the checkup and returns a URL that the file is uploaded to with PUT, without sending it through this server when the storage supports presigning.
+// @Description	The image isn't visible until the upload is completed, uploads that aren't completed within an hour are removed.
+// @Tags			checkup
+// @Accept			json
+// @Produce		json
+// @Param			uuid	path	string				true	"UUID of the checkup"
+// @Param			upload	body	dto.CreateUploadDto	true	"Name and size of the file"
+// @Success		201		{object}	dto.PresignedUploadDto
+// @Failure		400		{object}	problem.Problem
+// @Failure		403		{object}	problem.Problem
+// @Failure		404		{object}	problem.Problem
+// @Failure		413		{object}	problem.Problem	"File is too large"
+// @Failure		500		{object}	problem.Problem
+// @Router			/checkup/{uuid}/uploads [post]
+func (cc *CheckupController) createUpload(c *gin.Context) {
+	actor, ok := getActor(c, cc.accessService)
+	if !ok {
+		return
+	}
+
+	checkupUuid, err := uuid.Parse(c.Param("uuid"))
+	if err != nil {
+		abortWithStatus(c, http.StatusBadRequest, errors.New("invalid UUID format"))
+		return
+	}
+
+	var createDto dto.CreateUploadDto
+	if err := c.ShouldBindJSON(&createDto); err != nil {
+		abortOnBindError(c, err)
+		return
+	}
+
+	upload, err := cc.checkupService.CreateUpload(c.Request.Context(), actor, checkupUuid, createDto.FileName, createDto.Size)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, dto.PresignedUploadDto{
+		ImageUuid: upload.Image.Uuid,
+		Path:      upload.Image.Path,
+		Method:    http.MethodPut,
+		UploadUrl: upload.Url,
+		ExpiresAt: upload.ExpiresAt,
+	})
+}
+
+// completeUpload godoc
+// @Summary		Complete a presigned image upload
+// @Description	Validates the uploaded file like POST /checkup/{uuid}/images, generates its preview and thumbnail and makes the image visible.
+// @Description	Rejected files are deleted together with their image.
+// @Tags			checkup
+// @Produce		json
+// @Param			uuid		path	string	true	"UUID of the checkup"
+// @Param			imageUuid	path	string	true	"UUID of the image returned by POST /checkup/{uuid}/uploads"
+// @Success		200			{object}	dto.CheckupDto
+// @Failure		400			{object}	problem.Problem	"Invalid DICOM file or not an imaging checkup"
+// @Failure		403			{object}	problem.Problem
+// @Failure		404			{object}	problem.Problem
+// @Failure		409			{object}	problem.Problem	"File wasn't uploaded"
+// @Failure		413			{object}	problem.Problem	"File is too large"
+// @Failure		415			{object}	problem.Problem	"File type is not allowed"
+// @Failure		422			{object}	problem.Problem	"Patient ID of a DICOM file doesn't match"
+// @Failure		500			{object}	problem.Problem
+// @Router			/checkup/{uuid}/uploads/{imageUuid}/complete [post]
+func (cc *CheckupController) completeUpload(c *gin.Context) {
+	actor, ok := getActor(c, cc.accessService)
+	if !ok {
+		return
+	}
 
-		zap.S().Errorf("Failed to retrieve file from bucket: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not retrieve file"})
+	checkupUuid, err := uuid.Parse(c.Param("uuid"))
+	if err != nil {
+		abortWithStatus(c, http.StatusBadRequest, errors.New("invalid UUID format"))
+		return
+	}
+	imageUuid, err := uuid.Parse(c.Param("imageUuid"))
+	if err != nil {
+		abortWithStatus(c, http.StatusBadRequest, errors.New("invalid image UUID format"))
 		return
 	}
-	defer reader.Close()
 
-	ext := filepath.Ext(name)
-	contentType := mime.TypeByExtension(ext)
-	if contentType == "" {
-		contentType = "application/octet-stream"
+	updatedCheckup, err := cc.checkupService.CompleteUpload(c.Request.Context(), actor, checkupUuid, imageUuid)
+	if err != nil {
+		cc.logger.Errorf("Failed to complete upload %s of checkup %s: %v", imageUuid, checkupUuid, err)
+		abortWithError(c, err)
+		return
 	}
-	c.Header("Content-Type", contentType)
 
-	_, err = io.Copy(c.Writer, reader)
+	var responseDto dto.CheckupDto
+	c.JSON(http.StatusOK, responseDto.FromModel(updatedCheckup))
+}
+
+// getImageUrl godoc
+// @Summary		Get a presigned image URL
+// @Description	Returns a URL that downloads the image, its DICOM preview or its thumbnail without authentication until it expires after STORAGE_PRESIGN_EXPIRY.
+// @Tags			checkup
+// @Produce		json
+// @Param			name	path	string	true	"The unique name of the image file"
+// @Param			size	query	string	false	"original (default) or thumb"	Enums(original, thumb)
+// @Success		200		{object}	dto.PresignedUrlDto
+// @Failure		400		{object}	problem.Problem
+// @Failure		403		{object}	problem.Problem
+// @Failure		404		{object}	problem.Problem
+// @Failure		500		{object}	problem.Problem
+// @Router			/checkup/image/{name}/url [get]
+func (cc *CheckupController) getImageUrl(c *gin.Context) {
+	actor, ok := getActor(c, cc.accessService)
+	if !ok {
+		return
+	}
+
+	image, err := cc.checkupService.GetImage(actor, c.Param("name"))
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	name, contentType, ok := imageObject(c, image, c.Param("name"))
+	if !ok {
+		return
+	}
+
+	expiry := config.AppConfig.Storage.PresignExpiry
+	url, err := cc.bucketService.PresignDownload(c.Request.Context(), name, contentType, expiry)
 	if err != nil {
-		zap.S().Errorf("Failed to write image to response stream: %v", err)
+		cc.logger.Errorf("Failed to presign download of %s: %v", name, err)
+		abortWithError(c, err)
+		return
 	}
+
+	c.JSON(http.StatusOK, dto.PresignedUrlDto{Url: url, ExpiresAt: time.Now().Add(expiry)})
 }
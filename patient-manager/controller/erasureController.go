@@ -0,0 +1,256 @@
+package controller
+
+import (
+	"PatientManager/app"
+	"PatientManager/dto"
+	"PatientManager/service"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+type ErasureController struct {
+	erasureService service.IErasureService
+	accessService  service.IAccessService
+}
+
+func NewErasureController() *ErasureController {
+	var controller *ErasureController
+	app.Invoke(func(erasureService service.IErasureService, accessService service.IAccessService) {
+		controller = &ErasureController{
+			erasureService: erasureService,
+			accessService:  accessService,
+		}
+	})
+	return controller
+}
+
+func (ec *ErasureController) RegisterEndpoints(router *gin.RouterGroup) {
+	// gin requires the same wildcard name as the other patient routes
+	router.PUT("/patients/:id/legal-hold", ec.setLegalHold)
+	router.POST("/patients/:id/erasure", ec.request)
+
+	erasures := router.Group("/erasures")
+	{
+		erasures.GET("", ec.list)
+		erasures.POST("/:uuid/approve", ec.approve)
+		erasures.POST("/:uuid/reject", ec.reject)
+		erasures.GET("/:uuid/certificate", ec.certificate)
+	}
+}
+
+// setLegalHold godoc
+//
+//	@Summary		Place a patient under legal hold
+//	@Description	Patients under legal hold can't be erased and aren't purged from trash. The reason is required when placing the hold.
+//	@Tags			erasures
+//	@Accept			json
+//	@Param			id			path	int					true	"Patient ID"
+//	@Param			legalHold	body	dto.LegalHoldDto	true	"Legal hold"
+//	@Success		204
+//	@Failure		400	{object}	problem.Problem
+//	@Failure		403	{object}	problem.Problem
+//	@Failure		404	{object}	problem.Problem
+//	@Failure		500	{object}	problem.Problem
+//	@Router			/patients/{id}/legal-hold [put]
+func (ec *ErasureController) setLegalHold(c *gin.Context) {
+	actor, ok := getActor(c, ec.accessService)
+	if !ok {
+		return
+	}
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		abortWithStatus(c, http.StatusBadRequest, errors.New("invalid patient ID"))
+		return
+	}
+
+	var legalHold dto.LegalHoldDto
+	if err := c.ShouldBindJSON(&legalHold); err != nil {
+		abortOnBindError(c, err)
+		return
+	}
+
+	if err := ec.erasureService.SetLegalHold(actor, uint(id), legalHold); err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// request godoc
+//
+//	@Summary		Request erasure of a patient
+//	@Description	Creates a pending request to erase personal data of the patient, it has to be approved by another superadmin.
+//	@Tags			erasures
+//	@Accept			json
+//	@Produce		json
+//	@Param			id		path		int							true	"Patient ID"
+//	@Param			request	body		dto.NewErasureRequestDto	true	"Reason for the erasure"
+//	@Success		201		{object}	dto.ErasureRequestDto
+//	@Failure		400		{object}	problem.Problem
+//	@Failure		403		{object}	problem.Problem
+//	@Failure		404		{object}	problem.Problem
+//	@Failure		409		{object}	problem.Problem	"Patient is under legal hold, already erased or has a pending request"
+//	@Failure		500		{object}	problem.Problem
+//	@Router			/patients/{id}/erasure [post]
+func (ec *ErasureController) request(c *gin.Context) {
+	actor, ok := getActor(c, ec.accessService)
+	if !ok {
+		return
+	}
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		abortWithStatus(c, http.StatusBadRequest, errors.New("invalid patient ID"))
+		return
+	}
+
+	var requestDto dto.NewErasureRequestDto
+	if err := c.ShouldBindJSON(&requestDto); err != nil {
+		abortOnBindError(c, err)
+		return
+	}
+
+	request, err := ec.erasureService.Request(actor, uint(id), requestDto.Reason)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, (&dto.ErasureRequestDto{}).FromModel(request))
+}
+
+// list godoc
+//
+//	@Summary		List erasure requests
+//	@Description	Returns a page of erasure requests, sortable by createdAt
+//	@Tags			erasures
+//	@Produce		json
+//	@Param			filter	query		dto.ErasureQueryDto	false	"Status, pagination and sorting"
+//	@Success		200		{object}	dto.PageDto[dto.ErasureRequestDto]
+//	@Failure		400		{object}	problem.Problem
+//	@Failure		403		{object}	problem.Problem
+//	@Failure		500		{object}	problem.Problem
+//	@Router			/erasures [get]
+func (ec *ErasureController) list(c *gin.Context) {
+	var filter dto.ErasureQueryDto
+	if err := c.ShouldBindQuery(&filter); err != nil {
+		abortOnBindError(c, err)
+		return
+	}
+
+	requests, page, err := ec.erasureService.List(filter)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	responseDtos := make([]dto.ErasureRequestDto, 0, len(requests))
+	for _, request := range requests {
+		responseDtos = append(responseDtos, *(&dto.ErasureRequestDto{}).FromModel(&request))
+	}
+	c.JSON(http.StatusOK, dto.NewPageDto(responseDtos, page))
+}
+
+// approve godoc
+//
+//	@Summary		Approve an erasure request
+//	@Description	Erases personal data of the patient and returns the erasure certificate. Must be approved by a superadmin other than the requester.
+//	@Tags			erasures
+//	@Produce		json
+//	@Param			uuid	path		string	true	"Erasure request UUID"
+//	@Success		200		{object}	dto.ErasureCertificateDto
+//	@Failure		400		{object}	problem.Problem
+//	@Failure		403		{object}	problem.Problem	"Approver made the request"
+//	@Failure		404		{object}	problem.Problem
+//	@Failure		409		{object}	problem.Problem	"Request is already decided or patient is under legal hold"
+//	@Failure		500		{object}	problem.Problem
+//	@Router			/erasures/{uuid}/approve [post]
+func (ec *ErasureController) approve(c *gin.Context) {
+	actor, ok := getActor(c, ec.accessService)
+	if !ok {
+		return
+	}
+
+	requestUuid, ok := parseErasureUuid(c)
+	if !ok {
+		return
+	}
+
+	certificate, err := ec.erasureService.Approve(c.Request.Context(), actor, requestUuid)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, (&dto.ErasureCertificateDto{}).FromModel(certificate))
+}
+
+// reject godoc
+//
+//	@Summary		Reject an erasure request
+//	@Tags			erasures
+//	@Produce		json
+//	@Param			uuid	path		string	true	"Erasure request UUID"
+//	@Success		200		{object}	dto.ErasureRequestDto
+//	@Failure		400		{object}	problem.Problem
+//	@Failure		403		{object}	problem.Problem
+//	@Failure		404		{object}	problem.Problem
+//	@Failure		409		{object}	problem.Problem	"Request is already decided"
+//	@Failure		500		{object}	problem.Problem
+//	@Router			/erasures/{uuid}/reject [post]
+func (ec *ErasureController) reject(c *gin.Context) {
+	actor, ok := getActor(c, ec.accessService)
+	if !ok {
+		return
+	}
+
+	requestUuid, ok := parseErasureUuid(c)
+	if !ok {
+		return
+	}
+
+	request, err := ec.erasureService.Reject(actor, requestUuid)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, (&dto.ErasureRequestDto{}).FromModel(request))
+}
+
+// certificate godoc
+//
+//	@Summary		Get the certificate of an approved erasure
+//	@Tags			erasures
+//	@Produce		json
+//	@Param			uuid	path		string	true	"Erasure request UUID"
+//	@Success		200		{object}	dto.ErasureCertificateDto
+//	@Failure		400		{object}	problem.Problem
+//	@Failure		403		{object}	problem.Problem
+//	@Failure		404		{object}	problem.Problem
+//	@Failure		500		{object}	problem.Problem
+//	@Router			/erasures/{uuid}/certificate [get]
+func (ec *ErasureController) certificate(c *gin.Context) {
+	requestUuid, ok := parseErasureUuid(c)
+	if !ok {
+		return
+	}
+
+	certificate, err := ec.erasureService.GetCertificate(requestUuid)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, (&dto.ErasureCertificateDto{}).FromModel(certificate))
+}
+
+func parseErasureUuid(c *gin.Context) (uuid.UUID, bool) {
+	requestUuid, err := uuid.Parse(c.Param("uuid"))
+	if err != nil {
+		abortWithStatus(c, http.StatusBadRequest, errors.New("invalid erasure request UUID"))
+		return uuid.Nil, false
+	}
+	return requestUuid, true
+}
@@ -0,0 +1,35 @@
+package controller
+
+import (
+	"PatientManager/service"
+	"PatientManager/util/middleware"
+	"PatientManager/util/problem"
+	"PatientManager/util/validation"
+	"errors"
+	"math"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// abortWithError stops the request, middleware.Problems responds with the
+// status mapped to err (see problem.From). Retry-After is set for
+// *service.ThrottleError.
+func abortWithError(c *gin.Context, err error) {
+	var throttleErr *service.ThrottleError
+	if errors.As(err, &throttleErr) {
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(throttleErr.RetryAfter.Seconds()))))
+	}
+	middleware.Abort(c, err)
+}
+
+// abortWithStatus stops the request responding with status, err describes
+// the problem and can be nil
+func abortWithStatus(c *gin.Context, status int, err error) {
+	middleware.Abort(c, problem.WithStatus(status, err))
+}
+
+// abortOnBindError responds with 400 and the invalid fields of the request
+func abortOnBindError(c *gin.Context, err error) {
+	middleware.Abort(c, validation.NewBindError(err))
+}
@@ -0,0 +1,22 @@
+package controller
+
+import (
+	"PatientManager/service"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// writeExport streams the archive as an attachment, errors while streaming
+// can only be logged since the response was already started
+func writeExport(c *gin.Context, export *service.PatientExport) {
+	c.Header("Content-Type", "application/zip")
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", export.FileName()))
+	c.Status(http.StatusOK)
+
+	if err := export.WriteZip(c.Request.Context(), c.Writer); err != nil {
+		zap.S().Errorf("Failed to write export of patient %s, err = %+v", export.Patient.Uuid, err)
+	}
+}
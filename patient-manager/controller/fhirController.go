@@ -0,0 +1,237 @@
+package controller
+
+import (
+	"PatientManager/app"
+	"PatientManager/service"
+	"PatientManager/util/fhir"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// FhirController serves the FHIR R4 API, errors are responded with an
+// OperationOutcome by middleware.Outcomes
+type FhirController struct {
+	fhirService   service.IFhirService
+	accessService service.IAccessService
+	basePath      string
+}
+
+func NewFhirController() *FhirController {
+	var controller *FhirController
+	app.Invoke(func(fhirService service.IFhirService, accessService service.IAccessService) {
+		controller = &FhirController{
+			fhirService:   fhirService,
+			accessService: accessService,
+		}
+	})
+	return controller
+}
+
+// RegisterEndpoints registers the FHIR routes directly on router, it should
+// be a group of its own. The API is served at /fhir, outside of /api.
+func (fc *FhirController) RegisterEndpoints(router *gin.RouterGroup) {
+	fc.basePath = router.BasePath()
+
+	router.GET("/:type", fc.search)
+	router.POST("/:type", fc.create)
+	router.GET("/:type/:id", fc.read)
+	// a static Patient segment would shadow /:type/:id for patients, so
+	// operations are matched by a parameter
+	router.GET("/:type/:id/:operation", fc.operation)
+}
+
+// read godoc
+//
+//	@Summary		Read a FHIR resource
+//	@Description	Patient, Condition (illness), MedicationRequest (prescription line), Encounter and DiagnosticReport (checkup) and Media (checkup image).
+//	@Tags			fhir
+//	@Produce		application/fhir+json
+//	@Param			type	path		string	true	"Resource type"	Enums(Patient, Condition, MedicationRequest, Encounter, DiagnosticReport, Media)
+//	@Param			id		path		string	true	"Resource ID"
+//	@Success		200		{object}	any
+//	@Failure		403		{object}	fhir.OperationOutcome
+//	@Failure		404		{object}	fhir.OperationOutcome
+//	@Failure		500		{object}	fhir.OperationOutcome
+//	@Router			/fhir/{type}/{id} [get]
+func (fc *FhirController) read(c *gin.Context) {
+	actor, ok := getActor(c, fc.accessService)
+	if !ok {
+		return
+	}
+
+	resourceType, ok := fhirResourceType(c)
+	if !ok {
+		return
+	}
+	id, ok := fhirResourceID(c, resourceType)
+	if !ok {
+		return
+	}
+
+	resource, err := fc.fhirService.Read(actor, resourceType, id)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	writeFhir(c, http.StatusOK, resource)
+}
+
+// search godoc
+//
+//	@Summary		Search FHIR resources
+//	@Description	Patients are searched by identifier, an OIB optionally prefixed with http://fhir.cezih.hr/specifikacije/identifikatori/OIB|.
+//	@Description	Other resources are searched by patient (or subject), Patient/{id} or {id}.
+//	@Tags			fhir
+//	@Produce		application/fhir+json
+//	@Param			type		path		string	true	"Resource type"	Enums(Patient, Condition, MedicationRequest, Encounter, DiagnosticReport, Media)
+//	@Param			identifier	query		string	false	"Patient identifier"
+//	@Param			patient		query		string	false	"Patient reference"
+//	@Success		200			{object}	fhir.Bundle
+//	@Failure		400			{object}	fhir.OperationOutcome
+//	@Failure		404			{object}	fhir.OperationOutcome
+//	@Failure		500			{object}	fhir.OperationOutcome
+//	@Router			/fhir/{type} [get]
+func (fc *FhirController) search(c *gin.Context) {
+	actor, ok := getActor(c, fc.accessService)
+	if !ok {
+		return
+	}
+
+	resourceType, ok := fhirResourceType(c)
+	if !ok {
+		return
+	}
+
+	bundle, err := fc.fhirService.Search(actor, resourceType, c.Request.URL.Query())
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	bundle.SetBase(fc.baseURL(c))
+	writeFhir(c, http.StatusOK, bundle)
+}
+
+// create godoc
+//
+//	@Summary		Create a FHIR resource
+//	@Description	Patient, Condition, Encounter and MedicationRequest can be created, resources reference each other by ID.
+//	@Description	A MedicationRequest codes its medication by UUID in system urn:patient-manager:medication, see /medications.
+//	@Tags			fhir
+//	@Accept			application/fhir+json
+//	@Produce		application/fhir+json
+//	@Param			type		path		string	true	"Resource type"	Enums(Patient, Condition, MedicationRequest, Encounter)
+//	@Param			resource	body		object	true	"Resource"
+//	@Success		201			{object}	any
+//	@Failure		400			{object}	fhir.OperationOutcome
+//	@Failure		403			{object}	fhir.OperationOutcome
+//	@Failure		405			{object}	fhir.OperationOutcome
+//	@Failure		409			{object}	fhir.OperationOutcome
+//	@Failure		500			{object}	fhir.OperationOutcome
+//	@Router			/fhir/{type} [post]
+func (fc *FhirController) create(c *gin.Context) {
+	actor, ok := getActor(c, fc.accessService)
+	if !ok {
+		return
+	}
+
+	resourceType, ok := fhirResourceType(c)
+	if !ok {
+		return
+	}
+	resource, ok := fhir.New(resourceType)
+	if !ok {
+		abortWithStatus(c, http.StatusMethodNotAllowed, fmt.Errorf("%s can't be created", resourceType))
+		return
+	}
+	if err := c.ShouldBindJSON(resource); err != nil {
+		abortOnBindError(c, err)
+		return
+	}
+
+	created, err := fc.fhirService.Create(actor, resource)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.Header("Location", fc.baseURL(c)+"/"+created.Reference())
+	writeFhir(c, http.StatusCreated, created)
+}
+
+// operation runs the operation named by the path, only Patient $everything
+// is supported
+func (fc *FhirController) operation(c *gin.Context) {
+	if c.Param("type") != fhir.TypePatient || c.Param("operation") != "$everything" {
+		abortWithStatus(c, http.StatusNotFound, fmt.Errorf("operation %s is not supported", c.Param("operation")))
+		return
+	}
+	fc.everything(c)
+}
+
+// everything godoc
+//
+//	@Summary		Patient $everything operation
+//	@Description	Returns the patient with all of its Conditions, MedicationRequests, Encounters, DiagnosticReports and Media.
+//	@Tags			fhir
+//	@Produce		application/fhir+json
+//	@Param			id	path		string	true	"Patient ID"
+//	@Success		200	{object}	fhir.Bundle
+//	@Failure		403	{object}	fhir.OperationOutcome
+//	@Failure		404	{object}	fhir.OperationOutcome
+//	@Failure		500	{object}	fhir.OperationOutcome
+//	@Router			/fhir/Patient/{id}/$everything [get]
+func (fc *FhirController) everything(c *gin.Context) {
+	actor, ok := getActor(c, fc.accessService)
+	if !ok {
+		return
+	}
+
+	id, ok := fhirResourceID(c, fhir.TypePatient)
+	if !ok {
+		return
+	}
+
+	bundle, err := fc.fhirService.Everything(actor, id)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	bundle.SetBase(fc.baseURL(c))
+	writeFhir(c, http.StatusOK, bundle)
+}
+
+// baseURL is the absolute URL of the FHIR API, used in Bundle entries and
+// Location headers
+func (fc *FhirController) baseURL(c *gin.Context) string {
+	scheme := "http"
+	if c.Request.TLS != nil || c.GetHeader("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	return scheme + "://" + c.Request.Host + fc.basePath
+}
+
+func fhirResourceType(c *gin.Context) (string, bool) {
+	resourceType := c.Param("type")
+	if !fhir.Supported(resourceType) {
+		abortWithStatus(c, http.StatusNotFound, fmt.Errorf("resource type %s is not supported", resourceType))
+		return "", false
+	}
+	return resourceType, true
+}
+
+// fhirResourceID parses the id path parameter, IDs that aren't UUIDs can't exist
+func fhirResourceID(c *gin.Context, resourceType string) (uuid.UUID, bool) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		abortWithStatus(c, http.StatusNotFound, fmt.Errorf("resource %s/%s not found", resourceType, c.Param("id")))
+		return uuid.Nil, false
+	}
+	return id, true
+}
+
+func writeFhir(c *gin.Context, status int, value any) {
+	c.Header("Content-Type", fhir.ContentType+"; charset=utf-8")
+	c.JSON(status, value)
+}
@@ -0,0 +1,140 @@
+package controller
+
+import (
+	"PatientManager/app"
+	"PatientManager/dto"
+	"PatientManager/model"
+	"PatientManager/service"
+	"PatientManager/util/hl7"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+type Hl7Controller struct {
+	hl7Service    service.IHl7Service
+	accessService service.IAccessService
+}
+
+func NewHl7Controller() *Hl7Controller {
+	var controller *Hl7Controller
+	app.Invoke(func(hl7Service service.IHl7Service, accessService service.IAccessService) {
+		controller = &Hl7Controller{
+			hl7Service:    hl7Service,
+			accessService: accessService,
+		}
+	})
+	return controller
+}
+
+func (hc *Hl7Controller) RegisterEndpoints(router *gin.RouterGroup) {
+	hl7 := router.Group("/hl7")
+	{
+		hl7.POST("", hc.ingest)
+		hl7.GET("/dead-letters", hc.listDeadLetters)
+		hl7.POST("/dead-letters/:uuid/retry", hc.retry)
+	}
+}
+
+// ingest godoc
+//
+//	@Summary		Ingest an HL7 v2 message
+//	@Description	Accepts ADT^A01, ADT^A04 and ADT^A08 that create or update the patient by OIB (PID-3) and ORU^R01 that attaches lab results to a blood test (KRV) checkup.
+//	@Description	The response is the HL7 acknowledgement, messages that are acknowledged with AE or AR are stored as dead letters.
+//	@Tags			hl7
+//	@Accept			x-application/hl7-v2+er7
+//	@Produce		x-application/hl7-v2+er7
+//	@Param			message	body		string	true	"HL7 v2 message"
+//	@Success		200		{string}	string	"ACK message"
+//	@Failure		400		{object}	problem.Problem
+//	@Failure		403		{object}	problem.Problem
+//	@Failure		413		{object}	problem.Problem
+//	@Router			/hl7 [post]
+func (hc *Hl7Controller) ingest(c *gin.Context) {
+	actor, ok := getActor(c, hc.accessService)
+	if !ok {
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, hl7.MaxMessageSize))
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			abortWithStatus(c, http.StatusRequestEntityTooLarge, errors.New("HL7 message is too large"))
+			return
+		}
+		abortWithStatus(c, http.StatusBadRequest, err)
+		return
+	}
+
+	ack := hc.hl7Service.Ingest(actor, string(body), model.Hl7SourceHttp)
+	c.Data(http.StatusOK, hl7.ContentType, []byte(ack))
+}
+
+// listDeadLetters godoc
+//
+//	@Summary		List HL7 dead letters
+//	@Description	Returns a page of HL7 messages that couldn't be processed, sortable by createdAt
+//	@Tags			hl7
+//	@Produce		json
+//	@Param			filter	query		dto.ListQueryDto	false	"Pagination and sorting"
+//	@Success		200		{object}	dto.PageDto[dto.Hl7DeadLetterDto]
+//	@Failure		400		{object}	problem.Problem
+//	@Failure		403		{object}	problem.Problem
+//	@Failure		500		{object}	problem.Problem
+//	@Router			/hl7/dead-letters [get]
+func (hc *Hl7Controller) listDeadLetters(c *gin.Context) {
+	var filter dto.ListQueryDto
+	if err := c.ShouldBindQuery(&filter); err != nil {
+		abortOnBindError(c, err)
+		return
+	}
+
+	deadLetters, page, err := hc.hl7Service.ListDeadLetters(filter)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	responseDtos := make([]dto.Hl7DeadLetterDto, 0, len(deadLetters))
+	for _, deadLetter := range deadLetters {
+		responseDtos = append(responseDtos, *(&dto.Hl7DeadLetterDto{}).FromModel(&deadLetter))
+	}
+	c.JSON(http.StatusOK, dto.NewPageDto(responseDtos, page))
+}
+
+// retry godoc
+//
+//	@Summary		Retry an HL7 dead letter
+//	@Description	Processes the message again as the current user and returns its acknowledgement. The dead letter is deleted when the message is accepted.
+//	@Tags			hl7
+//	@Produce		x-application/hl7-v2+er7
+//	@Param			uuid	path		string	true	"Dead letter UUID"
+//	@Success		200		{string}	string	"ACK message"
+//	@Failure		400		{object}	problem.Problem
+//	@Failure		403		{object}	problem.Problem
+//	@Failure		404		{object}	problem.Problem
+//	@Failure		500		{object}	problem.Problem
+//	@Router			/hl7/dead-letters/{uuid}/retry [post]
+func (hc *Hl7Controller) retry(c *gin.Context) {
+	actor, ok := getActor(c, hc.accessService)
+	if !ok {
+		return
+	}
+
+	deadLetterUuid, err := uuid.Parse(c.Param("uuid"))
+	if err != nil {
+		abortWithStatus(c, http.StatusBadRequest, errors.New("invalid dead letter UUID"))
+		return
+	}
+
+	ack, err := hc.hl7Service.Retry(actor, deadLetterUuid)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.Data(http.StatusOK, hl7.ContentType, []byte(ack))
+}
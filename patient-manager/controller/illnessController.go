@@ -4,6 +4,7 @@ import (
 	"PatientManager/app"
 	"PatientManager/dto"
 	"PatientManager/service"
+	"errors"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
@@ -13,14 +14,16 @@ import (
 
 type IllnessController struct {
 	illnessService service.IIllnessService
+	accessService  service.IAccessService
 	logger         *zap.SugaredLogger
 }
 
 func NewIllnessController() *IllnessController {
 	var controller *IllnessController
-	app.Invoke(func(illnessService service.IIllnessService, logger *zap.SugaredLogger) {
+	app.Invoke(func(illnessService service.IIllnessService, accessService service.IAccessService, logger *zap.SugaredLogger) {
 		controller = &IllnessController{
 			illnessService: illnessService,
+			accessService:  accessService,
 			logger:         logger,
 		}
 	})
@@ -45,20 +48,27 @@ func (ic *IllnessController) RegisterEndpoints(router *gin.RouterGroup) {
 // @Produce		json
 // @Param			model	body		dto.CreateIllnessDto	true	"New Illness Data"
 // @Success		201		{object}	model.Illness
-// @Failure		400		{object}	gin.H
-// @Failure		500		{object}	gin.H
+// @Failure		400		{object}	problem.Problem
+// @Failure		403		{object}	problem.Problem
+// @Failure		404		{object}	problem.Problem
+// @Failure		500		{object}	problem.Problem
 // @Router			/illnesses [post]
 func (ic *IllnessController) create(c *gin.Context) {
+	actor, ok := getActor(c, ic.accessService)
+	if !ok {
+		return
+	}
+
 	var createDto dto.CreateIllnessDto
 	if err := c.ShouldBindJSON(&createDto); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		abortOnBindError(c, err)
 		return
 	}
 
 	illnessModel := createDto.ToModel()
-	createdIllness, err := ic.illnessService.Create(illnessModel, createDto.MedicalRecordUuid)
+	createdIllness, err := ic.illnessService.Create(actor, illnessModel, createDto.MedicalRecordUuid)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create illness"})
+		abortWithError(c, err)
 		return
 	}
 
@@ -67,33 +77,47 @@ func (ic *IllnessController) create(c *gin.Context) {
 
 // getAllForRecord godoc
 // @Summary		Get all illnesses for a record
-// @Description	Retrieves a list of illnesses for a specific medical record
+// @Description	Retrieves a page of illnesses for a specific medical record, sortable by name and startDate
 // @Tags			illnesses
 // @Produce		json
-// @Param			recordUuid	path		string	true	"Medical Record UUID"
-// @Success		200			{array}		dto.IllnessListDto
-// @Failure		400			{object}	gin.H
-// @Failure		500			{object}	gin.H
+// @Param			recordUuid	path		string				true	"Medical Record UUID"
+// @Param			filter		query		dto.IllnessQueryDto	false	"Pagination, sorting and filters"
+// @Success		200			{object}	dto.PageDto[dto.IllnessListDto]
+// @Failure		400			{object}	problem.Problem
+// @Failure		403			{object}	problem.Problem
+// @Failure		404			{object}	problem.Problem
+// @Failure		500			{object}	problem.Problem
 // @Router			/illnesses/record/{recordUuid} [get]
 func (ic *IllnessController) getAllForRecord(c *gin.Context) {
+	actor, ok := getActor(c, ic.accessService)
+	if !ok {
+		return
+	}
+
 	recordUuid, err := uuid.Parse(c.Param("recordUuid"))
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid UUID format"})
+		abortWithStatus(c, http.StatusBadRequest, errors.New("invalid UUID format"))
 		return
 	}
 
-	illnesses, err := ic.illnessService.GetAllForRecord(recordUuid)
+	var filter dto.IllnessQueryDto
+	if err := c.ShouldBindQuery(&filter); err != nil {
+		abortOnBindError(c, err)
+		return
+	}
+
+	illnesses, page, err := ic.illnessService.ListForRecord(actor, recordUuid, filter)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve illnesses"})
+		abortWithError(c, err)
 		return
 	}
 
-	var responseDtos []*dto.IllnessListDto
+	responseDtos := make([]*dto.IllnessListDto, 0, len(illnesses))
 	for _, illness := range illnesses {
 		dto := (&dto.IllnessListDto{}).FromModel(&illness)
 		responseDtos = append(responseDtos, dto)
 	}
-	c.JSON(http.StatusOK, responseDtos)
+	c.JSON(http.StatusOK, dto.NewPageDto(responseDtos, page))
 }
 
 // update godoc
@@ -105,25 +129,32 @@ func (ic *IllnessController) getAllForRecord(c *gin.Context) {
 // @Param			uuid	path		string					true	"Illness UUID"
 // @Param			model	body		dto.UpdateIllnessDto	true	"Updated Illness Data"
 // @Success		200		{object}	model.Illness
-// @Failure		400		{object}	gin.H
-// @Failure		500		{object}	gin.H
+// @Failure		400		{object}	problem.Problem
+// @Failure		403		{object}	problem.Problem
+// @Failure		404		{object}	problem.Problem
+// @Failure		500		{object}	problem.Problem
 // @Router			/illnesses/{uuid} [put]
 func (ic *IllnessController) update(c *gin.Context) {
+	actor, ok := getActor(c, ic.accessService)
+	if !ok {
+		return
+	}
+
 	illnessUuid, err := uuid.Parse(c.Param("uuid"))
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid UUID format"})
+		abortWithStatus(c, http.StatusBadRequest, errors.New("invalid UUID format"))
 		return
 	}
 
 	var updateDto dto.UpdateIllnessDto
 	if err := c.ShouldBindJSON(&updateDto); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		abortOnBindError(c, err)
 		return
 	}
 
-	updatedIllness, err := ic.illnessService.Update(illnessUuid, updateDto.ToModel())
+	updatedIllness, err := ic.illnessService.Update(actor, illnessUuid, updateDto.ToModel())
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update illness"})
+		abortWithError(c, err)
 		return
 	}
 	c.JSON(http.StatusOK, updatedIllness)
@@ -135,18 +166,25 @@ func (ic *IllnessController) update(c *gin.Context) {
 // @Tags			illnesses
 // @Param			uuid	path	string	true	"Illness UUID"
 // @Success		204
-// @Failure		400	{object}	gin.H
-// @Failure		500	{object}	gin.H
+// @Failure		400	{object}	problem.Problem
+// @Failure		403	{object}	problem.Problem
+// @Failure		404	{object}	problem.Problem
+// @Failure		500	{object}	problem.Problem
 // @Router			/illnesses/{uuid} [delete]
 func (ic *IllnessController) delete(c *gin.Context) {
+	actor, ok := getActor(c, ic.accessService)
+	if !ok {
+		return
+	}
+
 	illnessUuid, err := uuid.Parse(c.Param("uuid"))
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid UUID format"})
+		abortWithStatus(c, http.StatusBadRequest, errors.New("invalid UUID format"))
 		return
 	}
 
-	if err := ic.illnessService.Delete(illnessUuid); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete illness"})
+	if err := ic.illnessService.Delete(actor, illnessUuid); err != nil {
+		abortWithError(c, err)
 		return
 	}
 	c.Status(http.StatusNoContent)
@@ -0,0 +1,80 @@
+package controller
+
+import (
+	"PatientManager/model"
+	"PatientManager/service"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"path/filepath"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// imageObject returns the object and content type of the image, its DICOM
+// preview if name is the preview path, or its thumbnail for ?size=thumb. The
+// request is aborted if ok is false.
+func imageObject(c *gin.Context, image *model.Image, name string) (object string, contentType string, ok bool) {
+	contentType = image.ContentType
+	if name == image.PreviewPath {
+		contentType = "image/png"
+	}
+
+	switch c.Query("size") {
+	case "", "original":
+	case "thumb":
+		if image.ThumbnailPath == "" {
+			abortWithStatus(c, http.StatusNotFound, errors.New("image has no thumbnail"))
+			return "", "", false
+		}
+		name, contentType = image.ThumbnailPath, "image/jpeg"
+	default:
+		abortWithStatus(c, http.StatusBadRequest, errors.New("size must be original or thumb"))
+		return "", "", false
+	}
+
+	if contentType == "" {
+		contentType = mime.TypeByExtension(filepath.Ext(name))
+	}
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	return name, contentType, true
+}
+
+// serveImage serves the object of the image selected by imageObject. Range,
+// If-None-Match and If-Modified-Since requests are answered by
+// http.ServeContent.
+func serveImage(c *gin.Context, bucketService service.IbucketService, image *model.Image, name string) {
+	name, contentType, ok := imageObject(c, image, name)
+	if !ok {
+		return
+	}
+	serveObject(c, bucketService, name, contentType)
+}
+
+func serveObject(c *gin.Context, bucketService service.IbucketService, name string, contentType string) {
+	reader, info, err := bucketService.OpenFile(c.Request.Context(), name)
+	if err != nil {
+		zap.S().Errorf("Failed to retrieve file %s from bucket: %v", name, err)
+		abortWithError(c, err)
+		return
+	}
+	defer reader.Close()
+
+	if contentType == "" {
+		contentType = info.ContentType
+	}
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	c.Header("Content-Type", contentType)
+	c.Header("Cache-Control", "private, no-cache")
+	if info.ETag != "" {
+		c.Header("ETag", fmt.Sprintf("%q", info.ETag))
+	}
+
+	http.ServeContent(c.Writer, c.Request, "", info.LastModified, reader)
+}
@@ -0,0 +1,32 @@
+package controller
+
+import (
+	"PatientManager/util/auth"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+type KeyController struct{}
+
+func NewKeyController() *KeyController {
+	return &KeyController{}
+}
+
+func (k *KeyController) RegisterEndpoints(router gin.IRouter) {
+	router.GET("/.well-known/jwks.json", k.jwks)
+}
+
+// JWKS godoc
+//
+//	@Summary		Token signing keys
+//	@Description	Returns public keys that verify access tokens as a JSON Web Key Set, the key is selected by the kid header of the token.
+//	@Description	Keys are rotated, clients should refetch the set when they see an unknown kid.
+//	@Tags			auth
+//	@Produce		json
+//	@Success		200	{object}	auth.JWKSet
+//	@Router			/.well-known/jwks.json [get]
+func (k *KeyController) jwks(c *gin.Context) {
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, auth.PublicKeys())
+}
@@ -2,33 +2,40 @@ package controller
 
 import (
 	"PatientManager/app"
-	"PatientManager/config"
 	"PatientManager/dto"
-	"PatientManager/model"
 	"PatientManager/service"
-	"PatientManager/util/auth"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
-	"github.com/golang-jwt/jwt/v4"
-	"github.com/google/uuid"
 	"go.uber.org/zap"
 )
 
 type LoginController struct {
-	loginService service.ILoginService
-	logger       *zap.SugaredLogger
+	loginService     service.ILoginService
+	sessionService   service.ISessionService
+	passwordService  service.IPasswordService
+	twoFactorService service.ITwoFactorService
+	logger           *zap.SugaredLogger
 }
 
 func NewLoginController() *LoginController {
 	var controller *LoginController
 
 	// Use the mock service for testing
-	app.Invoke(func(loginService service.ILoginService, logger *zap.SugaredLogger) {
+	app.Invoke(func(
+		loginService service.ILoginService,
+		sessionService service.ISessionService,
+		passwordService service.IPasswordService,
+		twoFactorService service.ITwoFactorService,
+		logger *zap.SugaredLogger,
+	) {
 		// create controller
 		controller = &LoginController{
-			loginService: loginService,
-			logger:       logger,
+			loginService:     loginService,
+			sessionService:   sessionService,
+			passwordService:  passwordService,
+			twoFactorService: twoFactorService,
+			logger:           logger,
 		}
 	})
 
@@ -42,88 +49,272 @@ func (c *LoginController) RegisterEndpoints(api *gin.RouterGroup) {
 	// register Endpoints
 	group.POST("/login", c.login)
 	group.POST("/refresh", c.RefreshToken)
+	group.POST("/logout", c.logout)
+	group.POST("/change-password", c.changePassword)
+	group.POST("/reset-password/request", c.requestPasswordReset)
+	group.POST("/reset-password", c.resetPassword)
+	group.POST("/2fa/enroll", c.enrollTwoFactor)
+	group.POST("/2fa/verify", c.verifyTwoFactor)
 }
 
 // Login godoc
 //
 //	@Summary		User login
-//	@Description	Authenticates a user and returns access and refresh tokens
+//	@Description	Authenticates a user and returns access and refresh tokens.
+//	@Description	If two-factor authentication is needed only challengeToken is returned, use it with /auth/2fa/verify,
+//	@Description	or with /auth/2fa/enroll first when enrollmentRequired is set.
 //	@Tags			auth
 //	@Accept			json
 //	@Produce		json
 //	@Param			loginDto	body		dto.LoginDto	true	"Login credentials"
 //	@Success		200			{object}	dto.TokenDto
+//	@Failure		401
+//	@Failure		403			"Password must be changed first, see /auth/change-password"
+//	@Failure		429			"Too many failed attempts, see Retry-After header"
 //	@Router			/auth/login [post]
 func (l *LoginController) login(c *gin.Context) {
 	var loginDto dto.LoginDto
 
-	if err := c.BindJSON(&loginDto); err != nil {
+	if err := c.ShouldBindJSON(&loginDto); err != nil {
 		l.logger.Errorf("Invalid login request err = %+v", err)
+		abortOnBindError(c, err)
 		return
 	}
 
-	accessToken, refreshToken, err := l.loginService.Login(loginDto.Email, loginDto.Password)
+	result, err := l.loginService.Login(loginDto.Email, loginDto.Password, c.ClientIP())
 	if err != nil {
 		l.logger.Errorf("Login failed err = %+v", err)
-		c.JSON(http.StatusUnauthorized, err.Error())
+		abortWithError(c, err)
 		return
 	}
 
-	c.JSON(http.StatusOK, dto.TokenDto{
-		AccessToken:  accessToken,
-		RefreshToken: refreshToken,
-	})
+	c.JSON(http.StatusOK, tokenDto(result))
 }
 
 // Refresh godoc
 //
 //	@Summary		Refresh Access Token
-//	@Description	Generates a new access token using a valid refresh token
+//	@Description	Exchanges a refresh token for new access and refresh tokens, the used refresh token becomes invalid.
+//	@Description	Using a refresh token twice revokes the whole session.
 //	@Tags			auth
+//	@Accept			json
 //	@Produce		json
-//	@Param			refreshToken	body		string	true	"Refresh Token"
-//	@Success		200				{object}	dto.TokenDto
+//	@Param			refreshDto	body		dto.RefreshDto	true	"Refresh Token"
+//	@Success		200			{object}	dto.TokenDto
+//	@Failure		400
+//	@Failure		401
+//	@Failure		500
 //	@Router			/auth/refresh [post]
 func (l *LoginController) RefreshToken(c *gin.Context) {
-	// TODO: chage refresh scheme to work same as iss to store refresh token in the databse not on chlient
 	var rToken dto.RefreshDto
-	if err := c.BindJSON(&rToken); err != nil {
+	if err := c.ShouldBindJSON(&rToken); err != nil {
 		l.logger.Errorf("Failed to bind refresh token JSON, err %+v", err)
+		abortOnBindError(c, err)
 		return
 	}
-	l.logger.Debugf("Parsed token from body token = %+v", rToken)
 
-	var claims auth.Claims
+	token, refreshNew, err := l.sessionService.Rotate(rToken.RefreshToken)
+	if err != nil {
+		l.logger.Errorf("Refresh failed err = %+v", err)
+		abortWithError(c, err)
+		return
+	}
 
-	_, err := jwt.ParseWithClaims(rToken.RefreshToken, &claims, func(token *jwt.Token) (any, error) {
-		return []byte(config.AppConfig.RefreshKey), nil
+	c.JSON(http.StatusOK, dto.TokenDto{
+		AccessToken:  token,
+		RefreshToken: refreshNew,
 	})
+}
+
+// Logout godoc
+//
+//	@Summary		Logout
+//	@Description	Revokes the session that the refresh token belongs to
+//	@Tags			auth
+//	@Accept			json
+//	@Param			refreshDto	body	dto.RefreshDto	true	"Refresh Token"
+//	@Success		204
+//	@Failure		400
+//	@Failure		401
+//	@Failure		500
+//	@Router			/auth/logout [post]
+func (l *LoginController) logout(c *gin.Context) {
+	var rToken dto.RefreshDto
+	if err := c.ShouldBindJSON(&rToken); err != nil {
+		l.logger.Errorf("Failed to bind refresh token JSON, err %+v", err)
+		abortOnBindError(c, err)
+		return
+	}
+
+	if err := l.sessionService.Revoke(rToken.RefreshToken); err != nil {
+		l.logger.Errorf("Logout failed err = %+v", err)
+		abortWithError(c, err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// ChangePassword godoc
+//
+//	@Summary		Change password
+//	@Description	Sets a new password using the current one, also completes a forced password change.
+//	@Description	All sessions of the user are revoked, log in again with the new password.
+//	@Tags			auth
+//	@Accept			json
+//	@Param			changePasswordDto	body	dto.ChangePasswordDto	true	"Current and new password"
+//	@Success		204
+//	@Failure		400
+//	@Failure		401
+//	@Failure		429
+//	@Failure		500
+//	@Router			/auth/change-password [post]
+func (l *LoginController) changePassword(c *gin.Context) {
+	var changeDto dto.ChangePasswordDto
+	if err := c.ShouldBindJSON(&changeDto); err != nil {
+		l.logger.Errorf("Invalid change password request err = %+v", err)
+		abortOnBindError(c, err)
+		return
+	}
+
+	err := l.passwordService.ChangePassword(changeDto.Email, changeDto.CurrentPassword, changeDto.NewPassword, c.ClientIP())
 	if err != nil {
-		l.logger.Errorf("Error Parsing clames err = %+v", err)
-		c.JSON(http.StatusInternalServerError, err.Error())
+		abortWithError(c, err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// RequestPasswordReset godoc
+//
+//	@Summary		Request password reset
+//	@Description	Sends a password reset token to the user, response is the same whether the email exists or not
+//	@Tags			auth
+//	@Accept			json
+//	@Param			resetRequestDto	body	dto.PasswordResetRequestDto	true	"Email of the account"
+//	@Success		202
+//	@Failure		400
+//	@Failure		500
+//	@Router			/auth/reset-password/request [post]
+func (l *LoginController) requestPasswordReset(c *gin.Context) {
+	var requestDto dto.PasswordResetRequestDto
+	if err := c.ShouldBindJSON(&requestDto); err != nil {
+		l.logger.Errorf("Invalid password reset request err = %+v", err)
+		abortOnBindError(c, err)
 		return
 	}
 
-	userUuid, err := uuid.Parse(claims.Uuid)
+	if err := l.passwordService.RequestReset(requestDto.Email); err != nil {
+		l.logger.Errorf("Password reset request failed err = %+v", err)
+		abortWithError(c, err)
+		return
+	}
+
+	c.Status(http.StatusAccepted)
+}
+
+// ResetPassword godoc
+//
+//	@Summary		Reset password
+//	@Description	Sets a new password using a token from /auth/reset-password/request
+//	@Tags			auth
+//	@Accept			json
+//	@Param			resetDto	body	dto.PasswordResetDto	true	"Reset token and new password"
+//	@Success		204
+//	@Failure		400
+//	@Failure		401
+//	@Failure		500
+//	@Router			/auth/reset-password [post]
+func (l *LoginController) resetPassword(c *gin.Context) {
+	var resetDto dto.PasswordResetDto
+	if err := c.ShouldBindJSON(&resetDto); err != nil {
+		l.logger.Errorf("Invalid password reset err = %+v", err)
+		abortOnBindError(c, err)
+		return
+	}
+
+	if err := l.passwordService.ResetPassword(resetDto.Token, resetDto.NewPassword); err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// EnrollTwoFactor godoc
+//
+//	@Summary		Enroll two-factor authentication at login
+//	@Description	Starts TOTP enrollment for a user whose role requires two-factor authentication, the challenge token
+//	@Description	comes from /auth/login. Add the secret to an authenticator app and finish with /auth/2fa/verify.
+//	@Tags			auth
+//	@Accept			json
+//	@Produce		json
+//	@Param			enrollDto	body		dto.TwoFactorEnrollDto	true	"Challenge token"
+//	@Success		200			{object}	dto.TotpEnrollmentDto
+//	@Failure		400
+//	@Failure		401
+//	@Failure		409
+//	@Failure		500
+//	@Router			/auth/2fa/enroll [post]
+func (l *LoginController) enrollTwoFactor(c *gin.Context) {
+	var enrollDto dto.TwoFactorEnrollDto
+	if err := c.ShouldBindJSON(&enrollDto); err != nil {
+		l.logger.Errorf("Invalid 2FA enroll request err = %+v", err)
+		abortOnBindError(c, err)
+		return
+	}
+
+	enrollment, err := l.twoFactorService.EnrollWithChallenge(enrollDto.ChallengeToken)
 	if err != nil {
-		l.logger.Errorf("Error Parsing uuid err = %+v", err)
-		c.JSON(http.StatusInternalServerError, err.Error())
+		abortWithError(c, err)
 		return
 	}
 
-	token, refreshNew, err := l.loginService.RefreshTokens(&model.User{
-		Uuid:  userUuid,
-		Email: claims.Email,
-		Role:  claims.Role,
+	c.JSON(http.StatusOK, dto.TotpEnrollmentDto{
+		Secret:     enrollment.Secret,
+		OtpauthUri: enrollment.URI,
 	})
+}
+
+// VerifyTwoFactor godoc
+//
+//	@Summary		Verify two-factor authentication
+//	@Description	Completes login with a TOTP code or a recovery code and returns access and refresh tokens.
+//	@Description	When it completes enrollment recovery codes are returned as well, they are shown only once.
+//	@Tags			auth
+//	@Accept			json
+//	@Produce		json
+//	@Param			verifyDto	body		dto.TwoFactorVerifyDto	true	"Challenge token and code"
+//	@Success		200			{object}	dto.TokenDto
+//	@Failure		400
+//	@Failure		401
+//	@Failure		429			"Too many failed attempts, see Retry-After header"
+//	@Failure		500
+//	@Router			/auth/2fa/verify [post]
+func (l *LoginController) verifyTwoFactor(c *gin.Context) {
+	var verifyDto dto.TwoFactorVerifyDto
+	if err := c.ShouldBindJSON(&verifyDto); err != nil {
+		l.logger.Errorf("Invalid 2FA verify request err = %+v", err)
+		abortOnBindError(c, err)
+		return
+	}
+
+	result, err := l.twoFactorService.Verify(verifyDto.ChallengeToken, verifyDto.Code, c.ClientIP())
 	if err != nil {
-		l.logger.Error("Refresh failed err = %+v", err)
-		c.JSON(http.StatusInternalServerError, err.Error())
+		abortWithError(c, err)
 		return
 	}
 
-	c.JSON(http.StatusOK, dto.TokenDto{
-		AccessToken:  token,
-		RefreshToken: refreshNew,
-	})
+	c.JSON(http.StatusOK, tokenDto(result))
+}
+
+func tokenDto(result *service.LoginResult) dto.TokenDto {
+	return dto.TokenDto{
+		AccessToken:        result.AccessToken,
+		RefreshToken:       result.RefreshToken,
+		ChallengeToken:     result.ChallengeToken,
+		EnrollmentRequired: result.EnrollmentRequired,
+		RecoveryCodes:      result.RecoveryCodes,
+	}
 }
@@ -15,15 +15,17 @@ import (
 
 type MedicalRecordController struct {
 	MedicalRecordService service.IMedicalRecordService
+	accessService        service.IAccessService
 	logger               *zap.SugaredLogger
 }
 
 func NewMedicalRecordController() *MedicalRecordController {
 	var controller *MedicalRecordController
 
-	app.Invoke(func(medicalRecordService service.IMedicalRecordService, logger *zap.SugaredLogger) {
+	app.Invoke(func(medicalRecordService service.IMedicalRecordService, accessService service.IAccessService, logger *zap.SugaredLogger) {
 		controller = &MedicalRecordController{
 			MedicalRecordService: medicalRecordService,
+			accessService:        accessService,
 			logger:               logger,
 		}
 	})
@@ -47,28 +49,34 @@ func (m *MedicalRecordController) RegisterEndpoints(router *gin.RouterGroup) {
 //	@Produce		json
 //	@Success		200	{object}	dto.MedicalRecordDto
 //	@Failure		400
+//	@Failure		403
 //	@Failure		404
 //	@Failure		500
 //	@Param			patientOib	path	string	true	"Patient OIB"
 //	@Router			/medical-record/{patientOib} [get]
 func (m *MedicalRecordController) get(c *gin.Context) {
+	actor, ok := getActor(c, m.accessService)
+	if !ok {
+		return
+	}
+
 	patientOib := c.Param("patientOib")
 	if patientOib == "" {
 		m.logger.Error("patientOib path parameter is empty")
-		c.AbortWithError(http.StatusBadRequest, errors.New("patientOib cannot be empty"))
+		abortWithStatus(c, http.StatusBadRequest, errors.New("patientOib cannot be empty"))
 		return
 	}
 
-	record, err := m.MedicalRecordService.Read(patientOib)
+	record, err := m.MedicalRecordService.Read(actor, patientOib)
 	if err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			m.logger.Errorf("Medical record for patient with OIB = %s not found", patientOib)
-			c.AbortWithError(http.StatusNotFound, err)
+			abortWithError(c, err)
 			return
 		}
 
 		m.logger.Errorf("Failed to get medical record for patient with OIB = %s", patientOib)
-		c.AbortWithError(http.StatusInternalServerError, err)
+		abortWithError(c, err)
 		return
 	}
 
@@ -83,31 +91,47 @@ func (m *MedicalRecordController) get(c *gin.Context) {
 //	@Produce	json
 //	@Success	200	{object}	dto.MedicalRecordDto
 //	@Failure	400
+//	@Failure	403
 //	@Failure	404
 //	@Failure	500
 //	@Param		uuid	path	string					true	"uuid of medical record to be updated"
 //	@Param		model	body	dto.MedicalRecordDto	true	"Data for updating medical record"
 //	@Router		/medical-record/{uuid} [put]
 func (m *MedicalRecordController) update(c *gin.Context) {
+	actor, ok := getActor(c, m.accessService)
+	if !ok {
+		return
+	}
+
 	recordUuid, err := uuid.Parse(c.Param("uuid"))
 	if err != nil {
 		m.logger.Errorf("Error parsing UUID = %s", c.Param("uuid"))
-		c.AbortWithError(http.StatusBadRequest, err)
+		abortWithStatus(c, http.StatusBadRequest, err)
 		return
 	}
 
-	data, err := m.MedicalRecordService.Read(recordUuid.String())
-	data.DoctorID = 1
+	var updateDto dto.MedicalRecordDto
+	if err := c.ShouldBindJSON(&updateDto); err != nil {
+		m.logger.Errorf("Failed to bind error = %+v", err)
+		abortOnBindError(c, err)
+		return
+	}
+
+	data, err := updateDto.ToModel()
+	if err != nil {
+		abortWithStatus(c, http.StatusBadRequest, err)
+		return
+	}
 
-	record, err := m.MedicalRecordService.Update(recordUuid, data)
+	record, err := m.MedicalRecordService.Update(actor, recordUuid, data)
 	if err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			m.logger.Errorf("Medical record with uuid = %s not found for update", recordUuid)
-			c.AbortWithError(http.StatusNotFound, err)
+			abortWithError(c, err)
 			return
 		}
 		m.logger.Errorf("Failed to update medical record: %+v", err)
-		c.AbortWithError(http.StatusInternalServerError, err)
+		abortWithError(c, err)
 		return
 	}
 
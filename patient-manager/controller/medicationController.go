@@ -34,26 +34,35 @@ func (mc *MedicationController) RegisterEndpoints(router *gin.RouterGroup) {
 }
 
 // getAll godoc
-// @Summary		List all medications
-// @Description	get all medications available in the system
+// @Summary		List medications of the catalog
+// @Description	get a page of medications of the catalog, sorted by name. q matches prefixes of the name,
+// @Description	the active ingredient or the ATC code, atc matches a prefix of the ATC code (e.g. N02B).
 // @Tags			medications
 // @Produce		json
-// @Success		200	{array}		dto.MedicationListDto
+// @Param			filter	query		dto.MedicationQueryDto	false	"Pagination, sorting and search"
+// @Success		200		{object}	dto.PageDto[dto.MedicationDto]
+// @Failure		400
 // @Failure		500
 // @Router			/medications [get]
 func (mc *MedicationController) getAll(c *gin.Context) {
-	medications, err := mc.medicationService.GetAll()
+	var filter dto.MedicationQueryDto
+	if err := c.ShouldBindQuery(&filter); err != nil {
+		abortOnBindError(c, err)
+		return
+	}
+
+	medications, page, err := mc.medicationService.List(filter)
 	if err != nil {
 		mc.logger.Errorf("Failed to get all medications: %+v", err)
-		c.AbortWithStatus(http.StatusInternalServerError)
+		abortWithError(c, err)
 		return
 	}
 
-	var responseDtos []*dto.MedicationListDto
+	responseDtos := make([]*dto.MedicationDto, 0, len(medications))
 	for _, medication := range medications {
-		dto := (&dto.MedicationListDto{}).FromModel(&medication)
+		dto := (&dto.MedicationDto{}).FromModel(&medication)
 		responseDtos = append(responseDtos, dto)
 	}
 
-	c.JSON(http.StatusOK, responseDtos)
+	c.JSON(http.StatusOK, dto.NewPageDto(responseDtos, page))
 }
@@ -0,0 +1,110 @@
+package controller
+
+import (
+	"PatientManager/app"
+	"PatientManager/config"
+	"PatientManager/service"
+	"PatientManager/util/presign"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// ObjectController serves presigned URLs of storage drivers that can't
+// presign them, it's public because the signature authorizes the request
+type ObjectController struct {
+	bucketService service.IbucketService
+	signer        *presign.Signer
+	logger        *zap.SugaredLogger
+}
+
+func NewObjectController() *ObjectController {
+	var controller *ObjectController
+	app.Invoke(func(bucketService service.IbucketService, signer *presign.Signer, logger *zap.SugaredLogger) {
+		controller = &ObjectController{
+			bucketService: bucketService,
+			signer:        signer,
+			logger:        logger,
+		}
+	})
+	return controller
+}
+
+func (oc *ObjectController) RegisterEndpoints(router *gin.RouterGroup) {
+	objects := router.Group(strings.TrimPrefix(presign.Path, "/api"))
+	{
+		objects.GET("/:name", oc.download)
+		objects.PUT("/:name", oc.upload)
+	}
+}
+
+// download godoc
+//
+//	@Summary		Download an object with a presigned URL
+//	@Description	Serves the object of a URL returned by GET /checkup/image/{name}/url. Supports Range and conditional requests.
+//	@Tags			storage
+//	@Produce		application/octet-stream
+//	@Param			name		path	string	true	"Name of the object"
+//	@Param			expires		query	int		true	"Unix time the URL expires at"
+//	@Param			signature	query	string	true	"Signature of the URL"
+//	@Success		200			{file}	file
+//	@Success		206			{file}	file
+//	@Success		304
+//	@Failure		403			{object}	problem.Problem	"Invalid or expired URL"
+//	@Failure		404			{object}	problem.Problem
+//	@Router			/storage/objects/{name} [get]
+func (oc *ObjectController) download(c *gin.Context) {
+	name := c.Param("name")
+	if err := oc.signer.Verify(http.MethodGet, name, c.Request.URL.Query()); err != nil {
+		abortWithStatus(c, http.StatusForbidden, err)
+		return
+	}
+
+	serveObject(c, oc.bucketService, name, "")
+}
+
+// upload godoc
+//
+//	@Summary		Upload an object with a presigned URL
+//	@Description	Stores the request body as the object of a URL returned by POST /checkup/{uuid}/uploads, the upload is then completed with POST /checkup/{uuid}/uploads/{imageUuid}/complete.
+//	@Tags			storage
+//	@Accept			application/octet-stream
+//	@Param			name		path	string	true	"Name of the object"
+//	@Param			expires		query	int		true	"Unix time the URL expires at"
+//	@Param			signature	query	string	true	"Signature of the URL"
+//	@Success		200
+//	@Failure		403			{object}	problem.Problem	"Invalid or expired URL"
+//	@Failure		413			{object}	problem.Problem	"File is too large"
+//	@Failure		500			{object}	problem.Problem
+//	@Router			/storage/objects/{name} [put]
+func (oc *ObjectController) upload(c *gin.Context) {
+	name := c.Param("name")
+	if err := oc.signer.Verify(http.MethodPut, name, c.Request.URL.Query()); err != nil {
+		abortWithStatus(c, http.StatusForbidden, err)
+		return
+	}
+
+	limit := config.AppConfig.Images.MaxFileSize
+	if c.Request.ContentLength > limit {
+		abortWithStatus(c, http.StatusRequestEntityTooLarge, fmt.Errorf("upload is larger than %d bytes", limit))
+		return
+	}
+	body := http.MaxBytesReader(c.Writer, c.Request.Body, limit)
+
+	err := oc.bucketService.Upload(c.Request.Context(), name, body, c.Request.ContentLength, c.ContentType())
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			abortWithStatus(c, http.StatusRequestEntityTooLarge, fmt.Errorf("upload is larger than %d bytes", maxBytesErr.Limit))
+			return
+		}
+		oc.logger.Errorf("Failed to upload object %s: %v", name, err)
+		abortWithError(c, err)
+		return
+	}
+	c.Status(http.StatusOK)
+}
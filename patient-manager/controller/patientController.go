@@ -4,22 +4,29 @@ import (
 	"PatientManager/app"
 	"PatientManager/dto"
 	"PatientManager/service"
+	"PatientManager/util/cerror"
+	"errors"
 	"net/http"
 	"strconv"
 
 	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
 )
 
 type PatientController struct {
 	patientService service.IPatientService
+	accessService  service.IAccessService
+	exportService  service.IExportService
 }
 
 func NewPatientController() *PatientController {
 	var controller *PatientController
 
-	app.Invoke(func(service service.IPatientService) {
+	app.Invoke(func(service service.IPatientService, accessService service.IAccessService, exportService service.IExportService) {
 		controller = &PatientController{
 			patientService: service,
+			accessService:  accessService,
+			exportService:  exportService,
 		}
 	})
 
@@ -30,31 +37,81 @@ func (c *PatientController) RegisterEndpoints(router *gin.RouterGroup) {
 	patients := router.Group("/patients")
 	{
 		patients.GET("", c.GetAllPatients)
+		patients.GET("/search", c.SearchPatients)
 		patients.GET("/:id", c.GetPatientById)
 		patients.POST("", c.CreatePatient)
 		patients.PUT("/:id", c.UpdatePatient)
 		patients.DELETE("/:id", c.DeletePatient)
+		patients.GET("/:id/export", c.ExportPatient)
+		patients.POST("/:id/shares", c.SharePatient)
+		patients.DELETE("/:id/shares/:doctorUuid", c.UnsharePatient)
 	}
 }
 
 // GetAllPatients godoc
 //
 //	@Summary		List all patients
-//	@Description	get all patients
+//	@Description	get a page of patients, sortable by lastName, firstName, birthDate and createdAt
 //	@Tags			patients
 //	@Produce		json
-//	@Success		200	{array}		dto.PatientDto
-//	@Failure		500	{object}	gin.H
+//	@Param			filter	query		dto.PatientQueryDto	false	"Pagination, sorting and filters"
+//	@Success		200		{object}	dto.PageDto[dto.PatientDto]
+//	@Failure		400		{object}	problem.Problem
+//	@Failure		500		{object}	problem.Problem
 //	@Router			/patients [get]
 func (c *PatientController) GetAllPatients(ctx *gin.Context) {
-	patients, err := c.patientService.GetAllPatients()
+	actor, ok := getActor(ctx, c.accessService)
+	if !ok {
+		return
+	}
+
+	var filter dto.PatientQueryDto
+	if err := ctx.ShouldBindQuery(&filter); err != nil {
+		abortOnBindError(ctx, err)
+		return
+	}
+
+	patients, err := c.patientService.GetAllPatients(actor, filter)
 	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve patients"})
+		abortWithError(ctx, err)
 		return
 	}
 	ctx.JSON(http.StatusOK, patients)
 }
 
+// SearchPatients godoc
+//
+//	@Summary		Search patients
+//	@Description	Finds patients by name (fuzzy), OIB, birth date and medical record UUID.
+//	@Description	Parts of the query are combined, e.g. "horvat 1980-05-01". Results are ranked by score (0-1).
+//	@Tags			patients
+//	@Produce		json
+//	@Param			q		query		string	true	"Search query"
+//	@Param			limit	query		int		false	"Maximum number of results (default 20, max 100)"
+//	@Success		200		{array}		dto.PatientSearchResultDto
+//	@Failure		400		{object}	problem.Problem
+//	@Failure		500		{object}	problem.Problem
+//	@Router			/patients/search [get]
+func (c *PatientController) SearchPatients(ctx *gin.Context) {
+	actor, ok := getActor(ctx, c.accessService)
+	if !ok {
+		return
+	}
+
+	var search dto.PatientSearchDto
+	if err := ctx.ShouldBindQuery(&search); err != nil {
+		abortOnBindError(ctx, err)
+		return
+	}
+
+	results, err := c.patientService.Search(actor, search)
+	if err != nil {
+		abortWithError(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, results)
+}
+
 // GetPatientById godoc
 //
 //	@Summary		Get a patient by ID
@@ -63,19 +120,25 @@ func (c *PatientController) GetAllPatients(ctx *gin.Context) {
 //	@Produce		json
 //	@Param			id	path		int	true	"Patient ID"
 //	@Success		200	{object}	dto.PatientDto
-//	@Failure		400	{object}	gin.H
-//	@Failure		404	{object}	gin.H
+//	@Failure		400	{object}	problem.Problem
+//	@Failure		403	{object}	problem.Problem
+//	@Failure		404	{object}	problem.Problem
 //	@Router			/patients/{id} [get]
 func (c *PatientController) GetPatientById(ctx *gin.Context) {
+	actor, ok := getActor(ctx, c.accessService)
+	if !ok {
+		return
+	}
+
 	id, err := strconv.Atoi(ctx.Param("id"))
 	if err != nil {
-		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid patient ID"})
+		abortWithStatus(ctx, http.StatusBadRequest, errors.New("invalid patient ID"))
 		return
 	}
 
-	patient, err := c.patientService.GetPatientById(uint(id))
+	patient, err := c.patientService.GetPatientById(actor, uint(id))
 	if err != nil {
-		ctx.JSON(http.StatusNotFound, gin.H{"error": "Patient not found"})
+		abortWithError(ctx, err)
 		return
 	}
 
@@ -91,19 +154,25 @@ func (c *PatientController) GetPatientById(ctx *gin.Context) {
 //	@Produce		json
 //	@Param			patient	body		dto.NewPatientDto	true	"New Patient"
 //	@Success		201		{object}	dto.PatientDto
-//	@Failure		400		{object}	gin.H
-//	@Failure		500		{object}	gin.H
+//	@Failure		400		{object}	problem.Problem
+//	@Failure		409		{object}	problem.Problem
+//	@Failure		500		{object}	problem.Problem
 //	@Router			/patients [post]
 func (c *PatientController) CreatePatient(ctx *gin.Context) {
+	actor, ok := getActor(ctx, c.accessService)
+	if !ok {
+		return
+	}
+
 	var newPatient dto.NewPatientDto
 	if err := ctx.ShouldBindJSON(&newPatient); err != nil {
-		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		abortOnBindError(ctx, err)
 		return
 	}
 
-	createdPatient, err := c.patientService.CreatePatient(newPatient)
+	createdPatient, err := c.patientService.CreatePatient(actor, newPatient)
 	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create patient"})
+		abortWithError(ctx, err)
 		return
 	}
 
@@ -118,27 +187,35 @@ func (c *PatientController) CreatePatient(ctx *gin.Context) {
 //	@Accept			json
 //	@Produce		json
 //	@Param			id		path		int				true	"Patient ID"
-//	@Param			patient	body		dto.PatientDto	true	"Patient Data"
+//	@Param			patient	body		dto.UpdatePatientDto	true	"Patient Data"
 //	@Success		200		{object}	dto.PatientDto
-//	@Failure		400		{object}	gin.H
-//	@Failure		500		{object}	gin.H
+//	@Failure		400		{object}	problem.Problem
+//	@Failure		403		{object}	problem.Problem
+//	@Failure		404		{object}	problem.Problem
+//	@Failure		409		{object}	problem.Problem
+//	@Failure		500		{object}	problem.Problem
 //	@Router			/patients/{id} [put]
 func (c *PatientController) UpdatePatient(ctx *gin.Context) {
+	actor, ok := getActor(ctx, c.accessService)
+	if !ok {
+		return
+	}
+
 	id, err := strconv.Atoi(ctx.Param("id"))
 	if err != nil {
-		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid patient ID"})
+		abortWithStatus(ctx, http.StatusBadRequest, errors.New("invalid patient ID"))
 		return
 	}
 
 	var patientDto dto.UpdatePatientDto
 	if err := ctx.ShouldBindJSON(&patientDto); err != nil {
-		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		abortOnBindError(ctx, err)
 		return
 	}
 
-	updatedPatient, err := c.patientService.UpdatePatient(uint(id), patientDto)
+	updatedPatient, err := c.patientService.UpdatePatient(actor, uint(id), patientDto)
 	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update patient"})
+		abortWithError(ctx, err)
 		return
 	}
 
@@ -152,20 +229,156 @@ func (c *PatientController) UpdatePatient(ctx *gin.Context) {
 //	@Tags			patients
 //	@Param			id	path		int	true	"Patient ID"
 //	@Success		204	{object}	nil
-//	@Failure		400	{object}	gin.H
-//	@Failure		500	{object}	gin.H
+//	@Failure		400	{object}	problem.Problem
+//	@Failure		403	{object}	problem.Problem
+//	@Failure		404	{object}	problem.Problem
+//	@Failure		500	{object}	problem.Problem
 //	@Router			/patients/{id} [delete]
 func (c *PatientController) DeletePatient(ctx *gin.Context) {
+	actor, ok := getActor(ctx, c.accessService)
+	if !ok {
+		return
+	}
+
 	id, err := strconv.Atoi(ctx.Param("id"))
 	if err != nil {
-		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid patient ID"})
+		abortWithStatus(ctx, http.StatusBadRequest, errors.New("invalid patient ID"))
 		return
 	}
 
-	if err := c.patientService.DeletePatient(uint(id)); err != nil {
-		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete patient"})
+	if err := c.patientService.DeletePatient(actor, uint(id)); err != nil {
+		abortWithError(ctx, err)
 		return
 	}
 
 	ctx.JSON(http.StatusNoContent, nil)
 }
+
+// ExportPatient godoc
+//
+//	@Summary		Export all data of a patient
+//	@Description	Streams a ZIP archive with patient.json, medical-record.json, illnesses.json (with prescriptions
+//	@Description	and medications), checkups.json and checkup images in images/. The export is recorded in the audit log.
+//	@Tags			patients
+//	@Produce		application/zip
+//	@Param			id	path		string	true	"Patient UUID"
+//	@Success		200	{file}		file
+//	@Failure		400	{object}	problem.Problem
+//	@Failure		403	{object}	problem.Problem
+//	@Failure		404	{object}	problem.Problem
+//	@Failure		500	{object}	problem.Problem
+//	@Router			/patients/{id}/export [get]
+func (c *PatientController) ExportPatient(ctx *gin.Context) {
+	actor, ok := getActor(ctx, c.accessService)
+	if !ok {
+		return
+	}
+
+	// gin requires the same wildcard name as the other patient routes
+	patientUuid, err := uuid.Parse(ctx.Param("id"))
+	if err != nil {
+		abortWithStatus(ctx, http.StatusBadRequest, errors.New("invalid patient UUID"))
+		return
+	}
+
+	export, err := c.exportService.Export(actor, patientUuid)
+	if err != nil {
+		abortWithError(ctx, err)
+		return
+	}
+
+	writeExport(ctx, export)
+}
+
+// SharePatient godoc
+//
+//	@Summary		Share a patient with another doctor
+//	@Description	give another doctor access to the patient, allowed for the assigned doctor and superadmin
+//	@Tags			patients
+//	@Accept			json
+//	@Param			id		path		int					true	"Patient ID"
+//	@Param			share	body		dto.PatientShareDto	true	"Doctor to share the patient with"
+//	@Success		204		{object}	nil
+//	@Failure		400		{object}	problem.Problem
+//	@Failure		403		{object}	problem.Problem
+//	@Failure		404		{object}	problem.Problem
+//	@Failure		500		{object}	problem.Problem
+//	@Router			/patients/{id}/shares [post]
+func (c *PatientController) SharePatient(ctx *gin.Context) {
+	actor, ok := getActor(ctx, c.accessService)
+	if !ok {
+		return
+	}
+
+	id, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		abortWithStatus(ctx, http.StatusBadRequest, errors.New("invalid patient ID"))
+		return
+	}
+
+	var shareDto dto.PatientShareDto
+	if err := ctx.ShouldBindJSON(&shareDto); err != nil {
+		abortOnBindError(ctx, err)
+		return
+	}
+
+	doctorUuid, err := uuid.Parse(shareDto.DoctorUuid)
+	if err != nil {
+		abortWithStatus(ctx, http.StatusBadRequest, errors.New("invalid doctor UUID"))
+		return
+	}
+
+	if err := c.accessService.SharePatient(actor, uint(id), doctorUuid); err != nil {
+		if errors.Is(err, cerror.ErrBadRole) {
+			abortWithStatus(ctx, http.StatusBadRequest, errors.New("patients can only be shared with doctors"))
+			return
+		}
+		abortWithError(ctx, err)
+		return
+	}
+
+	ctx.Status(http.StatusNoContent)
+}
+
+// UnsharePatient godoc
+//
+//	@Summary		Revoke a patient share
+//	@Description	remove access to the patient given to another doctor
+//	@Tags			patients
+//	@Param			id			path		int		true	"Patient ID"
+//	@Param			doctorUuid	path		string	true	"Doctor UUID"
+//	@Success		204			{object}	nil
+//	@Failure		400			{object}	problem.Problem
+//	@Failure		403			{object}	problem.Problem
+//	@Failure		404			{object}	problem.Problem
+//	@Failure		500			{object}	problem.Problem
+//	@Router			/patients/{id}/shares/{doctorUuid} [delete]
+func (c *PatientController) UnsharePatient(ctx *gin.Context) {
+	actor, ok := getActor(ctx, c.accessService)
+	if !ok {
+		return
+	}
+
+	id, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		abortWithStatus(ctx, http.StatusBadRequest, errors.New("invalid patient ID"))
+		return
+	}
+
+	doctorUuid, err := uuid.Parse(ctx.Param("doctorUuid"))
+	if err != nil {
+		abortWithStatus(ctx, http.StatusBadRequest, errors.New("invalid doctor UUID"))
+		return
+	}
+
+	if err := c.accessService.UnsharePatient(actor, uint(id), doctorUuid); err != nil {
+		if errors.Is(err, cerror.ErrBadRole) {
+			abortWithStatus(ctx, http.StatusBadRequest, errors.New("patients can only be shared with doctors"))
+			return
+		}
+		abortWithError(ctx, err)
+		return
+	}
+
+	ctx.Status(http.StatusNoContent)
+}
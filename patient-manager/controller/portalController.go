@@ -0,0 +1,318 @@
+package controller
+
+import (
+	"PatientManager/app"
+	"PatientManager/dto"
+	"PatientManager/service"
+	"PatientManager/util/cerror"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+const portalImagePrefix = "/api/me/images/"
+
+type PortalController struct {
+	portalService  service.IPortalService
+	exportService  service.IExportService
+	checkupService service.ICheckupService
+	bucketService  service.IbucketService
+	accessService  service.IAccessService
+	logger         *zap.SugaredLogger
+}
+
+func NewPortalController() *PortalController {
+	var controller *PortalController
+
+	app.Invoke(func(
+		portalService service.IPortalService,
+		exportService service.IExportService,
+		checkupService service.ICheckupService,
+		bucketService service.IbucketService,
+		accessService service.IAccessService,
+		logger *zap.SugaredLogger,
+	) {
+		controller = &PortalController{
+			portalService:  portalService,
+			exportService:  exportService,
+			checkupService: checkupService,
+			bucketService:  bucketService,
+			accessService:  accessService,
+			logger:         logger,
+		}
+	})
+
+	return controller
+}
+
+func (pc *PortalController) RegisterEndpoints(router *gin.RouterGroup) {
+	me := router.Group("/me")
+	{
+		me.POST("/link", pc.link)
+		me.GET("/patient", pc.getPatient)
+		me.GET("/record", pc.getRecord)
+		me.GET("/checkups", pc.getCheckups)
+		me.GET("/illnesses", pc.getIllnesses)
+		me.GET("/prescriptions", pc.getPrescriptions)
+		me.GET("/images/:name", pc.getImage)
+		me.GET("/export", pc.export)
+	}
+}
+
+// link godoc
+//
+//	@Summary		Link account to patient
+//	@Description	Links the logged-in patient account to the patient with the same OIB
+//	@Tags			me
+//	@Produce		json
+//	@Success		200	{object}	dto.PatientDto
+//	@Failure		401
+//	@Failure		404
+//	@Failure		409
+//	@Failure		500
+//	@Router			/me/link [post]
+func (pc *PortalController) link(c *gin.Context) {
+	actor, ok := getActor(c, pc.accessService)
+	if !ok {
+		return
+	}
+
+	patient, err := pc.portalService.Link(actor)
+	if err != nil {
+		pc.abortWithError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, dto.FromModel(patient))
+}
+
+// getPatient godoc
+//
+//	@Summary		Get my patient data
+//	@Description	Returns patient data linked to the logged-in account
+//	@Tags			me
+//	@Produce		json
+//	@Success		200	{object}	dto.PatientDto
+//	@Failure		401
+//	@Failure		404
+//	@Failure		500
+//	@Router			/me/patient [get]
+func (pc *PortalController) getPatient(c *gin.Context) {
+	actor, ok := getActor(c, pc.accessService)
+	if !ok {
+		return
+	}
+
+	patient, err := pc.portalService.GetPatient(actor)
+	if err != nil {
+		pc.abortWithError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, dto.FromModel(patient))
+}
+
+// getRecord godoc
+//
+//	@Summary		Get my medical record
+//	@Description	Returns the medical record of the patient linked to the logged-in account
+//	@Tags			me
+//	@Produce		json
+//	@Success		200	{object}	dto.MedicalRecordDto
+//	@Failure		401
+//	@Failure		404
+//	@Failure		500
+//	@Router			/me/record [get]
+func (pc *PortalController) getRecord(c *gin.Context) {
+	actor, ok := getActor(c, pc.accessService)
+	if !ok {
+		return
+	}
+
+	record, err := pc.portalService.GetRecord(actor)
+	if err != nil {
+		pc.abortWithError(c, err)
+		return
+	}
+
+	var responseDto dto.MedicalRecordDto
+	c.JSON(http.StatusOK, responseDto.FromModel(record))
+}
+
+// getCheckups godoc
+//
+//	@Summary		Get my checkups
+//	@Description	Returns checkups of the patient linked to the logged-in account with links to their images
+//	@Tags			me
+//	@Produce		json
+//	@Success		200	{array}	dto.CheckupDto
+//	@Failure		401
+//	@Failure		404
+//	@Failure		500
+//	@Router			/me/checkups [get]
+func (pc *PortalController) getCheckups(c *gin.Context) {
+	actor, ok := getActor(c, pc.accessService)
+	if !ok {
+		return
+	}
+
+	checkups, err := pc.portalService.GetCheckups(actor)
+	if err != nil {
+		pc.abortWithError(c, err)
+		return
+	}
+
+	responseDtos := make([]*dto.CheckupDto, 0, len(checkups))
+	for _, checkup := range checkups {
+		responseDtos = append(responseDtos, (&dto.CheckupDto{}).FromModel(&checkup).WithImageLinks(portalImagePrefix))
+	}
+
+	c.JSON(http.StatusOK, responseDtos)
+}
+
+// getIllnesses godoc
+//
+//	@Summary		Get my illnesses
+//	@Description	Returns illnesses of the patient linked to the logged-in account
+//	@Tags			me
+//	@Produce		json
+//	@Success		200	{array}	dto.IllnessListDto
+//	@Failure		401
+//	@Failure		404
+//	@Failure		500
+//	@Router			/me/illnesses [get]
+func (pc *PortalController) getIllnesses(c *gin.Context) {
+	actor, ok := getActor(c, pc.accessService)
+	if !ok {
+		return
+	}
+
+	illnesses, err := pc.portalService.GetIllnesses(actor)
+	if err != nil {
+		pc.abortWithError(c, err)
+		return
+	}
+
+	responseDtos := make([]*dto.IllnessListDto, 0, len(illnesses))
+	for _, illness := range illnesses {
+		responseDtos = append(responseDtos, (&dto.IllnessListDto{}).FromModel(&illness))
+	}
+
+	c.JSON(http.StatusOK, responseDtos)
+}
+
+// getPrescriptions godoc
+//
+//	@Summary		Get my prescriptions
+//	@Description	Returns prescriptions of the patient linked to the logged-in account
+//	@Tags			me
+//	@Produce		json
+//	@Success		200	{array}	dto.PrescriptionListDto
+//	@Failure		401
+//	@Failure		404
+//	@Failure		500
+//	@Router			/me/prescriptions [get]
+func (pc *PortalController) getPrescriptions(c *gin.Context) {
+	actor, ok := getActor(c, pc.accessService)
+	if !ok {
+		return
+	}
+
+	prescriptions, err := pc.portalService.GetPrescriptions(actor)
+	if err != nil {
+		pc.abortWithError(c, err)
+		return
+	}
+
+	responseDtos := make([]*dto.PrescriptionListDto, 0, len(prescriptions))
+	for _, prescription := range prescriptions {
+		responseDtos = append(responseDtos, (&dto.PrescriptionListDto{}).FromModel(&prescription))
+	}
+
+	c.JSON(http.StatusOK, responseDtos)
+}
+
+// getImage godoc
+//
+//	@Summary		Get my checkup image
+//	@Description	Serves an image of a checkup belonging to the patient linked to the logged-in account
+//	@Description	Supports Range requests and conditional requests with If-None-Match, size=thumb serves the thumbnail.
+//	@Tags			me
+//	@Produce		image/png
+//	@Produce		image/jpeg
+//	@Produce		application/octet-stream
+//	@Success		200	{file}	file
+//	@Success		206	{file}	file
+//	@Success		304
+//	@Failure		400
+//	@Failure		401
+//	@Failure		403
+//	@Failure		404
+//	@Failure		500
+//	@Param			name	path	string	true	"The unique name of the image file"
+//	@Param			size	query	string	false	"original (default) or thumb"	Enums(original, thumb)
+//	@Router			/me/images/{name} [get]
+func (pc *PortalController) getImage(c *gin.Context) {
+	actor, ok := getActor(c, pc.accessService)
+	if !ok {
+		return
+	}
+
+	name := c.Param("name")
+	image, err := pc.checkupService.GetImage(actor, name)
+	if err != nil {
+		pc.abortWithError(c, err)
+		return
+	}
+
+	serveImage(c, pc.bucketService, image, name)
+}
+
+func (pc *PortalController) abortWithError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, cerror.ErrPatientNotLinked), errors.Is(err, gorm.ErrRecordNotFound):
+		abortWithStatus(c, http.StatusNotFound, err)
+
+	case errors.Is(err, cerror.ErrForbidden), errors.Is(err, cerror.ErrBadRole):
+		abortWithStatus(c, http.StatusForbidden, err)
+
+	default:
+		pc.logger.Errorf("Portal request failed, err = %+v", err)
+		abortWithError(c, err)
+	}
+}
+
+// export godoc
+//
+//	@Summary		Export my data
+//	@Description	Streams a ZIP archive with all data of the linked patient, see GET /patients/{id}/export
+//	@Tags			me
+//	@Produce		application/zip
+//	@Success		200	{file}	file
+//	@Failure		401
+//	@Failure		404
+//	@Failure		500
+//	@Router			/me/export [get]
+func (pc *PortalController) export(c *gin.Context) {
+	actor, ok := getActor(c, pc.accessService)
+	if !ok {
+		return
+	}
+
+	patient, err := pc.portalService.GetPatient(actor)
+	if err != nil {
+		pc.abortWithError(c, err)
+		return
+	}
+
+	export, err := pc.exportService.Export(actor, patient.Uuid)
+	if err != nil {
+		pc.abortWithError(c, err)
+		return
+	}
+
+	writeExport(c, export)
+}
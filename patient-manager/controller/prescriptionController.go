@@ -4,6 +4,7 @@ import (
 	"PatientManager/app"
 	"PatientManager/dto"
 	"PatientManager/service"
+	"errors"
 	"net/http"
 	"strconv"
 
@@ -14,14 +15,16 @@ import (
 
 type PrescriptionController struct {
 	prescriptionService service.IPrescriptionService
+	accessService       service.IAccessService
 	logger              *zap.SugaredLogger
 }
 
 func NewPrescriptionController() *PrescriptionController {
 	var controller *PrescriptionController
-	app.Invoke(func(prescriptionService service.IPrescriptionService, logger *zap.SugaredLogger) {
+	app.Invoke(func(prescriptionService service.IPrescriptionService, accessService service.IAccessService, logger *zap.SugaredLogger) {
 		controller = &PrescriptionController{
 			prescriptionService: prescriptionService,
+			accessService:       accessService,
 			logger:              logger,
 		}
 	})
@@ -45,20 +48,27 @@ func (pc *PrescriptionController) RegisterEndpoints(router *gin.RouterGroup) {
 // @Produce		json
 // @Param			model	body		dto.CreatePrescriptionDto	true	"Data for new prescription"
 // @Success		201		{object}	dto.PrescriptionListDto
-// @Failure		400		{object}	gin.H
-// @Failure		500		{object}	gin.H
+// @Failure		400		{object}	problem.Problem
+// @Failure		403		{object}	problem.Problem
+// @Failure		404		{object}	problem.Problem
+// @Failure		500		{object}	problem.Problem
 // @Router			/prescriptions [post]
 func (pc *PrescriptionController) create(c *gin.Context) {
+	actor, ok := getActor(c, pc.accessService)
+	if !ok {
+		return
+	}
+
 	var createDto dto.CreatePrescriptionDto
 	if err := c.ShouldBindJSON(&createDto); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		abortOnBindError(c, err)
 		return
 	}
 
 	prescriptionModel := createDto.ToModel()
-	createdPrescription, err := pc.prescriptionService.Create(prescriptionModel, createDto.MedicationUuids)
+	createdPrescription, err := pc.prescriptionService.Create(actor, prescriptionModel, createDto.MedicationUuids)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create prescription"})
+		abortWithError(c, err)
 		return
 	}
 
@@ -73,19 +83,26 @@ func (pc *PrescriptionController) create(c *gin.Context) {
 // @Produce		json
 // @Param			illnessId	path		int	true	"Illness ID"
 // @Success		200			{array}		dto.PrescriptionListDto
-// @Failure		400			{object}	gin.H
-// @Failure		500			{object}	gin.H
+// @Failure		400			{object}	problem.Problem
+// @Failure		403			{object}	problem.Problem
+// @Failure		404			{object}	problem.Problem
+// @Failure		500			{object}	problem.Problem
 // @Router			/prescriptions/illness/{illnessId} [get]
 func (pc *PrescriptionController) getAllForIllness(c *gin.Context) {
+	actor, ok := getActor(c, pc.accessService)
+	if !ok {
+		return
+	}
+
 	illnessId, err := strconv.ParseUint(c.Param("illnessId"), 10, 32)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid illness ID"})
+		abortWithStatus(c, http.StatusBadRequest, errors.New("invalid illness ID"))
 		return
 	}
 
-	prescriptions, err := pc.prescriptionService.GetAllForIllness(uint(illnessId))
+	prescriptions, err := pc.prescriptionService.GetAllForIllness(actor, uint(illnessId))
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve prescriptions"})
+		abortWithError(c, err)
 		return
 	}
 
@@ -102,18 +119,25 @@ func (pc *PrescriptionController) getAllForIllness(c *gin.Context) {
 // @Tags			prescriptions
 // @Param			uuid	path	string	true	"Prescription UUID"
 // @Success		204
-// @Failure		400	{object}	gin.H
-// @Failure		500	{object}	gin.H
+// @Failure		400	{object}	problem.Problem
+// @Failure		403	{object}	problem.Problem
+// @Failure		404	{object}	problem.Problem
+// @Failure		500	{object}	problem.Problem
 // @Router			/prescriptions/{uuid} [delete]
 func (pc *PrescriptionController) delete(c *gin.Context) {
+	actor, ok := getActor(c, pc.accessService)
+	if !ok {
+		return
+	}
+
 	prescriptionUuid, err := uuid.Parse(c.Param("uuid"))
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid UUID format"})
+		abortWithStatus(c, http.StatusBadRequest, errors.New("invalid UUID format"))
 		return
 	}
 
-	if err := pc.prescriptionService.Delete(prescriptionUuid); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete prescription"})
+	if err := pc.prescriptionService.Delete(actor, prescriptionUuid); err != nil {
+		abortWithError(c, err)
 		return
 	}
 	c.Status(http.StatusNoContent)
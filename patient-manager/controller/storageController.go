@@ -0,0 +1,50 @@
+package controller
+
+import (
+	"PatientManager/app"
+	"PatientManager/service"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+type StorageController struct {
+	reconcileService service.IReconcileService
+}
+
+func NewStorageController() *StorageController {
+	var controller *StorageController
+	app.Invoke(func(reconcileService service.IReconcileService) {
+		controller = &StorageController{
+			reconcileService: reconcileService,
+		}
+	})
+	return controller
+}
+
+func (sc *StorageController) RegisterEndpoints(router *gin.RouterGroup) {
+	storage := router.Group("/storage")
+	{
+		storage.POST("/reconcile", sc.reconcile)
+	}
+}
+
+// reconcile godoc
+//
+//	@Summary		Reconcile stored images
+//	@Description	Completes or removes images whose upload was interrupted, clears previews and thumbnails that don't exist and deletes objects without an image.
+//	@Description	Objects of images that are missing from the bucket are only reported. Images and objects younger than an hour are skipped. The same runs every STORAGE_RECONCILE_INTERVAL.
+//	@Tags			storage
+//	@Produce		json
+//	@Success		200	{object}	dto.StorageReportDto
+//	@Failure		403	{object}	problem.Problem
+//	@Failure		500	{object}	problem.Problem
+//	@Router			/storage/reconcile [post]
+func (sc *StorageController) reconcile(c *gin.Context) {
+	report, err := sc.reconcileService.Reconcile(c.Request.Context())
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, report)
+}
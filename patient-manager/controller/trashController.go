@@ -0,0 +1,101 @@
+package controller
+
+import (
+	"PatientManager/app"
+	"PatientManager/dto"
+	"PatientManager/service"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+type TrashController struct {
+	trashService  service.ITrashService
+	accessService service.IAccessService
+}
+
+func NewTrashController() *TrashController {
+	var controller *TrashController
+	app.Invoke(func(trashService service.ITrashService, accessService service.IAccessService) {
+		controller = &TrashController{
+			trashService:  trashService,
+			accessService: accessService,
+		}
+	})
+	return controller
+}
+
+func (tc *TrashController) RegisterEndpoints(router *gin.RouterGroup) {
+	trash := router.Group("/trash")
+	{
+		trash.GET("", tc.list)
+		trash.POST("/:type/:uuid/restore", tc.restore)
+	}
+}
+
+// list godoc
+//
+//	@Summary		List deleted entities
+//	@Description	Returns a page of soft deleted entities of one type that can be restored until they are purged.
+//	@Description	Checkups, illnesses and prescriptions deleted together with their parent are restored with it and aren't listed.
+//	@Tags			trash
+//	@Produce		json
+//	@Param			filter	query		dto.TrashQueryDto	true	"Entity type, pagination and sorting"
+//	@Success		200		{object}	dto.PageDto[dto.DeletedEntityDto]
+//	@Failure		400		{object}	problem.Problem
+//	@Failure		403		{object}	problem.Problem
+//	@Failure		500		{object}	problem.Problem
+//	@Router			/trash [get]
+func (tc *TrashController) list(c *gin.Context) {
+	actor, ok := getActor(c, tc.accessService)
+	if !ok {
+		return
+	}
+
+	var filter dto.TrashQueryDto
+	if err := c.ShouldBindQuery(&filter); err != nil {
+		abortOnBindError(c, err)
+		return
+	}
+
+	entities, err := tc.trashService.List(actor, filter)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, entities)
+}
+
+// restore godoc
+//
+//	@Summary		Restore a deleted entity
+//	@Description	Restores the entity with everything that was deleted with it, e.g. a patient with its medical record, checkups, images, illnesses and prescriptions.
+//	@Tags			trash
+//	@Param			type	path	string	true	"Entity type"	Enums(patient, checkup, illness, prescription)
+//	@Param			uuid	path	string	true	"Entity UUID"
+//	@Success		204
+//	@Failure		400	{object}	problem.Problem
+//	@Failure		403	{object}	problem.Problem
+//	@Failure		404	{object}	problem.Problem
+//	@Failure		409	{object}	problem.Problem	"Parent entity is deleted"
+//	@Failure		500	{object}	problem.Problem
+//	@Router			/trash/{type}/{uuid}/restore [post]
+func (tc *TrashController) restore(c *gin.Context) {
+	actor, ok := getActor(c, tc.accessService)
+	if !ok {
+		return
+	}
+
+	var entity dto.TrashEntityDto
+	if err := c.ShouldBindUri(&entity); err != nil {
+		abortOnBindError(c, err)
+		return
+	}
+
+	if err := tc.trashService.Restore(actor, entity.Type, uuid.MustParse(entity.Uuid)); err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
@@ -5,6 +5,7 @@ import (
 	"PatientManager/dto"
 	"PatientManager/service"
 	"PatientManager/util/auth"
+	"PatientManager/util/middleware"
 	"errors"
 	"net/http"
 
@@ -15,19 +16,37 @@ import (
 )
 
 type UserController struct {
-	UserCrud service.IUserCrudService
-	logger   *zap.SugaredLogger
+	UserCrud         service.IUserCrudService
+	sessionService   service.ISessionService
+	passwordService  service.IPasswordService
+	throttleService  service.ILoginThrottleService
+	twoFactorService service.ITwoFactorService
+	accessService    service.IAccessService
+	logger           *zap.SugaredLogger
 }
 
 func NewUserController() *UserController {
 	var controller *UserController
 
 	// Call dependency injection
-	app.Invoke(func(UserService service.IUserCrudService, logger *zap.SugaredLogger) {
+	app.Invoke(func(
+		UserService service.IUserCrudService,
+		sessionService service.ISessionService,
+		passwordService service.IPasswordService,
+		throttleService service.ILoginThrottleService,
+		twoFactorService service.ITwoFactorService,
+		accessService service.IAccessService,
+		logger *zap.SugaredLogger,
+	) {
 		// create controller
 		controller = &UserController{
-			UserCrud: UserService,
-			logger:   logger,
+			UserCrud:         UserService,
+			sessionService:   sessionService,
+			passwordService:  passwordService,
+			throttleService:  throttleService,
+			twoFactorService: twoFactorService,
+			accessService:    accessService,
+			logger:           logger,
 		}
 	})
 
@@ -42,6 +61,11 @@ func (u *UserController) RegisterEndpoints(router *gin.RouterGroup) {
 		user.GET("/my-data", u.getLoggedInUser)
 		user.PUT("/:uuid", u.update)
 		user.DELETE("/:uuid", u.delete)
+		user.DELETE("/:uuid/sessions", u.revokeSessions)
+		user.POST("/:uuid/unlock", u.unlock)
+		user.POST("/2fa/enroll", u.enrollTwoFactor)
+		user.POST("/2fa/confirm", u.confirmTwoFactor)
+		user.DELETE("/:uuid/2fa", u.resetTwoFactor)
 	}
 }
 
@@ -53,15 +77,21 @@ func (u *UserController) RegisterEndpoints(router *gin.RouterGroup) {
 //	@Produce		json
 //	@Success		200	{object}	dto.UserDto
 //	@Failure		400
+//	@Failure		403
 //	@Failure		404
 //	@Failure		500
 //	@Param			uuid	path	string	true	"user uuid"
 //	@Router			/user/{uuid} [get]
 func (u *UserController) get(c *gin.Context) {
+	actor, ok := getActor(c, u.accessService)
+	if !ok {
+		return
+	}
+
 	userUuid, err := uuid.Parse(c.Param("uuid"))
 	if err != nil {
 		u.logger.Errorf("error parsing uuid value = %s", c.Param("uuid"))
-		c.AbortWithError(http.StatusBadRequest, err)
+		abortWithStatus(c, http.StatusBadRequest, err)
 		return
 	}
 
@@ -69,12 +99,17 @@ func (u *UserController) get(c *gin.Context) {
 	if err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			u.logger.Errorf("User with uuid = %s not found", userUuid)
-			c.AbortWithError(http.StatusNotFound, err)
+			abortWithError(c, err)
 			return
 		}
 
 		u.logger.Errorf("Failed to get user with uuid = %s", userUuid)
-		c.AbortWithError(http.StatusInternalServerError, err)
+		abortWithError(c, err)
+		return
+	}
+
+	if err := u.accessService.CheckUser(actor, user); err != nil {
+		abortWithError(c, err)
 		return
 	}
 
@@ -84,34 +119,67 @@ func (u *UserController) get(c *gin.Context) {
 
 // UserExample godoc
 //
-//	@Summary	Create new user
-//	@Tags		user
-//	@Produce	json
-//	@Success	201	{object}	dto.UserDto
-//	@Failure	400
-//	@Failure	404
-//	@Failure	500
-//	@Param		model	body	dto.NewUserDto	true	"Data for new user"
-//	@Router		/user [post]
+//	@Summary		Create new user
+//	@Description	Creates a user that must change the password on first login. If password is empty
+//	@Description	a temporary one is generated and sent to the user. Doctors can only create patient users
+//	@Description	for patients they can access.
+//	@Tags			user
+//	@Produce		json
+//	@Success		201	{object}	dto.UserDto
+//	@Failure		400	{object}	problem.Problem
+//	@Failure		403
+//	@Failure		404
+//	@Failure		409
+//	@Failure		500
+//	@Param			model	body	dto.NewUserDto	true	"Data for new user"
+//	@Router			/user [post]
 func (u *UserController) create(c *gin.Context) {
+	actor, ok := getActor(c, u.accessService)
+	if !ok {
+		return
+	}
+
 	var dto dto.NewUserDto
-	if err := c.BindJSON(&dto); err != nil {
+	if err := c.ShouldBindJSON(&dto); err != nil {
 		u.logger.Errorf("Failed to bind error = %+v", err)
+		abortOnBindError(c, err)
 		return
 	}
 
 	newUser, err := dto.ToModel()
 	if err != nil {
-		c.AbortWithError(http.StatusBadRequest, err)
+		abortWithStatus(c, http.StatusBadRequest, err)
 		return
 	}
 
-	user, err := u.UserCrud.Create(newUser, "Pa$$w0rd")
+	if err := u.accessService.CheckUserCreation(actor, newUser); err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	password := dto.Password
+	generated := password == ""
+	if generated {
+		if password, err = auth.GeneratePassword(); err != nil {
+			abortWithError(c, err)
+			return
+		}
+	}
+	newUser.MustChangePassword = true
+
+	user, err := u.UserCrud.Create(newUser, password)
 	if err != nil {
-		c.AbortWithError(http.StatusInternalServerError, err)
+		abortWithError(c, err)
 		return
 	}
 
+	if generated {
+		if err := u.passwordService.SendTemporaryPassword(user, password); err != nil {
+			abortWithError(c, err)
+			return
+		}
+	}
+
 	// Log the response for debugging
 	responseDto := dto.FromModel(user)
 	u.logger.Infof("Response DTO: %+v", responseDto)
@@ -127,6 +195,7 @@ func (u *UserController) create(c *gin.Context) {
 //	@Success	200	{object}	dto.UserDto
 //	@Failure	400
 //	@Failure	404
+//	@Failure	409
 //	@Failure	500
 //	@Param		uuid	path	string		true	"uuid of user to be updated"
 //	@Param		model	body	dto.UserDto	true	"Data for updating user"
@@ -135,25 +204,26 @@ func (u *UserController) update(c *gin.Context) {
 	userUuid, err := uuid.Parse(c.Param("uuid"))
 	if err != nil {
 		u.logger.Errorf("Error parsing UUID = %s", c.Param("uuid"))
-		c.AbortWithError(http.StatusBadRequest, err)
+		abortWithStatus(c, http.StatusBadRequest, err)
 		return
 	}
 
 	var dto dto.UserDto
-	if err := c.BindJSON(&dto); err != nil {
+	if err := c.ShouldBindJSON(&dto); err != nil {
 		u.logger.Errorf("Failed to bind error = %+v", err)
+		abortOnBindError(c, err)
 		return
 	}
 
 	newUser, err := dto.ToModel()
 	if err != nil {
-		c.AbortWithError(http.StatusBadRequest, err)
+		abortWithStatus(c, http.StatusBadRequest, err)
 		return
 	}
 
 	user, err := u.UserCrud.Update(userUuid, newUser)
 	if err != nil {
-		c.AbortWithError(http.StatusInternalServerError, err)
+		abortWithError(c, err)
 		return
 	}
 
@@ -176,7 +246,7 @@ func (u *UserController) delete(c *gin.Context) {
 	userUuid, err := uuid.Parse(c.Param("uuid"))
 	if err != nil {
 		u.logger.Errorf("error parsing uuid value = %s", c.Param("uuid"))
-		c.AbortWithError(http.StatusBadRequest, err)
+		abortWithStatus(c, http.StatusBadRequest, err)
 		return
 	}
 
@@ -184,18 +254,202 @@ func (u *UserController) delete(c *gin.Context) {
 	if err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			u.logger.Errorf("User with uuid = %s not found", userUuid)
-			c.AbortWithError(http.StatusNotFound, err)
+			abortWithError(c, err)
 			return
 		}
 
 		u.logger.Errorf("Failed to delete user with uuid = %s", userUuid)
-		c.AbortWithError(http.StatusInternalServerError, err)
+		abortWithError(c, err)
 		return
 	}
 
 	c.JSON(http.StatusNoContent, nil)
 }
 
+// UserExample  godoc
+//
+//	@Summary		revoke all sessions of user
+//	@Description	revokes every refresh token of the user, they have to log in again once their access token expires
+//	@Tags			user
+//	@Success		204
+//	@Failure		400
+//	@Failure		404
+//	@Failure		500
+//	@Param			uuid	path	string	true	"user uuid"
+//	@Router			/user/{uuid}/sessions [delete]
+func (u *UserController) revokeSessions(c *gin.Context) {
+	userUuid, err := uuid.Parse(c.Param("uuid"))
+	if err != nil {
+		u.logger.Errorf("error parsing uuid value = %s", c.Param("uuid"))
+		abortWithStatus(c, http.StatusBadRequest, err)
+		return
+	}
+
+	user, err := u.UserCrud.Read(userUuid)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			u.logger.Errorf("User with uuid = %s not found", userUuid)
+			abortWithError(c, err)
+			return
+		}
+
+		u.logger.Errorf("Failed to get user with uuid = %s", userUuid)
+		abortWithError(c, err)
+		return
+	}
+
+	if err := u.sessionService.RevokeAll(user.ID); err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// UserExample  godoc
+//
+//	@Summary		unlock user
+//	@Description	clears failed login attempts and lockout of the user's account
+//	@Tags			user
+//	@Success		204
+//	@Failure		400
+//	@Failure		404
+//	@Failure		500
+//	@Param			uuid	path	string	true	"user uuid"
+//	@Router			/user/{uuid}/unlock [post]
+func (u *UserController) unlock(c *gin.Context) {
+	actor, ok := getActor(c, u.accessService)
+	if !ok {
+		return
+	}
+
+	userUuid, err := uuid.Parse(c.Param("uuid"))
+	if err != nil {
+		u.logger.Errorf("error parsing uuid value = %s", c.Param("uuid"))
+		abortWithStatus(c, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := u.throttleService.Unlock(actor, userUuid); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			u.logger.Errorf("User with uuid = %s not found", userUuid)
+			abortWithError(c, err)
+			return
+		}
+
+		u.logger.Errorf("Failed to unlock user with uuid = %s", userUuid)
+		abortWithError(c, err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// UserExample  godoc
+//
+//	@Summary		enroll two-factor authentication
+//	@Description	generates a TOTP secret for the logged-in user, it's enabled once confirmed with /user/2fa/confirm
+//	@Tags			user
+//	@Produce		json
+//	@Success		200	{object}	dto.TotpEnrollmentDto
+//	@Failure		401
+//	@Failure		403
+//	@Failure		409
+//	@Failure		500
+//	@Router			/user/2fa/enroll [post]
+func (u *UserController) enrollTwoFactor(c *gin.Context) {
+	actor, ok := getActor(c, u.accessService)
+	if !ok {
+		return
+	}
+
+	enrollment, err := u.twoFactorService.Enroll(actor.UserID)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, dto.TotpEnrollmentDto{
+		Secret:     enrollment.Secret,
+		OtpauthUri: enrollment.URI,
+	})
+}
+
+// UserExample  godoc
+//
+//	@Summary		confirm two-factor authentication
+//	@Description	enables two-factor authentication with a code from the authenticator app and returns recovery codes
+//	@Tags			user
+//	@Accept			json
+//	@Produce		json
+//	@Param			confirmDto	body		dto.TotpConfirmDto	true	"TOTP code"
+//	@Success		200			{object}	dto.RecoveryCodesDto
+//	@Failure		400
+//	@Failure		401
+//	@Failure		409
+//	@Failure		500
+//	@Router			/user/2fa/confirm [post]
+func (u *UserController) confirmTwoFactor(c *gin.Context) {
+	actor, ok := getActor(c, u.accessService)
+	if !ok {
+		return
+	}
+
+	var confirmDto dto.TotpConfirmDto
+	if err := c.ShouldBindJSON(&confirmDto); err != nil {
+		u.logger.Errorf("Invalid 2FA confirm request err = %+v", err)
+		abortOnBindError(c, err)
+		return
+	}
+
+	codes, err := u.twoFactorService.Confirm(actor.UserID, confirmDto.Code)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, dto.RecoveryCodesDto{RecoveryCodes: codes})
+}
+
+// UserExample  godoc
+//
+//	@Summary		reset two-factor authentication
+//	@Description	disables two-factor authentication of the user and revokes their sessions, used when the device is lost
+//	@Tags			user
+//	@Success		204
+//	@Failure		400
+//	@Failure		404
+//	@Failure		500
+//	@Param			uuid	path	string	true	"user uuid"
+//	@Router			/user/{uuid}/2fa [delete]
+func (u *UserController) resetTwoFactor(c *gin.Context) {
+	actor, ok := getActor(c, u.accessService)
+	if !ok {
+		return
+	}
+
+	userUuid, err := uuid.Parse(c.Param("uuid"))
+	if err != nil {
+		u.logger.Errorf("error parsing uuid value = %s", c.Param("uuid"))
+		abortWithStatus(c, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := u.twoFactorService.Reset(actor, userUuid); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			u.logger.Errorf("User with uuid = %s not found", userUuid)
+			abortWithError(c, err)
+			return
+		}
+
+		u.logger.Errorf("Failed to reset 2FA of user with uuid = %s", userUuid)
+		abortWithError(c, err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
 // GetLoggedInUser godoc
 //
 //	@Summary		Get logged-in user data
@@ -209,17 +463,17 @@ func (u *UserController) delete(c *gin.Context) {
 //	@Failure		500
 //	@Router			/user/my-data [get]
 func (u *UserController) getLoggedInUser(c *gin.Context) {
-	_, claims, err := auth.ParseToken(c.Request.Header.Get("Authorization"))
+	claims, err := middleware.GetClaims(c)
 	if err != nil {
-		u.logger.Errorf("Failed to parse token: %v", err)
-		c.AbortWithError(http.StatusUnauthorized, err)
+		u.logger.Errorf("Failed to get token claims: %v", err)
+		abortWithStatus(c, http.StatusUnauthorized, err)
 		return
 	}
 
 	userUuid, err := uuid.Parse(claims.Uuid)
 	if err != nil {
 		u.logger.Errorf("Error parsing UUID = %s", err)
-		c.AbortWithError(http.StatusBadRequest, err)
+		abortWithStatus(c, http.StatusBadRequest, err)
 		return
 	}
 
@@ -227,12 +481,12 @@ func (u *UserController) getLoggedInUser(c *gin.Context) {
 	if err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			u.logger.Errorf("User with uuid = %s not found", userUuid)
-			c.AbortWithError(http.StatusNotFound, err)
+			abortWithError(c, err)
 			return
 		}
 
 		u.logger.Errorf("Failed to fetch user with uuid = %s: %v", userUuid, err)
-		c.AbortWithError(http.StatusInternalServerError, err)
+		abortWithError(c, err)
 		return
 	}
 
@@ -255,7 +509,7 @@ func (u *UserController) searchUsersByName(c *gin.Context) {
 	query := c.Query("query")
 	if query == "" {
 		u.logger.Warn("Search query is empty")
-		c.JSON(http.StatusBadRequest, "Search query is required")
+		abortWithStatus(c, http.StatusBadRequest, errors.New("search query is required"))
 		return
 	}
 
@@ -264,7 +518,7 @@ func (u *UserController) searchUsersByName(c *gin.Context) {
 	users, err := u.UserCrud.SearchUsersByName(query)
 	if err != nil {
 		u.logger.Errorf("Failed to search users: %v", err)
-		c.JSON(http.StatusInternalServerError, "Failed to search users")
+		abortWithError(c, err)
 		return
 	}
 
@@ -0,0 +1,49 @@
+package dto
+
+import (
+	"PatientManager/model"
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// AuditQueryDto filters audit events, all fields are optional
+type AuditQueryDto struct {
+	ActorUuid  string     `form:"actorUuid" binding:"omitempty,uuid"`
+	PatientID  *uint      `form:"patientId"`
+	From       *time.Time `form:"from" time_format:"2006-01-02T15:04:05Z07:00"`
+	To         *time.Time `form:"to" time_format:"2006-01-02T15:04:05Z07:00"`
+	EntityType string     `form:"entityType" binding:"omitempty,oneof=patient checkup illness prescription user ip"`
+	Action     string     `form:"action" binding:"omitempty,oneof=read create update delete lockout unlock export erase"`
+	Limit      int        `form:"limit" binding:"omitempty,min=1,max=1000"`
+}
+
+type AuditEventDto struct {
+	Uuid       string          `json:"uuid"`
+	CreatedAt  time.Time       `json:"createdAt"`
+	ActorUuid  *uuid.UUID      `json:"actorUuid"`
+	Action     string          `json:"action"`
+	EntityType string          `json:"entityType"`
+	EntityUuid *uuid.UUID      `json:"entityUuid"`
+	PatientID  *uint           `json:"patientId"`
+	Diff       json.RawMessage `json:"diff,omitempty" swaggertype:"object"`
+	ClientIP   string          `json:"clientIp,omitempty"`
+}
+
+func (dto *AuditEventDto) FromModel(e *model.AuditEvent) *AuditEventDto {
+	rez := &AuditEventDto{
+		Uuid:       e.Uuid.String(),
+		CreatedAt:  e.CreatedAt,
+		ActorUuid:  e.ActorUuid,
+		Action:     string(e.Action),
+		EntityType: e.EntityType,
+		EntityUuid: e.EntityUuid,
+		PatientID:  e.PatientID,
+		ClientIP:   e.ClientIP,
+	}
+	if e.Diff != "" {
+		rez.Diff = json.RawMessage(e.Diff)
+	}
+	return rez
+}
@@ -2,6 +2,7 @@ package dto
 
 import (
 	"PatientManager/model"
+	"net/url"
 	"time"
 
 	"github.com/google/uuid"
@@ -9,17 +10,48 @@ import (
 
 // Dodan novi ImageDto
 type ImageDto struct {
-	Uuid string `json:"uuid"`
-	Path string `json:"path"`
+	Uuid        string `json:"uuid"`
+	Path        string `json:"path"`
+	Url         string `json:"url,omitempty"`
+	PreviewPath string `json:"previewPath,omitempty"`
+	PreviewUrl  string `json:"previewUrl,omitempty"`
+	ContentType string `json:"contentType,omitempty"`
+	Size        int64  `json:"size,omitempty"`
+	// Thumbnail is true if the image has a thumbnail served with ?size=thumb
+	Thumbnail    bool      `json:"thumbnail"`
+	ThumbnailUrl string    `json:"thumbnailUrl,omitempty"`
+	Dicom        *DicomDto `json:"dicom,omitempty"`
+}
+
+// DicomDto holds attributes of a DICOM image
+type DicomDto struct {
+	StudyInstanceUid  string     `json:"studyInstanceUid"`
+	SeriesInstanceUid string     `json:"seriesInstanceUid"`
+	SopInstanceUid    string     `json:"sopInstanceUid"`
+	Modality          string     `json:"modality"`
+	AcquiredAt        *time.Time `json:"acquiredAt,omitempty"`
 }
 
 type CheckupDto struct {
 	Uuid              uuid.UUID         `json:"uuid"`
-	CheckupDate       time.Time         `json:"checkupDate"`
-	Type              model.CheckupType `json:"type"`
+	CheckupDate       time.Time         `json:"checkupDate" binding:"required"`
+	Type              model.CheckupType `json:"type" binding:"required,checkuptype"`
 	MedicalRecordUuid string            `json:"medicalRecordUuid"`
 	IllnessID         *uint             `json:"illnessId,omitempty"`
 	Images            []ImageDto        `json:"images"`
+	LabResults        []LabResultDto    `json:"labResults,omitempty"`
+}
+
+// LabResultDto is a result of a blood test received from a laboratory
+type LabResultDto struct {
+	Code           string     `json:"code"`
+	Name           string     `json:"name,omitempty"`
+	Value          string     `json:"value"`
+	Units          string     `json:"units,omitempty"`
+	ReferenceRange string     `json:"referenceRange,omitempty"`
+	Flag           string     `json:"flag,omitempty"`
+	Status         string     `json:"status,omitempty"`
+	ObservedAt     *time.Time `json:"observedAt,omitempty"`
 }
 
 func (dto *CheckupDto) FromModel(c *model.Checkup) *CheckupDto {
@@ -32,11 +64,38 @@ func (dto *CheckupDto) FromModel(c *model.Checkup) *CheckupDto {
 	imageDtos := make([]ImageDto, len(c.Images))
 	for i, image := range c.Images {
 		imageDtos[i] = ImageDto{
-			Uuid: image.Uuid.String(),
-			Path: image.Path,
+			Uuid:        image.Uuid.String(),
+			Path:        image.Path,
+			PreviewPath: image.PreviewPath,
+			ContentType: image.ContentType,
+			Size:        image.Size,
+			Thumbnail:   image.ThumbnailPath != "",
+		}
+		if image.SOPInstanceUID != "" {
+			imageDtos[i].Dicom = &DicomDto{
+				StudyInstanceUid:  image.StudyInstanceUID,
+				SeriesInstanceUid: image.SeriesInstanceUID,
+				SopInstanceUid:    image.SOPInstanceUID,
+				Modality:          image.Modality,
+				AcquiredAt:        image.AcquiredAt,
+			}
 		}
 	}
 
+	var labResultDtos []LabResultDto
+	for _, result := range c.LabResults {
+		labResultDtos = append(labResultDtos, LabResultDto{
+			Code:           result.Code,
+			Name:           result.Name,
+			Value:          result.Value,
+			Units:          result.Units,
+			ReferenceRange: result.ReferenceRange,
+			Flag:           result.Flag,
+			Status:         result.Status,
+			ObservedAt:     result.ObservedAt,
+		})
+	}
+
 	return &CheckupDto{
 		Uuid:              c.Uuid,
 		CheckupDate:       c.CheckupDate,
@@ -44,6 +103,7 @@ func (dto *CheckupDto) FromModel(c *model.Checkup) *CheckupDto {
 		MedicalRecordUuid: recordUuid,
 		IllnessID:         c.IllnessID,
 		Images:            imageDtos, // Dodano
+		LabResults:        labResultDtos,
 	}
 }
 
@@ -56,10 +116,24 @@ func (dto *CheckupDto) ToModel() (*model.Checkup, error) {
 	}, nil
 }
 
+// WithImageLinks sets image urls using the given route prefix
+func (dto *CheckupDto) WithImageLinks(prefix string) *CheckupDto {
+	for i := range dto.Images {
+		dto.Images[i].Url = prefix + url.PathEscape(dto.Images[i].Path)
+		if dto.Images[i].PreviewPath != "" {
+			dto.Images[i].PreviewUrl = prefix + url.PathEscape(dto.Images[i].PreviewPath)
+		}
+		if dto.Images[i].Thumbnail {
+			dto.Images[i].ThumbnailUrl = dto.Images[i].Url + "?size=thumb"
+		}
+	}
+	return dto
+}
+
 type CreateCheckupDto struct {
 	CheckupDate       time.Time         `json:"checkupDate" binding:"required"`
-	Type              model.CheckupType `json:"type" binding:"required"`
-	MedicalRecordUuid string            `json:"medicalRecordUuid" binding:"required"`
+	Type              model.CheckupType `json:"type" binding:"required,checkuptype"`
+	MedicalRecordUuid string            `json:"medicalRecordUuid" binding:"required,uuid"`
 	IllnessID         *uint             `json:"illnessId"`
 	Images            []string          `json:"images"`
 }
@@ -0,0 +1,85 @@
+package dto
+
+import (
+	"PatientManager/model"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// LegalHoldDto places the patient under legal hold or releases it
+type LegalHoldDto struct {
+	Hold   *bool  `json:"hold" binding:"required"`
+	Reason string `json:"reason" binding:"required_if=Hold true,max=255"`
+}
+
+type NewErasureRequestDto struct {
+	Reason string `json:"reason" binding:"required,max=255"`
+}
+
+// ErasureQueryDto filters erasure requests, sortable by createdAt
+type ErasureQueryDto struct {
+	ListQueryDto
+	Status string `form:"status" binding:"omitempty,oneof=pending approved rejected"`
+}
+
+type ErasureRequestDto struct {
+	Uuid            uuid.UUID  `json:"uuid"`
+	PatientUuid     uuid.UUID  `json:"patientUuid"`
+	Reason          string     `json:"reason"`
+	Status          string     `json:"status"`
+	RequestedByUuid uuid.UUID  `json:"requestedByUuid"`
+	DecidedByUuid   *uuid.UUID `json:"decidedByUuid,omitempty"`
+	CreatedAt       time.Time  `json:"createdAt"`
+	DecidedAt       *time.Time `json:"decidedAt,omitempty"`
+}
+
+// FromModel expects Patient, RequestedBy and DecidedBy to be loaded
+func (dto *ErasureRequestDto) FromModel(r *model.ErasureRequest) *ErasureRequestDto {
+	var decidedBy *uuid.UUID
+	if r.DecidedBy != nil {
+		decidedBy = &r.DecidedBy.Uuid
+	}
+
+	return &ErasureRequestDto{
+		Uuid:            r.Uuid,
+		PatientUuid:     r.Patient.Uuid,
+		Reason:          r.Reason,
+		Status:          string(r.Status),
+		RequestedByUuid: r.RequestedBy.Uuid,
+		DecidedByUuid:   decidedBy,
+		CreatedAt:       r.CreatedAt,
+		DecidedAt:       r.DecidedAt,
+	}
+}
+
+type ErasureCertificateDto struct {
+	Uuid                uuid.UUID `json:"uuid"`
+	ErasedAt            time.Time `json:"erasedAt"`
+	PatientUuid         uuid.UUID `json:"patientUuid"`
+	Reason              string    `json:"reason"`
+	RequestedByUuid     uuid.UUID `json:"requestedByUuid"`
+	ApprovedByUuid      uuid.UUID `json:"approvedByUuid"`
+	ErasedFields        []string  `json:"erasedFields"`
+	ImagesDeleted       int       `json:"imagesDeleted"`
+	AuditEventsRedacted int       `json:"auditEventsRedacted"`
+	DeadLettersDeleted  int       `json:"deadLettersDeleted"`
+	UserAnonymized      bool      `json:"userAnonymized"`
+}
+
+func (dto *ErasureCertificateDto) FromModel(c *model.ErasureCertificate) *ErasureCertificateDto {
+	return &ErasureCertificateDto{
+		Uuid:                c.Uuid,
+		ErasedAt:            c.CreatedAt,
+		PatientUuid:         c.PatientUuid,
+		Reason:              c.Reason,
+		RequestedByUuid:     c.RequestedByUuid,
+		ApprovedByUuid:      c.ApprovedByUuid,
+		ErasedFields:        strings.Split(c.ErasedFields, ","),
+		ImagesDeleted:       c.ImagesDeleted,
+		AuditEventsRedacted: c.AuditEventsRedacted,
+		DeadLettersDeleted:  c.DeadLettersDeleted,
+		UserAnonymized:      c.UserAnonymized,
+	}
+}
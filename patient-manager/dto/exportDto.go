@@ -0,0 +1,42 @@
+package dto
+
+import (
+	"PatientManager/model"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// MedicalRecordExportDto is the medical record in a patient data export,
+// its checkups and illnesses are exported separately
+type MedicalRecordExportDto struct {
+	Uuid      uuid.UUID `json:"uuid"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+func (dto *MedicalRecordExportDto) FromModel(mr *model.MedicalRecord) *MedicalRecordExportDto {
+	return &MedicalRecordExportDto{
+		Uuid:      mr.Uuid,
+		CreatedAt: mr.CreatedAt,
+		UpdatedAt: mr.UpdatedAt,
+	}
+}
+
+// IllnessExportDto is an illness with its prescriptions and their medications
+type IllnessExportDto struct {
+	IllnessListDto
+	Prescriptions []PrescriptionListDto `json:"prescriptions"`
+}
+
+func (dto *IllnessExportDto) FromModel(i *model.Illness) *IllnessExportDto {
+	prescriptions := make([]PrescriptionListDto, len(i.Prescriptions))
+	for j, p := range i.Prescriptions {
+		prescriptions[j] = *(&PrescriptionListDto{}).FromModel(&p)
+	}
+
+	return &IllnessExportDto{
+		IllnessListDto: *(&IllnessListDto{}).FromModel(i),
+		Prescriptions:  prescriptions,
+	}
+}
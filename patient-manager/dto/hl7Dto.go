@@ -0,0 +1,38 @@
+package dto
+
+import (
+	"PatientManager/model"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Hl7DeadLetterDto is an HL7 message that couldn't be processed, Error is
+// the text of its negative acknowledgement
+type Hl7DeadLetterDto struct {
+	Uuid        uuid.UUID `json:"uuid"`
+	CreatedAt   time.Time `json:"createdAt"`
+	UpdatedAt   time.Time `json:"updatedAt"`
+	Source      string    `json:"source"`
+	ClientIP    string    `json:"clientIp,omitempty"`
+	MessageType string    `json:"messageType,omitempty"`
+	ControlID   string    `json:"controlId,omitempty"`
+	Message     string    `json:"message"`
+	Error       string    `json:"error"`
+	Attempts    int       `json:"attempts"`
+}
+
+func (dto *Hl7DeadLetterDto) FromModel(d *model.Hl7DeadLetter) *Hl7DeadLetterDto {
+	return &Hl7DeadLetterDto{
+		Uuid:        d.Uuid,
+		CreatedAt:   d.CreatedAt,
+		UpdatedAt:   d.UpdatedAt,
+		Source:      string(d.Source),
+		ClientIP:    d.ClientIP,
+		MessageType: d.MessageType,
+		ControlID:   d.ControlID,
+		Message:     d.Message,
+		Error:       d.Error,
+		Attempts:    d.Attempts,
+	}
+}
@@ -6,10 +6,10 @@ import (
 )
 
 type CreateIllnessDto struct {
-	Name              string     `json:"name" binding:"required"`
-	StartDate         time.Time  `json:"startDate" binding:"required"`
-	EndDate           *time.Time `json:"endDate"`
-	MedicalRecordUuid string     `json:"medicalRecordUuid" binding:"required"`
+	Name              string     `json:"name" binding:"required,max=100"`
+	StartDate         time.Time  `json:"startDate" binding:"required,pastdate"`
+	EndDate           *time.Time `json:"endDate" binding:"omitempty,gtefield=StartDate"`
+	MedicalRecordUuid string     `json:"medicalRecordUuid" binding:"required,uuid"`
 }
 
 func (dto *CreateIllnessDto) ToModel() *model.Illness {
@@ -21,9 +21,9 @@ func (dto *CreateIllnessDto) ToModel() *model.Illness {
 }
 
 type UpdateIllnessDto struct {
-	Name      string     `json:"name" binding:"required"`
-	StartDate time.Time  `json:"startDate" binding:"required"`
-	EndDate   *time.Time `json:"endDate"`
+	Name      string     `json:"name" binding:"required,max=100"`
+	StartDate time.Time  `json:"startDate" binding:"required,pastdate"`
+	EndDate   *time.Time `json:"endDate" binding:"omitempty,gtefield=StartDate"`
 }
 
 func (dto *UpdateIllnessDto) ToModel() *model.Illness {
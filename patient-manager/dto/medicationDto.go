@@ -2,47 +2,61 @@ package dto
 
 import (
 	"PatientManager/model"
+	"strings"
 
 	"github.com/google/uuid"
 )
 
-// MedicationDto is used when viewing a medication within a prescription.
+// MedicationDto is a medication of the catalog, also used within prescriptions
 type MedicationDto struct {
-	Uuid           uuid.UUID `json:"uuid"`
-	Name           string    `json:"name"`
-	PrescriptionID uint      `json:"prescriptionId"`
+	Uuid             uuid.UUID `json:"uuid"`
+	Name             string    `json:"name"`
+	ATCCode          string    `json:"atcCode,omitempty"`
+	ActiveIngredient string    `json:"activeIngredient,omitempty"`
+	Strength         string    `json:"strength,omitempty"`
+	DosageForm       string    `json:"dosageForm,omitempty"`
+	Route            string    `json:"route,omitempty"`
 }
 
 func (dto *MedicationDto) FromModel(m *model.Medication) *MedicationDto {
-	var prescriptionID uint
-	if m.PrescriptionID != nil {
-		prescriptionID = *m.PrescriptionID
-	}
-
 	return &MedicationDto{
-		Uuid:           m.Uuid,
-		Name:           m.Name,
-		PrescriptionID: prescriptionID,
+		Uuid:             m.Uuid,
+		Name:             m.Name,
+		ATCCode:          m.ATCCode,
+		ActiveIngredient: m.ActiveIngredient,
+		Strength:         m.Strength,
+		DosageForm:       m.DosageForm,
+		Route:            m.Route,
 	}
 }
 
-func (dto *MedicationDto) ToModel() (*model.Medication, error) {
-	return &model.Medication{
-		Uuid:           dto.Uuid,
-		Name:           dto.Name,
-		PrescriptionID: &dto.PrescriptionID,
-	}, nil
+// MedicationImportDto is an entry of a medication catalog file, CSV files
+// have a header with the JSON names of the columns
+type MedicationImportDto struct {
+	Name             string `json:"name" binding:"required,max=100"`
+	ATCCode          string `json:"atcCode" binding:"required,atc"`
+	ActiveIngredient string `json:"activeIngredient" binding:"required,max=200"`
+	Strength         string `json:"strength" binding:"max=50"`
+	DosageForm       string `json:"dosageForm" binding:"required,max=50"`
+	Route            string `json:"route" binding:"max=50"`
 }
 
-// MedicationListDto is used for the general list of all available medications.
-type MedicationListDto struct {
-	Uuid string `json:"uuid"`
-	Name string `json:"name"`
+// ToModel returns the medication with trimmed values, the ATC code in upper
+// case and the route in lower case
+func (dto *MedicationImportDto) ToModel() *model.Medication {
+	return &model.Medication{
+		Name:             strings.TrimSpace(dto.Name),
+		ATCCode:          strings.ToUpper(strings.TrimSpace(dto.ATCCode)),
+		ActiveIngredient: strings.TrimSpace(dto.ActiveIngredient),
+		Strength:         strings.TrimSpace(dto.Strength),
+		DosageForm:       strings.TrimSpace(dto.DosageForm),
+		Route:            strings.ToLower(strings.TrimSpace(dto.Route)),
+	}
 }
 
-func (dto *MedicationListDto) FromModel(m *model.Medication) *MedicationListDto {
-	return &MedicationListDto{
-		Uuid: m.Uuid.String(),
-		Name: m.Name,
-	}
+// MedicationImportReportDto counts medications of an imported catalog
+type MedicationImportReportDto struct {
+	Created   int `json:"created"`
+	Updated   int `json:"updated"`
+	Unchanged int `json:"unchanged"`
 }
@@ -13,8 +13,8 @@ type NewUserDto struct {
 	Uuid      string `json:"uuid"`
 	FirstName string `json:"firstName" binding:"required,min=2,max=100"`
 	LastName  string `json:"lastName" binding:"required,min=2,max=100"`
-	OIB       string `json:"oib" binding:"required,len=11"`
-	BirthDate string `json:"birthDate" binding:"required,datetime=2006-01-02"`
+	OIB       string `json:"oib" binding:"required,oib"`
+	BirthDate string `json:"birthDate" binding:"required,datetime=2006-01-02,pastdate"`
 	Email     string `json:"email" binding:"required,email"`
 	Password  string `json:"password"`
 	Role      string `json:"role" binding:"required,oneof=doctor patient superadmin"`
@@ -0,0 +1,84 @@
+package dto
+
+import (
+	"PatientManager/util/query"
+	"time"
+)
+
+// ListQueryDto holds pagination and sorting parameters of list endpoints,
+// cursor from the previous page takes precedence over page
+type ListQueryDto struct {
+	Page     int    `form:"page" binding:"omitempty,min=1"`
+	PageSize int    `form:"pageSize" binding:"omitempty,min=1,max=100"`
+	Cursor   string `form:"cursor"`
+	// comma separated fields, prefix a field with "-" for descending order
+	Sort string `form:"sort"`
+}
+
+func (dto *ListQueryDto) ToRequest() query.Request {
+	return query.Request{
+		Page:     dto.Page,
+		PageSize: dto.PageSize,
+		Cursor:   dto.Cursor,
+		Sort:     dto.Sort,
+	}
+}
+
+// PageDto is the response envelope of list endpoints
+type PageDto[T any] struct {
+	Items      []T    `json:"items"`
+	Total      int64  `json:"total"`
+	Page       int    `json:"page,omitempty"`
+	PageSize   int    `json:"pageSize"`
+	NextCursor string `json:"nextCursor,omitempty"`
+}
+
+func NewPageDto[T any](items []T, page *query.Page) *PageDto[T] {
+	if items == nil {
+		items = []T{}
+	}
+	return &PageDto[T]{
+		Items:      items,
+		Total:      page.Total,
+		Page:       page.Page,
+		PageSize:   page.PageSize,
+		NextCursor: page.NextCursor,
+	}
+}
+
+// PatientQueryDto filters patients, sortable by lastName, firstName, birthDate and createdAt
+type PatientQueryDto struct {
+	ListQueryDto
+	Gender     string     `form:"gender" binding:"omitempty,gender"`
+	BornFrom   *time.Time `form:"bornFrom" time_format:"2006-01-02"`
+	BornTo     *time.Time `form:"bornTo" time_format:"2006-01-02"`
+	DoctorUuid string     `form:"doctorUuid" binding:"omitempty,uuid"`
+}
+
+// CheckupQueryDto filters checkups, sortable by date and type
+type CheckupQueryDto struct {
+	ListQueryDto
+	Type string     `form:"type" binding:"omitempty,checkuptype"`
+	From *time.Time `form:"from" time_format:"2006-01-02"`
+	To   *time.Time `form:"to" time_format:"2006-01-02"`
+}
+
+// IllnessQueryDto filters illnesses by start date, active illnesses have no end date,
+// sortable by name and startDate
+type IllnessQueryDto struct {
+	ListQueryDto
+	From   *time.Time `form:"from" time_format:"2006-01-02"`
+	To     *time.Time `form:"to" time_format:"2006-01-02"`
+	Active *bool      `form:"active"`
+}
+
+// MedicationQueryDto filters medications of the catalog, sortable by name
+// and atc. Query matches prefixes of the name, the active ingredient or the
+// ATC code, ATC matches a prefix of the code, e.g. N02B for all
+// non-opioid analgesics.
+type MedicationQueryDto struct {
+	ListQueryDto
+	Name  string `form:"name"`
+	Query string `form:"q" binding:"max=100"`
+	ATC   string `form:"atc" binding:"max=7"`
+}
@@ -0,0 +1,16 @@
+package dto
+
+type ChangePasswordDto struct {
+	Email           string `json:"email" binding:"required,email"`
+	CurrentPassword string `json:"currentPassword" binding:"required"`
+	NewPassword     string `json:"newPassword" binding:"required"`
+}
+
+type PasswordResetRequestDto struct {
+	Email string `json:"email" binding:"required,email"`
+}
+
+type PasswordResetDto struct {
+	Token       string `json:"token" binding:"required"`
+	NewPassword string `json:"newPassword" binding:"required"`
+}
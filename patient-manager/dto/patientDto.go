@@ -3,10 +3,13 @@ package dto
 import (
 	"PatientManager/model"
 	"time"
+
+	"github.com/google/uuid"
 )
 
 type PatientDto struct {
 	ID                uint       `json:"id"`
+	Uuid              uuid.UUID  `json:"uuid"`
 	FirstName         string     `json:"firstName"`
 	LastName          string     `json:"lastName"`
 	OIB               string     `json:"oib"`
@@ -14,26 +17,33 @@ type PatientDto struct {
 	Gender            string     `json:"gender"`
 	MedicalRecordUuid string     `json:"medicalRecordUuid"`
 	Doctor            *DoctorDto `json:"doctor,omitempty"`
+	LegalHold         bool       `json:"legalHold"`
+	ErasedAt          *time.Time `json:"erasedAt,omitempty"`
 }
 
 type NewPatientDto struct {
-	FirstName string `json:"firstName" binding:"required"`
-	LastName  string `json:"lastName" binding:"required"`
-	OIB       string `json:"oib" binding:"required"`
-	BirthDate string `json:"birthDate" binding:"required"`
-	Gender    string `json:"gender" binding:"required"`
+	FirstName string `json:"firstName" binding:"required,max=100"`
+	LastName  string `json:"lastName" binding:"required,max=100"`
+	OIB       string `json:"oib" binding:"required,oib"`
+	BirthDate string `json:"birthDate" binding:"required,datetime=2006-01-02,pastdate"`
+	Gender    string `json:"gender" binding:"required,gender"`
 	DoctorID  *uint  `json:"doctorId,omitempty"`
 }
 
+// UpdatePatientDto replaces patient details, BirthDate is in RFC 3339 format
 type UpdatePatientDto struct {
-	FirstName string `json:"firstName"`
-	LastName  string `json:"lastName"`
-	OIB       string `json:"oib"`
-	BirthDate string `json:"birthDate"`
-	Gender    string `json:"gender"`
+	FirstName string `json:"firstName" binding:"required,max=100"`
+	LastName  string `json:"lastName" binding:"required,max=100"`
+	OIB       string `json:"oib" binding:"required,oib"`
+	BirthDate string `json:"birthDate" binding:"required,pastdate"`
+	Gender    string `json:"gender" binding:"required,gender"`
 	DoctorID  *uint  `json:"doctorId"`
 }
 
+type PatientShareDto struct {
+	DoctorUuid string `json:"doctorUuid" binding:"required,uuid"`
+}
+
 func FromModel(p *model.Patient) PatientDto {
 	var doctorDto *DoctorDto
 	if p.DoctorID != nil {
@@ -45,6 +55,7 @@ func FromModel(p *model.Patient) PatientDto {
 
 	return PatientDto{
 		ID:                p.ID,
+		Uuid:              p.Uuid,
 		FirstName:         p.FirstName,
 		LastName:          p.LastName,
 		OIB:               p.OIB,
@@ -52,5 +63,20 @@ func FromModel(p *model.Patient) PatientDto {
 		Gender:            p.Gender,
 		MedicalRecordUuid: p.MedicalRecord.Uuid.String(),
 		Doctor:            doctorDto,
+		LegalHold:         p.LegalHold,
+		ErasedAt:          p.ErasedAt,
 	}
 }
+
+// PatientSearchDto is a free text search, q can contain name words, an OIB,
+// a birth date (2006-01-02 or 02.01.2006) and a medical record or patient
+// UUID, all parts must match
+type PatientSearchDto struct {
+	Query string `form:"q" binding:"required,max=200"`
+	Limit int    `form:"limit" binding:"omitempty,min=1,max=100"`
+}
+
+type PatientSearchResultDto struct {
+	Score   float64    `json:"score"`
+	Patient PatientDto `json:"patient"`
+}
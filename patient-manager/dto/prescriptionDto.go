@@ -14,10 +14,10 @@ type PrescriptionListDto struct {
 }
 
 func (dto *PrescriptionListDto) FromModel(p *model.Prescription) *PrescriptionListDto {
-	medications := make([]MedicationDto, len(p.Medications))
-	for i, m := range p.Medications {
+	medications := make([]MedicationDto, len(p.Lines))
+	for i, line := range p.Lines {
 		dto := MedicationDto{}
-		medications[i] = *dto.FromModel(&m)
+		medications[i] = *dto.FromModel(&line.Medication)
 	}
 
 	return &PrescriptionListDto{
@@ -30,7 +30,7 @@ func (dto *PrescriptionListDto) FromModel(p *model.Prescription) *PrescriptionLi
 type CreatePrescriptionDto struct {
 	IssuedAt        time.Time `json:"issuedAt" binding:"required"`
 	IllnessID       uint      `json:"illnessId" binding:"required"`
-	MedicationUuids []string  `json:"medicationUuids"`
+	MedicationUuids []string  `json:"medicationUuids" binding:"dive,uuid"`
 }
 
 func (dto *CreatePrescriptionDto) ToModel() *model.Prescription {
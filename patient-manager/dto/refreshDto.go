@@ -1,5 +1,5 @@
 package dto
 
 type RefreshDto struct {
-	RefreshToken string `json:"refreshToken"`
+	RefreshToken string `json:"refreshToken" binding:"required"`
 }
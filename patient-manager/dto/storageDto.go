@@ -0,0 +1,50 @@
+package dto
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// StorageReportDto is the result of reconciling image rows with the objects
+// in the bucket. Images are identified by their path.
+type StorageReportDto struct {
+	ObjectsScanned int `json:"objectsScanned"`
+	ImagesScanned  int `json:"imagesScanned"`
+	// CompletedImages stayed pending although their upload finished
+	CompletedImages []string `json:"completedImages,omitempty"`
+	// RemovedImages stayed pending or uploading and their upload was never
+	// finished or completed
+	RemovedImages []string `json:"removedImages,omitempty"`
+	// RepairedImages referenced a preview or thumbnail that doesn't exist
+	RepairedImages []string `json:"repairedImages,omitempty"`
+	// MissingObjects belong to images but aren't in the bucket, they can't
+	// be repaired
+	MissingObjects []string `json:"missingObjects,omitempty"`
+	// DeletedObjects didn't belong to any image
+	DeletedObjects []string `json:"deletedObjects,omitempty"`
+}
+
+// CreateUploadDto announces a file that is uploaded with a presigned URL
+type CreateUploadDto struct {
+	FileName string `json:"fileName" binding:"required,max=150"`
+	Size     int64  `json:"size" binding:"required,gt=0"`
+}
+
+// PresignedUploadDto tells the client where to upload the file, the upload
+// is completed with POST /checkup/{uuid}/uploads/{imageUuid}/complete
+type PresignedUploadDto struct {
+	ImageUuid uuid.UUID `json:"imageUuid"`
+	Path      string    `json:"path"`
+	Method    string    `json:"method"`
+	// UploadUrl is relative to the server if the storage can't presign URLs
+	UploadUrl string    `json:"uploadUrl"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// PresignedUrlDto is a URL that downloads an object without authentication
+// until it expires
+type PresignedUrlDto struct {
+	Url       string    `json:"url"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
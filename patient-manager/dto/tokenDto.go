@@ -1,7 +1,11 @@
 package dto
 
-// Response structure
+// Response structure, when two-factor authentication is needed only
+// challengeToken is set and it's used with /auth/2fa endpoints
 type TokenDto struct {
-	AccessToken  string `json:"accessToken"`
-	RefreshToken string `json:"refreshToken"`
+	AccessToken        string   `json:"accessToken"`
+	RefreshToken       string   `json:"refreshToken"`
+	ChallengeToken     string   `json:"challengeToken,omitempty"`
+	EnrollmentRequired bool     `json:"enrollmentRequired,omitempty"`
+	RecoveryCodes      []string `json:"recoveryCodes,omitempty"`
 }
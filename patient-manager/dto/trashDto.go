@@ -0,0 +1,28 @@
+package dto
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// TrashQueryDto selects deleted entities of one type, sortable by deletedAt
+type TrashQueryDto struct {
+	ListQueryDto
+	Type string `form:"type" binding:"required,oneof=patient checkup illness prescription"`
+}
+
+// TrashEntityDto identifies a deleted entity in restore requests
+type TrashEntityDto struct {
+	Type string `uri:"type" binding:"required,oneof=patient checkup illness prescription"`
+	Uuid string `uri:"uuid" binding:"required,uuid"`
+}
+
+// DeletedEntityDto is a soft deleted entity that can be restored until PurgeAt
+type DeletedEntityDto struct {
+	Type      string     `json:"type"`
+	Uuid      uuid.UUID  `json:"uuid"`
+	Label     string     `json:"label"`
+	DeletedAt time.Time  `json:"deletedAt"`
+	PurgeAt   *time.Time `json:"purgeAt,omitempty"`
+}
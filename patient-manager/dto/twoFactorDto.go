@@ -0,0 +1,26 @@
+package dto
+
+type TwoFactorEnrollDto struct {
+	ChallengeToken string `json:"challengeToken" binding:"required"`
+}
+
+type TwoFactorVerifyDto struct {
+	ChallengeToken string `json:"challengeToken" binding:"required"`
+	// TOTP code from the authenticator app or one of the recovery codes
+	Code string `json:"code" binding:"required"`
+}
+
+type TotpConfirmDto struct {
+	Code string `json:"code" binding:"required"`
+}
+
+// Response structure
+type TotpEnrollmentDto struct {
+	Secret     string `json:"secret"`
+	OtpauthUri string `json:"otpauthUri"`
+}
+
+// Response structure, codes are shown only once
+type RecoveryCodesDto struct {
+	RecoveryCodes []string `json:"recoveryCodes"`
+}
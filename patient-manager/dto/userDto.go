@@ -17,6 +17,8 @@ type UserDto struct {
 	OIB       string `json:"oib"`
 	Email     string `json:"email"`
 	Role      string `json:"role"`
+	// MustChangePassword is read only, it's ignored on update
+	MustChangePassword bool `json:"mustChangePassword"`
 }
 
 func (dto *UserDto) ToModel() (*model.User, error) {
@@ -49,6 +51,8 @@ func (dto UserDto) FromModel(m *model.User) UserDto {
 		LastName:  m.LastName,
 		Email:     m.Email,
 		Role:      fmt.Sprint(m.Role),
+
+		MustChangePassword: m.MustChangePassword,
 	}
 	return dto
 }
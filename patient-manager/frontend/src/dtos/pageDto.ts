@@ -0,0 +1,17 @@
+export interface PageDto<T> {
+    items: T[];
+    total: number;
+    page?: number;
+    pageSize: number;
+    nextCursor?: string;
+}
+
+// ListQuery holds pagination and sorting parameters of list endpoints, a
+// cursor from the previous page takes precedence over page
+export interface ListQuery {
+    page?: number;
+    pageSize?: number;
+    cursor?: string;
+    // comma separated fields, prefix a field with "-" for descending order
+    sort?: string;
+}
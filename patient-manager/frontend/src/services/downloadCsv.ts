@@ -1,14 +1,27 @@
-import { getAllPatients, getCheckupsForRecord, getIllnessesForRecord, getPrescriptionsForIllness } from './patientService';
+import { getPatients, getCheckupsForRecord, getIllnessesForRecord, getPrescriptionsForIllness } from './patientService';
+import type { ListQuery, PageDto } from '@/dtos/pageDto';
 
-export async function downloadAllPatientsData(): Promise<void> {
-    console.log("Starting patient data download...");
-    const patients = await getAllPatients();
+// pages loads a list page by page with the largest page size, following
+// nextCursor, the export is the only place that needs every item
+async function* pages<T>(load: (query: ListQuery) => Promise<PageDto<T>>): AsyncGenerator<T[]> {
+    let cursor: string | undefined;
+    do {
+        const page = await load({ pageSize: 100, cursor });
+        yield page.items;
+        cursor = page.nextCursor;
+    } while (cursor);
+}
 
-    if (!patients || patients.length === 0) {
-        console.error("No patients found to download.");
-        throw new Error('No patients found to download.');
+async function loadAll<T>(load: (query: ListQuery) => Promise<PageDto<T>>): Promise<T[]> {
+    const items: T[] = [];
+    for await (const page of pages(load)) {
+        items.push(...page);
     }
-    console.log(`Found ${patients.length} patients.`);
+    return items;
+}
+
+export async function downloadAllPatientsData(): Promise<void> {
+    console.log("Starting patient data download...");
 
     const allData = [];
 
@@ -22,87 +35,97 @@ export async function downloadAllPatientsData(): Promise<void> {
     allData.push(headers);
     console.log("CSV headers prepared:", headers);
 
-    for (const patient of patients) {
-        console.log(`Processing data for patient: ${patient.firstName} ${patient.lastName} (OIB: ${patient.oib})`);
-        const patientInfo = {
-            "Record Type": "Patient",
-            "Patient First Name": patient.firstName,
-            "Patient Last Name": patient.lastName,
-            "Patient OIB": patient.oib,
-            "Checkup UUID": "", "Checkup Date": "", "Checkup Type": "", "Checkup Illness ID": "",
-            "Illness UUID": "", "Illness Name": "", "Illness Start Date": "", "Illness End Date": "",
-            "Prescription UUID": "", "Prescription Issued At": "", "Prescription Illness Name": "", "Medications": ""
-        };
-        
-        allData.push(Object.values(patientInfo));
-
-        const checkups = await getCheckupsForRecord(patient.medicalRecordUuid) || [];
-        const illnesses = await getIllnessesForRecord(patient.medicalRecordUuid) || [];
-        
-        console.log(`- Found ${checkups.length} checkups.`);
-        console.log(`- Found ${illnesses.length} illnesses.`);
-
-        let allPrescriptions = [];
-        for (const illness of illnesses) {
-            const prescriptions = await getPrescriptionsForIllness(illness.id) || [];
-            if (prescriptions) {
-                allPrescriptions.push(...prescriptions.map(p => ({
-                    ...p,
-                    illnessName: illness.name
-                })));
-            }
-        }
-        console.log(`- Found ${allPrescriptions.length} total prescriptions.`);
-
-        for (const checkup of checkups) {
-            const checkupRow = {
-                "Record Type": "Checkup",
+    let patientCount = 0;
+    for await (const patients of pages(getPatients)) {
+        patientCount += patients.length;
+        for (const patient of patients) {
+            console.log(`Processing data for patient: ${patient.firstName} ${patient.lastName} (OIB: ${patient.oib})`);
+            const patientInfo = {
+                "Record Type": "Patient",
                 "Patient First Name": patient.firstName,
                 "Patient Last Name": patient.lastName,
                 "Patient OIB": patient.oib,
-                "Checkup UUID": checkup.uuid,
-                "Checkup Date": new Date(checkup.checkupDate).toLocaleDateString(),
-                "Checkup Type": checkup.type,
-                "Checkup Illness ID": checkup.illnessId || "N/A",
+                "Checkup UUID": "", "Checkup Date": "", "Checkup Type": "", "Checkup Illness ID": "",
                 "Illness UUID": "", "Illness Name": "", "Illness Start Date": "", "Illness End Date": "",
                 "Prescription UUID": "", "Prescription Issued At": "", "Prescription Illness Name": "", "Medications": ""
             };
-            allData.push(Object.values(checkupRow));
-        }
+        
+            allData.push(Object.values(patientInfo));
 
-        for (const illness of illnesses) {
-            const illnessRow = {
-                "Record Type": "Illness",
-                "Patient First Name": patient.firstName,
-                "Patient Last Name": patient.lastName,
-                "Patient OIB": patient.oib,
-                "Checkup UUID": "", "Checkup Date": "", "Checkup Type": "", "Checkup Illness ID": "",
-                "Illness UUID": illness.uuid,
-                "Illness Name": illness.name,
-                "Illness Start Date": new Date(illness.startDate).toLocaleDateString(),
-                "Illness End Date": illness.endDate ? new Date(illness.endDate).toLocaleDateString() : "Ongoing",
-                "Prescription UUID": "", "Prescription Issued At": "", "Prescription Illness Name": "", "Medications": ""
-            };
-            allData.push(Object.values(illnessRow));
-        }
+            const checkups = await loadAll(query => getCheckupsForRecord(patient.medicalRecordUuid, query));
+            const illnesses = await loadAll(query => getIllnessesForRecord(patient.medicalRecordUuid, query));
+        
+            console.log(`- Found ${checkups.length} checkups.`);
+            console.log(`- Found ${illnesses.length} illnesses.`);
 
-        for (const prescription of allPrescriptions) {
-            const prescriptionRow = {
-                "Record Type": "Prescription",
-                "Patient First Name": patient.firstName,
-                "Patient Last Name": patient.lastName,
-                "Patient OIB": patient.oib,
-                "Checkup UUID": "", "Checkup Date": "", "Checkup Type": "", "Checkup Illness ID": "",
-                "Illness UUID": "", "Illness Name": "", "Illness Start Date": "", "Illness End Date": "",
-                "Prescription UUID": prescription.uuid,
-                "Prescription Issued At": new Date(prescription.issuedAt).toLocaleDateString(),
-                "Prescription Illness Name": prescription.illnessName,
-                "Medications": prescription.medications.map(m => m.name).join(', ')
-            };
-            allData.push(Object.values(prescriptionRow));
+            let allPrescriptions = [];
+            for (const illness of illnesses) {
+                const prescriptions = await getPrescriptionsForIllness(illness.id) || [];
+                if (prescriptions) {
+                    allPrescriptions.push(...prescriptions.map(p => ({
+                        ...p,
+                        illnessName: illness.name
+                    })));
+                }
+            }
+            console.log(`- Found ${allPrescriptions.length} total prescriptions.`);
+
+            for (const checkup of checkups) {
+                const checkupRow = {
+                    "Record Type": "Checkup",
+                    "Patient First Name": patient.firstName,
+                    "Patient Last Name": patient.lastName,
+                    "Patient OIB": patient.oib,
+                    "Checkup UUID": checkup.uuid,
+                    "Checkup Date": new Date(checkup.checkupDate).toLocaleDateString(),
+                    "Checkup Type": checkup.type,
+                    "Checkup Illness ID": checkup.illnessId || "N/A",
+                    "Illness UUID": "", "Illness Name": "", "Illness Start Date": "", "Illness End Date": "",
+                    "Prescription UUID": "", "Prescription Issued At": "", "Prescription Illness Name": "", "Medications": ""
+                };
+                allData.push(Object.values(checkupRow));
+            }
+
+            for (const illness of illnesses) {
+                const illnessRow = {
+                    "Record Type": "Illness",
+                    "Patient First Name": patient.firstName,
+                    "Patient Last Name": patient.lastName,
+                    "Patient OIB": patient.oib,
+                    "Checkup UUID": "", "Checkup Date": "", "Checkup Type": "", "Checkup Illness ID": "",
+                    "Illness UUID": illness.uuid,
+                    "Illness Name": illness.name,
+                    "Illness Start Date": new Date(illness.startDate).toLocaleDateString(),
+                    "Illness End Date": illness.endDate ? new Date(illness.endDate).toLocaleDateString() : "Ongoing",
+                    "Prescription UUID": "", "Prescription Issued At": "", "Prescription Illness Name": "", "Medications": ""
+                };
+                allData.push(Object.values(illnessRow));
+            }
+
+            for (const prescription of allPrescriptions) {
+                const prescriptionRow = {
+                    "Record Type": "Prescription",
+                    "Patient First Name": patient.firstName,
+                    "Patient Last Name": patient.lastName,
+                    "Patient OIB": patient.oib,
+                    "Checkup UUID": "", "Checkup Date": "", "Checkup Type": "", "Checkup Illness ID": "",
+                    "Illness UUID": "", "Illness Name": "", "Illness Start Date": "", "Illness End Date": "",
+                    "Prescription UUID": prescription.uuid,
+                    "Prescription Issued At": new Date(prescription.issuedAt).toLocaleDateString(),
+                    "Prescription Illness Name": prescription.illnessName,
+                    "Medications": prescription.medications.map(m => m.name).join(', ')
+                };
+                allData.push(Object.values(prescriptionRow));
+            }
         }
     }
 
+    if (patientCount === 0) {
+        console.error("No patients found to download.");
+        throw new Error('No patients found to download.');
+    }
+    console.log(`Found ${patientCount} patients.`);
+
     console.log("Finished processing all patient data. Total rows:", allData.length);
     const csvContent = allData.map(row => row.map(value => `"${value}"`).join(',')).join('\n');
     console.log("CSV content generated. Creating blob...");
@@ -4,6 +4,7 @@ import axios from './axios';
 import type { CheckupDto, CreateCheckupDto, UpdateCheckupDto } from '@/dtos/checkupDto';
 import type { CreateIllnessDto, IllnessListDto, UpdateIllnessDto } from '@/dtos/illnessDto';
 import type { PrescriptionListDto, CreatePrescriptionDto, MedicationListDto } from '@/dtos/prescriptionDto';
+import type { ListQuery, PageDto } from '@/dtos/pageDto';
 
 const BASE_URL_PATIENTS = '/patients';
 const BASE_URL_CHECKUPS = '/checkup';
@@ -20,8 +21,20 @@ export interface UpdatePatientDto {
     doctorId?: number;
 }
 
-export async function getAllPatients(): Promise<PatientDto[]> {
-  const response = await axios.get<PatientDto[]>(BASE_URL_PATIENTS);
+export interface PatientSearchResultDto {
+    score: number;
+    patient: PatientDto;
+}
+
+export async function getPatients(query: ListQuery = {}): Promise<PageDto<PatientDto>> {
+  const response = await axios.get<PageDto<PatientDto>>(BASE_URL_PATIENTS, { params: query });
+  return response.data;
+}
+
+// searchPatients finds patients by name, OIB, birth date and medical record
+// UUID, results are ranked by score
+export async function searchPatients(q: string, limit?: number): Promise<PatientSearchResultDto[]> {
+  const response = await axios.get<PatientSearchResultDto[]>(`${BASE_URL_PATIENTS}/search`, { params: { q, limit } });
   return response.data;
 }
 
@@ -55,8 +68,8 @@ export async function createCheckup(checkupDto: CreateCheckupDto): Promise<Check
   return response.data;
 }
 
-export async function getCheckupsForRecord(recordUuid: string): Promise<CheckupDto[]> {
-    const response = await axios.get<CheckupDto[]>(`${BASE_URL_CHECKUPS}/record/${recordUuid}`);
+export async function getCheckupsForRecord(recordUuid: string, query: ListQuery = {}): Promise<PageDto<CheckupDto>> {
+    const response = await axios.get<PageDto<CheckupDto>>(`${BASE_URL_CHECKUPS}/record/${recordUuid}`, { params: query });
     return response.data;
 }
 
@@ -86,8 +99,8 @@ export async function deleteCheckupImage(checkupUuid: string, imageUuid: string)
 
 //ILLNESSES
 
-export async function getIllnessesForRecord(recordUuid: string): Promise<IllnessListDto[]> {
-    const response = await axios.get<IllnessListDto[]>(`${BASE_URL_ILLNESSES}/record/${recordUuid}`);
+export async function getIllnessesForRecord(recordUuid: string, query: ListQuery = {}): Promise<PageDto<IllnessListDto>> {
+    const response = await axios.get<PageDto<IllnessListDto>>(`${BASE_URL_ILLNESSES}/record/${recordUuid}`, { params: query });
     return response.data;
 }
 
@@ -123,8 +136,10 @@ export async function deletePrescription(uuid: string): Promise<void> {
 
 //MEDICATIONS
 
-export async function getAllMedications(): Promise<MedicationListDto[]> {
-  const response = await axios.get<MedicationListDto[]>(BASE_URL_MEDICATIONS);
+// searchMedications returns a page of the catalog, q matches prefixes of the
+// name, the active ingredient or the ATC code
+export async function searchMedications(q: string, query: ListQuery = {}): Promise<PageDto<MedicationListDto>> {
+  const response = await axios.get<PageDto<MedicationListDto>>(BASE_URL_MEDICATIONS, { params: { ...query, q: q || undefined } });
   return response.data;
 }
 
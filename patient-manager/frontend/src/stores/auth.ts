@@ -27,6 +27,17 @@ export const useAuthStore = defineStore('auth', () => {
   const IsAuthenticated = computed(() => localStorage.getItem('accessToken') != null)
 
   function Logout(): void {
+    // revoke the session on the server, the user is logged out locally either way
+    const refreshToken = localStorage.getItem('refreshToken')
+    if (refreshToken != null) {
+      fetch('/api/auth/logout', {
+        method: 'POST',
+        headers: { 'Content-Type': 'application/json' },
+        body: JSON.stringify({ refreshToken }),
+        keepalive: true,
+      }).catch(() => undefined)
+    }
+
     localStorage.removeItem('user')
     localStorage.removeItem('accessToken');
     localStorage.removeItem('refreshToken');
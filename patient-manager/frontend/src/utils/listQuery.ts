@@ -0,0 +1,33 @@
+import type { ListQuery } from '@/dtos/pageDto';
+
+export interface SortItem {
+    key: string;
+    order?: boolean | 'asc' | 'desc';
+}
+
+// TableOptions are options that v-data-table-server emits with update:options
+export interface TableOptions {
+    page: number;
+    itemsPerPage: number;
+    sortBy: SortItem[];
+    groupBy?: SortItem[];
+    search?: string;
+}
+
+// pageSizeOptions of server tables, the list endpoints return at most 100 items
+export const pageSizeOptions = [10, 20, 50, 100];
+
+// toListQuery maps options of a server table to list parameters, sortFields
+// maps column keys to sort fields of the endpoint. Grouped columns are sorted
+// first so that groups aren't split, columns without a sort field are skipped.
+export function toListQuery(options: TableOptions, sortFields: Record<string, string>): ListQuery {
+    const sort = [...(options.groupBy ?? []), ...options.sortBy]
+        .filter(item => sortFields[item.key])
+        .map(item => (item.order === 'desc' ? '-' : '') + sortFields[item.key])
+        .join(',');
+    return {
+        page: options.page,
+        pageSize: options.itemsPerPage,
+        sort: sort || undefined,
+    };
+}
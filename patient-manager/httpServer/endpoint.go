@@ -2,21 +2,44 @@ package httpServer
 
 import (
 	"PatientManager/controller"
+	"PatientManager/util/middleware"
+	"PatientManager/util/problem"
+	"net/http"
 
 	"github.com/gin-gonic/gin"
 )
 
 func setupHandlers(router *gin.Engine) {
+	router.NoRoute(func(c *gin.Context) {
+		middleware.Abort(c, problem.WithStatus(http.StatusNotFound, nil))
+	})
 
 	router.Static("/uploads", "./uploads")
+	controller.NewKeyController().RegisterEndpoints(router)
 
 	basePath := router.Group("/api")
 
+	// public routes
 	controller.NewLoginController().RegisterEndpoints(basePath)
-	controller.NewPatientController().RegisterEndpoints(basePath)
-	controller.NewUserController().RegisterEndpoints(basePath)
-	controller.NewCheckupController().RegisterEndpoints(basePath)
-	controller.NewIllnessController().RegisterEndpoints(basePath)
-	controller.NewPrescriptionController().RegisterEndpoints(basePath)
-	controller.NewMedicationController().RegisterEndpoints(basePath)
+	controller.NewObjectController().RegisterEndpoints(basePath)
+
+	// protected routes, access is defined in routePolicy
+	protected := basePath.Group("", middleware.Authorize(routePolicy))
+
+	controller.NewPatientController().RegisterEndpoints(protected)
+	controller.NewUserController().RegisterEndpoints(protected)
+	controller.NewCheckupController().RegisterEndpoints(protected)
+	controller.NewIllnessController().RegisterEndpoints(protected)
+	controller.NewPrescriptionController().RegisterEndpoints(protected)
+	controller.NewMedicationController().RegisterEndpoints(protected)
+	controller.NewPortalController().RegisterEndpoints(protected)
+	controller.NewAuditController().RegisterEndpoints(protected)
+	controller.NewStorageController().RegisterEndpoints(protected)
+	controller.NewTrashController().RegisterEndpoints(protected)
+	controller.NewErasureController().RegisterEndpoints(protected)
+	controller.NewHl7Controller().RegisterEndpoints(protected)
+
+	// FHIR API, errors are responded with an OperationOutcome instead of a problem
+	fhir := router.Group("/fhir", middleware.Outcomes(), middleware.Authorize(routePolicy))
+	controller.NewFhirController().RegisterEndpoints(fhir)
 }
@@ -1,9 +1,17 @@
 package httpServer
 
 import (
+	"PatientManager/app"
 	"PatientManager/config"
+	"PatientManager/model"
+	"PatientManager/service"
+	"PatientManager/util/auth"
+	"PatientManager/util/encryption"
+	"PatientManager/util/hl7"
+	"PatientManager/util/middleware"
 	"context"
 	"fmt"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
@@ -17,6 +25,11 @@ import (
 
 var signalNotificationCh = make(chan os.Signal, 1)
 
+const (
+	keyRotationCheck = encryption.ReloadInterval
+	purgeCheck       = time.Hour
+)
+
 func Start() {
 	// relay selected signals to channel
 	// - os.Interrupt, ctrl-c
@@ -33,6 +46,22 @@ func Start() {
 	go checkInterrupt(schedulerCtx, &schedulerWg, schedulerCancel)
 	zap.S().Debugf("Started CheckInterrupt")
 
+	schedulerWg.Add(1)
+	go rotateKeys(schedulerCtx, &schedulerWg)
+	zap.S().Debugf("Started key rotation")
+
+	schedulerWg.Add(1)
+	go purgeDeleted(schedulerCtx, &schedulerWg)
+	zap.S().Debugf("Started purge of deleted data")
+
+	schedulerWg.Add(1)
+	go reconcileStorage(schedulerCtx, &schedulerWg)
+	zap.S().Debugf("Started reconciliation of stored images")
+
+	schedulerWg.Add(1)
+	go listenMllp(schedulerCtx, &schedulerWg)
+	zap.S().Debugf("Started HL7 MLLP listener")
+
 	schedulerWg.Add(1)
 	go run(schedulerCtx, &schedulerWg)
 	zap.S().Debugf("Started HTTP server")
@@ -59,13 +88,146 @@ func checkInterrupt(ctx context.Context, wg *sync.WaitGroup, schedulerCancel con
 	}
 }
 
+// rotateKeys periodically rotates token signing keys (see auth.RotateKeys)
+// and loads encryption keys added by the rotate-keys command (see
+// encryption.ReloadKeys)
+func rotateKeys(ctx context.Context, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	ticker := time.NewTicker(keyRotationCheck)
+	defer ticker.Stop()
+
+	for {
+		select {
+
+		case <-ctx.Done():
+			zap.S().Debugf("Terminated key rotation")
+			return
+
+		case <-ticker.C:
+			if err := auth.RotateKeys(); err != nil {
+				zap.S().Errorf("Failed to rotate signing keys, err = %+v", err)
+			}
+			if err := encryption.ReloadKeys(); err != nil {
+				zap.S().Errorf("Failed to reload encryption keys, err = %+v", err)
+			}
+		}
+	}
+}
+
+// purgeDeleted periodically purges data that was deleted longer than
+// DeletedRetention ago, see service.ITrashService
+func purgeDeleted(ctx context.Context, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	retention := config.AppConfig.DeletedRetention
+	if retention <= 0 {
+		zap.S().Infof("Purge of deleted data is disabled")
+		return
+	}
+
+	var trashService service.ITrashService
+	app.Invoke(func(s service.ITrashService) {
+		trashService = s
+	})
+
+	ticker := time.NewTicker(purgeCheck)
+	defer ticker.Stop()
+
+	for {
+		select {
+
+		case <-ctx.Done():
+			zap.S().Debugf("Terminated purge of deleted data")
+			return
+
+		case <-ticker.C:
+			if err := trashService.Purge(ctx, time.Now().Add(-retention)); err != nil {
+				zap.S().Errorf("Failed to purge deleted data, err = %+v", err)
+			}
+		}
+	}
+}
+
+// reconcileStorage periodically repairs inconsistencies between image rows
+// and stored objects, see service.IReconcileService
+func reconcileStorage(ctx context.Context, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	interval := config.AppConfig.Storage.ReconcileInterval
+	if interval <= 0 {
+		zap.S().Infof("Reconciliation of stored images is disabled")
+		return
+	}
+
+	var reconcileService service.IReconcileService
+	app.Invoke(func(s service.IReconcileService) {
+		reconcileService = s
+	})
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+
+		case <-ctx.Done():
+			zap.S().Debugf("Terminated reconciliation of stored images")
+			return
+
+		case <-ticker.C:
+			if _, err := reconcileService.Reconcile(ctx); err != nil {
+				zap.S().Errorf("Failed to reconcile stored images, err = %+v", err)
+			}
+		}
+	}
+}
+
+// listenMllp receives HL7 messages over MLLP and processes them as the
+// configured user, see service.IHl7Service
+func listenMllp(ctx context.Context, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	hl7Config := config.AppConfig.Hl7
+	if hl7Config.MllpAddr == "" {
+		zap.S().Infof("HL7 MLLP listener is disabled")
+		return
+	}
+
+	var hl7Service service.IHl7Service
+	app.Invoke(func(s service.IHl7Service) {
+		hl7Service = s
+	})
+
+	actor, err := hl7Service.ResolveActor(hl7Config.MllpUser)
+	if err != nil {
+		zap.S().Errorf("Failed to start HL7 MLLP listener, err = %+v", err)
+		return
+	}
+
+	listener, err := net.Listen("tcp", hl7Config.MllpAddr)
+	if err != nil {
+		zap.S().Errorf("Failed to start HL7 MLLP listener on %s, err = %+v", hl7Config.MllpAddr, err)
+		return
+	}
+	zap.S().Infof("HL7 MLLP listener is listening on %s", listener.Addr())
+
+	hl7.Serve(ctx, listener, func(message, remoteAddr string) string {
+		connActor := *actor
+		connActor.ClientIP, _, _ = net.SplitHostPort(remoteAddr)
+		return hl7Service.Ingest(&connActor, message, model.Hl7SourceMllp)
+	})
+	zap.S().Debugf("Terminated HL7 MLLP listener")
+}
+
 func run(ctx context.Context, wg *sync.WaitGroup) {
 	defer wg.Done()
 	// gin.DisableConsoleColor()
 	if config.AppConfig.Env == config.Prod {
 		gin.SetMode(gin.ReleaseMode)
 	}
-	router := gin.Default()
+	router := gin.New()
+	router.Use(gin.Logger(), middleware.RequestID(), middleware.Problems(), middleware.Recovery())
 	setupHandlers(router)
 
 	addr := fmt.Sprintf(":%d", config.AppConfig.Port)
@@ -0,0 +1,104 @@
+package httpServer
+
+import (
+	"PatientManager/model"
+	"PatientManager/util/middleware"
+)
+
+var (
+	anyRole   = []model.UserRole{}
+	staff     = []model.UserRole{model.RoleDoctor, model.RoleSuperAdmin}
+	patient   = []model.UserRole{model.RolePatient}
+	adminOnly = []model.UserRole{model.RoleSuperAdmin}
+)
+
+// routePolicy lists every protected route with roles that can access it,
+// routes that are not listed here are denied by middleware.Authorize
+var routePolicy = middleware.Policy{
+	// patients
+	"GET /api/patients":                           staff,
+	"GET /api/patients/search":                    staff,
+	"GET /api/patients/:id":                       staff,
+	"POST /api/patients":                          staff,
+	"PUT /api/patients/:id":                       staff,
+	"DELETE /api/patients/:id":                    staff,
+	"GET /api/patients/:id/export":                staff,
+	"POST /api/patients/:id/shares":               staff,
+	"DELETE /api/patients/:id/shares/:doctorUuid": staff,
+
+	// user
+	"POST /api/user":                  staff,
+	"GET /api/user/:uuid":             staff,
+	"GET /api/user/my-data":           anyRole,
+	"PUT /api/user/:uuid":             adminOnly,
+	"DELETE /api/user/:uuid":          adminOnly,
+	"DELETE /api/user/:uuid/sessions": adminOnly,
+	"POST /api/user/:uuid/unlock":     adminOnly,
+	"POST /api/user/2fa/enroll":       staff,
+	"POST /api/user/2fa/confirm":      staff,
+	"DELETE /api/user/:uuid/2fa":      adminOnly,
+
+	// checkup
+	"GET /api/checkup/record/:recordUuid":                 staff,
+	"POST /api/checkup":                                   staff,
+	"PUT /api/checkup/:uuid":                              staff,
+	"DELETE /api/checkup/:uuid":                           staff,
+	"POST /api/checkup/:uuid/images":                      staff,
+	"GET /api/checkup/image/:name":                        staff,
+	"GET /api/checkup/image/:name/url":                    staff,
+	"POST /api/checkup/:uuid/uploads":                     staff,
+	"POST /api/checkup/:uuid/uploads/:imageUuid/complete": staff,
+
+	// illnesses
+	"POST /api/illnesses":                   staff,
+	"GET /api/illnesses/record/:recordUuid": staff,
+	"PUT /api/illnesses/:uuid":              staff,
+	"DELETE /api/illnesses/:uuid":           staff,
+
+	// prescriptions
+	"POST /api/prescriptions":                   staff,
+	"GET /api/prescriptions/illness/:illnessId": staff,
+	"DELETE /api/prescriptions/:uuid":           staff,
+
+	// medications
+	"GET /api/medications": staff,
+
+	// patient self-service portal
+	"POST /api/me/link":         patient,
+	"GET /api/me/patient":       patient,
+	"GET /api/me/record":        patient,
+	"GET /api/me/checkups":      patient,
+	"GET /api/me/illnesses":     patient,
+	"GET /api/me/prescriptions": patient,
+	"GET /api/me/images/:name":  patient,
+	"GET /api/me/export":        patient,
+
+	// audit log
+	"GET /api/audit": adminOnly,
+
+	// object storage
+	"POST /api/storage/reconcile": adminOnly,
+
+	// deleted entities
+	"GET /api/trash":                      adminOnly,
+	"POST /api/trash/:type/:uuid/restore": adminOnly,
+
+	// erasure of personal data
+	"PUT /api/patients/:id/legal-hold":    adminOnly,
+	"POST /api/patients/:id/erasure":      adminOnly,
+	"GET /api/erasures":                   adminOnly,
+	"POST /api/erasures/:uuid/approve":    adminOnly,
+	"POST /api/erasures/:uuid/reject":     adminOnly,
+	"GET /api/erasures/:uuid/certificate": adminOnly,
+
+	// FHIR API
+	"GET /fhir/:type":                staff,
+	"POST /fhir/:type":               staff,
+	"GET /fhir/:type/:id":            staff,
+	"GET /fhir/:type/:id/:operation": staff,
+
+	// HL7 v2 ingestion
+	"POST /api/hl7":                          staff,
+	"GET /api/hl7/dead-letters":              adminOnly,
+	"POST /api/hl7/dead-letters/:uuid/retry": adminOnly,
+}
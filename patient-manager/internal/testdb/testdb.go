@@ -0,0 +1,49 @@
+// Package testdb opens in-memory SQLite databases for tests
+package testdb
+
+import (
+	"PatientManager/config"
+	"PatientManager/model"
+	"PatientManager/util/encryption"
+	"fmt"
+	"testing"
+
+	"github.com/google/uuid"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// Open returns an empty in-memory database of the test migrated with the
+// given models, every call opens a separate database. Foreign keys are
+// enforced like on Postgres, SQLite ignores them by default.
+func Open(t testing.TB, models ...any) *gorm.DB {
+	t.Helper()
+
+	dsn := fmt.Sprintf("file:%s?mode=memory&cache=shared&_foreign_keys=1", uuid.NewString())
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{
+		Logger:         logger.Default.LogMode(logger.Silent),
+		TranslateError: true,
+	})
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	if err := db.AutoMigrate(models...); err != nil {
+		t.Fatalf("failed to migrate database: %v", err)
+	}
+	return db
+}
+
+// New sets config.AppConfig with encryption keys in a temporary directory
+// and returns a database of the test migrated with all models
+func New(t testing.TB) *gorm.DB {
+	t.Helper()
+
+	config.AppConfig = &config.AppConfiguration{
+		Encryption: config.EncryptionConfig{KeyDir: t.TempDir()},
+	}
+	if err := encryption.LoadKeys(); err != nil {
+		t.Fatalf("failed to load encryption keys: %v", err)
+	}
+	return Open(t, model.GetAllModels()...)
+}
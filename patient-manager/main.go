@@ -6,7 +6,15 @@ import (
 	"PatientManager/httpServer"
 	"PatientManager/repository"
 	"PatientManager/service"
+	"PatientManager/util/auth"
+	"PatientManager/util/encryption"
+	"PatientManager/util/notify"
+	"PatientManager/util/presign"
 	"PatientManager/util/seed"
+	"PatientManager/util/validation"
+	"context"
+	"os"
+	"time"
 
 	"go.uber.org/zap"
 )
@@ -24,13 +32,29 @@ func main() {
 	if err != nil {
 		panic(err)
 	}
+	if err := auth.LoadKeys(); err != nil {
+		panic(err)
+	}
+	if err := encryption.LoadKeys(); err != nil {
+		panic(err)
+	}
+	if err := validation.Register(); err != nil {
+		panic(err)
+	}
 	app.Setup()
 
 	// Provide logger
 	app.Provide(zap.S)
 
 	// Provide User and Login dependencies
+	app.Provide(notify.NewNotifier)
+	app.Provide(service.NewSessionService)
+	app.Provide(service.NewLoginThrottleService)
+	app.Provide(service.NewTwoFactorService)
+	app.Provide(service.NewPasswordService)
 	app.Provide(service.NewUserCrudService)
+	app.Provide(service.NewAccessService)
+	app.Provide(service.NewAuditService)
 	app.Provide(service.NewLoginService)
 
 	// Provide Patient dependencies
@@ -41,7 +65,47 @@ func main() {
 	app.Provide(service.NewMedicationService)
 	app.Provide(service.NewIllnessService)
 	app.Provide(service.NewPrescriptionService)
+	app.Provide(presign.NewSigner)
 	app.Provide(service.NewBucketService)
+	app.Provide(service.NewReconcileService)
+	app.Provide(service.NewEncryptionService)
+	app.Provide(service.NewTrashService)
+	app.Provide(service.NewExportService)
+	app.Provide(service.NewErasureService)
+	app.Provide(service.NewFhirService)
+	app.Provide(service.NewHl7Service)
+	app.Provide(service.NewPortalService)
+
+	// fail loudly instead of on the first upload if the storage is unreachable
+	app.Invoke(func(bucketService service.IbucketService) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := bucketService.Ping(ctx); err != nil {
+			zap.S().Panicf("Object storage %s is unavailable, err = %+v", config.AppConfig.Storage.Driver, err)
+		}
+	})
+
+	if len(os.Args) > 1 {
+		if err := runCommand(os.Args[1], os.Args[2:]); err != nil {
+			zap.S().Fatalf("Command %s failed, err = %+v", os.Args[1], err)
+		}
+		return
+	}
+
+	// patients and dead letters stored before encryption was enabled are
+	// encrypted once, and patients stored before they were indexed for search
+	// are indexed
+	app.Invoke(func(encryptionService service.IEncryptionService) {
+		if _, err := encryptionService.EncryptPatients(context.Background()); err != nil {
+			zap.S().Panicf("Can't encrypt patients, err = %+v", err)
+		}
+		if _, err := encryptionService.EncryptDeadLetters(context.Background()); err != nil {
+			zap.S().Panicf("Can't encrypt HL7 dead letters, err = %+v", err)
+		}
+		if _, err := encryptionService.IndexPatients(context.Background()); err != nil {
+			zap.S().Panicf("Can't index patients, err = %+v", err)
+		}
+	})
 
 	zap.S().Infof("Database: http://localhost:8080")
 
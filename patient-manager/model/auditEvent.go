@@ -0,0 +1,52 @@
+package model
+
+import (
+	"PatientManager/util/cerror"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type AuditAction string
+
+const (
+	AuditRead   AuditAction = "read"
+	AuditCreate AuditAction = "create"
+	AuditUpdate AuditAction = "update"
+	AuditDelete AuditAction = "delete"
+	AuditLock   AuditAction = "lockout"
+	AuditUnlock AuditAction = "unlock"
+	AuditExport AuditAction = "export"
+	AuditErase  AuditAction = "erase"
+)
+
+// AuditEvent is an append-only record of access to clinical data,
+// it doesn't embed gorm.Model since rows can never be updated or deleted
+type AuditEvent struct {
+	ID         uint        `gorm:"primarykey"`
+	Uuid       uuid.UUID   `gorm:"type:uuid;unique;not null"`
+	CreatedAt  time.Time   `gorm:"not null;index"`
+	ActorUuid  *uuid.UUID  `gorm:"type:uuid;null;index"`
+	Action     AuditAction `gorm:"type:varchar(20);not null"`
+	EntityType string      `gorm:"type:varchar(50);not null"`
+	EntityUuid *uuid.UUID  `gorm:"type:uuid;null"`
+	PatientID  *uint       `gorm:"type:uint;null;index"`
+	Diff       string      `gorm:"type:text;null"`
+	ClientIP   string      `gorm:"type:varchar(45);null"`
+}
+
+func (a *AuditEvent) BeforeCreate(tx *gorm.DB) error {
+	if a.Uuid == uuid.Nil {
+		a.Uuid = uuid.New()
+	}
+	return nil
+}
+
+func (a *AuditEvent) BeforeUpdate(tx *gorm.DB) error {
+	return cerror.ErrAuditImmutable
+}
+
+func (a *AuditEvent) BeforeDelete(tx *gorm.DB) error {
+	return cerror.ErrAuditImmutable
+}
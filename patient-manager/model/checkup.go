@@ -25,6 +25,47 @@ const (
 	NeurologyExam       CheckupType = "NEURO"
 )
 
+// CheckupTypes are all known checkup types
+var CheckupTypes = []CheckupType{
+	GeneralPractitioner,
+	BloodTest,
+	XRayScan,
+	CTScan,
+	MRIScan,
+	Ultrasound,
+	Electrocardiogram,
+	Echocardiogram,
+	EyeExam,
+	DermatologyExam,
+	DentalExam,
+	Mammography,
+	NeurologyExam,
+}
+
+// Valid reports whether t is one of CheckupTypes
+func (t CheckupType) Valid() bool {
+	for _, known := range CheckupTypes {
+		if t == known {
+			return true
+		}
+	}
+	return false
+}
+
+// Imaging reports whether checkups of type t produce DICOM images
+func (t CheckupType) Imaging() bool {
+	return t == CTScan || t == MRIScan || t == XRayScan || t == Ultrasound
+}
+
+// CheckupTypeNames returns CheckupTypes as strings
+func CheckupTypeNames() []string {
+	names := make([]string, len(CheckupTypes))
+	for i, t := range CheckupTypes {
+		names[i] = string(t)
+	}
+	return names
+}
+
 type Checkup struct {
 	gorm.Model
 	Uuid            uuid.UUID   `gorm:"type:uuid;unique;not null"`
@@ -34,7 +75,8 @@ type Checkup struct {
 	MedicalRecord   MedicalRecord
 	IllnessID       *uint `gorm:"type:uint;null"`
 	Illness         Illness
-	Images          []Image `gorm:"foreignKey:CheckupID"`
+	Images          []Image     `gorm:"foreignKey:CheckupID"`
+	LabResults      []LabResult `gorm:"foreignKey:CheckupID"`
 }
 
 func (c *Checkup) UpdateCheckup(checkup *Checkup) *Checkup {
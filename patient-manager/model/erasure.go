@@ -0,0 +1,66 @@
+package model
+
+import (
+	"PatientManager/util/cerror"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type ErasureStatus string
+
+const (
+	ErasurePending  ErasureStatus = "pending"
+	ErasureApproved ErasureStatus = "approved"
+	ErasureRejected ErasureStatus = "rejected"
+)
+
+// ErasureRequest asks for personal data of a patient to be erased, it has
+// to be approved by a superadmin other than the one that requested it
+type ErasureRequest struct {
+	gorm.Model
+	Uuid          uuid.UUID     `gorm:"type:uuid;unique;not null"`
+	PatientID     uint          `gorm:"type:uint;not null;index"`
+	Patient       Patient       `gorm:"foreignKey:PatientID"`
+	Reason        string        `gorm:"type:varchar(255);not null"`
+	Status        ErasureStatus `gorm:"type:varchar(20);not null;index"`
+	RequestedByID uint          `gorm:"type:uint;not null"`
+	RequestedBy   User          `gorm:"foreignKey:RequestedByID"`
+	DecidedByID   *uint         `gorm:"type:uint;null"`
+	DecidedBy     *User         `gorm:"foreignKey:DecidedByID"`
+	DecidedAt     *time.Time    `gorm:"null"`
+}
+
+// ErasureCertificate records that personal data of a patient was erased
+// without keeping any of it, rows can never be updated or deleted
+type ErasureCertificate struct {
+	ID                  uint      `gorm:"primarykey"`
+	Uuid                uuid.UUID `gorm:"type:uuid;unique;not null"`
+	CreatedAt           time.Time `gorm:"not null"`
+	ErasureRequestID    uint      `gorm:"type:uint;not null;uniqueIndex"`
+	PatientUuid         uuid.UUID `gorm:"type:uuid;not null;index"`
+	Reason              string    `gorm:"type:varchar(255);not null"`
+	RequestedByUuid     uuid.UUID `gorm:"type:uuid;not null"`
+	ApprovedByUuid      uuid.UUID `gorm:"type:uuid;not null"`
+	ErasedFields        string    `gorm:"type:varchar(255);not null"`
+	ImagesDeleted       int       `gorm:"not null"`
+	AuditEventsRedacted int       `gorm:"not null"`
+	DeadLettersDeleted  int       `gorm:"not null;default:0"`
+	UserAnonymized      bool      `gorm:"not null"`
+}
+
+func (c *ErasureCertificate) BeforeCreate(tx *gorm.DB) error {
+	if c.Uuid == uuid.Nil {
+		c.Uuid = uuid.New()
+	}
+	return nil
+}
+
+func (c *ErasureCertificate) BeforeUpdate(tx *gorm.DB) error {
+	return cerror.ErrErasureImmutable
+}
+
+func (c *ErasureCertificate) BeforeDelete(tx *gorm.DB) error {
+	return cerror.ErrErasureImmutable
+}
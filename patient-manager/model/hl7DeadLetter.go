@@ -0,0 +1,35 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type Hl7Source string
+
+const (
+	Hl7SourceMllp Hl7Source = "mllp"
+	Hl7SourceHttp Hl7Source = "http"
+)
+
+// Hl7DeadLetter is an HL7 message that couldn't be parsed or processed,
+// it's kept for manual review until it's successfully retried. The message
+// holds personal data, so it's encrypted and OIBIndex links it to the
+// patient for erasure.
+type Hl7DeadLetter struct {
+	ID          uint      `gorm:"primarykey"`
+	Uuid        uuid.UUID `gorm:"type:uuid;unique;not null"`
+	CreatedAt   time.Time `gorm:"not null;index"`
+	UpdatedAt   time.Time
+	Source      Hl7Source `gorm:"type:varchar(10);not null"`
+	ClientIP    string    `gorm:"type:varchar(45);null"`
+	MessageType string    `gorm:"type:varchar(20);null"`
+	ControlID   string    `gorm:"type:varchar(50);null"`
+	Message     string    `gorm:"type:text;not null;serializer:encrypted"`
+	// OIBIndex is the blind index of the patient's OIB, empty if the
+	// message has no valid OIB
+	OIBIndex string `gorm:"type:char(64);null;index"`
+	Error    string `gorm:"type:text;not null"`
+	Attempts int    `gorm:"not null;default:1"`
+}
@@ -1,14 +1,60 @@
 package model
 
 import (
+	"time"
+
 	"github.com/google/uuid"
 	"gorm.io/gorm"
 )
 
+// ImageStatus tracks the upload of an image's objects. Pending images are
+// created before the upload and become ready after it, they are hidden until
+// then and repaired or removed by service.IReconcileService if the upload
+// was interrupted. Uploading images wait for the client to upload the object
+// to a presigned URL, they are checked only when the upload is completed and
+// are removed if it isn't.
+type ImageStatus string
+
+const (
+	ImagePending   ImageStatus = "pending"
+	ImageUploading ImageStatus = "uploading"
+	ImageReady     ImageStatus = "ready"
+)
+
 type Image struct {
 	gorm.Model
 	Uuid      uuid.UUID `gorm:"type:uuid;unique;not null"`
 	Path      string    `gorm:"type:varchar(255);not null"`
 	CheckupID uint
 	Checkup   Checkup
+	Status    ImageStatus `gorm:"type:varchar(20);not null;default:ready;index"`
+	// ContentType is detected from the content on upload
+	ContentType string `gorm:"type:varchar(100);null"`
+	Size        int64  `gorm:"null"`
+	// ThumbnailPath is a JPEG of at most ImagePolicy.ThumbnailSize pixels,
+	// empty if the image can't be decoded
+	ThumbnailPath string `gorm:"type:varchar(255);null"`
+	// PreviewPath is the PNG rendering of a DICOM image, empty for other
+	// images or if the pixel data can't be rendered
+	PreviewPath string `gorm:"type:varchar(255);null"`
+	// KeyID is the kid of the key that wraps data keys of the image's
+	// objects, empty if they are stored unencrypted
+	KeyID string `gorm:"type:varchar(64);null;index"`
+	// DICOM attributes, set only for DICOM images
+	StudyInstanceUID  string     `gorm:"type:varchar(64);null"`
+	SeriesInstanceUID string     `gorm:"type:varchar(64);null"`
+	SOPInstanceUID    string     `gorm:"type:varchar(64);null;index"`
+	Modality          string     `gorm:"type:varchar(16);null"`
+	AcquiredAt        *time.Time `gorm:"null"`
+}
+
+// ObjectNames returns names of the image's objects in the bucket
+func (i *Image) ObjectNames() []string {
+	names := []string{i.Path}
+	for _, name := range []string{i.PreviewPath, i.ThumbnailPath} {
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
 }
@@ -0,0 +1,29 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// LabResult is an observation of a blood test checkup received in an HL7
+// ORU^R01 message. Results are identified by Code within the checkup, a
+// resent or corrected observation replaces the previous value. They don't
+// embed gorm.Model since they're deleted together with the checkup.
+type LabResult struct {
+	ID             uint      `gorm:"primarykey"`
+	Uuid           uuid.UUID `gorm:"type:uuid;unique;not null"`
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+	CheckupID      uint   `gorm:"type:uint;not null;uniqueIndex:idx_lab_results_checkup_code"`
+	Code           string `gorm:"type:varchar(50);not null;uniqueIndex:idx_lab_results_checkup_code"`
+	Name           string `gorm:"type:varchar(255);null"`
+	Value          string `gorm:"type:varchar(255);not null"`
+	Units          string `gorm:"type:varchar(50);null"`
+	ReferenceRange string `gorm:"type:varchar(100);null"`
+	// Flag is the abnormal flag, e.g. H (high) or L (low)
+	Flag string `gorm:"type:varchar(10);null"`
+	// Status is the result status, e.g. F (final) or C (corrected)
+	Status     string     `gorm:"type:varchar(2);null"`
+	ObservedAt *time.Time `gorm:"null"`
+}
@@ -0,0 +1,26 @@
+package model
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type ThrottleKind string
+
+const (
+	ThrottleAccount ThrottleKind = "account"
+	ThrottleIP      ThrottleKind = "ip"
+)
+
+// LoginThrottle tracks failed logins of an account (by email) or a client IP
+type LoginThrottle struct {
+	gorm.Model
+	Kind          ThrottleKind `gorm:"type:varchar(10);not null;uniqueIndex:idx_login_throttle_subject"`
+	Subject       string       `gorm:"type:varchar(255);not null;uniqueIndex:idx_login_throttle_subject"`
+	Failures      int          `gorm:"not null;default:0"`
+	LastFailureAt *time.Time
+	BlockedUntil  *time.Time
+	// Locked is set when blocked because of too many failures, not just backoff
+	Locked bool `gorm:"not null;default:false"`
+}
@@ -5,16 +5,32 @@ import (
 	"gorm.io/gorm"
 )
 
+// Medication is a drug of the catalog, identified by its name, strength and
+// dosage form. ATCCode is its WHO ATC code, e.g. N02BE01. Medications added
+// before the catalog have only a name until an import completes them.
 type Medication struct {
 	gorm.Model
-	Uuid           uuid.UUID `gorm:"type:uuid;unique;not null"`
-	Name           string    `gorm:"type:varchar(100);not null"`
-	PrescriptionID *uint     `gorm:"type:uint;null"`
-	Prescription   Prescription
+	Uuid             uuid.UUID `gorm:"type:uuid;unique;not null"`
+	Name             string    `gorm:"type:varchar(100);not null;uniqueIndex:idx_medications_product"`
+	ATCCode          string    `gorm:"type:varchar(7);null;index"`
+	ActiveIngredient string    `gorm:"type:varchar(200);null"`
+	Strength         string    `gorm:"type:varchar(50);not null;default:'';uniqueIndex:idx_medications_product"`
+	DosageForm       string    `gorm:"type:varchar(50);not null;default:'';uniqueIndex:idx_medications_product"`
+	Route            string    `gorm:"type:varchar(50);null"`
 }
 
 func (m *Medication) UpdateMedication(medication *Medication) *Medication {
 	m.Name = medication.Name
+	m.ATCCode = medication.ATCCode
+	m.ActiveIngredient = medication.ActiveIngredient
+	m.Strength = medication.Strength
+	m.DosageForm = medication.DosageForm
+	m.Route = medication.Route
 
 	return m
 }
+
+// Legacy tells if the medication was added before the catalog
+func (m *Medication) Legacy() bool {
+	return m.ATCCode == "" && m.Strength == "" && m.DosageForm == ""
+}
@@ -0,0 +1,18 @@
+package model
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// PasswordResetToken is a single use token for resetting a forgotten
+// password, only its hash is stored
+type PasswordResetToken struct {
+	gorm.Model
+	UserID    uint `gorm:"type:uint;not null;index"`
+	User      User
+	TokenHash string    `gorm:"type:char(64);not null;uniqueIndex"`
+	ExpiresAt time.Time `gorm:"not null"`
+	UsedAt    *time.Time
+}
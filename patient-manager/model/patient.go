@@ -1,6 +1,7 @@
 package model
 
 import (
+	"PatientManager/util/encryption"
 	"time"
 
 	"github.com/google/uuid"
@@ -9,16 +10,42 @@ import (
 
 type Patient struct {
 	gorm.Model
-	Uuid            uuid.UUID `gorm:"type:uuid;unique;not null"`
-	FirstName       string    `gorm:"type:varchar(100);not null"`
-	LastName        string    `gorm:"type:varchar(100);not null"`
-	OIB             string    `gorm:"type:char(11);unique;not null"`
+	Uuid uuid.UUID `gorm:"type:uuid;unique;not null"`
+	// names and the OIB are encrypted, patients are looked up by OIBIndex
+	FirstName string `gorm:"type:text;not null;serializer:encrypted"`
+	LastName  string `gorm:"type:text;not null;serializer:encrypted"`
+	OIB       string `gorm:"type:varchar(128);not null;serializer:encrypted"`
+	OIBIndex  string `gorm:"type:char(64);null;uniqueIndex"`
+	// patients are sorted by ranks of their names, see repository.IndexPatient
+	FirstNameRank   float64   `gorm:"not null;default:0;index"`
+	LastNameRank    float64   `gorm:"not null;default:0;index"`
 	BirthDate       time.Time `gorm:"type:date;not null"`
 	Gender          string    `gorm:"type:char(1);not null"`
 	MedicalRecordID uint      `gorm:"type:uint;not null"`
 	MedicalRecord   MedicalRecord
 	DoctorID        *uint `gorm:"type:uint;null"`
 	Doctor          User
+	// UserID links the patient to a user account with role patient
+	UserID *uint `gorm:"type:uint;null;uniqueIndex"`
+	User   *User `gorm:"foreignKey:UserID"`
+	// LegalHold blocks erasure and purge of the patient's data
+	LegalHold       bool   `gorm:"not null;default:false"`
+	LegalHoldReason string `gorm:"type:varchar(255);null"`
+	// ErasedAt is set when personal data is replaced with pseudonyms, see ErasureRequest
+	ErasedAt *time.Time `gorm:"null"`
+}
+
+// BeforeSave updates the blind index of the OIB, map updates of the OIB must
+// set oib_index too
+func (p *Patient) BeforeSave(tx *gorm.DB) error {
+	p.OIBIndex = OIBIndex(p.OIB)
+	return nil
+}
+
+// OIBIndex returns the blind index of the OIB, patients are looked up with
+// Where("oib_index = ?", OIBIndex(oib))
+func OIBIndex(oib string) string {
+	return encryption.BlindIndex(oib)
 }
 
 func (p *Patient) UpdatePatient(patient *Patient) *Patient {
@@ -0,0 +1,25 @@
+package model
+
+// PatientSearchToken is a blind index of a part of a patient's name. Names
+// are encrypted, so patients are searched by tokens of the search terms
+// instead, see repository.PatientRepository.Search. Tokens are replaced by
+// repository.IndexPatient whenever the name changes.
+type PatientSearchToken struct {
+	ID        uint            `gorm:"primarykey"`
+	PatientID uint            `gorm:"not null;index"`
+	Kind      SearchTokenKind `gorm:"type:varchar(10);not null"`
+	Token     string          `gorm:"type:char(64);not null;index"`
+}
+
+type SearchTokenKind string
+
+const (
+	// TokenTrigram are trigrams of the full name
+	TokenTrigram SearchTokenKind = "trigram"
+	// TokenPrefix are prefixes of words of the name
+	TokenPrefix SearchTokenKind = "prefix"
+	// TokenWord are words of the name
+	TokenWord SearchTokenKind = "word"
+	// TokenName is the full name with first and last name in both orders
+	TokenName SearchTokenKind = "name"
+)
@@ -0,0 +1,13 @@
+package model
+
+import "gorm.io/gorm"
+
+// PatientShare gives a doctor access to a patient that is not assigned to them
+type PatientShare struct {
+	gorm.Model
+	PatientID   uint `gorm:"type:uint;not null;index"`
+	Patient     Patient
+	DoctorID    uint `gorm:"type:uint;not null;index"`
+	Doctor      User
+	GrantedByID uint `gorm:"type:uint;not null"`
+}
@@ -0,0 +1,20 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// PrescriptionLine is a medication of the catalog on a prescription, a
+// medication can be on any number of prescriptions but only once on each.
+// Lines are deleted only when their prescription is purged.
+type PrescriptionLine struct {
+	ID             uint `gorm:"primarykey"`
+	CreatedAt      time.Time
+	Uuid           uuid.UUID `gorm:"type:uuid;unique;not null"`
+	PrescriptionID uint      `gorm:"not null;uniqueIndex:idx_prescription_lines_medication"`
+	Prescription   Prescription
+	MedicationID   uint `gorm:"not null;uniqueIndex:idx_prescription_lines_medication;index"`
+	Medication     Medication
+}
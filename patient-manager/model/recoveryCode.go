@@ -0,0 +1,17 @@
+package model
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// RecoveryCode is a single use code that replaces a TOTP code when the
+// authenticator is lost, only its hash is stored
+type RecoveryCode struct {
+	gorm.Model
+	UserID   uint `gorm:"type:uint;not null;index"`
+	User     User
+	CodeHash string `gorm:"type:char(64);not null;uniqueIndex"`
+	UsedAt   *time.Time
+}
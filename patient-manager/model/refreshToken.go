@@ -0,0 +1,21 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// RefreshToken is an issued refresh token, only its hash is stored. Tokens
+// created by rotating each other share a FamilyID which identifies a session.
+type RefreshToken struct {
+	gorm.Model
+	UserID    uint `gorm:"type:uint;not null;index"`
+	User      User
+	FamilyID  uuid.UUID `gorm:"type:uuid;not null;index"`
+	TokenHash string    `gorm:"type:char(64);not null;uniqueIndex"`
+	ExpiresAt time.Time `gorm:"not null"`
+	UsedAt    *time.Time
+	RevokedAt *time.Time
+}
@@ -4,11 +4,23 @@ func GetAllModels() []any {
 	return []any{
 		&User{},
 		&Patient{},
+		&PatientSearchToken{},
 		&MedicalRecord{},
 		&Checkup{},
 		&Prescription{},
 		&Medication{},
+		&PrescriptionLine{},
 		&Illness{},
 		&Image{},
+		&PatientShare{},
+		&AuditEvent{},
+		&RefreshToken{},
+		&PasswordResetToken{},
+		&LoginThrottle{},
+		&RecoveryCode{},
+		&ErasureRequest{},
+		&ErasureCertificate{},
+		&LabResult{},
+		&Hl7DeadLetter{},
 	}
 }
@@ -43,6 +43,12 @@ type User struct {
 	PasswordHash string    `gorm:"type:varchar(255);not null"`
 	Role         UserRole  `gorm:"type:varchar(20);not null"`
 	Patients     []Patient `gorm:"foreignKey:DoctorID"`
+	// MustChangePassword blocks login until the user sets a new password
+	MustChangePassword bool `gorm:"not null;default:false"`
+	// TotpSecret is set on enrollment, TotpEnabled once the first code is confirmed
+	TotpSecret      string `gorm:"type:varchar(64);null"`
+	TotpEnabled     bool   `gorm:"not null;default:false"`
+	TotpLastCounter int64  `gorm:"not null;default:0"`
 }
 
 func (u *User) BeforeCreate(tx *gorm.DB) error {
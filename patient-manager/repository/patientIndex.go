@@ -0,0 +1,296 @@
+package repository
+
+import (
+	"PatientManager/model"
+	"PatientManager/util/encryption"
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"gorm.io/gorm"
+)
+
+// Names are encrypted, so patients are sorted by ranks that preserve the
+// order of names (case-insensitive) instead. A new rank is put halfway
+// between the ranks of its neighbours, found by a binary search over
+// decrypted names, and ranks are spread rankGap apart again when there is
+// no room left between neighbours. Equal names share the rank.
+//
+// Ranks give away the order of names to anyone who can read the patients
+// table, though not the names. That is accepted to sort patients by name,
+// erasure redacts the ranks of the patient.
+const rankGap = 1 << 20
+
+// rankLockKey is the Postgres advisory lock held while ranks are set
+const rankLockKey = 0x706d5f72616e6b
+
+const (
+	// minPrefixLength keeps prefixes short enough to narrow names down to a
+	// few letters out of the index, shorter words are indexed whole
+	minPrefixLength = 3
+	// maxPrefixLength limits prefixes of long words, longer search words
+	// match by trigrams
+	maxPrefixLength = 20
+)
+
+// rankedNames are columns of names with their rank columns
+var rankedNames = map[string]string{
+	"first_name": "first_name_rank",
+	"last_name":  "last_name_rank",
+}
+
+// IndexPatient sets the ranks of the patient's names and replaces its search
+// tokens, see model.PatientSearchToken. tx must be a transaction, ranking is
+// serialized until it ends so that ranks of other patients don't change
+// meanwhile. Queries aren't recorded by the audit, they read names of other
+// patients.
+func IndexPatient(tx *gorm.DB, p *model.Patient) error {
+	if err := lockRanks(tx); err != nil {
+		return err
+	}
+
+	for column := range rankedNames {
+		rank, err := nameRank(tx, column, patientName(p, column), p.ID)
+		if err != nil {
+			return err
+		}
+		setPatientRank(p, column, rank)
+	}
+	err := indexSession(tx).Model(p).
+		UpdateColumns(map[string]any{"first_name_rank": p.FirstNameRank, "last_name_rank": p.LastNameRank}).Error
+	if err != nil {
+		return err
+	}
+
+	session := indexSession(tx)
+	if err := session.Where("patient_id = ?", p.ID).Delete(&model.PatientSearchToken{}).Error; err != nil {
+		return err
+	}
+	return session.CreateInBatches(searchTokens(p), 500).Error
+}
+
+// lockRanks holds the rank lock until the transaction ends, SQLite allows
+// only one writing transaction at a time anyway
+func lockRanks(tx *gorm.DB) error {
+	if tx.Dialector.Name() != "postgres" {
+		return nil
+	}
+	return tx.Exec("SELECT pg_advisory_xact_lock(?)", rankLockKey).Error
+}
+
+// indexSession returns a session of the transaction whose queries aren't
+// recorded by the audit
+func indexSession(tx *gorm.DB) *gorm.DB {
+	return tx.Session(&gorm.Session{NewDB: true, Context: context.Background()}).Unscoped()
+}
+
+// nameRank returns the rank of the name among ranked names of other patients
+func nameRank(tx *gorm.DB, column, name string, id uint) (float64, error) {
+	rank, ok, err := findRank(tx, column, name, id)
+	if err != nil || ok {
+		return rank, err
+	}
+
+	if err := spreadRanks(tx, rankedNames[column]); err != nil {
+		return 0, err
+	}
+	rank, ok, err = findRank(tx, column, name, id)
+	if err == nil && !ok {
+		err = fmt.Errorf("no rank left for %s after spreading ranks", column)
+	}
+	return rank, err
+}
+
+// findRank returns false if there is no room between the ranks of the
+// name's neighbours
+func findRank(tx *gorm.DB, column, name string, id uint) (float64, bool, error) {
+	rankColumn := rankedNames[column]
+	others := func() *gorm.DB {
+		return indexSession(tx).Model(&model.Patient{}).Where(rankColumn+" > 0 AND id <> ?", id)
+	}
+	// at returns the patient at the position in the order of ranks
+	at := func(position int64) (*model.Patient, error) {
+		var patient model.Patient
+		err := others().
+			Select("id", column, rankColumn).
+			Order(rankColumn).Order("id").
+			Offset(int(position)).Limit(1).
+			Take(&patient).Error
+		return &patient, err
+	}
+
+	var count int64
+	if err := others().Count(&count).Error; err != nil {
+		return 0, false, err
+	}
+
+	// position of the first patient with a greater name
+	key := normalizeName(name)
+	low, high := int64(0), count
+	for low < high {
+		middle := (low + high) / 2
+		patient, err := at(middle)
+		if err != nil {
+			return 0, false, err
+		}
+		if normalizeName(patientName(patient, column)) > key {
+			high = middle
+		} else {
+			low = middle + 1
+		}
+	}
+
+	previous, next := 0.0, 0.0
+	if low > 0 {
+		patient, err := at(low - 1)
+		if err != nil {
+			return 0, false, err
+		}
+		if normalizeName(patientName(patient, column)) == key {
+			return patientRank(patient, column), true, nil
+		}
+		previous = patientRank(patient, column)
+	}
+	if low < count {
+		patient, err := at(low)
+		if err != nil {
+			return 0, false, err
+		}
+		next = patientRank(patient, column)
+	} else {
+		next = previous + 2*rankGap
+	}
+
+	rank := previous + (next-previous)/2
+	return rank, rank > previous && rank < next, nil
+}
+
+// spreadRanks sets ranks of the column rankGap apart keeping their order
+func spreadRanks(tx *gorm.DB, rankColumn string) error {
+	return indexSession(tx).Exec(fmt.Sprintf(
+		`UPDATE patients SET %[1]s = ranked.position * ?
+		FROM (SELECT id, ROW_NUMBER() OVER (ORDER BY %[1]s, id) AS position FROM patients WHERE %[1]s > 0) AS ranked
+		WHERE ranked.id = patients.id`, rankColumn), rankGap).Error
+}
+
+func patientName(p *model.Patient, column string) string {
+	if column == "first_name" {
+		return p.FirstName
+	}
+	return p.LastName
+}
+
+func patientRank(p *model.Patient, column string) float64 {
+	if column == "first_name" {
+		return p.FirstNameRank
+	}
+	return p.LastNameRank
+}
+
+func setPatientRank(p *model.Patient, column string, rank float64) {
+	if column == "first_name" {
+		p.FirstNameRank = rank
+	} else {
+		p.LastNameRank = rank
+	}
+}
+
+// searchToken returns the token of a normalized value, tokens of different
+// kinds never match each other or model.OIBIndex
+func searchToken(kind model.SearchTokenKind, value string) string {
+	return encryption.BlindIndex(string(kind) + ":" + value)
+}
+
+// normalizeName lower cases the name and collapses white space
+func normalizeName(name string) string {
+	return strings.ToLower(strings.Join(strings.Fields(name), " "))
+}
+
+// searchPrefix returns the indexed prefix that a search word matches, words
+// longer than maxPrefixLength match by their first maxPrefixLength letters
+// and words shorter than minPrefixLength only whole words
+func searchPrefix(word string) string {
+	count := 0
+	for i := range word {
+		if count == maxPrefixLength {
+			return word[:i]
+		}
+		count++
+	}
+	return word
+}
+
+// namePrefixes returns prefixes of the word that are indexed, from
+// minPrefixLength to maxPrefixLength letters, or the word if it's shorter
+func namePrefixes(word string) []string {
+	if utf8.RuneCountInString(word) < minPrefixLength {
+		return []string{word}
+	}
+
+	var prefixes []string
+	count := 0
+	for i := range word {
+		if count >= minPrefixLength {
+			prefixes = append(prefixes, word[:i])
+		}
+		if len(prefixes) == maxPrefixLength-minPrefixLength+1 {
+			return prefixes
+		}
+		count++
+	}
+	return append(prefixes, word)
+}
+
+// nameTrigrams returns distinct trigrams of words of the normalized text
+// like pg_trgm, words are padded with two spaces in front and one at the end
+func nameTrigrams(text string) []string {
+	set := map[string]bool{}
+	words := strings.FieldsFunc(text, func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r > 127)
+	})
+	for _, word := range words {
+		padded := []rune("  " + word + " ")
+		for i := 0; i+3 <= len(padded); i++ {
+			set[string(padded[i:i+3])] = true
+		}
+	}
+
+	trigrams := make([]string, 0, len(set))
+	for trigram := range set {
+		trigrams = append(trigrams, trigram)
+	}
+	sort.Strings(trigrams)
+	return trigrams
+}
+
+// searchTokens returns tokens of the patient's name, the OIB is looked up
+// by model.OIBIndex only
+func searchTokens(p *model.Patient) []model.PatientSearchToken {
+	first, last := normalizeName(p.FirstName), normalizeName(p.LastName)
+	fullName := first + " " + last
+
+	var tokens []model.PatientSearchToken
+	seen := map[string]bool{}
+	add := func(kind model.SearchTokenKind, value string) {
+		token := searchToken(kind, value)
+		if !seen[token] {
+			seen[token] = true
+			tokens = append(tokens, model.PatientSearchToken{PatientID: p.ID, Kind: kind, Token: token})
+		}
+	}
+
+	for _, trigram := range nameTrigrams(fullName) {
+		add(model.TokenTrigram, trigram)
+	}
+	for _, word := range strings.Fields(fullName) {
+		add(model.TokenWord, word)
+		for _, prefix := range namePrefixes(word) {
+			add(model.TokenPrefix, prefix)
+		}
+	}
+	add(model.TokenName, fullName)
+	add(model.TokenName, last+" "+first)
+	return tokens
+}
@@ -3,6 +3,8 @@ package repository
 import (
 	"PatientManager/app"
 	"PatientManager/model"
+	"PatientManager/util/query"
+	"context"
 
 	"gorm.io/gorm"
 )
@@ -21,25 +23,59 @@ func NewPatientRepository() PatientRepository {
 	return repo
 }
 
-func (r *PatientRepository) FindAll() ([]model.Patient, error) {
+// WithContext returns a copy of the repository that runs queries with ctx
+func (r PatientRepository) WithContext(ctx context.Context) PatientRepository {
+	return PatientRepository{db: r.db.WithContext(ctx)}
+}
+
+func (r *PatientRepository) FindAll(scopes ...func(*gorm.DB) *gorm.DB) ([]model.Patient, error) {
 	var patients []model.Patient
-	err := r.db.Preload("MedicalRecord").Find(&patients).Error
+	err := r.db.Scopes(scopes...).Preload("MedicalRecord").Find(&patients).Error
 	return patients, err
 }
 
+// FindPage loads a page of patients matched by scopes, see query.Find
+func (r *PatientRepository) FindPage(req query.Request, fields query.Fields, defaultSort string, scopes ...func(*gorm.DB) *gorm.DB) ([]model.Patient, *query.Page, error) {
+	var patients []model.Patient
+	page, err := query.Find(r.db.Model(&model.Patient{}).Scopes(scopes...).Preload("MedicalRecord"), req, fields, defaultSort, &patients)
+	return patients, page, err
+}
+
 func (r *PatientRepository) FindById(id uint) (model.Patient, error) {
 	var patient model.Patient
 	err := r.db.Preload("MedicalRecord").First(&patient, id).Error
 	return patient, err
 }
 
+// Create stores the patient and indexes its names, see IndexPatient
 func (r *PatientRepository) Create(patient model.Patient) (model.Patient, error) {
-	err := r.db.Create(&patient).Error
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&patient).Error; err != nil {
+			return err
+		}
+		return IndexPatient(tx, &patient)
+	})
 	return patient, err
 }
 
+// Update saves the patient, its names are indexed again if the names
+// changed
 func (r *PatientRepository) Update(patient model.Patient) (model.Patient, error) {
-	err := r.db.Preload("MedicalRecord").Save(&patient).Error
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		var stored model.Patient
+		err := indexSession(tx).Select("id", "first_name", "last_name").Take(&stored, patient.ID).Error
+		if err != nil {
+			return err
+		}
+
+		if err := tx.Preload("MedicalRecord").Save(&patient).Error; err != nil {
+			return err
+		}
+		if stored.FirstName == patient.FirstName && stored.LastName == patient.LastName {
+			return nil
+		}
+		return IndexPatient(tx, &patient)
+	})
 	return patient, err
 }
 
@@ -48,7 +84,3 @@ func (r *PatientRepository) FindByIdWithDoctor(id uint) (model.Patient, error) {
 	err := r.db.Preload("Doctor").Preload("MedicalRecord").First(&patient, id).Error
 	return patient, err
 }
-
-func (r *PatientRepository) Delete(id uint) error {
-	return r.db.Delete(&model.Patient{}, id).Error
-}
@@ -0,0 +1,223 @@
+package repository
+
+import (
+	"PatientManager/model"
+	"context"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const oibLength = 11
+
+// PatientSearch describes a patient search, every set criterion must match
+type PatientSearch struct {
+	// Name is matched against first and last name in any order, by word
+	// prefixes or fuzzy by trigram similarity
+	Name string
+	// OIB matches exactly, it isn't indexed by prefixes
+	OIB       string
+	BirthDate *time.Time
+	// Uuid matches the medical record or the patient
+	Uuid  *uuid.UUID
+	Limit int
+}
+
+// PatientMatch is a patient found by Search with its score between 0 and 1
+type PatientMatch struct {
+	Patient model.Patient
+	Score   float64
+}
+
+// Search returns patients matched by search and scopes ordered by score.
+// Names and OIBs are encrypted, so they are matched by blind-indexed search
+// tokens (see model.PatientSearchToken) and only returned matches are
+// decrypted.
+func (r *PatientRepository) Search(search PatientSearch, scopes ...func(*gorm.DB) *gorm.DB) ([]PatientMatch, error) {
+	name := normalizeName(search.Name)
+	if search.Uuid == nil && search.OIB == "" && search.BirthDate == nil && name == "" {
+		return nil, nil
+	}
+	if search.OIB != "" && len(search.OIB) != oibLength {
+		return nil, nil
+	}
+
+	// candidates aren't recorded as read by the audit, only returned matches are
+	db := r.db.WithContext(context.Background()).Model(&model.Patient{}).Scopes(scopes...)
+	tokens := func() *gorm.DB {
+		return r.db.Session(&gorm.Session{NewDB: true}).Model(&model.PatientSearchToken{})
+	}
+
+	if search.Uuid != nil {
+		db = db.Where("patients.uuid = ? OR patients.medical_record_id IN (?)",
+			*search.Uuid,
+			r.db.Session(&gorm.Session{NewDB: true}).Model(&model.MedicalRecord{}).Select("id").Where("uuid = ?", *search.Uuid))
+	}
+	if search.OIB != "" {
+		db = db.Where("patients.oib_index = ?", model.OIBIndex(search.OIB))
+	}
+	if search.BirthDate != nil {
+		day := search.BirthDate.Truncate(24 * time.Hour)
+		db = db.Where("patients.birth_date >= ? AND patients.birth_date < ?", day, day.AddDate(0, 0, 1))
+	}
+
+	var terms nameTerms
+	columns := "patients.id, 0 AS common, 0 AS prefixes, 0 AS words, 0 AS names, 0 AS total"
+	if name != "" {
+		terms = newNameTerms(name)
+		db = terms.match(db, tokens)
+		columns = "patients.id, matched.common, matched.prefixes, matched.words, matched.names, matched.total"
+	}
+
+	var candidates []nameMatch
+	err := db.Select(columns).
+		Order("patients.last_name_rank").Order("patients.first_name_rank").Order("patients.id").
+		Scan(&candidates).Error
+	if err != nil {
+		return nil, err
+	}
+
+	matches := make([]PatientMatch, 0, len(candidates))
+	for _, candidate := range candidates {
+		// the score is the average of all criteria
+		var scores []float64
+		if search.Uuid != nil || search.OIB != "" || search.BirthDate != nil {
+			scores = append(scores, 1.0)
+		}
+		if name != "" {
+			scores = append(scores, terms.score(candidate))
+		}
+
+		total := 0.0
+		for _, score := range scores {
+			total += score
+		}
+		matches = append(matches, PatientMatch{Patient: model.Patient{Model: gorm.Model{ID: candidate.ID}}, Score: total / float64(len(scores))})
+	}
+
+	// candidates are ordered by name, which orders matches with equal scores
+	sort.SliceStable(matches, func(i, j int) bool {
+		return matches[i].Score > matches[j].Score
+	})
+	if search.Limit > 0 && len(matches) > search.Limit {
+		matches = matches[:search.Limit]
+	}
+	if len(matches) == 0 {
+		return nil, nil
+	}
+
+	ids := make([]uint, 0, len(matches))
+	for _, match := range matches {
+		ids = append(ids, match.Patient.ID)
+	}
+
+	var patients []model.Patient
+	if err := r.db.Preload("Doctor").Preload("MedicalRecord").Where("id IN ?", ids).Find(&patients).Error; err != nil {
+		return nil, err
+	}
+	byID := make(map[uint]model.Patient, len(patients))
+	for _, patient := range patients {
+		byID[patient.ID] = patient
+	}
+
+	found := matches[:0]
+	for _, match := range matches {
+		if patient, ok := byID[match.Patient.ID]; ok {
+			found = append(found, PatientMatch{Patient: patient, Score: match.Score})
+		}
+	}
+	return found, nil
+}
+
+// nameMatch counts search tokens of a name that a patient has
+type nameMatch struct {
+	ID uint
+	// Common are trigrams of the name, Total all trigrams of the patient
+	Common   int
+	Prefixes int
+	Words    int
+	Names    int
+	Total    int
+}
+
+// nameTerms are search tokens of a name, every word is looked up as a
+// prefix and as a whole word
+type nameTerms struct {
+	trigrams []string
+	prefixes []string
+	words    []string
+	name     string
+}
+
+func newNameTerms(name string) nameTerms {
+	terms := nameTerms{name: searchToken(model.TokenName, name)}
+	for _, trigram := range nameTrigrams(name) {
+		terms.trigrams = append(terms.trigrams, searchToken(model.TokenTrigram, trigram))
+	}
+	prefixes, words := map[string]bool{}, map[string]bool{}
+	for _, word := range strings.Fields(name) {
+		prefixes[searchToken(model.TokenPrefix, searchPrefix(word))] = true
+		words[searchToken(model.TokenWord, word)] = true
+	}
+	for prefix := range prefixes {
+		terms.prefixes = append(terms.prefixes, prefix)
+	}
+	for word := range words {
+		terms.words = append(terms.words, word)
+	}
+	return terms
+}
+
+// match joins counts of matched tokens and keeps patients where every word
+// is a prefix of the first or the last name, or whose name is similar enough
+// by trigrams, with the thresholds of pg_trgm (0.3 and 0.6 for words)
+func (t nameTerms) match(db *gorm.DB, tokens func() *gorm.DB) *gorm.DB {
+	values := append(append(append([]string{t.name}, t.trigrams...), t.prefixes...), t.words...)
+	counts := tokens().
+		Select(`patient_id,
+			SUM(CASE WHEN kind = ? THEN 1 ELSE 0 END) AS common,
+			SUM(CASE WHEN kind = ? THEN 1 ELSE 0 END) AS prefixes,
+			SUM(CASE WHEN kind = ? THEN 1 ELSE 0 END) AS words,
+			SUM(CASE WHEN kind = ? THEN 1 ELSE 0 END) AS names,
+			(SELECT COUNT(*) FROM patient_search_tokens AS patient_trigrams
+				WHERE patient_trigrams.patient_id = patient_search_tokens.patient_id AND patient_trigrams.kind = ?) AS total`,
+			model.TokenTrigram, model.TokenPrefix, model.TokenWord, model.TokenName, model.TokenTrigram).
+		Where("token IN ?", values).
+		Group("patient_id")
+
+	db = db.Joins("JOIN (?) AS matched ON matched.patient_id = patients.id", counts)
+	n := len(t.trigrams)
+	if n == 0 {
+		return db.Where("matched.prefixes = ?", len(t.prefixes))
+	}
+	return db.Where("matched.prefixes = ? OR matched.common >= ? OR matched.common * 1.3 >= 0.3 * (matched.total + ?)",
+		len(t.prefixes), 0.6*float64(n), n)
+}
+
+// score of a matched name: 1.0 for the full name, 0.9 for whole words
+// followed by a prefix, 0.7 for prefixes, or the trigram similarity
+func (t nameTerms) score(m nameMatch) float64 {
+	score := 0.0
+	if m.Prefixes == len(t.prefixes) {
+		switch {
+		case m.Names > 0:
+			score = 1.0
+		case m.Words >= len(t.words)-1:
+			score = 0.9
+		default:
+			score = 0.7
+		}
+	}
+
+	if n := len(t.trigrams); n > 0 {
+		similarity := float64(m.Common) / float64(n+m.Total-m.Common)
+		wordSimilarity := float64(m.Common) / float64(n)
+		if similarity >= 0.3 || wordSimilarity >= 0.6 {
+			score = max(score, similarity, wordSimilarity)
+		}
+	}
+	return score
+}
@@ -0,0 +1,184 @@
+package repository
+
+import (
+	"PatientManager/internal/testdb"
+	"PatientManager/model"
+	"PatientManager/util/query"
+	"fmt"
+	"math/rand"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+func createTestPatient(t *testing.T, db *gorm.DB, firstName, lastName, oib string) *model.Patient {
+	t.Helper()
+
+	patient := &model.Patient{
+		Uuid:      uuid.New(),
+		FirstName: firstName,
+		LastName:  lastName,
+		OIB:       oib,
+		BirthDate: time.Date(1980, 1, 1, 0, 0, 0, 0, time.UTC),
+		Gender:    "F",
+	}
+	created, err := (&PatientRepository{db: db}).Create(*patient)
+	if err != nil {
+		t.Fatalf("failed to create patient: %v", err)
+	}
+	return &created
+}
+
+func TestPatientRepository_Search(t *testing.T) {
+	db := testdb.New(t)
+	repo := PatientRepository{db: db}
+
+	createTestPatient(t, db, "Ana Marija", "Horvat", "12345678903")
+	createTestPatient(t, db, "Ivan", "Horvatić", "98765432106")
+	createTestPatient(t, db, "Marko", "Kovač", "69435151530")
+	createTestPatient(t, db, "Petra", "Kovačević", "94577403194")
+
+	// a renamed patient is found by the new name only
+	renamed := createTestPatient(t, db, "Luka", "Babić", "71481280786")
+	renamed.LastName = "Perić"
+	if _, err := repo.Update(*renamed); err != nil {
+		t.Fatalf("failed to rename patient: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		search PatientSearch
+		want   []string
+	}{
+		{"full name", PatientSearch{Name: "ana marija horvat"}, []string{"Ana Marija"}},
+		{"reversed name", PatientSearch{Name: "Horvat  Ana Marija"}, []string{"Ana Marija"}},
+		{"prefix", PatientSearch{Name: "horv"}, []string{"Ana Marija", "Ivan"}},
+		{"prefixes of both names", PatientSearch{Name: "iva horv"}, []string{"Ivan"}},
+		{"similar name", PatientSearch{Name: "kovac"}, []string{"Marko", "Petra"}},
+		{"typo", PatientSearch{Name: "marko kovacc"}, []string{"Marko"}},
+		{"no match", PatientSearch{Name: "xyz"}, nil},
+		{"new name", PatientSearch{Name: "perić"}, []string{"Luka"}},
+		{"old name", PatientSearch{Name: "babić"}, nil},
+		{"OIB prefix", PatientSearch{OIB: "1234"}, nil},
+		{"OIB", PatientSearch{OIB: "98765432106"}, []string{"Ivan"}},
+		{"OIB longer than an OIB", PatientSearch{OIB: "123456789031"}, nil},
+		{"name and OIB", PatientSearch{Name: "horv", OIB: "98765432106"}, []string{"Ivan"}},
+		{"limit", PatientSearch{Name: "horv", Limit: 1}, []string{"Ana Marija"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			matches, err := repo.Search(tt.search)
+			if err != nil {
+				t.Fatalf("Search() error = %v", err)
+			}
+			var got []string
+			for _, match := range matches {
+				got = append(got, match.Patient.FirstName)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Search() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPatientRepository_SearchScores(t *testing.T) {
+	db := testdb.New(t)
+	repo := PatientRepository{db: db}
+	createTestPatient(t, db, "Ana Marija", "Horvat", "12345678903")
+
+	tests := []struct {
+		name string
+		want float64
+	}{
+		{"ana marija horvat", 1.0},
+		{"ana hor", 0.9},
+		{"mar hor", 0.75},
+	}
+
+	for _, tt := range tests {
+		matches, err := repo.Search(PatientSearch{Name: tt.name})
+		if err != nil {
+			t.Fatalf("Search() error = %v", err)
+		}
+		if len(matches) != 1 {
+			t.Fatalf("Search(%q) found %d patients, want 1", tt.name, len(matches))
+		}
+		if matches[0].Score != tt.want {
+			t.Errorf("Search(%q) score = %v, want %v", tt.name, matches[0].Score, tt.want)
+		}
+	}
+}
+
+func TestPatientRepository_FindPageSortsByName(t *testing.T) {
+	db := testdb.New(t)
+	repo := PatientRepository{db: db}
+
+	// every name goes between the first one and the one before it, which
+	// uses up the room between their ranks and spreads the ranks again
+	names := []string{"a", "b"}
+	for i := 1; i <= 70; i++ {
+		names = append(names, strings.Repeat("a", i)+"b")
+	}
+	others := []string{"Zec", "anić", "Anić", "Marić", "c", "ab", "b"}
+	rand.New(rand.NewSource(1)).Shuffle(len(others), func(i, j int) {
+		others[i], others[j] = others[j], others[i]
+	})
+	names = append(names, others...)
+	for i, name := range names {
+		createTestPatient(t, db, fmt.Sprintf("%03d", i), name, fmt.Sprintf("%011d", i))
+	}
+
+	fields := query.Fields{"firstName": "first_name_rank", "lastName": "last_name_rank"}
+	patients, _, err := repo.FindPage(query.Request{PageSize: len(names)}, fields, "lastName,firstName")
+	if err != nil {
+		t.Fatalf("FindPage() error = %v", err)
+	}
+
+	var got []string
+	for _, patient := range patients {
+		got = append(got, patient.LastName+" "+patient.FirstName)
+	}
+	want := append([]string(nil), got...)
+	sort.SliceStable(want, func(i, j int) bool {
+		a, b := strings.Fields(want[i]), strings.Fields(want[j])
+		if last := strings.Compare(strings.ToLower(a[0]), strings.ToLower(b[0])); last != 0 {
+			return last < 0
+		}
+		return a[1] < b[1]
+	})
+	if len(got) != len(names) || !reflect.DeepEqual(got, want) {
+		t.Errorf("FindPage() sorted patients as %v", got)
+	}
+}
+
+func TestNamePrefixes(t *testing.T) {
+	tests := []struct {
+		word string
+		want []string
+	}{
+		{"iv", []string{"iv"}},
+		{"ana", []string{"ana"}},
+		{"horvat", []string{"hor", "horv", "horva", "horvat"}},
+		{"kovač", []string{"kov", "kova", "kovač"}},
+	}
+
+	for _, tt := range tests {
+		if got := namePrefixes(tt.word); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("namePrefixes(%q) = %v, want %v", tt.word, got, tt.want)
+		}
+	}
+
+	// long words are indexed and searched by their first maxPrefixLength letters
+	long := strings.Repeat("ž", 25)
+	got := namePrefixes(long)
+	if len(got) != maxPrefixLength-minPrefixLength+1 || got[len(got)-1] != searchPrefix(long) {
+		t.Errorf("namePrefixes(%q) = %v, want prefixes up to %d letters", long, got, maxPrefixLength)
+	}
+}
@@ -0,0 +1,318 @@
+package service
+
+import (
+	"PatientManager/app"
+	"PatientManager/model"
+	"PatientManager/util/audit"
+	"PatientManager/util/auth"
+	"PatientManager/util/cerror"
+	"context"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// Actor is the user on whose behalf a service call is made
+type Actor struct {
+	UserID   uint
+	Uuid     uuid.UUID
+	Role     model.UserRole
+	OIB      string
+	ClientIP string
+}
+
+// auditContext returns a context that records access to clinical data under
+// the actor, see util/audit
+func auditContext(actor *Actor) context.Context {
+	if actor == nil {
+		return context.Background()
+	}
+	return audit.WithActor(context.Background(), audit.Actor{Uuid: actor.Uuid, ClientIP: actor.ClientIP})
+}
+
+// audited returns db whose queries on clinical data are recorded under the actor
+func audited(db *gorm.DB, actor *Actor) *gorm.DB {
+	return db.WithContext(auditContext(actor))
+}
+
+type IAccessService interface {
+	ResolveActor(claims *auth.Claims) (*Actor, error)
+	PatientScope(actor *Actor) func(db *gorm.DB) *gorm.DB
+	RecordScope(actor *Actor) func(db *gorm.DB) *gorm.DB
+	CheckPatient(actor *Actor, patientID uint) error
+	CheckRecord(actor *Actor, recordID uint) error
+	CheckUserCreation(actor *Actor, user *model.User) error
+	CheckUser(actor *Actor, user *model.User) error
+	SharePatient(actor *Actor, patientID uint, doctorUuid uuid.UUID) error
+	UnsharePatient(actor *Actor, patientID uint, doctorUuid uuid.UUID) error
+}
+
+type AccessService struct {
+	db     *gorm.DB
+	logger *zap.SugaredLogger
+}
+
+func NewAccessService() IAccessService {
+	var service IAccessService
+	app.Invoke(func(db *gorm.DB, logger *zap.SugaredLogger) {
+		service = &AccessService{
+			db:     db,
+			logger: logger,
+		}
+	})
+
+	return service
+}
+
+// ResolveActor loads the user described by token claims
+func (s *AccessService) ResolveActor(claims *auth.Claims) (*Actor, error) {
+	if claims == nil {
+		return nil, cerror.ErrMissingClaims
+	}
+
+	userUuid, err := uuid.Parse(claims.Uuid)
+	if err != nil {
+		s.logger.Errorf("Failed to parse uuid from claims = %s, err = %+v", claims.Uuid, err)
+		return nil, cerror.ErrBadUuid
+	}
+
+	var user model.User
+	if err := s.db.Where("uuid = ?", userUuid).First(&user).Error; err != nil {
+		s.logger.Errorf("Failed to load user with uuid = %s, err = %+v", userUuid, err)
+		return nil, err
+	}
+
+	return &Actor{
+		UserID: user.ID,
+		Uuid:   user.Uuid,
+		Role:   user.Role,
+		OIB:    user.OIB,
+	}, nil
+}
+
+// PatientScope restricts a query on patients to the ones actor can access:
+// superadmin sees all patients, a doctor sees assigned and shared patients
+// and a patient sees only the patient linked to their account (by OIB, see
+// IPortalService.Link)
+func (s *AccessService) PatientScope(actor *Actor) func(db *gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		switch actor.Role {
+		case model.RoleSuperAdmin:
+			return db
+
+		case model.RoleDoctor:
+			return db.Where(
+				"patients.doctor_id = ? OR patients.id IN (?)",
+				actor.UserID,
+				s.db.Model(&model.PatientShare{}).Select("patient_id").Where("doctor_id = ?", actor.UserID),
+			)
+
+		case model.RolePatient:
+			return db.Where("patients.user_id = ?", actor.UserID)
+
+		default:
+			return db.Where("1 = 0")
+		}
+	}
+}
+
+// RecordScope restricts a query on medical records to the ones whose patient
+// actor can access, see PatientScope
+func (s *AccessService) RecordScope(actor *Actor) func(db *gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		if actor.Role == model.RoleSuperAdmin {
+			return db
+		}
+
+		visible := s.db.Model(&model.Patient{}).Select("patients.id").Scopes(s.PatientScope(actor))
+		if actor.Role == model.RoleDoctor {
+			return db.Where("medical_records.patient_id IN (?) OR medical_records.doctor_id = ?", visible, actor.UserID)
+		}
+		return db.Where("medical_records.patient_id IN (?)", visible)
+	}
+}
+
+// CheckPatient returns cerror.ErrForbidden if actor can't access the patient
+func (s *AccessService) CheckPatient(actor *Actor, patientID uint) error {
+	var count int64
+	err := s.db.Model(&model.Patient{}).
+		Scopes(s.PatientScope(actor)).
+		Where("patients.id = ?", patientID).
+		Count(&count).Error
+	if err != nil {
+		s.logger.Errorf("Failed to check access to patient ID %d, err = %+v", patientID, err)
+		return err
+	}
+
+	if count == 0 {
+		s.logger.Warnf("User %s denied access to patient ID %d", actor.Uuid, patientID)
+		return cerror.ErrForbidden
+	}
+	return nil
+}
+
+// CheckRecord returns cerror.ErrForbidden if actor can't access the medical record
+func (s *AccessService) CheckRecord(actor *Actor, recordID uint) error {
+	var count int64
+	err := s.db.Model(&model.MedicalRecord{}).
+		Scopes(s.RecordScope(actor)).
+		Where("medical_records.id = ?", recordID).
+		Count(&count).Error
+	if err != nil {
+		s.logger.Errorf("Failed to check access to medical record ID %d, err = %+v", recordID, err)
+		return err
+	}
+
+	if count == 0 {
+		s.logger.Warnf("User %s denied access to medical record ID %d", actor.Uuid, recordID)
+		return cerror.ErrForbidden
+	}
+	return nil
+}
+
+// CheckUserCreation returns cerror.ErrForbidden if actor can't create the
+// user: superadmin creates users of any role, a doctor creates only patient
+// users for patients they can access, so they can't link to other patients
+// through IPortalService.Link
+func (s *AccessService) CheckUserCreation(actor *Actor, user *model.User) error {
+	switch actor.Role {
+	case model.RoleSuperAdmin:
+		return nil
+
+	case model.RoleDoctor:
+		if user.Role != model.RolePatient {
+			s.logger.Warnf("Doctor %s denied creating a user with role %s", actor.Uuid, user.Role)
+			return cerror.ErrForbidden
+		}
+		return s.checkPatientOIB(actor, user.OIB)
+
+	default:
+		return cerror.ErrForbidden
+	}
+}
+
+// CheckUser returns cerror.ErrForbidden if actor can't read the user: every
+// user reads themselves, superadmin reads all users and a doctor reads
+// patient users of patients they can access
+func (s *AccessService) CheckUser(actor *Actor, user *model.User) error {
+	if actor.Role == model.RoleSuperAdmin || actor.UserID == user.ID {
+		return nil
+	}
+	if actor.Role != model.RoleDoctor || user.Role != model.RolePatient {
+		s.logger.Warnf("User %s denied access to user %s", actor.Uuid, user.Uuid)
+		return cerror.ErrForbidden
+	}
+	return s.checkPatientOIB(actor, user.OIB)
+}
+
+// checkPatientOIB returns cerror.ErrForbidden if actor can't access a patient
+// with the OIB
+func (s *AccessService) checkPatientOIB(actor *Actor, oib string) error {
+	var count int64
+	err := s.db.Model(&model.Patient{}).
+		Scopes(s.PatientScope(actor)).
+		Where("patients.oib_index = ?", model.OIBIndex(oib)).
+		Count(&count).Error
+	if err != nil {
+		s.logger.Errorf("Failed to check access to patient by OIB, err = %+v", err)
+		return err
+	}
+
+	if count == 0 {
+		s.logger.Warnf("User %s denied access to patient users without an accessible patient", actor.Uuid)
+		return cerror.ErrForbidden
+	}
+	return nil
+}
+
+// SharePatient gives another doctor access to the patient, only the assigned
+// doctor or a superadmin can share a patient
+func (s *AccessService) SharePatient(actor *Actor, patientID uint, doctorUuid uuid.UUID) error {
+	if err := s.checkOwner(actor, patientID); err != nil {
+		return err
+	}
+
+	doctor, err := s.findDoctor(doctorUuid)
+	if err != nil {
+		return err
+	}
+
+	var count int64
+	if err := s.db.Model(&model.PatientShare{}).
+		Where("patient_id = ? AND doctor_id = ?", patientID, doctor.ID).
+		Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		s.logger.Debugf("Patient ID %d is already shared with doctor %s", patientID, doctorUuid)
+		return nil
+	}
+
+	share := model.PatientShare{
+		PatientID:   patientID,
+		DoctorID:    doctor.ID,
+		GrantedByID: actor.UserID,
+	}
+	if err := s.db.Create(&share).Error; err != nil {
+		s.logger.Errorf("Failed to share patient ID %d with doctor %s, err = %+v", patientID, doctorUuid, err)
+		return err
+	}
+
+	s.logger.Infof("Patient ID %d shared with doctor %s by %s", patientID, doctorUuid, actor.Uuid)
+	return nil
+}
+
+// UnsharePatient revokes access given by SharePatient
+func (s *AccessService) UnsharePatient(actor *Actor, patientID uint, doctorUuid uuid.UUID) error {
+	if err := s.checkOwner(actor, patientID); err != nil {
+		return err
+	}
+
+	doctor, err := s.findDoctor(doctorUuid)
+	if err != nil {
+		return err
+	}
+
+	rez := s.db.Where("patient_id = ? AND doctor_id = ?", patientID, doctor.ID).Delete(&model.PatientShare{})
+	if rez.Error != nil {
+		s.logger.Errorf("Failed to unshare patient ID %d from doctor %s, err = %+v", patientID, doctorUuid, rez.Error)
+		return rez.Error
+	}
+	if rez.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+
+	s.logger.Infof("Patient ID %d unshared from doctor %s by %s", patientID, doctorUuid, actor.Uuid)
+	return nil
+}
+
+func (s *AccessService) checkOwner(actor *Actor, patientID uint) error {
+	var patient model.Patient
+	if err := s.db.First(&patient, patientID).Error; err != nil {
+		return err
+	}
+
+	if actor.Role == model.RoleSuperAdmin {
+		return nil
+	}
+	if actor.Role == model.RoleDoctor && patient.DoctorID != nil && *patient.DoctorID == actor.UserID {
+		return nil
+	}
+
+	s.logger.Warnf("User %s is not allowed to manage shares of patient ID %d", actor.Uuid, patientID)
+	return cerror.ErrForbidden
+}
+
+func (s *AccessService) findDoctor(doctorUuid uuid.UUID) (*model.User, error) {
+	var doctor model.User
+	if err := s.db.Where("uuid = ?", doctorUuid).First(&doctor).Error; err != nil {
+		return nil, err
+	}
+
+	if doctor.Role != model.RoleDoctor {
+		s.logger.Debugf("User %s is not a doctor, role = %s", doctorUuid, doctor.Role)
+		return nil, cerror.ErrBadRole
+	}
+	return &doctor, nil
+}
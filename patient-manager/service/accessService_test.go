@@ -0,0 +1,139 @@
+package service
+
+import (
+	"PatientManager/internal/testdb"
+	"PatientManager/model"
+	"PatientManager/repository"
+	"PatientManager/util/cerror"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+func createTestUser(t *testing.T, db *gorm.DB, role model.UserRole, oib string) *model.User {
+	t.Helper()
+
+	user := &model.User{
+		Uuid:         uuid.New(),
+		FirstName:    "Test",
+		LastName:     string(role),
+		OIB:          oib,
+		Email:        uuid.NewString() + "@example.com",
+		PasswordHash: "hash",
+		Role:         role,
+	}
+	if err := db.Create(user).Error; err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+	return user
+}
+
+func createTestPatient(t *testing.T, db *gorm.DB, doctor *model.User, oib string) *model.Patient {
+	t.Helper()
+
+	patient := &model.Patient{
+		Uuid:      uuid.New(),
+		FirstName: "Ana",
+		LastName:  "Horvat",
+		OIB:       oib,
+		BirthDate: time.Date(1980, 1, 1, 0, 0, 0, 0, time.UTC),
+		Gender:    "F",
+		DoctorID:  &doctor.ID,
+	}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(patient).Error; err != nil {
+			return err
+		}
+		return repository.IndexPatient(tx, patient)
+	})
+	if err != nil {
+		t.Fatalf("failed to create patient: %v", err)
+	}
+	return patient
+}
+
+func actorOf(user *model.User) *Actor {
+	return &Actor{UserID: user.ID, Uuid: user.Uuid, Role: user.Role, OIB: user.OIB}
+}
+
+func TestAccessService_CheckUserCreation(t *testing.T) {
+	db := testdb.New(t)
+	service := &AccessService{db: db, logger: zap.NewNop().Sugar()}
+
+	admin := createTestUser(t, db, model.RoleSuperAdmin, "69435151530")
+	doctor := createTestUser(t, db, model.RoleDoctor, "94577403194")
+	otherDoctor := createTestUser(t, db, model.RoleDoctor, "71481280786")
+	patientUser := createTestUser(t, db, model.RolePatient, "33392005961")
+	createTestPatient(t, db, doctor, "12345678903")
+	createTestPatient(t, db, otherDoctor, "98765432106")
+
+	tests := []struct {
+		name    string
+		actor   *model.User
+		role    model.UserRole
+		oib     string
+		wantErr error
+	}{
+		{"superadmin creates superadmin", admin, model.RoleSuperAdmin, "53056188337", nil},
+		{"superadmin creates doctor", admin, model.RoleDoctor, "53056188337", nil},
+		{"superadmin creates patient of any doctor", admin, model.RolePatient, "98765432106", nil},
+		{"doctor creates own patient", doctor, model.RolePatient, "12345678903", nil},
+		{"doctor creates superadmin", doctor, model.RoleSuperAdmin, "53056188337", cerror.ErrForbidden},
+		{"doctor creates doctor", doctor, model.RoleDoctor, "53056188337", cerror.ErrForbidden},
+		{"doctor creates patient of other doctor", doctor, model.RolePatient, "98765432106", cerror.ErrForbidden},
+		{"doctor creates patient without a record", doctor, model.RolePatient, "53056188337", cerror.ErrForbidden},
+		{"patient creates patient", patientUser, model.RolePatient, "12345678903", cerror.ErrForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user := &model.User{Role: tt.role, OIB: tt.oib}
+			err := service.CheckUserCreation(actorOf(tt.actor), user)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("CheckUserCreation() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestAccessService_CheckUser(t *testing.T) {
+	db := testdb.New(t)
+	service := &AccessService{db: db, logger: zap.NewNop().Sugar()}
+
+	admin := createTestUser(t, db, model.RoleSuperAdmin, "69435151530")
+	doctor := createTestUser(t, db, model.RoleDoctor, "94577403194")
+	otherDoctor := createTestUser(t, db, model.RoleDoctor, "71481280786")
+	ownPatient := createTestUser(t, db, model.RolePatient, "12345678903")
+	otherPatient := createTestUser(t, db, model.RolePatient, "98765432106")
+	createTestPatient(t, db, doctor, "12345678903")
+	createTestPatient(t, db, otherDoctor, "98765432106")
+
+	tests := []struct {
+		name    string
+		actor   *model.User
+		user    *model.User
+		wantErr error
+	}{
+		{"superadmin reads doctor", admin, doctor, nil},
+		{"doctor reads themselves", doctor, doctor, nil},
+		{"doctor reads own patient", doctor, ownPatient, nil},
+		{"doctor reads other doctor", doctor, otherDoctor, cerror.ErrForbidden},
+		{"doctor reads superadmin", doctor, admin, cerror.ErrForbidden},
+		{"doctor reads patient of other doctor", doctor, otherPatient, cerror.ErrForbidden},
+		{"patient reads themselves", ownPatient, ownPatient, nil},
+		{"patient reads doctor", ownPatient, doctor, cerror.ErrForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := service.CheckUser(actorOf(tt.actor), tt.user)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("CheckUser() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,91 @@
+package service
+
+import (
+	"PatientManager/app"
+	"PatientManager/dto"
+	"PatientManager/model"
+	"PatientManager/util/audit"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultAuditLimit = 100
+	maxAuditLimit     = 1000
+)
+
+// IAuditService gives read-only access to audit events. Access to clinical
+// data is recorded by gorm callbacks registered in util/audit, other events
+// (e.g. account lockouts) are recorded explicitly with Record.
+type IAuditService interface {
+	Record(event *model.AuditEvent) error
+	Query(filter dto.AuditQueryDto) ([]model.AuditEvent, error)
+}
+
+type AuditService struct {
+	db     *gorm.DB
+	logger *zap.SugaredLogger
+}
+
+func NewAuditService() IAuditService {
+	var service IAuditService
+	app.Invoke(func(db *gorm.DB, logger *zap.SugaredLogger) {
+		service = &AuditService{
+			db:     db,
+			logger: logger,
+		}
+	})
+
+	return service
+}
+
+// Record appends the event to the audit log
+func (s *AuditService) Record(event *model.AuditEvent) error {
+	if err := s.db.Create(event).Error; err != nil {
+		s.logger.Errorf("Failed to record %s audit event for %s, err = %+v", event.Action, event.EntityType, err)
+		return err
+	}
+	return nil
+}
+
+// Query returns audit events matching the filter, newest first
+func (s *AuditService) Query(filter dto.AuditQueryDto) ([]model.AuditEvent, error) {
+	query := s.db.Model(&model.AuditEvent{})
+
+	if filter.ActorUuid != "" {
+		query = query.Where("actor_uuid = ?", filter.ActorUuid)
+	}
+	if filter.PatientID != nil {
+		query = query.Where("patient_id = ?", *filter.PatientID)
+	}
+	if filter.From != nil {
+		query = query.Where("created_at >= ?", *filter.From)
+	}
+	if filter.To != nil {
+		query = query.Where("created_at <= ?", *filter.To)
+	}
+	if filter.EntityType != "" {
+		query = query.Where("entity_type = ?", filter.EntityType)
+	}
+	if filter.Action != "" {
+		query = query.Where("action = ?", filter.Action)
+	}
+
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = defaultAuditLimit
+	}
+	limit = min(limit, maxAuditLimit)
+
+	var events []model.AuditEvent
+	if err := query.Order("created_at desc").Order("id desc").Limit(limit).Find(&events).Error; err != nil {
+		s.logger.Errorf("Error querying audit events: %v", err)
+		return nil, err
+	}
+	for i := range events {
+		events[i].Diff = audit.DecryptDiff(events[i].EntityType, events[i].Diff)
+	}
+
+	return events, nil
+}
@@ -0,0 +1,73 @@
+package service
+
+import (
+	"PatientManager/model"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Deletes cascade patient -> medical record -> checkups (with images) and
+// illnesses -> prescriptions. Rows are soft deleted and every row deleted by
+// one cascade gets the same deletion time, so ITrashService can restore the
+// whole unit by restoring children deleted at or after their parent. Rows
+// that were deleted before their parent stay deleted. Images are removed
+// from the bucket only when they are purged.
+
+// cascade returns a session of tx where all deletes use the same time
+func cascade(tx *gorm.DB) *gorm.DB {
+	now := tx.NowFunc().Truncate(time.Microsecond)
+	return tx.Session(&gorm.Session{NowFunc: func() time.Time { return now }})
+}
+
+// deletePatient soft deletes the patient with its medical record and shares
+func deletePatient(tx *gorm.DB, patient *model.Patient) error {
+	tx = cascade(tx)
+	records := tx.Model(&model.MedicalRecord{}).Select("id").Where("patient_id = ?", patient.ID)
+	inRecords := func(db *gorm.DB) *gorm.DB {
+		return db.Where("medical_record_id IN (?)", records)
+	}
+
+	if err := deleteCheckups(tx, inRecords); err != nil {
+		return err
+	}
+	if err := deleteIllnesses(tx, inRecords); err != nil {
+		return err
+	}
+	if err := tx.Where("patient_id = ?", patient.ID).Delete(&model.PatientShare{}).Error; err != nil {
+		return err
+	}
+	if err := tx.Where("patient_id = ?", patient.ID).Delete(&model.MedicalRecord{}).Error; err != nil {
+		return err
+	}
+	return tx.Delete(patient).Error
+}
+
+// deleteCheckups soft deletes checkups matched by scope with their images
+func deleteCheckups(tx *gorm.DB, scope func(*gorm.DB) *gorm.DB) error {
+	tx = cascade(tx)
+	checkups := tx.Model(&model.Checkup{}).Select("id").Scopes(scope)
+	if err := tx.Where("checkup_id IN (?)", checkups).Delete(&model.Image{}).Error; err != nil {
+		return err
+	}
+	return tx.Scopes(scope).Delete(&model.Checkup{}).Error
+}
+
+// deleteIllnesses soft deletes illnesses matched by scope with their
+// prescriptions. Medications stay linked to deleted prescriptions so that
+// they are restored with them.
+func deleteIllnesses(tx *gorm.DB, scope func(*gorm.DB) *gorm.DB) error {
+	tx = cascade(tx)
+	illnesses := tx.Model(&model.Illness{}).Select("id").Scopes(scope)
+	if err := tx.Where("illness_id IN (?)", illnesses).Delete(&model.Prescription{}).Error; err != nil {
+		return err
+	}
+	return tx.Scopes(scope).Delete(&model.Illness{}).Error
+}
+
+// byID returns a scope that matches the row with id
+func byID(id uint) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Where("id = ?", id)
+	}
+}
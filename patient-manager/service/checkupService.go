@@ -2,7 +2,17 @@ package service
 
 import (
 	"PatientManager/app"
+	"PatientManager/config"
+	"PatientManager/dto"
 	"PatientManager/model"
+	"PatientManager/util/cerror"
+	"PatientManager/util/query"
+	"context"
+	"errors"
+	"fmt"
+	"mime/multipart"
+	"path/filepath"
+	"time"
 
 	"github.com/google/uuid"
 	"go.uber.org/zap"
@@ -10,33 +20,50 @@ import (
 )
 
 type ICheckupService interface {
-	Create(checkup *model.Checkup, recordUuid string) (*model.Checkup, error)
-	Update(checkupUuid uuid.UUID, checkupUpdateData *model.Checkup) (*model.Checkup, error)
-	GetAll(recordUuid uuid.UUID) ([]model.Checkup, error)
-	Delete(checkupUuid uuid.UUID) error
-	AddImagesToCheckup(checkupUuid string, files []string) (*model.Checkup, error)
+	Create(actor *Actor, checkup *model.Checkup, recordUuid string) (*model.Checkup, error)
+	Update(actor *Actor, checkupUuid uuid.UUID, checkupUpdateData *model.Checkup) (*model.Checkup, error)
+	GetAll(actor *Actor, recordUuid uuid.UUID) ([]model.Checkup, error)
+	List(actor *Actor, recordUuid uuid.UUID, filter dto.CheckupQueryDto) ([]model.Checkup, *query.Page, error)
+	Delete(actor *Actor, checkupUuid uuid.UUID) error
+	// AddImages uploads files to the checkup. DICOM files are accepted only
+	// for imaging checkups and must belong to the checkup's patient, their
+	// attributes are stored with the image along with a PNG preview.
+	AddImages(ctx context.Context, actor *Actor, checkupUuid uuid.UUID, files []*multipart.FileHeader) (*model.Checkup, error)
+	// CreateUpload creates an image of the checkup and returns a presigned
+	// URL that the client uploads its object to, the image stays hidden until
+	// the upload is completed
+	CreateUpload(ctx context.Context, actor *Actor, checkupUuid uuid.UUID, filename string, size int64) (*PresignedUpload, error)
+	// CompleteUpload checks the uploaded object like AddImages checks files
+	// and makes the image visible. Rejected objects are deleted with their
+	// image, cerror.ErrUploadMissing is returned if the object wasn't
+	// uploaded yet.
+	CompleteUpload(ctx context.Context, actor *Actor, checkupUuid uuid.UUID, imageUuid uuid.UUID) (*model.Checkup, error)
+	CheckAccess(actor *Actor, checkupUuid uuid.UUID) error
+	GetImage(actor *Actor, name string) (*model.Image, error)
 }
 
 type CheckupService struct {
 	db            *gorm.DB
 	logger        *zap.SugaredLogger
 	bucketService IbucketService
+	accessService IAccessService
 }
 
 func NewChekupService() ICheckupService {
 	var service ICheckupService
-	app.Invoke(func(db *gorm.DB, logger *zap.SugaredLogger, bucketService IbucketService) {
+	app.Invoke(func(db *gorm.DB, logger *zap.SugaredLogger, bucketService IbucketService, accessService IAccessService) {
 		service = &CheckupService{
 			db:            db,
 			logger:        logger,
 			bucketService: bucketService,
+			accessService: accessService,
 		}
 	})
 
 	return service
 }
 
-func (c *CheckupService) Create(checkup *model.Checkup, recordUuid string) (*model.Checkup, error) {
+func (c *CheckupService) Create(actor *Actor, checkup *model.Checkup, recordUuid string) (*model.Checkup, error) {
 	checkup.Uuid = uuid.New()
 	c.logger.Infof("Creating checkup for medical record uuid: %s", recordUuid)
 
@@ -46,9 +73,13 @@ func (c *CheckupService) Create(checkup *model.Checkup, recordUuid string) (*mod
 		return nil, err
 	}
 
+	if err := c.accessService.CheckRecord(actor, medicalRecord.ID); err != nil {
+		return nil, err
+	}
+
 	checkup.MedicalRecordID = medicalRecord.ID
 
-	rez := c.db.Create(checkup)
+	rez := audited(c.db, actor).Create(checkup)
 	if rez.Error != nil {
 		c.logger.Errorf("Error creating checkup: %v", rez.Error)
 		return nil, rez.Error
@@ -61,7 +92,7 @@ func (c *CheckupService) Create(checkup *model.Checkup, recordUuid string) (*mod
 func (c *CheckupService) findByUuid(checkupUuid uuid.UUID) (*model.Checkup, error) {
 	var checkup model.Checkup
 	rez := c.db.
-		Preload("Images").
+		Preload("Images", readyImages).
 		Where("uuid = ?", checkupUuid).
 		First(&checkup)
 
@@ -77,17 +108,21 @@ func (c *CheckupService) findByUuid(checkupUuid uuid.UUID) (*model.Checkup, erro
 	return &checkup, nil
 }
 
-func (c *CheckupService) Update(checkupUuid uuid.UUID, checkupUpdateData *model.Checkup) (*model.Checkup, error) {
+func (c *CheckupService) Update(actor *Actor, checkupUuid uuid.UUID, checkupUpdateData *model.Checkup) (*model.Checkup, error) {
 	existingCheckup, err := c.findByUuid(checkupUuid)
 	if err != nil {
 		return nil, err
 	}
 
+	if err := c.accessService.CheckRecord(actor, existingCheckup.MedicalRecordID); err != nil {
+		return nil, err
+	}
+
 	c.logger.Debugf("Updating checkup with UUID: %s", checkupUuid)
 
 	existingCheckup.UpdateCheckup(checkupUpdateData)
 
-	rez := c.db.Save(existingCheckup)
+	rez := audited(c.db, actor).Save(existingCheckup)
 	if rez.Error != nil {
 		c.logger.Errorf("Error saving updated checkup with UUID %s: %v", checkupUuid, rez.Error)
 		return nil, rez.Error
@@ -97,7 +132,7 @@ func (c *CheckupService) Update(checkupUuid uuid.UUID, checkupUpdateData *model.
 	return existingCheckup, nil
 }
 
-func (c *CheckupService) Delete(checkupUuid uuid.UUID) error {
+func (c *CheckupService) Delete(actor *Actor, checkupUuid uuid.UUID) error {
 	c.logger.Infof("Attempting to delete checkup with UUID: %s", checkupUuid)
 
 	checkup, err := c.findByUuid(checkupUuid)
@@ -105,56 +140,35 @@ func (c *CheckupService) Delete(checkupUuid uuid.UUID) error {
 		return err
 	}
 
-	if len(checkup.Images) == 0 {
-		c.logger.Infof("THERE WERE NO IMAGES")
-	}
-
-	if len(checkup.Images) > 0 {
-		c.logger.Infof("Checkup %s has %d images to delete from Minio.", checkupUuid, len(checkup.Images))
-		var imagePaths []string
-		for _, image := range checkup.Images {
-			imagePaths = append(imagePaths, image.Path)
-		}
-
-		err = c.bucketService.DeleteMany(imagePaths)
-		if err != nil {
-			c.logger.Errorf("Failed to delete images from bucket for checkup %s: %v", checkupUuid, err)
-			return err
-		}
-		c.logger.Infof("Successfully deleted images from bucket for checkup %s", checkupUuid)
-	}
-
-	rez := c.db.Delete(checkup)
-	if rez.Error != nil {
-		c.logger.Errorf("Error deleting checkup record from DB with UUID %s: %v", checkupUuid, rez.Error)
-		return rez.Error
+	if err := c.accessService.CheckRecord(actor, checkup.MedicalRecordID); err != nil {
+		return err
 	}
 
-	if rez.RowsAffected == 0 {
-		c.logger.Warnf("No checkup found with UUID %s to delete during the final delete operation", checkupUuid)
-		return gorm.ErrRecordNotFound
+	// images stay in the bucket until the checkup is purged, see ITrashService
+	err = audited(c.db, actor).Transaction(func(tx *gorm.DB) error {
+		return deleteCheckups(tx, byID(checkup.ID))
+	})
+	if err != nil {
+		c.logger.Errorf("Error deleting checkup record from DB with UUID %s: %v", checkupUuid, err)
+		return err
 	}
 
 	c.logger.Infof("Successfully deleted checkup with UUID: %s", checkupUuid)
 	return nil
 }
 
-func (c *CheckupService) GetAll(recordUuid uuid.UUID) ([]model.Checkup, error) {
+func (c *CheckupService) GetAll(actor *Actor, recordUuid uuid.UUID) ([]model.Checkup, error) {
 	c.logger.Infof("Fetching all checkups for medical record uuid: %s", recordUuid)
 
-	var medicalRecord model.MedicalRecord
-	if err := c.db.Where("uuid = ?", recordUuid).First(&medicalRecord).Error; err != nil {
-		if err == gorm.ErrRecordNotFound {
-			c.logger.Warnf("Medical record with UUID %s not found", recordUuid)
-		} else {
-			c.logger.Errorf("Error finding medical record with UUID %s: %v", recordUuid, err)
-		}
+	medicalRecord, err := c.findRecord(actor, recordUuid)
+	if err != nil {
 		return nil, err
 	}
 
 	var checkups []model.Checkup
-	rez := c.db.Preload("MedicalRecord").
-		Preload("Images").
+	rez := audited(c.db, actor).Preload("MedicalRecord").
+		Preload("Images", readyImages).
+		Preload("LabResults").
 		Where("medical_record_id = ?", medicalRecord.ID).
 		Order("checkup_date desc").
 		Find(&checkups)
@@ -167,30 +181,301 @@ func (c *CheckupService) GetAll(recordUuid uuid.UUID) ([]model.Checkup, error) {
 	return checkups, nil
 }
 
-func (c *CheckupService) AddImagesToCheckup(checkupUuid string, paths []string) (*model.Checkup, error) {
-	parsedUuid, err := uuid.Parse(checkupUuid)
+var checkupSortFields = query.Fields{
+	"date": "checkup_date",
+	"type": "type",
+}
+
+// List returns a page of checkups of the medical record, see dto.CheckupQueryDto
+func (c *CheckupService) List(actor *Actor, recordUuid uuid.UUID, filter dto.CheckupQueryDto) ([]model.Checkup, *query.Page, error) {
+	medicalRecord, err := c.findRecord(actor, recordUuid)
 	if err != nil {
-		c.logger.Errorf("Failed to parse checkup UUID %s: %v", checkupUuid, err)
+		return nil, nil, err
+	}
+
+	db := audited(c.db, actor).Model(&model.Checkup{}).
+		Preload("MedicalRecord").
+		Preload("Images", readyImages).
+		Preload("LabResults").
+		Where("medical_record_id = ?", medicalRecord.ID)
+	if filter.Type != "" {
+		db = db.Where("type = ?", filter.Type)
+	}
+	if filter.From != nil {
+		db = db.Where("checkup_date >= ?", *filter.From)
+	}
+	if filter.To != nil {
+		// the whole day is included
+		db = db.Where("checkup_date < ?", filter.To.AddDate(0, 0, 1))
+	}
+
+	var checkups []model.Checkup
+	page, err := query.Find(db, filter.ToRequest(), checkupSortFields, "-date", &checkups)
+	if err != nil {
+		c.logger.Errorf("Error listing checkups for medical record ID %d: %v", medicalRecord.ID, err)
+		return nil, nil, err
+	}
+	return checkups, page, nil
+}
+
+// findRecord loads the medical record and checks that actor can access it
+func (c *CheckupService) findRecord(actor *Actor, recordUuid uuid.UUID) (*model.MedicalRecord, error) {
+	var medicalRecord model.MedicalRecord
+	if err := c.db.Where("uuid = ?", recordUuid).First(&medicalRecord).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.logger.Warnf("Medical record with UUID %s not found", recordUuid)
+		} else {
+			c.logger.Errorf("Error finding medical record with UUID %s: %v", recordUuid, err)
+		}
 		return nil, err
 	}
 
+	if err := c.accessService.CheckRecord(actor, medicalRecord.ID); err != nil {
+		return nil, err
+	}
+	return &medicalRecord, nil
+}
+
+// PresignedUpload is an image waiting for the client to upload its object
+// to Url, see ICheckupService.CompleteUpload
+type PresignedUpload struct {
+	Image     *model.Image
+	Url       string
+	ExpiresAt time.Time
+}
+
+// findUploadCheckup returns the checkup that actor adds images to and the
+// OIB of its patient
+func (c *CheckupService) findUploadCheckup(actor *Actor, checkupUuid uuid.UUID) (*model.Checkup, string, error) {
 	var checkup model.Checkup
-	if err := c.db.Where("uuid = ?", parsedUuid).First(&checkup).Error; err != nil {
+	if err := c.db.Preload("MedicalRecord").Where("uuid = ?", checkupUuid).First(&checkup).Error; err != nil {
 		c.logger.Errorf("Checkup with UUID %s not found: %v", checkupUuid, err)
+		return nil, "", err
+	}
+
+	if err := c.accessService.CheckRecord(actor, checkup.MedicalRecordID); err != nil {
+		return nil, "", err
+	}
+
+	var patient model.Patient
+	if err := c.db.Select("oib").First(&patient, checkup.MedicalRecord.PatientID).Error; err != nil {
+		return nil, "", err
+	}
+	return &checkup, patient.OIB, nil
+}
+
+func (c *CheckupService) AddImages(ctx context.Context, actor *Actor, checkupUuid uuid.UUID, files []*multipart.FileHeader) (*model.Checkup, error) {
+	checkup, patientOIB, err := c.findUploadCheckup(actor, checkupUuid)
+	if err != nil {
 		return nil, err
 	}
 
-	for _, path := range paths {
-		image := model.Image{
-			Uuid:      uuid.New(),
-			Path:      path,
-			CheckupID: checkup.ID,
+	// all files are validated before anything is uploaded
+	uploads := make([]*imageUpload, 0, len(files))
+	for _, file := range files {
+		upload, err := c.inspectFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", file.Filename, err)
 		}
-		if err := c.db.Create(&image).Error; err != nil {
+		if err := upload.checkDicom(checkup, patientOIB); err != nil {
+			c.logger.Warnf("Rejected DICOM file %s of checkup %s: %v", file.Filename, checkupUuid, err)
+			return nil, err
+		}
+		uploads = append(uploads, upload)
+	}
+
+	// an image row is created as pending before its objects are uploaded so
+	// that the objects are never left without one, images that stay pending
+	// are repaired or removed by IReconcileService
+	var uploadErrors []error
+	for i, upload := range uploads {
+		image := upload.image(checkupUuid)
+		image.CheckupID = checkup.ID
+		if err := audited(c.db, actor).Create(image).Error; err != nil {
 			c.logger.Errorf("Failed to create image record for checkup %s: %v", checkupUuid, err)
 			return nil, err
 		}
+
+		if err := c.storeFile(ctx, upload, image, files[i]); err != nil {
+			c.logger.Errorf("Failed to upload %s: %v", upload.filename, err)
+			uploadErrors = append(uploadErrors, fmt.Errorf("failed to upload %s: %w", upload.filename, err))
+			if err := c.db.Unscoped().Delete(image).Error; err != nil {
+				c.logger.Warnf("Failed to remove pending image %s, it's left to the reconciler: %v", image.Uuid, err)
+			}
+			continue
+		}
+
+		err := audited(c.db, actor).Model(image).Updates(map[string]any{
+			"status":         model.ImageReady,
+			"preview_path":   image.PreviewPath,
+			"thumbnail_path": image.ThumbnailPath,
+			"key_id":         image.KeyID,
+		}).Error
+		if err != nil {
+			c.logger.Errorf("Failed to complete image %s of checkup %s: %v", image.Uuid, checkupUuid, err)
+			return nil, err
+		}
+	}
+	if len(uploadErrors) == len(uploads) {
+		return nil, errors.Join(uploadErrors...)
+	}
+	if len(uploadErrors) > 0 {
+		c.logger.Warnf("Proceeding with a partial upload. Successful files: %d", len(uploads)-len(uploadErrors))
+	}
+
+	return c.findByUuid(checkupUuid)
+}
+
+func (c *CheckupService) storeFile(ctx context.Context, upload *imageUpload, image *model.Image, file *multipart.FileHeader) error {
+	reader, err := file.Open()
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	return upload.store(ctx, c.bucketService, image, reader)
+}
+
+func (c *CheckupService) CreateUpload(ctx context.Context, actor *Actor, checkupUuid uuid.UUID, filename string, size int64) (*PresignedUpload, error) {
+	if limit := config.AppConfig.Images.MaxFileSize; size > limit {
+		return nil, fmt.Errorf("%w, the limit is %d bytes", cerror.ErrImageTooLarge, limit)
+	}
+
+	checkup, _, err := c.findUploadCheckup(actor, checkupUuid)
+	if err != nil {
+		return nil, err
+	}
+
+	image := &model.Image{
+		Uuid:      uuid.New(),
+		CheckupID: checkup.ID,
+		Status:    model.ImageUploading,
+	}
+	// the image UUID keeps clients from overwriting objects of other images
+	image.Path = fmt.Sprintf("%s_%s_%s", checkupUuid, image.Uuid, filepath.Base(filename))
+
+	expiry := config.AppConfig.Storage.PresignExpiry
+	url, err := c.bucketService.PresignUpload(ctx, image.Path, expiry)
+	if err != nil {
+		c.logger.Errorf("Failed to presign upload of %s: %v", image.Path, err)
+		return nil, err
+	}
+
+	if err := audited(c.db, actor).Create(image).Error; err != nil {
+		c.logger.Errorf("Failed to create image record for checkup %s: %v", checkupUuid, err)
+		return nil, err
+	}
+
+	return &PresignedUpload{Image: image, Url: url, ExpiresAt: time.Now().Add(expiry)}, nil
+}
+
+func (c *CheckupService) CompleteUpload(ctx context.Context, actor *Actor, checkupUuid uuid.UUID, imageUuid uuid.UUID) (*model.Checkup, error) {
+	checkup, patientOIB, err := c.findUploadCheckup(actor, checkupUuid)
+	if err != nil {
+		return nil, err
+	}
+
+	var image model.Image
+	err = c.db.Where("uuid = ? AND checkup_id = ? AND status = ?", imageUuid, checkup.ID, model.ImageUploading).
+		First(&image).Error
+	if err != nil {
+		return nil, err
+	}
+
+	reader, info, err := c.bucketService.OpenFile(ctx, image.Path)
+	if err != nil {
+		if errors.Is(err, cerror.ErrFileNotFound) {
+			return nil, cerror.ErrUploadMissing
+		}
+		return nil, err
+	}
+	upload, err := c.inspectImage(image.Path, info.Size, reader)
+	reader.Close()
+	if err == nil {
+		err = upload.checkDicom(checkup, patientOIB)
 	}
+	if err != nil {
+		if rejectedUpload(err) {
+			c.logger.Warnf("Rejected upload %s of checkup %s: %v", image.Path, checkupUuid, err)
+			c.removeUpload(ctx, actor, &image)
+		}
+		return nil, err
+	}
+
+	upload.describe(&image)
+	if err := upload.store(ctx, c.bucketService, &image, nil); err != nil {
+		return nil, err
+	}
+	image.Status = model.ImageReady
+	if err := audited(c.db, actor).Save(&image).Error; err != nil {
+		c.logger.Errorf("Failed to complete image %s of checkup %s: %v", image.Uuid, checkupUuid, err)
+		return nil, err
+	}
+
+	return c.findByUuid(checkupUuid)
+}
+
+// rejectedUpload tells if err is caused by the content of an upload rather
+// than a failure to read it
+func rejectedUpload(err error) bool {
+	for _, rejected := range []error{
+		cerror.ErrImageTooLarge,
+		cerror.ErrImageType,
+		cerror.ErrInvalidDicom,
+		cerror.ErrDicomCheckupType,
+		cerror.ErrDicomPatientMismatch,
+	} {
+		if errors.Is(err, rejected) {
+			return true
+		}
+	}
+	return false
+}
+
+// removeUpload deletes a rejected upload, failures are left to the reconciler
+func (c *CheckupService) removeUpload(ctx context.Context, actor *Actor, image *model.Image) {
+	if err := c.bucketService.DeleteMany(ctx, []string{image.Path}); err != nil {
+		c.logger.Warnf("Failed to delete rejected object %s, it's left to the reconciler: %v", image.Path, err)
+	}
+	if err := audited(c.db, actor).Unscoped().Delete(image).Error; err != nil {
+		c.logger.Warnf("Failed to remove rejected image %s, it's left to the reconciler: %v", image.Uuid, err)
+	}
+}
+
+// CheckAccess returns cerror.ErrForbidden if actor can't access the checkup
+func (c *CheckupService) CheckAccess(actor *Actor, checkupUuid uuid.UUID) error {
+	checkup, err := c.findByUuid(checkupUuid)
+	if err != nil {
+		return err
+	}
+
+	return c.accessService.CheckRecord(actor, checkup.MedicalRecordID)
+}
+
+// GetImage returns the image with given name, or whose DICOM preview has
+// the name, cerror.ErrForbidden if actor can't access its checkup
+func (c *CheckupService) GetImage(actor *Actor, name string) (*model.Image, error) {
+	var image model.Image
+	err := c.db.Preload("Checkup").
+		Where("status = ?", model.ImageReady).
+		Where("path = ? OR preview_path = ?", name, name).
+		First(&image).Error
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.logger.Warnf("Image with path %s not found", name)
+		} else {
+			c.logger.Errorf("Error finding image with path %s: %v", name, err)
+		}
+		return nil, err
+	}
+
+	if err := c.accessService.CheckRecord(actor, image.Checkup.MedicalRecordID); err != nil {
+		return nil, err
+	}
+	return &image, nil
+}
 
-	return c.findByUuid(parsedUuid)
+// readyImages hides images whose upload hasn't completed
+func readyImages(db *gorm.DB) *gorm.DB {
+	return db.Where("status = ?", model.ImageReady)
 }
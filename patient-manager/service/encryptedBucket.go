@@ -0,0 +1,68 @@
+package service
+
+import (
+	"PatientManager/util/encryption"
+	"PatientManager/util/presign"
+	"context"
+	"io"
+	"net/http"
+	"time"
+)
+
+// EncryptedBucket encrypts objects of another bucket with their own data
+// keys, see encryption.EncryptObject. Objects stored before encryption was
+// enabled are read as they are. Presigned URLs are served by this server for
+// every driver because objects are encrypted and decrypted on the way.
+type EncryptedBucket struct {
+	bucket IbucketService
+	signer *presign.Signer
+}
+
+func (b *EncryptedBucket) Ping(ctx context.Context) error {
+	return b.bucket.Ping(ctx)
+}
+
+func (b *EncryptedBucket) Upload(ctx context.Context, name string, reader io.Reader, size int64, contentType string) error {
+	encrypted, encryptedSize, err := encryption.EncryptObject(name, reader, size)
+	if err != nil {
+		return err
+	}
+	return b.bucket.Upload(ctx, name, encrypted, encryptedSize, contentType)
+}
+
+func (b *EncryptedBucket) OpenFile(ctx context.Context, name string) (io.ReadSeekCloser, *FileInfo, error) {
+	reader, info, err := b.bucket.OpenFile(ctx, name)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	decrypted, size, _, err := encryption.OpenObject(name, reader, info.Size)
+	if err != nil {
+		reader.Close()
+		return nil, nil, err
+	}
+	decryptedInfo := *info
+	decryptedInfo.Size = size
+	return decrypted, &decryptedInfo, nil
+}
+
+func (b *EncryptedBucket) DeleteMany(ctx context.Context, names []string) error {
+	return b.bucket.DeleteMany(ctx, names)
+}
+
+func (b *EncryptedBucket) PresignUpload(ctx context.Context, name string, expiry time.Duration) (string, error) {
+	return b.signer.URL(http.MethodPut, name, time.Now().Add(expiry)), nil
+}
+
+func (b *EncryptedBucket) PresignDownload(ctx context.Context, name string, contentType string, expiry time.Duration) (string, error) {
+	return b.signer.URL(http.MethodGet, name, time.Now().Add(expiry)), nil
+}
+
+// List lists objects of the bucket, sizes are of the encrypted objects
+func (b *EncryptedBucket) List(ctx context.Context, fn func(name string, info *FileInfo) error) error {
+	return b.bucket.List(ctx, fn)
+}
+
+func (b *EncryptedBucket) KeyID() string {
+	return encryption.CurrentKeyID()
+}
@@ -0,0 +1,262 @@
+package service
+
+import (
+	"PatientManager/app"
+	"PatientManager/model"
+	"PatientManager/repository"
+	"PatientManager/util/audit"
+	"PatientManager/util/cerror"
+	"PatientManager/util/encryption"
+	"PatientManager/util/hl7"
+	"context"
+	"errors"
+	"time"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+const encryptionBatchSize = 100
+
+// IEncryptionService encrypts data stored before encryption was enabled and
+// re-encrypts data after the key is rotated, see util/encryption.
+type IEncryptionService interface {
+	// EncryptPatients encrypts patients whose names and OIBs aren't
+	// encrypted yet and sets their blind index, returns the number of
+	// encrypted patients
+	EncryptPatients(ctx context.Context) (int, error)
+	// EncryptDeadLetters encrypts HL7 dead letters stored before their
+	// messages were encrypted and sets the blind index of their patient's
+	// OIB, returns the number of encrypted dead letters
+	EncryptDeadLetters(ctx context.Context) (int, error)
+	// IndexPatients sets name ranks and search tokens of patients stored
+	// before patients were indexed, returns the number of indexed patients
+	IndexPatients(ctx context.Context) (int, error)
+	// Rotate generates a new key if newKey is set, waits until running
+	// servers load it and re-encrypts patients, HL7 dead letters, images and
+	// audit diffs that aren't encrypted with the current key.
+	// Old keys must be kept until Rotate succeeds.
+	Rotate(ctx context.Context, newKey bool) error
+}
+
+type EncryptionService struct {
+	db            *gorm.DB
+	logger        *zap.SugaredLogger
+	bucketService IbucketService
+}
+
+func NewEncryptionService() IEncryptionService {
+	var service IEncryptionService
+	app.Invoke(func(db *gorm.DB, logger *zap.SugaredLogger, bucketService IbucketService) {
+		service = &EncryptionService{
+			db:            db,
+			logger:        logger,
+			bucketService: bucketService,
+		}
+	})
+
+	return service
+}
+
+func (s *EncryptionService) EncryptPatients(ctx context.Context) (int, error) {
+	count, err := s.encryptPatients(ctx, "oib_index IS NULL OR oib_index = ''")
+	if count > 0 {
+		s.logger.Infof("Encrypted %d patients", count)
+	}
+	return count, err
+}
+
+func (s *EncryptionService) EncryptDeadLetters(ctx context.Context) (int, error) {
+	count, err := s.encryptDeadLetters(ctx, "oib_index IS NULL")
+	if count > 0 {
+		s.logger.Infof("Encrypted %d HL7 dead letters", count)
+	}
+	return count, err
+}
+
+func (s *EncryptionService) IndexPatients(ctx context.Context) (int, error) {
+	count := 0
+	var patients []model.Patient
+	err := s.db.WithContext(ctx).Unscoped().
+		Select("id", "first_name", "last_name", "oib").
+		Where("first_name_rank = 0 OR last_name_rank = 0").
+		FindInBatches(&patients, encryptionBatchSize, func(tx *gorm.DB, batch int) error {
+			for i := range patients {
+				err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+					return repository.IndexPatient(tx, &patients[i])
+				})
+				if err != nil {
+					return err
+				}
+				count++
+			}
+			return nil
+		}).Error
+	if count > 0 {
+		s.logger.Infof("Indexed %d patients", count)
+	}
+	return count, err
+}
+
+func (s *EncryptionService) Rotate(ctx context.Context, newKey bool) error {
+	if newKey {
+		kid, err := encryption.RotateKey()
+		if err != nil {
+			return err
+		}
+		// running servers encrypt with the old key until they reload keys,
+		// data they write meanwhile would be left behind
+		s.logger.Infof("Waiting %s for running servers to load key %s", encryption.ReloadInterval, kid)
+		select {
+		case <-time.After(encryption.ReloadInterval):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	current := encryption.CurrentKeyID()
+	s.logger.Infof("Re-encrypting data with key %s", current)
+
+	prefix := encryption.ValuePrefix(current) + "%"
+	patients, err := s.encryptPatients(ctx,
+		"first_name NOT LIKE ? OR last_name NOT LIKE ? OR oib NOT LIKE ? OR oib_index IS NULL OR oib_index = ''",
+		prefix, prefix, prefix)
+	if err != nil {
+		return err
+	}
+	s.logger.Infof("Re-encrypted %d patients", patients)
+
+	deadLetters, err := s.encryptDeadLetters(ctx, "message NOT LIKE ? OR oib_index IS NULL", prefix)
+	if err != nil {
+		return err
+	}
+	s.logger.Infof("Re-encrypted %d HL7 dead letters", deadLetters)
+
+	images, err := s.encryptImages(ctx)
+	if err != nil {
+		return err
+	}
+	s.logger.Infof("Re-encrypted objects of %d images", images)
+
+	events, err := audit.Reencrypt(s.db.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	s.logger.Infof("Re-encrypted %d audit events", events)
+
+	s.logger.Infof("All data is encrypted with key %s, older keys aren't needed anymore: %v", current, olderKeys(current))
+	return nil
+}
+
+// encryptPatients saves names and OIBs of patients matched by the
+// conditions, which encrypts them with the current key
+func (s *EncryptionService) encryptPatients(ctx context.Context, conditions ...any) (int, error) {
+	count := 0
+	var patients []model.Patient
+	err := s.db.WithContext(ctx).Unscoped().
+		Select("id", "first_name", "last_name", "oib").
+		Where(conditions[0], conditions[1:]...).
+		FindInBatches(&patients, encryptionBatchSize, func(tx *gorm.DB, batch int) error {
+			for i := range patients {
+				patient := &patients[i]
+				// UpdateColumns skips BeforeSave, so the index is set here
+				patient.OIBIndex = model.OIBIndex(patient.OIB)
+				err := s.db.WithContext(ctx).Unscoped().Model(patient).
+					Select("first_name", "last_name", "oib", "oib_index").
+					UpdateColumns(patient).Error
+				if err != nil {
+					return err
+				}
+				count++
+			}
+			return nil
+		}).Error
+	return count, err
+}
+
+// encryptDeadLetters saves messages of dead letters matched by the
+// conditions, which encrypts them with the current key
+func (s *EncryptionService) encryptDeadLetters(ctx context.Context, conditions ...any) (int, error) {
+	count := 0
+	var deadLetters []model.Hl7DeadLetter
+	err := s.db.WithContext(ctx).
+		Select("id", "message").
+		Where(conditions[0], conditions[1:]...).
+		FindInBatches(&deadLetters, encryptionBatchSize, func(tx *gorm.DB, batch int) error {
+			for i := range deadLetters {
+				deadLetter := &deadLetters[i]
+				message, _ := hl7.Parse(deadLetter.Message)
+				deadLetter.OIBIndex = deadLetterOIBIndex(deadLetter.Message, message)
+				err := s.db.WithContext(ctx).Model(deadLetter).
+					Select("message", "oib_index").
+					UpdateColumns(deadLetter).Error
+				if err != nil {
+					return err
+				}
+				count++
+			}
+			return nil
+		}).Error
+	return count, err
+}
+
+// encryptImages uploads objects of images that aren't encrypted with the
+// current key again, which encrypts them with it. Objects that don't exist
+// are skipped, they are left to IReconcileService.
+func (s *EncryptionService) encryptImages(ctx context.Context) (int, error) {
+	current := s.bucketService.KeyID()
+	if current == "" {
+		s.logger.Warnf("Stored objects aren't encrypted, enable STORAGE_ENCRYPT to encrypt them")
+		return 0, nil
+	}
+
+	count := 0
+	var images []model.Image
+	err := s.db.WithContext(ctx).Unscoped().
+		Select("id", "path", "preview_path", "thumbnail_path", "key_id").
+		Where("key_id IS NULL OR key_id <> ?", current).
+		// objects of presigned uploads are encrypted when they are uploaded
+		Where("status <> ?", model.ImageUploading).
+		FindInBatches(&images, encryptionBatchSize, func(tx *gorm.DB, batch int) error {
+			for i := range images {
+				image := &images[i]
+				for _, name := range image.ObjectNames() {
+					if err := s.encryptObject(ctx, name); err != nil {
+						return err
+					}
+				}
+				err := s.db.WithContext(ctx).Unscoped().Model(image).UpdateColumn("key_id", current).Error
+				if err != nil {
+					return err
+				}
+				count++
+			}
+			return nil
+		}).Error
+	return count, err
+}
+
+func (s *EncryptionService) encryptObject(ctx context.Context, name string) error {
+	reader, info, err := s.bucketService.OpenFile(ctx, name)
+	if errors.Is(err, cerror.ErrFileNotFound) {
+		s.logger.Warnf("Object %s doesn't exist, it isn't re-encrypted", name)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	// drivers replace objects only after they are completely written, so
+	// the object can be read while it's replaced
+	return s.bucketService.Upload(ctx, name, reader, info.Size, info.ContentType)
+}
+
+func olderKeys(current string) []string {
+	var kids []string
+	for _, kid := range encryption.KeyIDs() {
+		if kid != current {
+			kids = append(kids, kid)
+		}
+	}
+	return kids
+}
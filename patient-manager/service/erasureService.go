@@ -0,0 +1,386 @@
+package service
+
+import (
+	"PatientManager/app"
+	"PatientManager/dto"
+	"PatientManager/model"
+	"PatientManager/repository"
+	"PatientManager/util/audit"
+	"PatientManager/util/cerror"
+	"PatientManager/util/query"
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// erasedFields are columns of model.Patient replaced with pseudonyms, gender
+// and birth year are kept so that clinical statistics stay intact
+var erasedFields = []string{"first_name", "last_name", "oib", "birth_date"}
+
+// IErasureService handles requests to erase personal data of patients. A
+// request has to be approved by a superadmin other than the one that made
+// it, patients under legal hold can't be erased. Erasure replaces personal
+// fields with pseudonyms, deletes checkup images and HL7 dead letters,
+// anonymizes the linked user account, redacts personal data from audit diffs
+// and issues a certificate.
+type IErasureService interface {
+	SetLegalHold(actor *Actor, patientID uint, legalHold dto.LegalHoldDto) error
+	Request(actor *Actor, patientID uint, reason string) (*model.ErasureRequest, error)
+	List(filter dto.ErasureQueryDto) ([]model.ErasureRequest, *query.Page, error)
+	Approve(ctx context.Context, actor *Actor, requestUuid uuid.UUID) (*model.ErasureCertificate, error)
+	Reject(actor *Actor, requestUuid uuid.UUID) (*model.ErasureRequest, error)
+	GetCertificate(requestUuid uuid.UUID) (*model.ErasureCertificate, error)
+}
+
+type ErasureService struct {
+	db             *gorm.DB
+	logger         *zap.SugaredLogger
+	bucketService  IbucketService
+	sessionService ISessionService
+	auditService   IAuditService
+}
+
+func NewErasureService() IErasureService {
+	var service IErasureService
+	app.Invoke(func(
+		db *gorm.DB,
+		logger *zap.SugaredLogger,
+		bucketService IbucketService,
+		sessionService ISessionService,
+		auditService IAuditService,
+	) {
+		service = &ErasureService{
+			db:             db,
+			logger:         logger,
+			bucketService:  bucketService,
+			sessionService: sessionService,
+			auditService:   auditService,
+		}
+	})
+
+	return service
+}
+
+func (s *ErasureService) SetLegalHold(actor *Actor, patientID uint, legalHold dto.LegalHoldDto) error {
+	var patient model.Patient
+	if err := s.db.Unscoped().First(&patient, patientID).Error; err != nil {
+		return err
+	}
+
+	reason := ""
+	if *legalHold.Hold {
+		reason = legalHold.Reason
+	}
+	err := audited(s.db, actor).Unscoped().Model(&patient).Updates(map[string]any{
+		"legal_hold":        *legalHold.Hold,
+		"legal_hold_reason": reason,
+	}).Error
+	if err != nil {
+		s.logger.Errorf("Failed to set legal hold of patient ID %d, err = %+v", patientID, err)
+		return err
+	}
+
+	s.logger.Infof("User %s set legal hold of patient %s to %t", actor.Uuid, patient.Uuid, *legalHold.Hold)
+	return nil
+}
+
+func (s *ErasureService) Request(actor *Actor, patientID uint, reason string) (*model.ErasureRequest, error) {
+	var patient model.Patient
+	if err := s.db.Unscoped().First(&patient, patientID).Error; err != nil {
+		return nil, err
+	}
+	if err := checkErasable(&patient); err != nil {
+		return nil, err
+	}
+
+	var pending int64
+	err := s.db.Model(&model.ErasureRequest{}).
+		Where("patient_id = ? AND status = ?", patient.ID, model.ErasurePending).
+		Count(&pending).Error
+	if err != nil {
+		return nil, err
+	}
+	if pending > 0 {
+		return nil, cerror.ErrErasurePending
+	}
+
+	request := model.ErasureRequest{
+		Uuid:          uuid.New(),
+		PatientID:     patient.ID,
+		Reason:        reason,
+		Status:        model.ErasurePending,
+		RequestedByID: actor.UserID,
+	}
+	if err := s.db.Create(&request).Error; err != nil {
+		s.logger.Errorf("Failed to create erasure request for patient %s, err = %+v", patient.Uuid, err)
+		return nil, err
+	}
+
+	s.logger.Infof("User %s requested erasure of patient %s", actor.Uuid, patient.Uuid)
+	return s.findRequest(s.db, request.Uuid)
+}
+
+var erasureSortFields = query.Fields{
+	"createdAt": "created_at",
+}
+
+func (s *ErasureService) List(filter dto.ErasureQueryDto) ([]model.ErasureRequest, *query.Page, error) {
+	db := s.withRelations(s.db.Model(&model.ErasureRequest{}))
+	if filter.Status != "" {
+		db = db.Where("status = ?", filter.Status)
+	}
+
+	var requests []model.ErasureRequest
+	page, err := query.Find(db, filter.ToRequest(), erasureSortFields, "-createdAt", &requests)
+	return requests, page, err
+}
+
+// Approve erases the patient's personal data. Objects of images are removed
+// from the bucket after the erasure is committed, objects that fail to be
+// removed are left to IReconcileService.
+func (s *ErasureService) Approve(ctx context.Context, actor *Actor, requestUuid uuid.UUID) (*model.ErasureCertificate, error) {
+	var certificate model.ErasureCertificate
+	var request *model.ErasureRequest
+	var userID *uint
+	var imagePaths []string
+
+	err := audited(s.db, actor).Transaction(func(tx *gorm.DB) error {
+		var err error
+		request, err = s.decide(tx, actor, requestUuid, model.ErasureApproved)
+		if err != nil {
+			return err
+		}
+
+		patient := request.Patient
+		if err := checkErasable(&patient); err != nil {
+			return err
+		}
+		userID = patient.UserID
+
+		// dead letters are found by the index of the OIB before erasure
+		oibIndex := model.OIBIndex(patient.OIB)
+		now := time.Now()
+		patient.FirstName = "Erased"
+		patient.LastName = strings.SplitN(patient.Uuid.String(), "-", 2)[0]
+		// can't collide with real OIBs which only have digits
+		patient.OIB = fmt.Sprintf("E%010d", patient.ID)
+		patient.BirthDate = time.Date(patient.BirthDate.Year(), time.January, 1, 0, 0, 0, 0, time.UTC)
+		patient.UserID = nil
+		patient.ErasedAt = &now
+		// a struct update encrypts the names and the OIB and updates oib_index,
+		// the ranks and search tokens are replaced with ones of the pseudonyms
+		err = tx.Unscoped().Model(&patient).
+			Select("first_name", "last_name", "oib", "oib_index", "birth_date", "user_id", "erased_at").
+			Updates(&patient).Error
+		if err != nil {
+			return err
+		}
+		if err := repository.IndexPatient(tx, &patient); err != nil {
+			return err
+		}
+
+		if userID != nil {
+			if err := anonymizeUser(tx, *userID); err != nil {
+				return err
+			}
+		}
+
+		// includes the update above, the blind index identifies the OIB too and
+		// ranks give away the order of names
+		redacted, err := audit.Redact(tx, "patient", patient.ID, append([]string{"oib_index", "first_name_rank", "last_name_rank"}, erasedFields...))
+		if err != nil {
+			return err
+		}
+
+		// messages of the patient that were never applied are deleted, a
+		// retry would restore the erased data
+		deadLetters := tx.Where("oib_index = ?", oibIndex).Delete(&model.Hl7DeadLetter{})
+		if deadLetters.Error != nil {
+			return deadLetters.Error
+		}
+
+		images, err := patientImages(tx, patient.ID)
+		if err != nil {
+			return err
+		}
+		if len(images) > 0 {
+			if err := tx.Unscoped().Delete(&images).Error; err != nil {
+				return err
+			}
+		}
+
+		certificate = model.ErasureCertificate{
+			ErasureRequestID:    request.ID,
+			PatientUuid:         patient.Uuid,
+			Reason:              request.Reason,
+			RequestedByUuid:     request.RequestedBy.Uuid,
+			ApprovedByUuid:      actor.Uuid,
+			ErasedFields:        strings.Join(erasedFields, ","),
+			ImagesDeleted:       len(images),
+			AuditEventsRedacted: redacted,
+			DeadLettersDeleted:  int(deadLetters.RowsAffected),
+			UserAnonymized:      userID != nil,
+		}
+		if err := tx.Create(&certificate).Error; err != nil {
+			return err
+		}
+
+		for _, image := range images {
+			imagePaths = append(imagePaths, image.ObjectNames()...)
+		}
+		return nil
+	})
+	if err != nil {
+		s.logger.Errorf("Failed to erase patient of request %s, err = %+v", requestUuid, err)
+		return nil, err
+	}
+
+	if len(imagePaths) > 0 {
+		if err := s.bucketService.DeleteMany(ctx, imagePaths); err != nil {
+			s.logger.Warnf("Failed to remove objects of erased images, they are left to the reconciler: %v", err)
+		}
+	}
+
+	if userID != nil {
+		if err := s.sessionService.RevokeAll(*userID); err != nil {
+			s.logger.Errorf("Failed to revoke sessions of erased patient's user ID %d, err = %+v", *userID, err)
+		}
+	}
+
+	// failure is already logged by Record, the erasure itself succeeded
+	_ = s.auditService.Record(&model.AuditEvent{
+		ActorUuid:  &actor.Uuid,
+		Action:     model.AuditErase,
+		EntityType: "patient",
+		EntityUuid: &certificate.PatientUuid,
+		PatientID:  &request.PatientID,
+		ClientIP:   actor.ClientIP,
+	})
+
+	s.logger.Infof("User %s approved erasure of patient %s", actor.Uuid, certificate.PatientUuid)
+	return &certificate, nil
+}
+
+func (s *ErasureService) Reject(actor *Actor, requestUuid uuid.UUID) (*model.ErasureRequest, error) {
+	var request *model.ErasureRequest
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		request, err = s.decide(tx, actor, requestUuid, model.ErasureRejected)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.logger.Infof("User %s rejected erasure request %s", actor.Uuid, requestUuid)
+	return s.findRequest(s.db, request.Uuid)
+}
+
+func (s *ErasureService) GetCertificate(requestUuid uuid.UUID) (*model.ErasureCertificate, error) {
+	var certificate model.ErasureCertificate
+	err := s.db.
+		Where("erasure_request_id = (?)", s.db.Model(&model.ErasureRequest{}).Select("id").Where("uuid = ?", requestUuid)).
+		First(&certificate).Error
+	if err != nil {
+		return nil, err
+	}
+	return &certificate, nil
+}
+
+// decide changes status of a pending request, the condition on status makes
+// sure that concurrent decisions can't both succeed
+func (s *ErasureService) decide(tx *gorm.DB, actor *Actor, requestUuid uuid.UUID, status model.ErasureStatus) (*model.ErasureRequest, error) {
+	request, err := s.findRequest(tx, requestUuid)
+	if err != nil {
+		return nil, err
+	}
+	if request.Status != model.ErasurePending {
+		return nil, cerror.ErrErasureDecided
+	}
+	if status == model.ErasureApproved && request.RequestedByID == actor.UserID {
+		return nil, cerror.ErrSameApprover
+	}
+
+	now := time.Now()
+	rez := tx.Model(&model.ErasureRequest{}).
+		Where("id = ? AND status = ?", request.ID, model.ErasurePending).
+		Updates(map[string]any{
+			"status":        status,
+			"decided_by_id": actor.UserID,
+			"decided_at":    now,
+		})
+	if rez.Error != nil {
+		return nil, rez.Error
+	}
+	if rez.RowsAffected == 0 {
+		return nil, cerror.ErrErasureDecided
+	}
+
+	request.Status = status
+	request.DecidedByID = &actor.UserID
+	request.DecidedAt = &now
+	return request, nil
+}
+
+func (s *ErasureService) findRequest(db *gorm.DB, requestUuid uuid.UUID) (*model.ErasureRequest, error) {
+	var request model.ErasureRequest
+	if err := s.withRelations(db).Where("uuid = ?", requestUuid).First(&request).Error; err != nil {
+		return nil, err
+	}
+	return &request, nil
+}
+
+// withRelations preloads relations needed by dto.ErasureRequestDto, patients
+// can be in trash
+func (s *ErasureService) withRelations(db *gorm.DB) *gorm.DB {
+	return db.
+		Preload("Patient", func(db *gorm.DB) *gorm.DB {
+			return db.Unscoped()
+		}).
+		Preload("RequestedBy").
+		Preload("DecidedBy")
+}
+
+func checkErasable(patient *model.Patient) error {
+	if patient.ErasedAt != nil {
+		return cerror.ErrPatientErased
+	}
+	if patient.LegalHold {
+		return cerror.ErrLegalHold
+	}
+	return nil
+}
+
+// anonymizeUser removes personal data of the user linked to an erased
+// patient, the account can't be used afterwards
+func anonymizeUser(tx *gorm.DB, userID uint) error {
+	var user model.User
+	if err := tx.First(&user, userID).Error; err != nil {
+		return err
+	}
+
+	return tx.Model(&user).Updates(map[string]any{
+		"first_name":    "Deleted",
+		"last_name":     "User",
+		"oib":           fmt.Sprintf("E%010d", user.ID),
+		"email":         fmt.Sprintf("deleted_%s@example.com", user.Uuid),
+		"password_hash": "",
+		"totp_secret":   "",
+		"totp_enabled":  false,
+	}).Error
+}
+
+// patientImages loads all images of the patient's checkups, including deleted ones
+func patientImages(tx *gorm.DB, patientID uint) ([]model.Image, error) {
+	records := tx.Unscoped().Model(&model.MedicalRecord{}).Select("id").Where("patient_id = ?", patientID)
+	checkups := tx.Unscoped().Model(&model.Checkup{}).Select("id").Where("medical_record_id IN (?)", records)
+
+	var images []model.Image
+	err := tx.Unscoped().Where("checkup_id IN (?)", checkups).Find(&images).Error
+	return images, err
+}
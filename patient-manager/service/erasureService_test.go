@@ -0,0 +1,97 @@
+package service
+
+import (
+	"PatientManager/internal/testdb"
+	"PatientManager/model"
+	"context"
+	"errors"
+	"testing"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// committedBucket records whether the erasure was committed when objects
+// were deleted
+type committedBucket struct {
+	IbucketService
+	db        *gorm.DB
+	committed bool
+}
+
+func (b *committedBucket) DeleteMany(ctx context.Context, names []string) error {
+	var certificates int64
+	err := b.db.Model(&model.ErasureCertificate{}).Count(&certificates).Error
+	b.committed = err == nil && certificates > 0
+	return b.IbucketService.DeleteMany(ctx, names)
+}
+
+func TestErasureService_Approve(t *testing.T) {
+	tests := []struct {
+		name string
+		// failCommit makes the erasure fail after images were deleted
+		failCommit bool
+	}{
+		{"approved", false},
+		{"rolled back", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := testdb.New(t)
+			bucket := &committedBucket{IbucketService: newMemoryBucket(nil), db: db}
+			logger := zap.NewNop().Sugar()
+			service := &ErasureService{db: db, logger: logger, bucketService: bucket, auditService: &AuditService{db: db, logger: logger}}
+
+			doctor := createTestUser(t, db, model.RoleDoctor, "")
+			requester := createTestUser(t, db, model.RoleSuperAdmin, "")
+			approver := createTestUser(t, db, model.RoleSuperAdmin, "")
+			r := createTestRecord(t, db, bucket, doctor, "12345678903")
+
+			request, err := service.Request(actorOf(requester), r.patient.ID, "patient asked")
+			if err != nil {
+				t.Fatalf("Request() error = %v", err)
+			}
+
+			errCommit := errors.New("commit failed")
+			if tt.failCommit {
+				err := db.Callback().Create().Before("gorm:create").Register("test:fail", func(db *gorm.DB) {
+					if db.Statement.Table == "erasure_certificates" {
+						db.AddError(errCommit)
+					}
+				})
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			certificate, err := service.Approve(context.Background(), actorOf(approver), request.Uuid)
+			if tt.failCommit {
+				if !errors.Is(err, errCommit) {
+					t.Fatalf("Approve() error = %v, want %v", err, errCommit)
+				}
+			} else if err != nil || certificate.ImagesDeleted != 1 {
+				t.Fatalf("Approve() = %+v, %v, want a certificate of 1 deleted image", certificate, err)
+			} else if !bucket.committed {
+				t.Errorf("objects were deleted before the erasure was committed")
+			}
+
+			// images are removed from the bucket only with their rows
+			var images int64
+			db.Model(&model.Image{}).Where("id = ?", r.image.ID).Count(&images)
+			_, _, openErr := bucket.OpenFile(context.Background(), r.image.Path)
+			stored := openErr == nil
+			if stored != tt.failCommit || (images > 0) != tt.failCommit {
+				t.Errorf("image row kept %v, object kept %v, want %v", images > 0, stored, tt.failCommit)
+			}
+
+			var patient model.Patient
+			if err := db.Unscoped().First(&patient, r.patient.ID).Error; err != nil {
+				t.Fatal(err)
+			}
+			if erased := patient.ErasedAt != nil; erased == tt.failCommit {
+				t.Errorf("patient erased %v, want %v", erased, !tt.failCommit)
+			}
+		})
+	}
+}
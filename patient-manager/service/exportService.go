@@ -0,0 +1,218 @@
+package service
+
+import (
+	"PatientManager/app"
+	"PatientManager/dto"
+	"PatientManager/model"
+	"PatientManager/util/cerror"
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// IExportService exports everything that is stored about a patient, e.g. to
+// answer GDPR data access requests
+type IExportService interface {
+	// Export loads the patient's data and records the export in the audit
+	// trail, the archive is streamed with PatientExport.WriteZip
+	Export(actor *Actor, patientUuid uuid.UUID) (*PatientExport, error)
+}
+
+type ExportService struct {
+	db            *gorm.DB
+	logger        *zap.SugaredLogger
+	bucketService IbucketService
+	accessService IAccessService
+	auditService  IAuditService
+}
+
+func NewExportService() IExportService {
+	var service IExportService
+	app.Invoke(func(
+		db *gorm.DB,
+		logger *zap.SugaredLogger,
+		bucketService IbucketService,
+		accessService IAccessService,
+		auditService IAuditService,
+	) {
+		service = &ExportService{
+			db:            db,
+			logger:        logger,
+			bucketService: bucketService,
+			accessService: accessService,
+			auditService:  auditService,
+		}
+	})
+
+	return service
+}
+
+// PatientExport holds the patient's data, images are read from the bucket
+// only when the archive is written
+type PatientExport struct {
+	ExportedAt    time.Time
+	Patient       dto.PatientDto
+	MedicalRecord dto.MedicalRecordExportDto
+	Illnesses     []dto.IllnessExportDto
+	Checkups      []dto.CheckupDto
+
+	images        []string
+	bucketService IbucketService
+	logger        *zap.SugaredLogger
+}
+
+func (s *ExportService) Export(actor *Actor, patientUuid uuid.UUID) (*PatientExport, error) {
+	db := audited(s.db, actor)
+
+	var patient model.Patient
+	if err := db.Preload("Doctor").Preload("MedicalRecord").Where("uuid = ?", patientUuid).First(&patient).Error; err != nil {
+		return nil, err
+	}
+	if err := s.accessService.CheckPatient(actor, patient.ID); err != nil {
+		return nil, err
+	}
+
+	var illnesses []model.Illness
+	err := db.Preload("Prescriptions", func(db *gorm.DB) *gorm.DB {
+		return db.Order("issued_at")
+	}).
+		Preload("Prescriptions.Lines.Medication").
+		Where("medical_record_id = ?", patient.MedicalRecordID).
+		Order("start_date").
+		Find(&illnesses).Error
+	if err != nil {
+		s.logger.Errorf("Failed to load illnesses of patient %s for export, err = %+v", patientUuid, err)
+		return nil, err
+	}
+
+	var checkups []model.Checkup
+	err = db.Preload("MedicalRecord").
+		Preload("Images", readyImages).
+		Preload("LabResults").
+		Where("medical_record_id = ?", patient.MedicalRecordID).
+		Order("checkup_date").
+		Find(&checkups).Error
+	if err != nil {
+		s.logger.Errorf("Failed to load checkups of patient %s for export, err = %+v", patientUuid, err)
+		return nil, err
+	}
+
+	export := &PatientExport{
+		ExportedAt:    time.Now().UTC(),
+		Patient:       dto.FromModel(&patient),
+		MedicalRecord: *(&dto.MedicalRecordExportDto{}).FromModel(&patient.MedicalRecord),
+		Illnesses:     make([]dto.IllnessExportDto, 0, len(illnesses)),
+		Checkups:      make([]dto.CheckupDto, 0, len(checkups)),
+		bucketService: s.bucketService,
+		logger:        s.logger,
+	}
+	for _, illness := range illnesses {
+		export.Illnesses = append(export.Illnesses, *(&dto.IllnessExportDto{}).FromModel(&illness))
+	}
+	for _, checkup := range checkups {
+		export.Checkups = append(export.Checkups, *(&dto.CheckupDto{}).FromModel(&checkup))
+		for _, image := range checkup.Images {
+			export.images = append(export.images, image.Path)
+		}
+	}
+
+	err = s.auditService.Record(&model.AuditEvent{
+		ActorUuid:  &actor.Uuid,
+		Action:     model.AuditExport,
+		EntityType: "patient",
+		EntityUuid: &patient.Uuid,
+		PatientID:  &patient.ID,
+		ClientIP:   actor.ClientIP,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.logger.Infof("User %s exported data of patient %s", actor.Uuid, patientUuid)
+	return export, nil
+}
+
+// FileName is the suggested name of the archive
+func (e *PatientExport) FileName() string {
+	return fmt.Sprintf("patient-%s.zip", e.Patient.Uuid)
+}
+
+// WriteZip streams the archive to w. It contains patient.json,
+// medical-record.json, illnesses.json (with prescriptions and medications),
+// checkups.json and checkup images in images/ named by their path. Images
+// are copied from the bucket one at a time, missing ones are skipped.
+func (e *PatientExport) WriteZip(ctx context.Context, w io.Writer) error {
+	zw := zip.NewWriter(w)
+
+	files := []struct {
+		name  string
+		value any
+	}{
+		{"patient.json", e.Patient},
+		{"medical-record.json", e.MedicalRecord},
+		{"illnesses.json", e.Illnesses},
+		{"checkups.json", e.Checkups},
+	}
+	for _, file := range files {
+		if err := e.writeJSON(zw, file.name, file.value); err != nil {
+			return err
+		}
+	}
+
+	for _, name := range e.images {
+		if err := e.writeImage(ctx, zw, name); err != nil {
+			return err
+		}
+	}
+
+	return zw.Close()
+}
+
+func (e *PatientExport) writeJSON(zw *zip.Writer, name string, value any) error {
+	f, err := zw.CreateHeader(&zip.FileHeader{
+		Name:     name,
+		Method:   zip.Deflate,
+		Modified: e.ExportedAt,
+	})
+	if err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(f)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(value)
+}
+
+func (e *PatientExport) writeImage(ctx context.Context, zw *zip.Writer, name string) error {
+	reader, _, err := e.bucketService.OpenFile(ctx, name)
+	if err != nil {
+		if errors.Is(err, cerror.ErrFileNotFound) {
+			e.logger.Warnf("Image %s of patient %s is missing from the bucket, skipping it", name, e.Patient.Uuid)
+			return nil
+		}
+		return err
+	}
+	defer reader.Close()
+
+	// images are already compressed
+	f, err := zw.CreateHeader(&zip.FileHeader{
+		Name:     path.Join("images", path.Base(name)),
+		Method:   zip.Store,
+		Modified: e.ExportedAt,
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(f, reader)
+	return err
+}
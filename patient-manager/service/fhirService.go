@@ -0,0 +1,532 @@
+package service
+
+import (
+	"PatientManager/app"
+	"PatientManager/dto"
+	"PatientManager/model"
+	"PatientManager/util/fhir"
+	"PatientManager/util/validation"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// IFhirService exposes the domain model as FHIR R4 resources, see util/fhir.
+// Resources are created through the other services so the same access
+// checks and audit apply.
+type IFhirService interface {
+	Read(actor *Actor, resourceType string, id uuid.UUID) (fhir.Resource, error)
+	// Search finds patients by identifier (an OIB, optionally prefixed with
+	// fhir.OIBSystem and |) and other resources by patient (or subject)
+	Search(actor *Actor, resourceType string, params url.Values) (*fhir.Bundle, error)
+	// Create supports the resource types returned by fhir.New
+	Create(actor *Actor, resource fhir.Resource) (fhir.Resource, error)
+	// Everything returns the patient with all of its resources
+	Everything(actor *Actor, patientUuid uuid.UUID) (*fhir.Bundle, error)
+}
+
+type FhirService struct {
+	db                  *gorm.DB
+	logger              *zap.SugaredLogger
+	accessService       IAccessService
+	patientService      IPatientService
+	illnessService      IIllnessService
+	checkupService      ICheckupService
+	prescriptionService IPrescriptionService
+}
+
+func NewFhirService() IFhirService {
+	var service IFhirService
+	app.Invoke(func(
+		db *gorm.DB,
+		logger *zap.SugaredLogger,
+		accessService IAccessService,
+		patientService IPatientService,
+		illnessService IIllnessService,
+		checkupService ICheckupService,
+		prescriptionService IPrescriptionService,
+	) {
+		service = &FhirService{
+			db:                  db,
+			logger:              logger,
+			accessService:       accessService,
+			patientService:      patientService,
+			illnessService:      illnessService,
+			checkupService:      checkupService,
+			prescriptionService: prescriptionService,
+		}
+	})
+
+	return service
+}
+
+func (s *FhirService) Read(actor *Actor, resourceType string, id uuid.UUID) (fhir.Resource, error) {
+	db := audited(s.db, actor)
+
+	switch resourceType {
+	case fhir.TypePatient:
+		var patient model.Patient
+		if err := db.Preload("Doctor").Where("uuid = ?", id).First(&patient).Error; err != nil {
+			return nil, err
+		}
+		if err := s.accessService.CheckPatient(actor, patient.ID); err != nil {
+			return nil, err
+		}
+		return fhir.FromPatient(&patient), nil
+
+	case fhir.TypeCondition:
+		var illness model.Illness
+		if err := db.Where("uuid = ?", id).First(&illness).Error; err != nil {
+			return nil, err
+		}
+		patientUuid, err := s.checkRecord(actor, illness.MedicalRecordID)
+		if err != nil {
+			return nil, err
+		}
+		return fhir.FromIllness(&illness, patientUuid), nil
+
+	case fhir.TypeMedicationRequest:
+		var line model.PrescriptionLine
+		err := db.Preload("Medication").
+			Preload("Prescription.Illness").
+			Where("uuid = ?", id).
+			First(&line).Error
+		if err != nil {
+			return nil, err
+		}
+		// the prescription or its illness can be deleted
+		prescription := line.Prescription
+		if prescription.ID == 0 || prescription.Illness.ID == 0 {
+			return nil, gorm.ErrRecordNotFound
+		}
+		patientUuid, err := s.checkRecord(actor, prescription.Illness.MedicalRecordID)
+		if err != nil {
+			return nil, err
+		}
+		return fhir.FromPrescriptionLine(&line, &prescription, patientUuid), nil
+
+	case fhir.TypeEncounter, fhir.TypeDiagnosticReport:
+		var checkup model.Checkup
+		if err := db.Preload("Illness").Preload("Images", readyImages).Where("uuid = ?", id).First(&checkup).Error; err != nil {
+			return nil, err
+		}
+		patientUuid, err := s.checkRecord(actor, checkup.MedicalRecordID)
+		if err != nil {
+			return nil, err
+		}
+		if resourceType == fhir.TypeEncounter {
+			return fhir.FromCheckup(&checkup, patientUuid), nil
+		}
+		return fhir.FromCheckupReport(&checkup, patientUuid), nil
+
+	case fhir.TypeMedia:
+		var image model.Image
+		if err := db.Preload("Checkup").Where("uuid = ? AND status = ?", id, model.ImageReady).First(&image).Error; err != nil {
+			return nil, err
+		}
+		if image.Checkup.ID == 0 {
+			return nil, gorm.ErrRecordNotFound
+		}
+		patientUuid, err := s.checkRecord(actor, image.Checkup.MedicalRecordID)
+		if err != nil {
+			return nil, err
+		}
+		return fhir.FromImage(&image, &image.Checkup, patientUuid), nil
+	}
+
+	return nil, fmt.Errorf("unsupported resource type %s", resourceType)
+}
+
+func (s *FhirService) Search(actor *Actor, resourceType string, params url.Values) (*fhir.Bundle, error) {
+	if resourceType == fhir.TypePatient {
+		return s.searchPatients(actor, params.Get("identifier"))
+	}
+
+	reference := params.Get("patient")
+	if reference == "" {
+		reference = params.Get("subject")
+	}
+	if reference == "" {
+		return nil, fhir.Invalid("patient", "required", "is required")
+	}
+
+	// both Patient/{id} and {id} are accepted
+	patientUuid, err := uuid.Parse(strings.TrimPrefix(reference, fhir.TypePatient+"/"))
+	if err != nil {
+		return nil, fhir.Invalid("patient", "reference", "must reference a Patient")
+	}
+
+	var patients []model.Patient
+	err = s.db.Scopes(s.accessService.PatientScope(actor)).
+		Where("patients.uuid = ?", patientUuid).
+		Find(&patients).Error
+	if err != nil {
+		return nil, err
+	}
+	if len(patients) == 0 {
+		return fhir.NewSearchset(nil), nil
+	}
+
+	resources, err := s.load(audited(s.db, actor), &patients[0], resourceType)
+	if err != nil {
+		s.logger.Errorf("Failed to search %s of patient %s, err = %+v", resourceType, patientUuid, err)
+		return nil, err
+	}
+	return fhir.NewSearchset(resources), nil
+}
+
+func (s *FhirService) searchPatients(actor *Actor, identifier string) (*fhir.Bundle, error) {
+	if identifier == "" {
+		return nil, fhir.Invalid("identifier", "required", "is required")
+	}
+
+	oib := identifier
+	if system, value, found := strings.Cut(identifier, "|"); found {
+		if system != "" && system != fhir.OIBSystem {
+			return fhir.NewSearchset(nil), nil
+		}
+		oib = value
+	}
+
+	// OIBs of erased patients are pseudonyms
+	var patients []model.Patient
+	err := audited(s.db, actor).
+		Scopes(s.accessService.PatientScope(actor)).
+		Preload("Doctor").
+		Where("patients.oib_index = ? AND patients.erased_at IS NULL", model.OIBIndex(oib)).
+		Find(&patients).Error
+	if err != nil {
+		return nil, err
+	}
+
+	resources := make([]fhir.Resource, 0, len(patients))
+	for i := range patients {
+		resources = append(resources, fhir.FromPatient(&patients[i]))
+	}
+	return fhir.NewSearchset(resources), nil
+}
+
+func (s *FhirService) Everything(actor *Actor, patientUuid uuid.UUID) (*fhir.Bundle, error) {
+	db := audited(s.db, actor)
+
+	var patient model.Patient
+	if err := db.Preload("Doctor").Where("uuid = ?", patientUuid).First(&patient).Error; err != nil {
+		return nil, err
+	}
+	if err := s.accessService.CheckPatient(actor, patient.ID); err != nil {
+		return nil, err
+	}
+
+	resources := []fhir.Resource{fhir.FromPatient(&patient)}
+	for _, resourceType := range []string{fhir.TypeCondition, fhir.TypeMedicationRequest} {
+		loaded, err := s.load(db, &patient, resourceType)
+		if err != nil {
+			s.logger.Errorf("Failed to load %s of patient %s, err = %+v", resourceType, patientUuid, err)
+			return nil, err
+		}
+		resources = append(resources, loaded...)
+	}
+
+	// checkups are loaded once for the resources derived from them
+	checkups, err := loadCheckups(db, &patient)
+	if err != nil {
+		s.logger.Errorf("Failed to load checkups of patient %s, err = %+v", patientUuid, err)
+		return nil, err
+	}
+	for _, resourceType := range []string{fhir.TypeEncounter, fhir.TypeDiagnosticReport, fhir.TypeMedia} {
+		resources = append(resources, checkupResources(checkups, patient.Uuid, resourceType)...)
+	}
+
+	return fhir.NewSearchset(resources), nil
+}
+
+// load returns resources of the patient's medical record
+func (s *FhirService) load(db *gorm.DB, patient *model.Patient, resourceType string) ([]fhir.Resource, error) {
+	var resources []fhir.Resource
+
+	switch resourceType {
+	case fhir.TypeCondition:
+		var illnesses []model.Illness
+		err := db.Where("medical_record_id = ?", patient.MedicalRecordID).
+			Order("start_date").
+			Find(&illnesses).Error
+		if err != nil {
+			return nil, err
+		}
+		for i := range illnesses {
+			resources = append(resources, fhir.FromIllness(&illnesses[i], patient.Uuid))
+		}
+
+	case fhir.TypeMedicationRequest:
+		var prescriptions []model.Prescription
+		err := db.Preload("Illness").
+			Preload("Lines.Medication").
+			Where("illness_id IN (?)", s.db.Model(&model.Illness{}).Select("id").Where("medical_record_id = ?", patient.MedicalRecordID)).
+			Order("issued_at").
+			Find(&prescriptions).Error
+		if err != nil {
+			return nil, err
+		}
+		for i := range prescriptions {
+			for j := range prescriptions[i].Lines {
+				resources = append(resources, fhir.FromPrescriptionLine(&prescriptions[i].Lines[j], &prescriptions[i], patient.Uuid))
+			}
+		}
+
+	default:
+		checkups, err := loadCheckups(db, patient)
+		if err != nil {
+			return nil, err
+		}
+		resources = checkupResources(checkups, patient.Uuid, resourceType)
+	}
+
+	return resources, nil
+}
+
+func loadCheckups(db *gorm.DB, patient *model.Patient) ([]model.Checkup, error) {
+	var checkups []model.Checkup
+	err := db.Preload("Illness").
+		Preload("Images", readyImages).
+		Where("medical_record_id = ?", patient.MedicalRecordID).
+		Order("checkup_date").
+		Find(&checkups).Error
+	return checkups, err
+}
+
+// checkupResources maps checkups to Encounters or DiagnosticReports, or
+// their images to Media
+func checkupResources(checkups []model.Checkup, patientUuid uuid.UUID, resourceType string) []fhir.Resource {
+	var resources []fhir.Resource
+	for i := range checkups {
+		switch resourceType {
+		case fhir.TypeEncounter:
+			resources = append(resources, fhir.FromCheckup(&checkups[i], patientUuid))
+		case fhir.TypeDiagnosticReport:
+			resources = append(resources, fhir.FromCheckupReport(&checkups[i], patientUuid))
+		case fhir.TypeMedia:
+			for j := range checkups[i].Images {
+				resources = append(resources, fhir.FromImage(&checkups[i].Images[j], &checkups[i], patientUuid))
+			}
+		}
+	}
+	return resources
+}
+
+// checkRecord checks access to the medical record and returns its patient's UUID
+func (s *FhirService) checkRecord(actor *Actor, recordID uint) (uuid.UUID, error) {
+	if err := s.accessService.CheckRecord(actor, recordID); err != nil {
+		return uuid.Nil, err
+	}
+
+	var patient model.Patient
+	err := s.db.Select("uuid").
+		Where("id = (?)", s.db.Model(&model.MedicalRecord{}).Select("patient_id").Where("id = ?", recordID)).
+		First(&patient).Error
+	return patient.Uuid, err
+}
+
+func (s *FhirService) Create(actor *Actor, resource fhir.Resource) (fhir.Resource, error) {
+	switch r := resource.(type) {
+	case *fhir.Patient:
+		return s.createPatient(actor, r)
+	case *fhir.Condition:
+		return s.createCondition(actor, r)
+	case *fhir.Encounter:
+		return s.createEncounter(actor, r)
+	case *fhir.MedicationRequest:
+		return s.createMedicationRequest(actor, r)
+	}
+	return nil, fmt.Errorf("resource %s can't be created", resource.Reference())
+}
+
+func (s *FhirService) createPatient(actor *Actor, r *fhir.Patient) (fhir.Resource, error) {
+	oib := r.OIB()
+	if !validation.ValidOIB(oib) {
+		return nil, fhir.Invalid("identifier", "oib", "must contain a valid OIB of system "+fhir.OIBSystem)
+	}
+
+	firstName := strings.Join(r.Name[0].Given, " ")
+	if len(firstName) > 100 {
+		return nil, fhir.Invalid("name[0].given", "max", "must be at most 100 characters in total")
+	}
+
+	created, err := s.patientService.CreatePatient(actor, dto.NewPatientDto{
+		FirstName: firstName,
+		LastName:  r.Name[0].Family,
+		OIB:       oib,
+		BirthDate: r.BirthDate,
+		Gender:    r.ModelGender(),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.logger.Infof("User %s created patient %s through FHIR", actor.Uuid, created.Uuid)
+	return s.Read(actor, fhir.TypePatient, created.Uuid)
+}
+
+func (s *FhirService) createCondition(actor *Actor, r *fhir.Condition) (fhir.Resource, error) {
+	patient, err := s.subject(r.Subject)
+	if err != nil {
+		return nil, err
+	}
+
+	name := r.Code.Text
+	if name == "" && len(r.Code.Coding) > 0 {
+		name = r.Code.Coding[0].Display
+	}
+	if name == "" {
+		return nil, fhir.Invalid("code.text", "required", "is required")
+	}
+	if len(name) > 100 {
+		return nil, fhir.Invalid("code.text", "max", "must be at most 100")
+	}
+
+	start, err := fhir.ParseDateTime(r.OnsetDateTime)
+	if err != nil {
+		return nil, fhir.Invalid("onsetDateTime", "datetime", "must be a date or dateTime")
+	}
+	illness := &model.Illness{Name: name, StartDate: start}
+	if r.AbatementDateTime != "" {
+		end, err := fhir.ParseDateTime(r.AbatementDateTime)
+		if err != nil {
+			return nil, fhir.Invalid("abatementDateTime", "datetime", "must be a date or dateTime")
+		}
+		if end.Before(start) {
+			return nil, fhir.Invalid("abatementDateTime", "gtefield", "must not be before onsetDateTime")
+		}
+		illness.EndDate = &end
+	}
+
+	created, err := s.illnessService.Create(actor, illness, patient.MedicalRecord.Uuid.String())
+	if err != nil {
+		return nil, err
+	}
+	return fhir.FromIllness(created, patient.Uuid), nil
+}
+
+func (s *FhirService) createEncounter(actor *Actor, r *fhir.Encounter) (fhir.Resource, error) {
+	patient, err := s.subject(r.Subject)
+	if err != nil {
+		return nil, err
+	}
+
+	checkupType := r.CheckupType()
+	if !checkupType.Valid() {
+		return nil, fhir.Invalid("type", "checkuptype", fmt.Sprintf(
+			"must contain a code of system %s, one of %s", fhir.CheckupTypeSystem, strings.Join(model.CheckupTypeNames(), ", ")))
+	}
+
+	start, err := fhir.ParseDateTime(r.Period.Start)
+	if err != nil {
+		return nil, fhir.Invalid("period.start", "datetime", "must be a date or dateTime")
+	}
+	checkup := &model.Checkup{CheckupDate: start, Type: checkupType}
+
+	var illness *model.Illness
+	if len(r.ReasonReference) > 0 {
+		illness, err = s.reason(patient, &r.ReasonReference[0], "reasonReference[0].reference")
+		if err != nil {
+			return nil, err
+		}
+		checkup.IllnessID = &illness.ID
+	}
+
+	created, err := s.checkupService.Create(actor, checkup, patient.MedicalRecord.Uuid.String())
+	if err != nil {
+		return nil, err
+	}
+	if illness != nil {
+		created.Illness = *illness
+	}
+	return fhir.FromCheckup(created, patient.Uuid), nil
+}
+
+func (s *FhirService) createMedicationRequest(actor *Actor, r *fhir.MedicationRequest) (fhir.Resource, error) {
+	patient, err := s.subject(r.Subject)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(r.ReasonReference) == 0 {
+		return nil, fhir.Invalid("reasonReference", "required", "is required")
+	}
+	illness, err := s.reason(patient, &r.ReasonReference[0], "reasonReference[0].reference")
+	if err != nil {
+		return nil, err
+	}
+
+	medicationUuid, err := uuid.Parse(r.MedicationCodeableConcept.Code(fhir.MedicationSystem))
+	if err != nil {
+		return nil, fhir.Invalid("medicationCodeableConcept.coding", "required", "must contain a medication UUID of system "+fhir.MedicationSystem)
+	}
+	var medication model.Medication
+	if err := s.db.Where("uuid = ?", medicationUuid).First(&medication).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fhir.Invalid("medicationCodeableConcept.coding", "reference", "medication not found")
+		}
+		return nil, err
+	}
+
+	issuedAt, err := fhir.ParseDateTime(r.AuthoredOn)
+	if err != nil {
+		return nil, fhir.Invalid("authoredOn", "datetime", "must be a date or dateTime")
+	}
+
+	prescription := &model.Prescription{IssuedAt: issuedAt, IllnessID: illness.ID}
+	created, err := s.prescriptionService.Create(actor, prescription, []string{medicationUuid.String()})
+	if err != nil {
+		return nil, err
+	}
+	created.Illness = *illness
+
+	for i := range created.Lines {
+		if created.Lines[i].MedicationID == medication.ID {
+			return fhir.FromPrescriptionLine(&created.Lines[i], created, patient.Uuid), nil
+		}
+	}
+	return nil, fmt.Errorf("medication %s missing from created prescription %s", medicationUuid, created.Uuid)
+}
+
+// subject loads the patient referenced by a resource with its medical
+// record, access is checked by the service that creates the resource
+func (s *FhirService) subject(reference fhir.Reference) (*model.Patient, error) {
+	id, ok := reference.ID(fhir.TypePatient)
+	if !ok {
+		return nil, fhir.Invalid("subject.reference", "reference", "must reference a Patient")
+	}
+
+	var patient model.Patient
+	if err := s.db.Preload("MedicalRecord").Where("uuid = ?", id).First(&patient).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fhir.Invalid("subject.reference", "reference", "patient not found")
+		}
+		return nil, err
+	}
+	return &patient, nil
+}
+
+// reason loads the illness referenced by a Condition reference, it must be
+// an illness of the patient
+func (s *FhirService) reason(patient *model.Patient, reference *fhir.Reference, field string) (*model.Illness, error) {
+	id, ok := reference.ID(fhir.TypeCondition)
+	if !ok {
+		return nil, fhir.Invalid(field, "reference", "must reference a Condition")
+	}
+
+	var illness model.Illness
+	err := s.db.Where("uuid = ? AND medical_record_id = ?", id, patient.MedicalRecordID).First(&illness).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fhir.Invalid(field, "reference", "must reference a Condition of the subject")
+		}
+		return nil, err
+	}
+	return &illness, nil
+}
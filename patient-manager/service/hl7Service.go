@@ -0,0 +1,432 @@
+package service
+
+import (
+	"PatientManager/app"
+	"PatientManager/config"
+	"PatientManager/dto"
+	"PatientManager/model"
+	"PatientManager/util/format"
+	"PatientManager/util/hl7"
+	"PatientManager/util/problem"
+	"PatientManager/util/query"
+	"PatientManager/util/validation"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// IHl7Service ingests HL7 v2 messages from admission and lab systems.
+// ADT^A01, A04 and A08 create or update the patient identified by OIB and
+// ORU^R01 attaches lab results to blood test checkups of the patient.
+// Messages that can't be parsed or applied are stored as dead letters.
+type IHl7Service interface {
+	// Ingest applies the message and returns its acknowledgement, it's
+	// negative (AE or AR) if the message was stored as a dead letter
+	Ingest(actor *Actor, raw string, source model.Hl7Source) string
+	ListDeadLetters(filter dto.ListQueryDto) ([]model.Hl7DeadLetter, *query.Page, error)
+	// Retry applies the dead letter again, it's deleted when that succeeds
+	Retry(actor *Actor, deadLetterUuid uuid.UUID) (string, error)
+	// ResolveActor loads the user that messages received over MLLP are
+	// processed as
+	ResolveActor(email string) (*Actor, error)
+}
+
+type Hl7Service struct {
+	db             *gorm.DB
+	logger         *zap.SugaredLogger
+	accessService  IAccessService
+	patientService IPatientService
+}
+
+func NewHl7Service() IHl7Service {
+	var service IHl7Service
+	app.Invoke(func(
+		db *gorm.DB,
+		logger *zap.SugaredLogger,
+		accessService IAccessService,
+		patientService IPatientService,
+	) {
+		service = &Hl7Service{
+			db:             db,
+			logger:         logger,
+			accessService:  accessService,
+			patientService: patientService,
+		}
+	})
+
+	return service
+}
+
+func (s *Hl7Service) Ingest(actor *Actor, raw string, source model.Hl7Source) string {
+	message, err := hl7.Parse(raw)
+	if err == nil {
+		err = s.apply(actor, message)
+	}
+
+	code, text := acknowledgement(err)
+	if code != hl7.AckAccept {
+		s.logger.Warnf("Failed to process HL7 message from %s (%s), err = %+v", actor.ClientIP, source, err)
+		s.storeDeadLetter(actor, raw, message, source, text)
+	}
+	return s.ack(message, code, text)
+}
+
+var deadLetterSortFields = query.Fields{
+	"createdAt": "created_at",
+}
+
+func (s *Hl7Service) ListDeadLetters(filter dto.ListQueryDto) ([]model.Hl7DeadLetter, *query.Page, error) {
+	var deadLetters []model.Hl7DeadLetter
+	page, err := query.Find(s.db.Model(&model.Hl7DeadLetter{}), filter.ToRequest(), deadLetterSortFields, "-createdAt", &deadLetters)
+	return deadLetters, page, err
+}
+
+func (s *Hl7Service) Retry(actor *Actor, deadLetterUuid uuid.UUID) (string, error) {
+	var deadLetter model.Hl7DeadLetter
+	if err := s.db.Where("uuid = ?", deadLetterUuid).First(&deadLetter).Error; err != nil {
+		return "", err
+	}
+
+	message, err := hl7.Parse(deadLetter.Message)
+	if err == nil {
+		err = s.apply(actor, message)
+	}
+
+	code, text := acknowledgement(err)
+	if code == hl7.AckAccept {
+		if err := s.db.Delete(&deadLetter).Error; err != nil {
+			s.logger.Errorf("Failed to delete HL7 dead letter %s, err = %+v", deadLetter.Uuid, err)
+			return "", err
+		}
+		s.logger.Infof("User %s successfully retried HL7 dead letter %s", actor.Uuid, deadLetter.Uuid)
+		return s.ack(message, code, text), nil
+	}
+
+	err = s.db.Model(&deadLetter).Updates(map[string]any{
+		"error":    text,
+		"attempts": gorm.Expr("attempts + 1"),
+	}).Error
+	if err != nil {
+		s.logger.Errorf("Failed to update HL7 dead letter %s, err = %+v", deadLetter.Uuid, err)
+		return "", err
+	}
+	return s.ack(message, code, text), nil
+}
+
+func (s *Hl7Service) ResolveActor(email string) (*Actor, error) {
+	var user model.User
+	if err := s.db.Where("email = ?", email).First(&user).Error; err != nil {
+		return nil, fmt.Errorf("failed to load HL7 user %s: %w", email, err)
+	}
+
+	return &Actor{
+		UserID: user.ID,
+		Uuid:   user.Uuid,
+		Role:   user.Role,
+		OIB:    user.OIB,
+	}, nil
+}
+
+func (s *Hl7Service) ack(message *hl7.Message, code, text string) string {
+	return hl7.Ack(message, code, text, config.AppConfig.Hl7.Application, config.AppConfig.Hl7.Facility)
+}
+
+// acknowledgement returns the acknowledgement code and text of a processing
+// error, details of server errors aren't sent to the sender
+func acknowledgement(err error) (string, string) {
+	if err == nil {
+		return hl7.AckAccept, ""
+	}
+
+	var hl7Err *hl7.Error
+	if errors.As(err, &hl7Err) {
+		return hl7Err.Code, hl7Err.Text
+	}
+
+	p := problem.From(err, false)
+	if p.Detail == "" {
+		return hl7.AckError, "internal error"
+	}
+	return hl7.AckError, p.Detail
+}
+
+func (s *Hl7Service) storeDeadLetter(actor *Actor, raw string, message *hl7.Message, source model.Hl7Source, text string) {
+	deadLetter := model.Hl7DeadLetter{
+		Uuid:     uuid.New(),
+		Source:   source,
+		ClientIP: actor.ClientIP,
+		Message:  raw,
+		OIBIndex: deadLetterOIBIndex(raw, message),
+		Error:    text,
+		Attempts: 1,
+	}
+	if message != nil {
+		code, event := message.Type()
+		deadLetter.MessageType = truncate(code+"^"+event, 20)
+		deadLetter.ControlID = truncate(message.ControlID(), 50)
+	}
+
+	if err := s.db.Create(&deadLetter).Error; err != nil {
+		s.logger.Errorf("Failed to store HL7 dead letter, err = %+v", err)
+		return
+	}
+	s.logger.Infof("Stored HL7 message as dead letter %s", deadLetter.Uuid)
+}
+
+// oibPattern matches numbers that can be OIBs, digits of longer numbers
+// like timestamps aren't matched
+var oibPattern = regexp.MustCompile(`\b\d{11}\b`)
+
+// deadLetterOIBIndex returns the blind index of the OIB of the patient the
+// message is about, from PID-3 or the first valid OIB in a message that
+// can't be parsed, or an empty string if it has no valid OIB
+func deadLetterOIBIndex(raw string, message *hl7.Message) string {
+	if message != nil {
+		if pid := message.Segment("PID"); pid != nil {
+			if oib, err := patientOIB(pid); err == nil {
+				return model.OIBIndex(oib)
+			}
+		}
+	}
+	for _, oib := range oibPattern.FindAllString(raw, -1) {
+		if validation.ValidOIB(oib) {
+			return model.OIBIndex(oib)
+		}
+	}
+	return ""
+}
+
+func (s *Hl7Service) apply(actor *Actor, message *hl7.Message) error {
+	code, event := message.Type()
+	switch {
+	case code == "ADT" && (event == "A01" || event == "A04" || event == "A08"):
+		return s.admit(actor, message)
+	case code == "ORU" && event == "R01":
+		return s.addResults(actor, message)
+	}
+	return hl7.Rejectf("message type %s^%s is not supported", code, event)
+}
+
+// admit creates the patient from the PID segment or updates the patient
+// with the same OIB, the assigned doctor of an existing patient is kept
+func (s *Hl7Service) admit(actor *Actor, message *hl7.Message) error {
+	pid := message.Segment("PID")
+	if pid == nil {
+		return hl7.Rejectf("PID segment is missing")
+	}
+	oib, err := patientOIB(pid)
+	if err != nil {
+		return err
+	}
+
+	lastName := pid.Component(5, 1)
+	firstName := strings.TrimSpace(pid.Component(5, 2) + " " + pid.Component(5, 3))
+	if lastName == "" || firstName == "" {
+		return hl7.Rejectf("PID-5 must contain the family and given name")
+	}
+	if len(lastName) > 100 || len(firstName) > 100 {
+		return hl7.Rejectf("PID-5 names must be at most 100 characters")
+	}
+
+	birthDate, err := hl7.ParseTime(pid.Field(7))
+	if err != nil || birthDate.After(time.Now()) {
+		return hl7.Rejectf("PID-7 must be a birth date in the past")
+	}
+
+	gender := strings.ToUpper(pid.Field(8))
+	if gender != "M" && gender != "F" {
+		return hl7.Rejectf("PID-8 sex must be M or F")
+	}
+
+	var patient model.Patient
+	err = s.db.Unscoped().Where("oib_index = ?", model.OIBIndex(oib)).First(&patient).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		created, err := s.patientService.CreatePatient(actor, dto.NewPatientDto{
+			FirstName: firstName,
+			LastName:  lastName,
+			OIB:       oib,
+			BirthDate: birthDate.Format(format.DateFormat),
+			Gender:    gender,
+		})
+		if err != nil {
+			return err
+		}
+		s.logger.Infof("Created patient %s from HL7 message %s", created.Uuid, message.ControlID())
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if patient.DeletedAt.Valid {
+		return hl7.Errorf("patient with the OIB is deleted")
+	}
+
+	_, err = s.patientService.UpdatePatient(actor, patient.ID, dto.UpdatePatientDto{
+		FirstName: firstName,
+		LastName:  lastName,
+		OIB:       oib,
+		BirthDate: birthDate.Format(time.RFC3339),
+		Gender:    gender,
+		DoctorID:  patient.DoctorID,
+	})
+	if err != nil {
+		return err
+	}
+	s.logger.Infof("Updated patient %s from HL7 message %s", patient.Uuid, message.ControlID())
+	return nil
+}
+
+// addResults stores the observations (OBX) of each order (OBR) in a blood
+// test checkup of the patient at the observation time (OBR-7), the checkup is
+// created if it doesn't exist. Results with the same code replace previous ones.
+func (s *Hl7Service) addResults(actor *Actor, message *hl7.Message) error {
+	pid := message.Segment("PID")
+	if pid == nil {
+		return hl7.Rejectf("PID segment is missing")
+	}
+	oib, err := patientOIB(pid)
+	if err != nil {
+		return err
+	}
+	if message.Segment("OBR") == nil {
+		return hl7.Rejectf("OBR segment is missing")
+	}
+
+	var patient model.Patient
+	err = s.db.Where("oib_index = ?", model.OIBIndex(oib)).First(&patient).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return hl7.Errorf("patient with the OIB doesn't exist, it has to be admitted first")
+	}
+	if err != nil {
+		return err
+	}
+	if err := s.accessService.CheckRecord(actor, patient.MedicalRecordID); err != nil {
+		return err
+	}
+
+	results := 0
+	err = audited(s.db, actor).Transaction(func(tx *gorm.DB) error {
+		var checkup *model.Checkup
+		var observedAt time.Time
+		var err error
+		for _, segment := range message.Segments {
+			switch segment.Name {
+			case "OBR":
+				observedAt, err = hl7.ParseTime(segment.Field(7))
+				if err != nil {
+					return hl7.Rejectf("OBR-7 observation time is invalid")
+				}
+				checkup, err = bloodTest(tx, patient.MedicalRecordID, observedAt)
+				if err != nil {
+					return err
+				}
+			case "OBX":
+				if checkup == nil {
+					return hl7.Rejectf("OBX segment must follow an OBR segment")
+				}
+				if err := saveLabResult(tx, checkup, segment, observedAt); err != nil {
+					return err
+				}
+				results++
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	s.logger.Infof("Stored %d lab results of patient %s from HL7 message %s", results, patient.Uuid, message.ControlID())
+	return nil
+}
+
+// bloodTest finds or creates the blood test checkup of the medical record
+func bloodTest(tx *gorm.DB, recordID uint, checkupDate time.Time) (*model.Checkup, error) {
+	checkup := model.Checkup{
+		Uuid:            uuid.New(),
+		CheckupDate:     checkupDate,
+		Type:            model.BloodTest,
+		MedicalRecordID: recordID,
+	}
+	err := tx.Where("medical_record_id = ? AND type = ? AND checkup_date = ?", recordID, model.BloodTest, checkupDate).
+		FirstOrCreate(&checkup).Error
+	return &checkup, err
+}
+
+func saveLabResult(tx *gorm.DB, checkup *model.Checkup, obx *hl7.Segment, observedAt time.Time) error {
+	code := obx.Component(3, 1)
+	if code == "" {
+		return hl7.Rejectf("OBX-3 observation identifier is missing")
+	}
+	value := obx.Field(5)
+	if value == "" {
+		return hl7.Rejectf("OBX-5 value of %s is missing", code)
+	}
+	if value := obx.Field(14); value != "" {
+		t, err := hl7.ParseTime(value)
+		if err != nil {
+			return hl7.Rejectf("OBX-14 observation time of %s is invalid", code)
+		}
+		observedAt = t
+	}
+
+	if len(code) > 50 {
+		return hl7.Rejectf("OBX-3 observation identifier must be at most 50 characters")
+	}
+
+	fields := map[string]any{
+		"name":            obx.Component(3, 2),
+		"value":           value,
+		"units":           obx.Component(6, 1),
+		"reference_range": obx.Field(7),
+		"flag":            obx.Field(8),
+		"status":          obx.Field(11),
+		"observed_at":     observedAt,
+	}
+	limits := map[string]int{"name": 255, "value": 255, "units": 50, "reference_range": 100, "flag": 10, "status": 2}
+	for column, limit := range limits {
+		if len(fields[column].(string)) > limit {
+			return hl7.Rejectf("%s of %s must be at most %d characters", column, code, limit)
+		}
+	}
+	var result model.LabResult
+	return tx.Where(model.LabResult{CheckupID: checkup.ID, Code: code}).
+		Attrs(model.LabResult{Uuid: uuid.New()}).
+		Assign(fields).
+		FirstOrCreate(&result).Error
+}
+
+// patientOIB returns the OIB from PID-3, the identifier with type (CX-5) or
+// assigning authority (CX-4) OIB is preferred over other valid OIBs
+func patientOIB(pid *hl7.Segment) (string, error) {
+	fallback := ""
+	for _, repetition := range pid.Repetitions(3) {
+		id := pid.ComponentOf(repetition, 1)
+		if !validation.ValidOIB(id) {
+			continue
+		}
+		if strings.EqualFold(pid.ComponentOf(repetition, 5), "OIB") || strings.EqualFold(pid.ComponentOf(repetition, 4), "OIB") {
+			return id, nil
+		}
+		if fallback == "" {
+			fallback = id
+		}
+	}
+	if fallback == "" {
+		return "", hl7.Rejectf("PID-3 must contain a valid OIB")
+	}
+	return fallback, nil
+}
+
+func truncate(value string, length int) string {
+	if len(value) > length {
+		return value[:length]
+	}
+	return value
+}
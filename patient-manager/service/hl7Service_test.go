@@ -0,0 +1,84 @@
+package service
+
+import (
+	"PatientManager/internal/testdb"
+	"PatientManager/model"
+	"PatientManager/util/encryption"
+	"PatientManager/util/hl7"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func TestDeadLetterOIBIndex(t *testing.T) {
+	testdb.New(t)
+
+	tests := []struct {
+		name string
+		raw  string
+		want string
+	}{
+		{
+			"OIB of PID-3",
+			"MSH|^~\\&|A|B|C|D|||ADT^A01|1|P|2.5\rPID|1||71481280786^^^MRN~12345678903^^^^OIB||Horvat^Ana",
+			"12345678903",
+		},
+		{
+			"message without PID",
+			"MSH|^~\\&|A|B|C|D|||ORU^R01|1|P|2.5\rOBR|1||||||20240210081500",
+			"",
+		},
+		{
+			"message that can't be parsed",
+			"PID|1||20240210081500~11111111111~12345678903||Horvat^Ana",
+			"12345678903",
+		},
+		{
+			"no valid OIB",
+			"PID|1||12345678900||Horvat^Ana",
+			"",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			message, _ := hl7.Parse(tt.raw)
+			want := ""
+			if tt.want != "" {
+				want = model.OIBIndex(tt.want)
+			}
+			if got := deadLetterOIBIndex(tt.raw, message); got != want {
+				t.Errorf("deadLetterOIBIndex() = %q, want index of %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHl7Service_StoreDeadLetterEncryptsMessage(t *testing.T) {
+	db := testdb.New(t)
+	service := &Hl7Service{db: db, logger: zap.NewNop().Sugar()}
+
+	raw := "MSH|^~\\&|A|B|C|D|||ADT^A01|MSG1|P|2.5\rPID|1||12345678903^^^^OIB||Horvat^Ana"
+	message, err := hl7.Parse(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	service.storeDeadLetter(&Actor{ClientIP: "10.0.0.1"}, raw, message, model.Hl7SourceMllp, "PID-7 must be a birth date in the past")
+
+	var stored string
+	if err := db.Table("hl7_dead_letters").Select("message").Scan(&stored).Error; err != nil {
+		t.Fatal(err)
+	}
+	if !encryption.IsEncrypted(stored) || strings.Contains(stored, "Horvat") {
+		t.Errorf("stored message %q isn't encrypted", stored)
+	}
+
+	var deadLetter model.Hl7DeadLetter
+	if err := db.Where("oib_index = ?", model.OIBIndex("12345678903")).First(&deadLetter).Error; err != nil {
+		t.Fatalf("dead letter isn't found by the OIB index: %v", err)
+	}
+	if deadLetter.Message != raw || deadLetter.MessageType != "ADT^A01" || deadLetter.ControlID != "MSG1" {
+		t.Errorf("dead letter = %+v", deadLetter)
+	}
+}
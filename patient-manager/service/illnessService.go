@@ -2,7 +2,9 @@ package service
 
 import (
 	"PatientManager/app"
+	"PatientManager/dto"
 	"PatientManager/model"
+	"PatientManager/util/query"
 
 	"github.com/google/uuid"
 	"go.uber.org/zap"
@@ -10,23 +12,26 @@ import (
 )
 
 type IIllnessService interface {
-	Create(illness *model.Illness, recordUuid string) (*model.Illness, error)
-	GetAllForRecord(recordUuid uuid.UUID) ([]model.Illness, error)
-	Update(illnessUuid uuid.UUID, illnessUpdateData *model.Illness) (*model.Illness, error)
-	Delete(illnessUuid uuid.UUID) error
+	Create(actor *Actor, illness *model.Illness, recordUuid string) (*model.Illness, error)
+	GetAllForRecord(actor *Actor, recordUuid uuid.UUID) ([]model.Illness, error)
+	ListForRecord(actor *Actor, recordUuid uuid.UUID, filter dto.IllnessQueryDto) ([]model.Illness, *query.Page, error)
+	Update(actor *Actor, illnessUuid uuid.UUID, illnessUpdateData *model.Illness) (*model.Illness, error)
+	Delete(actor *Actor, illnessUuid uuid.UUID) error
 }
 
 type IllnessService struct {
-	db     *gorm.DB
-	logger *zap.SugaredLogger
+	db            *gorm.DB
+	logger        *zap.SugaredLogger
+	accessService IAccessService
 }
 
 func NewIllnessService() IIllnessService {
 	var service IIllnessService
-	app.Invoke(func(db *gorm.DB, logger *zap.SugaredLogger) {
+	app.Invoke(func(db *gorm.DB, logger *zap.SugaredLogger, accessService IAccessService) {
 		service = &IllnessService{
-			db:     db,
-			logger: logger,
+			db:            db,
+			logger:        logger,
+			accessService: accessService,
 		}
 	})
 	return service
@@ -41,24 +46,35 @@ func (s *IllnessService) findMedicalRecordByUUID(recordUuid string) (*model.Medi
 	return &medicalRecord, nil
 }
 
-func (s *IllnessService) Create(illness *model.Illness, recordUuid string) (*model.Illness, error) {
+func (s *IllnessService) Create(actor *Actor, illness *model.Illness, recordUuid string) (*model.Illness, error) {
 	illness.Uuid = uuid.New()
 	medicalRecord, err := s.findMedicalRecordByUUID(recordUuid)
 	if err != nil {
 		return nil, err
 	}
+	if err := s.accessService.CheckRecord(actor, medicalRecord.ID); err != nil {
+		return nil, err
+	}
 	illness.MedicalRecordID = medicalRecord.ID
 
-	if err := s.db.Create(illness).Error; err != nil {
+	if err := audited(s.db, actor).Create(illness).Error; err != nil {
 		s.logger.Errorf("Error creating illness: %v", err)
 		return nil, err
 	}
 	return illness, nil
 }
 
-func (s *IllnessService) GetAllForRecord(recordUuid uuid.UUID) ([]model.Illness, error) {
+func (s *IllnessService) GetAllForRecord(actor *Actor, recordUuid uuid.UUID) ([]model.Illness, error) {
+	medicalRecord, err := s.findMedicalRecordByUUID(recordUuid.String())
+	if err != nil {
+		return nil, err
+	}
+	if err := s.accessService.CheckRecord(actor, medicalRecord.ID); err != nil {
+		return nil, err
+	}
+
 	var illnesses []model.Illness
-	if err := s.db.Joins("JOIN medical_records ON medical_records.id = illnesses.medical_record_id").
+	if err := audited(s.db, actor).Joins("JOIN medical_records ON medical_records.id = illnesses.medical_record_id").
 		Where("medical_records.uuid = ?", recordUuid).
 		Order("start_date desc").
 		Find(&illnesses).Error; err != nil {
@@ -68,6 +84,45 @@ func (s *IllnessService) GetAllForRecord(recordUuid uuid.UUID) ([]model.Illness,
 	return illnesses, nil
 }
 
+var illnessSortFields = query.Fields{
+	"name":      "name",
+	"startDate": "start_date",
+}
+
+// ListForRecord returns a page of illnesses of the medical record, see dto.IllnessQueryDto
+func (s *IllnessService) ListForRecord(actor *Actor, recordUuid uuid.UUID, filter dto.IllnessQueryDto) ([]model.Illness, *query.Page, error) {
+	medicalRecord, err := s.findMedicalRecordByUUID(recordUuid.String())
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := s.accessService.CheckRecord(actor, medicalRecord.ID); err != nil {
+		return nil, nil, err
+	}
+
+	db := audited(s.db, actor).Model(&model.Illness{}).Where("medical_record_id = ?", medicalRecord.ID)
+	if filter.From != nil {
+		db = db.Where("start_date >= ?", *filter.From)
+	}
+	if filter.To != nil {
+		db = db.Where("start_date <= ?", *filter.To)
+	}
+	if filter.Active != nil {
+		if *filter.Active {
+			db = db.Where("end_date IS NULL")
+		} else {
+			db = db.Where("end_date IS NOT NULL")
+		}
+	}
+
+	var illnesses []model.Illness
+	page, err := query.Find(db, filter.ToRequest(), illnessSortFields, "-startDate", &illnesses)
+	if err != nil {
+		s.logger.Errorf("Error listing illnesses for record UUID %s: %v", recordUuid, err)
+		return nil, nil, err
+	}
+	return illnesses, page, nil
+}
+
 func (s *IllnessService) findByUuid(illnessUuid uuid.UUID) (*model.Illness, error) {
 	var illness model.Illness
 	if err := s.db.Where("uuid = ?", illnessUuid).First(&illness).Error; err != nil {
@@ -76,21 +131,35 @@ func (s *IllnessService) findByUuid(illnessUuid uuid.UUID) (*model.Illness, erro
 	return &illness, nil
 }
 
-func (s *IllnessService) Update(illnessUuid uuid.UUID, illnessUpdateData *model.Illness) (*model.Illness, error) {
+func (s *IllnessService) Update(actor *Actor, illnessUuid uuid.UUID, illnessUpdateData *model.Illness) (*model.Illness, error) {
 	existingIllness, err := s.findByUuid(illnessUuid)
 	if err != nil {
 		return nil, err
 	}
+	if err := s.accessService.CheckRecord(actor, existingIllness.MedicalRecordID); err != nil {
+		return nil, err
+	}
 	existingIllness.UpdateIllness(illnessUpdateData)
-	if err := s.db.Save(existingIllness).Error; err != nil {
+	if err := audited(s.db, actor).Save(existingIllness).Error; err != nil {
 		s.logger.Errorf("Error saving updated illness with UUID %s: %v", illnessUuid, err)
 		return nil, err
 	}
 	return existingIllness, nil
 }
 
-func (s *IllnessService) Delete(illnessUuid uuid.UUID) error {
-	if err := s.db.Where("uuid = ?", illnessUuid).Delete(&model.Illness{}).Error; err != nil {
+func (s *IllnessService) Delete(actor *Actor, illnessUuid uuid.UUID) error {
+	existingIllness, err := s.findByUuid(illnessUuid)
+	if err != nil {
+		return err
+	}
+	if err := s.accessService.CheckRecord(actor, existingIllness.MedicalRecordID); err != nil {
+		return err
+	}
+
+	err = audited(s.db, actor).Transaction(func(tx *gorm.DB) error {
+		return deleteIllnesses(tx, byID(existingIllness.ID))
+	})
+	if err != nil {
 		s.logger.Errorf("Error deleting illness with UUID %s: %v", illnessUuid, err)
 		return err
 	}
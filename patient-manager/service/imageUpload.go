@@ -0,0 +1,212 @@
+package service
+
+import (
+	"PatientManager/config"
+	"PatientManager/model"
+	"PatientManager/util/cerror"
+	"PatientManager/util/dicom"
+	"PatientManager/util/imaging"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+const (
+	dicomContentType = "application/dicom"
+	// sniffLength is the number of bytes used to detect the content type
+	sniffLength = 512
+	// previewSize is the largest width and height of DICOM previews
+	previewSize = 1024
+	// maxDecodedPixels limits images decoded for thumbnails and DICOM previews
+	maxDecodedPixels = 100_000_000
+)
+
+// imageUpload is a validated image file with its generated renderings
+type imageUpload struct {
+	filename    string
+	size        int64
+	contentType string
+	dicom       *dicom.File
+	// preview is a PNG rendering of a DICOM file
+	preview []byte
+	// thumbnail is a JPEG of at most ImagePolicy.ThumbnailSize pixels
+	thumbnail []byte
+}
+
+// inspectFile inspects an uploaded file, see inspectImage
+func (c *CheckupService) inspectFile(file *multipart.FileHeader) (*imageUpload, error) {
+	reader, err := file.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	return c.inspectImage(file.Filename, file.Size, reader)
+}
+
+// inspectImage checks the size and content type of the file, parses DICOM
+// files and renders previews and thumbnails. Images that can't be rendered
+// are stored without them.
+func (c *CheckupService) inspectImage(filename string, size int64, reader io.ReadSeeker) (*imageUpload, error) {
+	policy := config.AppConfig.Images
+	if size > policy.MaxFileSize {
+		return nil, fmt.Errorf("%w, the limit is %d bytes", cerror.ErrImageTooLarge, policy.MaxFileSize)
+	}
+
+	header := make([]byte, sniffLength)
+	n, err := io.ReadFull(reader, header)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	upload := &imageUpload{filename: filename, size: size, contentType: sniffImage(header[:n], filename)}
+	if !slices.Contains(policy.AllowedTypes, upload.contentType) {
+		return nil, fmt.Errorf("%w: %s", cerror.ErrImageType, upload.contentType)
+	}
+	if _, err := reader.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	var img image.Image
+	if upload.contentType == dicomContentType {
+		if upload.dicom, err = dicom.Parse(reader, policy.MaxFileSize); err != nil {
+			return nil, err
+		}
+		if img, err = upload.dicom.Preview(maxDecodedPixels); err != nil {
+			c.logger.Warnf("DICOM file %s is stored without a preview: %v", filename, err)
+			return upload, nil
+		}
+		if upload.preview, err = encode(imaging.Fit(img, previewSize), png.Encode); err != nil {
+			return nil, err
+		}
+	} else if img, err = decodeImage(reader); err != nil {
+		c.logger.Warnf("Image %s is stored without a thumbnail: %v", filename, err)
+		return upload, nil
+	}
+
+	upload.thumbnail, err = encode(imaging.Fit(img, policy.ThumbnailSize), func(w io.Writer, img image.Image) error {
+		return jpeg.Encode(w, img, &jpeg.Options{Quality: 80})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return upload, nil
+}
+
+// checkDicom rejects DICOM files of other patients or checkups that aren't
+// imaging
+func (u *imageUpload) checkDicom(checkup *model.Checkup, patientOIB string) error {
+	if u.dicom == nil {
+		return nil
+	}
+	if !checkup.Type.Imaging() {
+		return fmt.Errorf("%s: %w", u.filename, cerror.ErrDicomCheckupType)
+	}
+	if u.dicom.PatientID != patientOIB {
+		return fmt.Errorf("%s: %w", u.filename, cerror.ErrDicomPatientMismatch)
+	}
+	return nil
+}
+
+// image returns the pending image row of the upload, the name is prefixed
+// with the checkup UUID
+func (u *imageUpload) image(checkupUuid uuid.UUID) *model.Image {
+	image := &model.Image{
+		Uuid:   uuid.New(),
+		Path:   fmt.Sprintf("%s_%s", checkupUuid, filepath.Base(u.filename)),
+		Status: model.ImagePending,
+	}
+	u.describe(image)
+	return image
+}
+
+// describe sets the content type, size, DICOM attributes and rendering
+// names of the image
+func (u *imageUpload) describe(image *model.Image) {
+	image.ContentType = u.contentType
+	image.Size = u.size
+	if u.dicom != nil {
+		image.StudyInstanceUID = u.dicom.StudyInstanceUID
+		image.SeriesInstanceUID = u.dicom.SeriesInstanceUID
+		image.SOPInstanceUID = u.dicom.SOPInstanceUID
+		image.Modality = u.dicom.Modality
+		image.AcquiredAt = u.dicom.AcquiredAt
+	}
+	if u.preview != nil {
+		image.PreviewPath = image.Path + ".preview.png"
+	}
+	if u.thumbnail != nil {
+		image.ThumbnailPath = image.Path + ".thumb.jpg"
+	}
+}
+
+// store uploads the original, unless it's nil because it's already stored,
+// and the renderings to the objects of the image. Renderings that fail to
+// upload are cleared from it.
+func (u *imageUpload) store(ctx context.Context, bucketService IbucketService, image *model.Image, original io.Reader) error {
+	image.KeyID = bucketService.KeyID()
+	if original != nil {
+		if err := bucketService.Upload(ctx, image.Path, original, u.size, u.contentType); err != nil {
+			return err
+		}
+	}
+
+	if image.PreviewPath != "" {
+		if bucketService.Upload(ctx, image.PreviewPath, bytes.NewReader(u.preview), int64(len(u.preview)), "image/png") != nil {
+			image.PreviewPath = ""
+		}
+	}
+	if image.ThumbnailPath != "" {
+		if bucketService.Upload(ctx, image.ThumbnailPath, bytes.NewReader(u.thumbnail), int64(len(u.thumbnail)), "image/jpeg") != nil {
+			image.ThumbnailPath = ""
+		}
+	}
+	return nil
+}
+
+// sniffImage detects the content type from the first bytes of a file, files
+// with the .dcm extension are DICOM even if they miss the DICM prefix so that
+// they are rejected as invalid
+func sniffImage(header []byte, filename string) string {
+	if dicom.Is(header) || strings.EqualFold(filepath.Ext(filename), ".dcm") {
+		return dicomContentType
+	}
+	contentType, _, _ := strings.Cut(http.DetectContentType(header), ";")
+	return contentType
+}
+
+// decodeImage decodes JPEG, PNG and GIF images up to maxDecodedPixels
+func decodeImage(reader io.ReadSeeker) (image.Image, error) {
+	cfg, _, err := image.DecodeConfig(reader)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Width*cfg.Height > maxDecodedPixels {
+		return nil, fmt.Errorf("image of %dx%d pixels is too large to decode", cfg.Width, cfg.Height)
+	}
+	if _, err := reader.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	img, _, err := image.Decode(reader)
+	return img, err
+}
+
+func encode(img image.Image, encoder func(io.Writer, image.Image) error) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := encoder(&buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
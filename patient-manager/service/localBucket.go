@@ -0,0 +1,185 @@
+package service
+
+import (
+	"PatientManager/util/cerror"
+	"PatientManager/util/presign"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// LocalBucket stores objects as files in a directory. Files don't keep the
+// content type, it's derived from the extension of the name.
+type LocalBucket struct {
+	dir    string
+	signer *presign.Signer
+}
+
+func newLocalBucket(dir string, signer *presign.Signer) (*LocalBucket, error) {
+	if dir == "" {
+		return nil, errors.New("directory of the local storage is not set")
+	}
+	return &LocalBucket{dir: dir, signer: signer}, nil
+}
+
+func (b *LocalBucket) Ping(ctx context.Context) error {
+	if err := os.MkdirAll(b.dir, 0o750); err != nil {
+		return fmt.Errorf("failed to create storage directory %s: %w", b.dir, err)
+	}
+
+	probe, err := os.CreateTemp(b.dir, ".ping-*")
+	if err != nil {
+		return fmt.Errorf("storage directory %s is not writable: %w", b.dir, err)
+	}
+	probe.Close()
+	return os.Remove(probe.Name())
+}
+
+func (b *LocalBucket) Upload(ctx context.Context, name string, reader io.Reader, size int64, contentType string) error {
+	path, err := b.path(name)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+		return err
+	}
+
+	zap.S().Debugf("Uploading file with name: %s", name)
+	// the file is written under a temporary name so that readers never see
+	// a partial object
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	written, err := io.Copy(tmp, contextReader{ctx: ctx, reader: reader})
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		zap.S().Errorf("Failed to upload %s: %v", name, err)
+		return err
+	}
+	if size >= 0 && written != size {
+		return fmt.Errorf("upload of %s is %d bytes, expected %d", name, written, size)
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+func (b *LocalBucket) OpenFile(ctx context.Context, name string) (io.ReadSeekCloser, *FileInfo, error) {
+	path, err := b.path(name)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil, cerror.ErrFileNotFound
+		}
+		return nil, nil, err
+	}
+	stat, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+
+	return file, &FileInfo{
+		Size:         stat.Size(),
+		ETag:         fmt.Sprintf("%x-%x", stat.ModTime().UnixNano(), stat.Size()),
+		LastModified: stat.ModTime(),
+		ContentType:  mime.TypeByExtension(filepath.Ext(name)),
+	}, nil
+}
+
+func (b *LocalBucket) DeleteMany(ctx context.Context, names []string) error {
+	var deleteErrors []error
+	for _, name := range names {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		path, err := b.path(name)
+		if err == nil {
+			err = os.Remove(path)
+		}
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			zap.S().Errorf("Failed to remove object '%s', error: %v", name, err)
+			deleteErrors = append(deleteErrors, err)
+		}
+	}
+	return errors.Join(deleteErrors...)
+}
+
+func (b *LocalBucket) PresignUpload(ctx context.Context, name string, expiry time.Duration) (string, error) {
+	if _, err := b.path(name); err != nil {
+		return "", err
+	}
+	return b.signer.URL(http.MethodPut, name, time.Now().Add(expiry)), nil
+}
+
+func (b *LocalBucket) PresignDownload(ctx context.Context, name string, contentType string, expiry time.Duration) (string, error) {
+	if _, err := b.path(name); err != nil {
+		return "", err
+	}
+	return b.signer.URL(http.MethodGet, name, time.Now().Add(expiry)), nil
+}
+
+func (b *LocalBucket) List(ctx context.Context, fn func(name string, info *FileInfo) error) error {
+	err := filepath.WalkDir(b.dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		// temporary files of uploads and pings start with a dot
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			return nil
+		}
+
+		stat, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		name, err := filepath.Rel(b.dir, path)
+		if err != nil {
+			return err
+		}
+		return fn(filepath.ToSlash(name), &FileInfo{
+			Size:         stat.Size(),
+			ETag:         fmt.Sprintf("%x-%x", stat.ModTime().UnixNano(), stat.Size()),
+			LastModified: stat.ModTime(),
+			ContentType:  mime.TypeByExtension(filepath.Ext(name)),
+		})
+	})
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+func (b *LocalBucket) KeyID() string {
+	return ""
+}
+
+// path returns the file of the object, names must not leave the directory
+func (b *LocalBucket) path(name string) (string, error) {
+	if !filepath.IsLocal(name) {
+		return "", fmt.Errorf("invalid object name %q", name)
+	}
+	return filepath.Join(b.dir, name), nil
+}
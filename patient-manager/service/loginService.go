@@ -18,55 +18,107 @@ type MobileLoginResult struct {
 	DeviceToken  string
 }
 
+// LoginResult holds tokens of a successful login, or a challenge token when
+// two-factor authentication is needed (see ITwoFactorService)
+type LoginResult struct {
+	AccessToken        string
+	RefreshToken       string
+	ChallengeToken     string
+	EnrollmentRequired bool
+	RecoveryCodes      []string
+}
+
 type ILoginService interface {
-	Login(email, password string) (string, string, error)
-	RefreshTokens(user *model.User) (string, string, error)
+	Login(email, password, clientIP string) (*LoginResult, error)
+	Authenticate(email, password, clientIP string) (*model.User, error)
 }
 
 type LoginService struct {
-	db     *gorm.DB
-	logger *zap.SugaredLogger
+	db               *gorm.DB
+	logger           *zap.SugaredLogger
+	sessionService   ISessionService
+	throttleService  ILoginThrottleService
+	twoFactorService ITwoFactorService
 }
 
 func NewLoginService() ILoginService {
 	var service ILoginService
 
-	app.Invoke(func(db *gorm.DB, logger *zap.SugaredLogger) {
+	app.Invoke(func(
+		db *gorm.DB,
+		logger *zap.SugaredLogger,
+		sessionService ISessionService,
+		throttleService ILoginThrottleService,
+		twoFactorService ITwoFactorService,
+	) {
 		service = &LoginService{
-			db:     db,
-			logger: logger,
+			db:               db,
+			logger:           logger,
+			sessionService:   sessionService,
+			throttleService:  throttleService,
+			twoFactorService: twoFactorService,
 		}
 	})
 
 	return service
 }
 
-func (s *LoginService) Login(email, password string) (string, string, error) {
+func (s *LoginService) Login(email, password, clientIP string) (*LoginResult, error) {
+	user, err := s.Authenticate(email, password, clientIP)
+	if err != nil {
+		return nil, err
+	}
+
+	if user.MustChangePassword {
+		s.logger.Debugf("User %s must change password before login", user.Uuid)
+		return nil, cerror.ErrMustChangePassword
+	}
+
+	if user.TotpEnabled || s.twoFactorService.Required(user) {
+		s.logger.Debugf("User %s needs two-factor authentication", user.Uuid)
+		return s.twoFactorService.Challenge(user)
+	}
+
+	accessToken, refreshToken, err := s.sessionService.Start(user)
+	if err != nil {
+		return nil, err
+	}
+	return &LoginResult{AccessToken: accessToken, RefreshToken: refreshToken}, nil
+}
+
+// Authenticate checks user credentials, failed attempts are throttled per
+// account and client IP (see ILoginThrottleService), while blocked it
+// returns *ThrottleError without checking the password
+func (s *LoginService) Authenticate(email, password, clientIP string) (*model.User, error) {
+	if err := s.throttleService.Check(email, clientIP); err != nil {
+		return nil, err
+	}
+
 	var user model.User
 	if err := s.db.Where("email = ?", email).First(&user).Error; err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			s.logger.Debugf("User not found Email = %s", email)
-			return "", "", cerror.ErrInvalidCredentials
+			return nil, s.onFailure(email, clientIP)
 		}
 
 		s.logger.Errorf("Failed to query user, error = %+v", err)
-		return "", "", err
+		return nil, err
 	}
 
 	if !auth.VerifyPassword(user.PasswordHash, password) {
 		s.logger.Debugf("Invalid password for user Email: %s, uuid: %s", user.Email, user.Uuid)
-		return "", "", cerror.ErrInvalidCredentials
+		return nil, s.onFailure(email, clientIP)
 	}
 
-	token, refresh, err := auth.GenerateTokens(&user)
-	if err != nil {
-		s.logger.Errorf("Failed to generate token error = %+v", err)
-		return "", "", err
+	if err := s.throttleService.RecordSuccess(email); err != nil {
+		return nil, err
 	}
-
-	return token, refresh, nil
+	return &user, nil
 }
 
-func (s *LoginService) RefreshTokens(user *model.User) (string, string, error) {
-	return auth.GenerateTokens(user)
+func (s *LoginService) onFailure(email, clientIP string) error {
+	if err := s.throttleService.RecordFailure(email, clientIP); err != nil {
+		return err
+	}
+	return cerror.ErrInvalidCredentials
 }
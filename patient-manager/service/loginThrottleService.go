@@ -0,0 +1,227 @@
+package service
+
+import (
+	"PatientManager/app"
+	"PatientManager/config"
+	"PatientManager/model"
+	"PatientManager/util/cerror"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ThrottleError is returned while login attempts are blocked, it wraps
+// cerror.ErrTooManyAttempts
+type ThrottleError struct {
+	RetryAfter time.Duration
+	Locked     bool
+}
+
+func (e *ThrottleError) Error() string {
+	return fmt.Sprintf("%s (retry after %s)", cerror.ErrTooManyAttempts, e.RetryAfter.Round(time.Second))
+}
+
+func (e *ThrottleError) Unwrap() error {
+	return cerror.ErrTooManyAttempts
+}
+
+// ILoginThrottleService limits password guessing per account and per client
+// IP using config.AppConfiguration.LoginPolicy, state is kept in the database
+type ILoginThrottleService interface {
+	Check(email, clientIP string) error
+	RecordFailure(email, clientIP string) error
+	RecordSuccess(email string) error
+	Unlock(actor *Actor, userUuid uuid.UUID) error
+}
+
+type LoginThrottleService struct {
+	db           *gorm.DB
+	logger       *zap.SugaredLogger
+	auditService IAuditService
+}
+
+func NewLoginThrottleService() ILoginThrottleService {
+	var service ILoginThrottleService
+	app.Invoke(func(db *gorm.DB, logger *zap.SugaredLogger, auditService IAuditService) {
+		service = &LoginThrottleService{
+			db:           db,
+			logger:       logger,
+			auditService: auditService,
+		}
+	})
+
+	return service
+}
+
+// Check returns *ThrottleError if the account or the client IP is blocked
+func (s *LoginThrottleService) Check(email, clientIP string) error {
+	var throttles []model.LoginThrottle
+	err := s.db.
+		Where("(kind = ? AND subject = ?) OR (kind = ? AND subject = ?)",
+			model.ThrottleAccount, normalizeEmail(email), model.ThrottleIP, clientIP).
+		Where("blocked_until > ?", time.Now()).
+		Find(&throttles).Error
+	if err != nil {
+		s.logger.Errorf("Failed to check login throttle, err = %+v", err)
+		return err
+	}
+
+	var throttleErr *ThrottleError
+	for _, throttle := range throttles {
+		retryAfter := time.Until(*throttle.BlockedUntil)
+		if throttleErr == nil || retryAfter > throttleErr.RetryAfter {
+			throttleErr = &ThrottleError{RetryAfter: retryAfter, Locked: throttle.Locked}
+		}
+	}
+	if throttleErr != nil {
+		s.logger.Debugf("Login of %s from %s blocked for %s", email, clientIP, throttleErr.RetryAfter)
+		return throttleErr
+	}
+	return nil
+}
+
+// RecordFailure counts a failed login of the account and the client IP
+func (s *LoginThrottleService) RecordFailure(email, clientIP string) error {
+	policy := config.AppConfig.LoginPolicy
+
+	if err := s.recordFailure(model.ThrottleAccount, normalizeEmail(email), policy.MaxAccountFailures, clientIP); err != nil {
+		return err
+	}
+	if clientIP == "" {
+		return nil
+	}
+	return s.recordFailure(model.ThrottleIP, clientIP, policy.MaxIPFailures, clientIP)
+}
+
+// RecordSuccess clears failures of the account, failures of the client IP
+// are kept so that one valid account can't be used to reset them
+func (s *LoginThrottleService) RecordSuccess(email string) error {
+	err := s.db.Model(&model.LoginThrottle{}).
+		Where("kind = ? AND subject = ? AND failures > 0", model.ThrottleAccount, normalizeEmail(email)).
+		Updates(map[string]any{"failures": 0, "blocked_until": nil, "locked": false}).Error
+	if err != nil {
+		s.logger.Errorf("Failed to clear login failures of %s, err = %+v", email, err)
+	}
+	return err
+}
+
+// Unlock clears failures and lockout of the user's account
+func (s *LoginThrottleService) Unlock(actor *Actor, userUuid uuid.UUID) error {
+	var user model.User
+	if err := s.db.Where("uuid = ?", userUuid).First(&user).Error; err != nil {
+		return err
+	}
+
+	err := s.db.Model(&model.LoginThrottle{}).
+		Where("kind = ? AND subject = ?", model.ThrottleAccount, normalizeEmail(user.Email)).
+		Updates(map[string]any{"failures": 0, "blocked_until": nil, "locked": false}).Error
+	if err != nil {
+		s.logger.Errorf("Failed to unlock user %s, err = %+v", userUuid, err)
+		return err
+	}
+
+	s.logger.Infof("User %s unlocked by %s", userUuid, actor.Uuid)
+	return s.auditService.Record(&model.AuditEvent{
+		ActorUuid:  &actor.Uuid,
+		Action:     model.AuditUnlock,
+		EntityType: "user",
+		EntityUuid: &user.Uuid,
+		ClientIP:   actor.ClientIP,
+	})
+}
+
+func (s *LoginThrottleService) recordFailure(kind model.ThrottleKind, subject string, maxFailures int, clientIP string) error {
+	policy := config.AppConfig.LoginPolicy
+	now := time.Now()
+
+	// the failure is counted by one statement so that concurrent logins
+	// can't lose failures, failures outside of the window are forgotten
+	throttle := model.LoginThrottle{Kind: kind, Subject: subject, Failures: 1, LastFailureAt: &now}
+	err := s.db.Clauses(
+		clause.OnConflict{
+			Columns: []clause.Column{{Name: "kind"}, {Name: "subject"}},
+			DoUpdates: clause.Assignments(map[string]any{
+				"failures": gorm.Expr("CASE WHEN login_throttles.last_failure_at > ? THEN login_throttles.failures + 1 ELSE 1 END",
+					now.Add(-policy.FailureWindow)),
+				"last_failure_at": now,
+				"updated_at":      now,
+			}),
+		},
+		clause.Returning{},
+	).Create(&throttle).Error
+	if err != nil {
+		s.logger.Errorf("Failed to count login failure of %s %s, err = %+v", kind, subject, err)
+		return err
+	}
+
+	locked := maxFailures > 0 && throttle.Failures >= maxFailures
+	updates := map[string]any{"locked": locked}
+	var blockedUntil time.Time
+	if locked {
+		blockedUntil = now.Add(policy.LockoutDuration)
+		// failures counted meanwhile by concurrent logins are kept
+		updates["failures"] = gorm.Expr("failures - ?", throttle.Failures)
+	} else {
+		blockedUntil = now.Add(backoff(throttle.Failures))
+	}
+	updates["blocked_until"] = blockedUntil
+
+	// a block is only extended, so a concurrent failure with a shorter
+	// backoff can't lift a lockout
+	err = s.db.Model(&model.LoginThrottle{}).
+		Where("id = ? AND (blocked_until IS NULL OR blocked_until < ?)", throttle.ID, blockedUntil).
+		Updates(updates).Error
+	if err != nil {
+		s.logger.Errorf("Failed to block login of %s %s, err = %+v", kind, subject, err)
+		return err
+	}
+
+	if locked {
+		s.logger.Warnf("Login of %s %s locked until %s", kind, subject, blockedUntil.Format(time.RFC3339))
+		s.recordLockout(kind, subject, clientIP)
+	}
+	return nil
+}
+
+// backoff returns how long to block after the given number of failures,
+// it doubles with every failure up to LoginPolicy.BackoffMax
+func backoff(failures int) time.Duration {
+	policy := config.AppConfig.LoginPolicy
+
+	delay := policy.BackoffBase
+	for i := 1; i < failures && delay < policy.BackoffMax; i++ {
+		delay *= 2
+	}
+	return min(delay, policy.BackoffMax)
+}
+
+func (s *LoginThrottleService) recordLockout(kind model.ThrottleKind, subject string, clientIP string) {
+	event := model.AuditEvent{
+		Action:   model.AuditLock,
+		ClientIP: clientIP,
+	}
+
+	switch kind {
+	case model.ThrottleAccount:
+		event.EntityType = "user"
+		var user model.User
+		if err := s.db.Where("LOWER(email) = ?", subject).First(&user).Error; err == nil {
+			event.EntityUuid = &user.Uuid
+		}
+
+	case model.ThrottleIP:
+		event.EntityType = "ip"
+	}
+
+	// failure is already logged by Record, lockout itself succeeded
+	_ = s.auditService.Record(&event)
+}
+
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
@@ -0,0 +1,100 @@
+package service
+
+import (
+	"PatientManager/config"
+	"PatientManager/internal/testdb"
+	"PatientManager/model"
+	"errors"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestLoginThrottleService_RecordFailure(t *testing.T) {
+	db := testdb.New(t)
+	config.AppConfig.LoginPolicy = config.LoginPolicy{
+		MaxAccountFailures: 3,
+		MaxIPFailures:      10,
+		FailureWindow:      15 * time.Minute,
+		BackoffBase:        time.Second,
+		BackoffMax:         time.Minute,
+		LockoutDuration:    15 * time.Minute,
+	}
+	logger := zap.NewNop().Sugar()
+	service := &LoginThrottleService{db: db, logger: logger, auditService: &AuditService{db: db, logger: logger}}
+
+	throttleOf := func(kind model.ThrottleKind, subject string) model.LoginThrottle {
+		t.Helper()
+		var throttle model.LoginThrottle
+		if err := db.Where("kind = ? AND subject = ?", kind, subject).First(&throttle).Error; err != nil {
+			t.Fatalf("failed to load throttle of %s %s: %v", kind, subject, err)
+		}
+		return throttle
+	}
+
+	tests := []struct {
+		name string
+		// before runs ahead of the failure
+		before     func()
+		failures   int
+		locked     bool
+		retryAfter time.Duration
+	}{
+		{name: "first failure", failures: 1, retryAfter: time.Second},
+		{name: "second failure doubles the backoff", failures: 2, retryAfter: 2 * time.Second},
+		{name: "lockout", failures: 0, locked: true, retryAfter: 15 * time.Minute},
+		{name: "failure while locked out", failures: 1, locked: true, retryAfter: 15 * time.Minute},
+		{
+			name: "failure after the window",
+			before: func() {
+				old := time.Now().Add(-time.Hour)
+				db.Model(&model.LoginThrottle{}).Where("kind = ?", model.ThrottleAccount).
+					Updates(map[string]any{"last_failure_at": old, "blocked_until": old, "locked": false})
+			},
+			failures:   1,
+			retryAfter: time.Second,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.before != nil {
+				tt.before()
+			}
+			if err := service.RecordFailure(" Ana@Test.hr", "10.0.0.1"); err != nil {
+				t.Fatalf("RecordFailure() error = %v", err)
+			}
+
+			throttle := throttleOf(model.ThrottleAccount, "ana@test.hr")
+			if throttle.Failures != tt.failures || throttle.Locked != tt.locked {
+				t.Errorf("throttle has %d failures, locked %v, want %d, %v", throttle.Failures, throttle.Locked, tt.failures, tt.locked)
+			}
+
+			var throttleErr *ThrottleError
+			if err := service.Check("ana@test.hr", "10.0.0.2"); !errors.As(err, &throttleErr) {
+				t.Fatalf("Check() error = %v, want ThrottleError", err)
+			}
+			if throttleErr.Locked != tt.locked || throttleErr.RetryAfter > tt.retryAfter || throttleErr.RetryAfter < tt.retryAfter-5*time.Second {
+				t.Errorf("Check() = %+v, want locked %v, retry after %s", throttleErr, tt.locked, tt.retryAfter)
+			}
+		})
+	}
+
+	if ip := throttleOf(model.ThrottleIP, "10.0.0.1"); ip.Failures != len(tests) || ip.Locked {
+		t.Errorf("client IP throttle has %d failures, locked %v, want %d", ip.Failures, ip.Locked, len(tests))
+	}
+
+	var lockouts int64
+	db.Model(&model.AuditEvent{}).Where("action = ?", model.AuditLock).Count(&lockouts)
+	if lockouts != 1 {
+		t.Errorf("%d lockouts recorded, want 1", lockouts)
+	}
+
+	if err := service.RecordSuccess("ana@test.hr"); err != nil {
+		t.Fatal(err)
+	}
+	if err := service.Check("ana@test.hr", "10.0.0.2"); err != nil {
+		t.Errorf("Check() after a successful login error = %v", err)
+	}
+}
@@ -11,22 +11,24 @@ import (
 
 type IMedicalRecordService interface {
 	Create(record *model.MedicalRecord) (*model.MedicalRecord, error)
-	Read(patientOib string) (*model.MedicalRecord, error)
-	Update(recordUuid uuid.UUID, recordUpdateData *model.MedicalRecord) (*model.MedicalRecord, error)
-	Delete(recordUuid uuid.UUID) error
+	Read(actor *Actor, patientOib string) (*model.MedicalRecord, error)
+	Update(actor *Actor, recordUuid uuid.UUID, recordUpdateData *model.MedicalRecord) (*model.MedicalRecord, error)
+	Delete(actor *Actor, recordUuid uuid.UUID) error
 }
 
 type MedicalRecordService struct {
-	db     *gorm.DB
-	logger *zap.SugaredLogger
+	db            *gorm.DB
+	logger        *zap.SugaredLogger
+	accessService IAccessService
 }
 
 func NewMedicalRecordService() IMedicalRecordService {
 	var service IMedicalRecordService
-	app.Invoke(func(db *gorm.DB, logger *zap.SugaredLogger) {
+	app.Invoke(func(db *gorm.DB, logger *zap.SugaredLogger, accessService IAccessService) {
 		service = &MedicalRecordService{
-			db:     db,
-			logger: logger,
+			db:            db,
+			logger:        logger,
+			accessService: accessService,
 		}
 	})
 
@@ -47,9 +49,9 @@ func (s *MedicalRecordService) Create(record *model.MedicalRecord) (*model.Medic
 	return record, nil
 }
 
-func (s *MedicalRecordService) Read(patientOib string) (*model.MedicalRecord, error) {
+func (s *MedicalRecordService) Read(actor *Actor, patientOib string) (*model.MedicalRecord, error) {
 	var patient model.Patient
-	if err := s.db.Where("oib = ?", patientOib).First(&patient).Error; err != nil {
+	if err := s.db.Where("oib_index = ?", model.OIBIndex(patientOib)).First(&patient).Error; err != nil {
 		if err == gorm.ErrRecordNotFound {
 			s.logger.Warnf("Patient with OIB %s not found", patientOib)
 		} else {
@@ -58,8 +60,12 @@ func (s *MedicalRecordService) Read(patientOib string) (*model.MedicalRecord, er
 		return nil, err
 	}
 
+	if err := s.accessService.CheckPatient(actor, patient.ID); err != nil {
+		return nil, err
+	}
+
 	var medicalRecord model.MedicalRecord
-	rez := s.db.
+	rez := audited(s.db, actor).
 		Preload("Checkups").
 		Preload("Illnesses").
 		Where("patient_id = ?", patient.ID).
@@ -94,12 +100,16 @@ func (s *MedicalRecordService) findByUuid(recordUuid uuid.UUID) (*model.MedicalR
 	return &record, nil
 }
 
-func (s *MedicalRecordService) Update(recordUuid uuid.UUID, recordUpdateData *model.MedicalRecord) (*model.MedicalRecord, error) {
+func (s *MedicalRecordService) Update(actor *Actor, recordUuid uuid.UUID, recordUpdateData *model.MedicalRecord) (*model.MedicalRecord, error) {
 	existingRecord, err := s.findByUuid(recordUuid)
 	if err != nil {
 		return nil, err
 	}
 
+	if err := s.accessService.CheckRecord(actor, existingRecord.ID); err != nil {
+		return nil, err
+	}
+
 	s.logger.Debugf("Updating medical record with UUID: %s", recordUuid)
 
 	existingRecord.UpdateMedicalRecord(recordUpdateData)
@@ -114,9 +124,18 @@ func (s *MedicalRecordService) Update(recordUuid uuid.UUID, recordUpdateData *mo
 	return existingRecord, nil
 }
 
-func (s *MedicalRecordService) Delete(recordUuid uuid.UUID) error {
+func (s *MedicalRecordService) Delete(actor *Actor, recordUuid uuid.UUID) error {
 	s.logger.Infof("Attempting to delete medical record with UUID: %s", recordUuid)
 
+	existingRecord, err := s.findByUuid(recordUuid)
+	if err != nil {
+		return err
+	}
+
+	if err := s.accessService.CheckRecord(actor, existingRecord.ID); err != nil {
+		return err
+	}
+
 	rez := s.db.Where("uuid = ?", recordUuid).Delete(&model.MedicalRecord{})
 
 	if rez.Error != nil {
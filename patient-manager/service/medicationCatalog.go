@@ -0,0 +1,113 @@
+package service
+
+import (
+	"PatientManager/dto"
+	"PatientManager/util/validation"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/gin-gonic/gin/binding"
+)
+
+// Formats of medication catalog files
+const (
+	CatalogCSV  = "csv"
+	CatalogJSON = "json"
+)
+
+// catalogColumns are the columns of CSV catalogs, named like the JSON fields
+// of dto.MedicationImportDto
+var catalogColumns = map[string]func(entry *dto.MedicationImportDto) *string{
+	"name":             func(e *dto.MedicationImportDto) *string { return &e.Name },
+	"atcCode":          func(e *dto.MedicationImportDto) *string { return &e.ATCCode },
+	"activeIngredient": func(e *dto.MedicationImportDto) *string { return &e.ActiveIngredient },
+	"strength":         func(e *dto.MedicationImportDto) *string { return &e.Strength },
+	"dosageForm":       func(e *dto.MedicationImportDto) *string { return &e.DosageForm },
+	"route":            func(e *dto.MedicationImportDto) *string { return &e.Route },
+}
+
+// ReadMedicationCatalog reads and validates entries of a catalog file. JSON
+// catalogs are an array of dto.MedicationImportDto, CSV catalogs have a
+// header row with the same names, e.g.
+//
+//	name,atcCode,activeIngredient,strength,dosageForm,route
+//	Paracetamol,N02BE01,paracetamol,500 mg,tablet,oral
+//
+// All invalid entries are reported in the error, counted from 1.
+func ReadMedicationCatalog(reader io.Reader, format string) ([]dto.MedicationImportDto, error) {
+	var entries []dto.MedicationImportDto
+	var err error
+	switch format {
+	case CatalogCSV:
+		entries, err = readCatalogCSV(reader)
+	case CatalogJSON:
+		err = json.NewDecoder(reader).Decode(&entries)
+	default:
+		return nil, fmt.Errorf("unknown catalog format %q, expected %s or %s", format, CatalogCSV, CatalogJSON)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var invalid []error
+	for i := range entries {
+		if err := binding.Validator.ValidateStruct(&entries[i]); err != nil {
+			invalid = append(invalid, fmt.Errorf("entry %d: %s", i+1, describeBindError(err)))
+		}
+	}
+	if len(invalid) > 0 {
+		return nil, errors.Join(invalid...)
+	}
+	return entries, nil
+}
+
+func readCatalogCSV(reader io.Reader) ([]dto.MedicationImportDto, error) {
+	records := csv.NewReader(reader)
+	records.TrimLeadingSpace = true
+
+	header, err := records.Read()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the header: %w", err)
+	}
+	// spreadsheets may start CSV files with a byte order mark
+	for i, column := range header {
+		header[i] = strings.TrimSpace(strings.TrimPrefix(column, "\ufeff"))
+		if _, ok := catalogColumns[header[i]]; !ok {
+			return nil, fmt.Errorf("unknown column %q", header[i])
+		}
+	}
+
+	var entries []dto.MedicationImportDto
+	for {
+		record, err := records.Read()
+		if errors.Is(err, io.EOF) {
+			return entries, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		var entry dto.MedicationImportDto
+		for i, value := range record {
+			*catalogColumns[header[i]](&entry) = value
+		}
+		entries = append(entries, entry)
+	}
+}
+
+// describeBindError lists invalid fields of a validation error
+func describeBindError(err error) string {
+	bindErr := validation.NewBindError(err)
+	if len(bindErr.Fields) == 0 {
+		return bindErr.Detail
+	}
+	fields := make([]string, 0, len(bindErr.Fields))
+	for _, field := range bindErr.Fields {
+		fields = append(fields, field.Field+" "+field.Message)
+	}
+	return strings.Join(fields, ", ")
+}
@@ -2,14 +2,25 @@ package service
 
 import (
 	"PatientManager/app"
+	"PatientManager/dto"
 	"PatientManager/model"
+	"PatientManager/util/query"
+	"fmt"
+	"strings"
 
+	"github.com/google/uuid"
 	"go.uber.org/zap"
 	"gorm.io/gorm"
 )
 
 type IMedicationService interface {
 	GetAll() ([]model.Medication, error)
+	List(filter dto.MedicationQueryDto) ([]model.Medication, *query.Page, error)
+	// Import adds medications of a catalog and updates existing ones with
+	// the same name, strength and dosage form, see ReadMedicationCatalog.
+	// Legacy medications that have only a name are completed by the first
+	// entry with their name. Medications missing from the catalog are kept.
+	Import(entries []dto.MedicationImportDto) (*dto.MedicationImportReportDto, error)
 }
 
 type MedicationService struct {
@@ -36,3 +47,96 @@ func (s *MedicationService) GetAll() ([]model.Medication, error) {
 	}
 	return medications, nil
 }
+
+var medicationSortFields = query.Fields{
+	"name": "name",
+	"atc":  "atc_code",
+}
+
+// List returns a page of medications matched by filter, see dto.MedicationQueryDto
+func (s *MedicationService) List(filter dto.MedicationQueryDto) ([]model.Medication, *query.Page, error) {
+	db := s.db.Model(&model.Medication{})
+	if filter.Name != "" {
+		db = db.Where(`LOWER(name) LIKE ? ESCAPE '\'`, query.LikePrefix(strings.ToLower(filter.Name)))
+	}
+	if q := strings.TrimSpace(filter.Query); q != "" {
+		prefix := query.LikePrefix(strings.ToLower(q))
+		db = db.Where(`LOWER(name) LIKE ? ESCAPE '\' OR LOWER(active_ingredient) LIKE ? ESCAPE '\' OR atc_code LIKE ? ESCAPE '\'`,
+			prefix, prefix, query.LikePrefix(strings.ToUpper(q)))
+	}
+	if atc := strings.TrimSpace(filter.ATC); atc != "" {
+		db = db.Where(`atc_code LIKE ? ESCAPE '\'`, query.LikePrefix(strings.ToUpper(atc)))
+	}
+
+	var medications []model.Medication
+	page, err := query.Find(db, filter.ToRequest(), medicationSortFields, "name", &medications)
+	if err != nil {
+		s.logger.Errorf("Error listing medications: %v", err)
+		return nil, nil, err
+	}
+	return medications, page, nil
+}
+
+func (s *MedicationService) Import(entries []dto.MedicationImportDto) (*dto.MedicationImportReportDto, error) {
+	report := &dto.MedicationImportReportDto{}
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		var existing []model.Medication
+		if err := tx.Unscoped().Find(&existing).Error; err != nil {
+			return err
+		}
+		byProduct := make(map[string]*model.Medication, len(existing))
+		legacy := make(map[string]*model.Medication)
+		for i := range existing {
+			medication := &existing[i]
+			byProduct[productKey(medication)] = medication
+			if medication.Legacy() {
+				legacy[strings.ToLower(medication.Name)] = medication
+			}
+		}
+
+		for _, entry := range entries {
+			imported := entry.ToModel()
+			key := productKey(imported)
+
+			medication, ok := byProduct[key]
+			if !ok {
+				medication, ok = legacy[strings.ToLower(imported.Name)]
+				delete(legacy, strings.ToLower(imported.Name))
+			}
+			if !ok {
+				imported.Uuid = uuid.New()
+				if err := tx.Create(imported).Error; err != nil {
+					return fmt.Errorf("medication %s: %w", imported.Name, err)
+				}
+				byProduct[key] = imported
+				report.Created++
+				continue
+			}
+
+			before := *medication
+			if before == *medication.UpdateMedication(imported) && !before.DeletedAt.Valid {
+				report.Unchanged++
+				continue
+			}
+			medication.DeletedAt = gorm.DeletedAt{}
+			if err := tx.Unscoped().Save(medication).Error; err != nil {
+				return fmt.Errorf("medication %s: %w", imported.Name, err)
+			}
+			byProduct[key] = medication
+			report.Updated++
+		}
+		return nil
+	})
+	if err != nil {
+		s.logger.Errorf("Failed to import medications: %v", err)
+		return nil, err
+	}
+
+	s.logger.Infof("Imported medications: %d created, %d updated, %d unchanged", report.Created, report.Updated, report.Unchanged)
+	return report, nil
+}
+
+// productKey identifies a medication of the catalog
+func productKey(medication *model.Medication) string {
+	return strings.ToLower(medication.Name + "\x00" + medication.Strength + "\x00" + medication.DosageForm)
+}
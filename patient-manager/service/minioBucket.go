@@ -0,0 +1,186 @@
+package service
+
+import (
+	"PatientManager/config"
+	"PatientManager/util/cerror"
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"go.uber.org/zap"
+)
+
+// MinioBucket stores objects in a MinIO or S3 bucket, the client is safe
+// for concurrent use
+type MinioBucket struct {
+	minioClientInstance *minio.Client
+	bucketName          string
+}
+
+func newMinioBucket(bucketName string) (*MinioBucket, error) {
+	minioClient, err := minio.New(config.AppConfig.MIOEndpoint, &minio.Options{
+		Creds:  credentials.NewStaticV4(config.AppConfig.MIOAccessKeyID, config.AppConfig.MIOSecretAccessKey, ""),
+		Secure: config.AppConfig.UseSSL,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create MinIO client %w", err)
+	}
+
+	return &MinioBucket{
+		minioClientInstance: minioClient,
+		bucketName:          bucketName,
+	}, nil
+}
+
+func (b *MinioBucket) Ping(ctx context.Context) error {
+	ok, err := b.minioClientInstance.BucketExists(ctx, b.bucketName)
+	if err != nil {
+		return fmt.Errorf("failed to reach MinIO at %s with access key %s: %w", config.AppConfig.MIOEndpoint, config.AppConfig.MIOAccessKeyID, err)
+	}
+
+	if !ok {
+		zap.S().Infof("Making new bucket: %s", b.bucketName)
+		if err := b.minioClientInstance.MakeBucket(ctx, b.bucketName, minio.MakeBucketOptions{}); err != nil {
+			return fmt.Errorf("failed to make bucket %s: %w", b.bucketName, err)
+		}
+	}
+	return nil
+}
+
+func (b *MinioBucket) OpenFile(ctx context.Context, name string) (io.ReadSeekCloser, *FileInfo, error) {
+	object, err := b.minioClientInstance.GetObject(ctx, b.bucketName, name, minio.GetObjectOptions{})
+	if err != nil {
+		zap.S().Errorf("Failed to get object '%s': %v", name, err)
+		return nil, nil, err
+	}
+
+	// objects are fetched lazily, stat tells if it exists
+	stat, err := object.Stat()
+	if err != nil {
+		object.Close()
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			return nil, nil, cerror.ErrFileNotFound
+		}
+		zap.S().Errorf("Failed to stat object '%s': %v", name, err)
+		return nil, nil, err
+	}
+
+	return object, &FileInfo{
+		Size:         stat.Size,
+		ETag:         stat.ETag,
+		LastModified: stat.LastModified,
+		ContentType:  stat.ContentType,
+	}, nil
+}
+
+func (b *MinioBucket) Upload(ctx context.Context, name string, reader io.Reader, size int64, contentType string) error {
+	zap.S().Debugf("Uploading file with name: %s", name)
+	_, err := b.minioClientInstance.PutObject(
+		ctx,
+		b.bucketName,
+		name,
+		reader,
+		size,
+		minio.PutObjectOptions{ContentType: contentType},
+	)
+	if err != nil {
+		zap.S().Errorf("Failed to upload %s: %v", name, err)
+		return err
+	}
+	return nil
+}
+
+func (b *MinioBucket) PresignUpload(ctx context.Context, name string, expiry time.Duration) (string, error) {
+	presigned, err := b.minioClientInstance.PresignedPutObject(ctx, b.bucketName, name, expiry)
+	if err != nil {
+		return "", err
+	}
+	return presigned.String(), nil
+}
+
+func (b *MinioBucket) PresignDownload(ctx context.Context, name string, contentType string, expiry time.Duration) (string, error) {
+	params := url.Values{}
+	if contentType != "" {
+		params.Set("response-content-type", contentType)
+	}
+	presigned, err := b.minioClientInstance.PresignedGetObject(ctx, b.bucketName, name, expiry, params)
+	if err != nil {
+		return "", err
+	}
+	return presigned.String(), nil
+}
+
+func (b *MinioBucket) List(ctx context.Context, fn func(name string, info *FileInfo) error) error {
+	// cancelling stops the listing goroutine when fn fails
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	for object := range b.minioClientInstance.ListObjects(ctx, b.bucketName, minio.ListObjectsOptions{Recursive: true}) {
+		if object.Err != nil {
+			return object.Err
+		}
+		err := fn(object.Key, &FileInfo{
+			Size:         object.Size,
+			ETag:         object.ETag,
+			LastModified: object.LastModified,
+			ContentType:  object.ContentType,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return ctx.Err()
+}
+
+func (b *MinioBucket) DeleteMany(ctx context.Context, names []string) error {
+	if len(names) == 0 {
+		return nil
+	}
+
+	zap.S().Debugf("Attempting to delete %d objects from bucket '%s'", len(names), b.bucketName)
+
+	objectsCh := make(chan minio.ObjectInfo)
+	go func() {
+		defer close(objectsCh)
+		for _, name := range names {
+			select {
+			case objectsCh <- minio.ObjectInfo{Key: name}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	opts := minio.RemoveObjectsOptions{
+		GovernanceBypass: true,
+	}
+
+	errorCh := b.minioClientInstance.RemoveObjects(ctx, b.bucketName, objectsCh, opts)
+
+	var deleteErrors []string
+	for e := range errorCh {
+		if e.Err != nil {
+			errMsg := fmt.Sprintf("Failed to remove object '%s', error: %v", e.ObjectName, e.Err)
+			zap.S().Error(errMsg)
+			deleteErrors = append(deleteErrors, errMsg)
+		}
+	}
+
+	if len(deleteErrors) > 0 {
+		return fmt.Errorf("encountered errors during object deletion: %v", deleteErrors)
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	zap.S().Infof("Successfully deleted %d objects from bucket '%s'", len(names), b.bucketName)
+	return nil
+}
+
+func (b *MinioBucket) KeyID() string {
+	return ""
+}
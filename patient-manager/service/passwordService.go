@@ -0,0 +1,192 @@
+package service
+
+import (
+	"PatientManager/app"
+	"PatientManager/model"
+	"PatientManager/util/auth"
+	"PatientManager/util/cerror"
+	"PatientManager/util/notify"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+const resetTokenDuration = 30 * time.Minute
+
+// IPasswordService changes and resets user passwords, every new password is
+// checked with auth.ValidatePassword and ends all sessions of the user
+type IPasswordService interface {
+	ChangePassword(email, currentPassword, newPassword, clientIP string) error
+	RequestReset(email string) error
+	ResetPassword(token, newPassword string) error
+	SendTemporaryPassword(user *model.User, password string) error
+}
+
+type PasswordService struct {
+	db             *gorm.DB
+	logger         *zap.SugaredLogger
+	notifier       notify.Notifier
+	sessionService ISessionService
+	loginService   ILoginService
+}
+
+func NewPasswordService() IPasswordService {
+	var service IPasswordService
+	app.Invoke(func(
+		db *gorm.DB,
+		logger *zap.SugaredLogger,
+		notifier notify.Notifier,
+		sessionService ISessionService,
+		loginService ILoginService,
+	) {
+		service = &PasswordService{
+			db:             db,
+			logger:         logger,
+			notifier:       notifier,
+			sessionService: sessionService,
+			loginService:   loginService,
+		}
+	})
+
+	return service
+}
+
+// ChangePassword sets a new password after checking the current one, it's
+// also used to complete a forced password change
+func (s *PasswordService) ChangePassword(email, currentPassword, newPassword, clientIP string) error {
+	user, err := s.loginService.Authenticate(email, currentPassword, clientIP)
+	if err != nil {
+		return err
+	}
+	if currentPassword == newPassword {
+		return cerror.ErrSamePassword
+	}
+
+	if err := s.setPassword(s.db, user, newPassword); err != nil {
+		return err
+	}
+
+	s.logger.Infof("User %s changed password", user.Uuid)
+	return s.sessionService.RevokeAll(user.ID)
+}
+
+// RequestReset sends a reset token to the user, unknown emails are ignored
+// so that the response doesn't reveal which accounts exist
+func (s *PasswordService) RequestReset(email string) error {
+	var user model.User
+	if err := s.db.Where("email = ?", email).First(&user).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			s.logger.Debugf("Password reset requested for unknown Email = %s", email)
+			return nil
+		}
+		s.logger.Errorf("Failed to query user, error = %+v", err)
+		return err
+	}
+
+	token, err := auth.RandomToken()
+	if err != nil {
+		return err
+	}
+
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		// only the latest token can be used
+		if err := tx.Model(&model.PasswordResetToken{}).
+			Where("user_id = ? AND used_at IS NULL", user.ID).
+			Update("used_at", time.Now()).Error; err != nil {
+			return err
+		}
+
+		return tx.Create(&model.PasswordResetToken{
+			UserID:    user.ID,
+			TokenHash: auth.HashToken(token),
+			ExpiresAt: time.Now().Add(resetTokenDuration),
+		}).Error
+	})
+	if err != nil {
+		s.logger.Errorf("Failed to create password reset token for user %s, err = %+v", user.Uuid, err)
+		return err
+	}
+
+	body := fmt.Sprintf(
+		"A password reset was requested for your account.\nUse this token to set a new password, it is valid for %s:\n\n%s",
+		resetTokenDuration, token,
+	)
+	if err := s.notifier.Notify(user.Email, "Password reset", body); err != nil {
+		s.logger.Errorf("Failed to send password reset token to user %s, err = %+v", user.Uuid, err)
+		return err
+	}
+
+	s.logger.Infof("Password reset token sent to user %s", user.Uuid)
+	return nil
+}
+
+// ResetPassword sets a new password using a token sent by RequestReset
+func (s *PasswordService) ResetPassword(token, newPassword string) error {
+	var resetToken model.PasswordResetToken
+	err := s.db.
+		Preload("User").
+		Where("token_hash = ? AND used_at IS NULL AND expires_at > ?", auth.HashToken(token), time.Now()).
+		First(&resetToken).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			s.logger.Debugf("Password reset token not found or expired")
+			return cerror.ErrInvalidToken
+		}
+		s.logger.Errorf("Failed to query password reset token, err = %+v", err)
+		return err
+	}
+
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		rez := tx.Model(&resetToken).Where("used_at IS NULL").Update("used_at", time.Now())
+		if rez.Error != nil {
+			return rez.Error
+		}
+		if rez.RowsAffected == 0 {
+			return cerror.ErrInvalidToken
+		}
+
+		return s.setPassword(tx, &resetToken.User, newPassword)
+	})
+	if err != nil {
+		return err
+	}
+
+	s.logger.Infof("User %s reset password", resetToken.User.Uuid)
+	return s.sessionService.RevokeAll(resetToken.UserID)
+}
+
+// SendTemporaryPassword sends a generated password to a newly created user
+func (s *PasswordService) SendTemporaryPassword(user *model.User, password string) error {
+	body := fmt.Sprintf(
+		"An account was created for you.\nLog in with this temporary password, you will be asked to change it:\n\n%s",
+		password,
+	)
+	if err := s.notifier.Notify(user.Email, "Your new account", body); err != nil {
+		s.logger.Errorf("Failed to send temporary password to user %s, err = %+v", user.Uuid, err)
+		return err
+	}
+	return nil
+}
+
+func (s *PasswordService) setPassword(db *gorm.DB, user *model.User, password string) error {
+	if err := auth.ValidatePassword(password); err != nil {
+		return err
+	}
+
+	hash, err := auth.HashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	err = db.Model(user).Updates(map[string]any{
+		"password_hash":        hash,
+		"must_change_password": false,
+	}).Error
+	if err != nil {
+		s.logger.Errorf("Failed to update password of user %s, err = %+v", user.Uuid, err)
+	}
+	return err
+}
@@ -7,58 +7,191 @@ import (
 	"PatientManager/repository"
 	"PatientManager/util/cerror"
 	"PatientManager/util/format"
+	"PatientManager/util/query"
+	"errors"
+	"math"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
 	"go.uber.org/zap"
+	"gorm.io/gorm"
 )
 
 type PatientService struct {
+	db                   *gorm.DB
 	patientRepository    repository.PatientRepository
 	medicalRecordService IMedicalRecordService
+	accessService        IAccessService
 }
 
 type IPatientService interface {
-	GetAllPatients() ([]dto.PatientDto, error)
-	GetPatientById(id uint) (dto.PatientDto, error)
-	CreatePatient(newPatient dto.NewPatientDto) (dto.PatientDto, error)
-	UpdatePatient(id uint, patientDto dto.UpdatePatientDto) (dto.PatientDto, error)
-	DeletePatient(id uint) error
+	GetAllPatients(actor *Actor, filter dto.PatientQueryDto) (*dto.PageDto[dto.PatientDto], error)
+	GetPatientById(actor *Actor, id uint) (dto.PatientDto, error)
+	Search(actor *Actor, search dto.PatientSearchDto) ([]dto.PatientSearchResultDto, error)
+	CreatePatient(actor *Actor, newPatient dto.NewPatientDto) (dto.PatientDto, error)
+	UpdatePatient(actor *Actor, id uint, patientDto dto.UpdatePatientDto) (dto.PatientDto, error)
+	DeletePatient(actor *Actor, id uint) error
 }
 
 func NewPatientService() IPatientService {
 	var service *PatientService
-	app.Invoke(func(repo repository.PatientRepository, mrservice IMedicalRecordService) {
+	app.Invoke(func(db *gorm.DB, repo repository.PatientRepository, mrservice IMedicalRecordService, accessService IAccessService) {
 		service = &PatientService{
+			db:                   db,
 			patientRepository:    repo,
 			medicalRecordService: mrservice,
+			accessService:        accessService,
 		}
 	})
 	return service
 }
 
-func (s *PatientService) GetAllPatients() ([]dto.PatientDto, error) {
-	patients, err := s.patientRepository.FindAll()
+// checkAccess returns gorm.ErrRecordNotFound if patient doesn't exist or
+// cerror.ErrForbidden if actor can't access it
+func (s *PatientService) checkAccess(actor *Actor, id uint) error {
+	if _, err := s.patientRepository.FindById(id); err != nil {
+		return err
+	}
+	return s.accessService.CheckPatient(actor, id)
+}
+
+// names are encrypted, they are sorted by ranks that keep their order
+var patientSortFields = query.Fields{
+	"firstName": "first_name_rank",
+	"lastName":  "last_name_rank",
+	"birthDate": "birth_date",
+	"createdAt": "created_at",
+}
+
+func (s *PatientService) GetAllPatients(actor *Actor, filter dto.PatientQueryDto) (*dto.PageDto[dto.PatientDto], error) {
+	repo := s.patientRepository.WithContext(auditContext(actor))
+	patients, page, err := repo.FindPage(
+		filter.ToRequest(),
+		patientSortFields,
+		"lastName,firstName",
+		s.accessService.PatientScope(actor),
+		patientFilter(filter),
+	)
 	if err != nil {
 		return nil, err
 	}
 
-	var patientDtos []dto.PatientDto
+	patientDtos := make([]dto.PatientDto, 0, len(patients))
 	for _, p := range patients {
 		patientDtos = append(patientDtos, dto.FromModel(&p))
 	}
-	return patientDtos, nil
+	return dto.NewPageDto(patientDtos, page), nil
+}
+
+func patientFilter(filter dto.PatientQueryDto) func(db *gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		if filter.Gender != "" {
+			db = db.Where("UPPER(patients.gender) = ?", strings.ToUpper(filter.Gender))
+		}
+		if filter.BornFrom != nil {
+			db = db.Where("patients.birth_date >= ?", *filter.BornFrom)
+		}
+		if filter.BornTo != nil {
+			db = db.Where("patients.birth_date <= ?", *filter.BornTo)
+		}
+		if filter.DoctorUuid != "" {
+			db = db.Where("patients.doctor_id IN (?)", db.Session(&gorm.Session{NewDB: true}).Model(&model.User{}).Select("id").Where("uuid = ?", filter.DoctorUuid))
+		}
+		return db
+	}
+}
+
+const defaultSearchLimit = 20
+
+// Search finds patients that actor can access, see dto.PatientSearchDto
+func (s *PatientService) Search(actor *Actor, searchDto dto.PatientSearchDto) ([]dto.PatientSearchResultDto, error) {
+	search := parseSearch(searchDto.Query)
+	search.Limit = searchDto.Limit
+	if search.Limit <= 0 {
+		search.Limit = defaultSearchLimit
+	}
+
+	repo := s.patientRepository.WithContext(auditContext(actor))
+	matches, err := repo.Search(search, s.accessService.PatientScope(actor))
+	if err != nil {
+		zap.S().Errorf("Failed to search patients, err = %+v", err)
+		return nil, err
+	}
+
+	results := make([]dto.PatientSearchResultDto, 0, len(matches))
+	for _, match := range matches {
+		results = append(results, dto.PatientSearchResultDto{
+			Score:   math.Round(match.Score*1000) / 1000,
+			Patient: dto.FromModel(&match.Patient),
+		})
+	}
+	return results, nil
+}
+
+// parseSearch splits the query into criteria: UUIDs, dates, digits (OIB)
+// and the remaining words which are matched against the name
+func parseSearch(q string) repository.PatientSearch {
+	var search repository.PatientSearch
+	var words []string
+
+	for _, part := range strings.Fields(q) {
+		if id, err := uuid.Parse(part); err == nil {
+			search.Uuid = &id
+			continue
+		}
+		if date, ok := parseSearchDate(part); ok {
+			search.BirthDate = &date
+			continue
+		}
+		if isDigits(part) {
+			search.OIB = part
+			continue
+		}
+		words = append(words, part)
+	}
+
+	search.Name = strings.Join(words, " ")
+	return search
+}
+
+func parseSearchDate(value string) (time.Time, bool) {
+	for _, layout := range []string{format.DateFormat, "02.01.2006", "02.01.2006."} {
+		if date, err := time.Parse(layout, value); err == nil {
+			return date, true
+		}
+	}
+	return time.Time{}, false
 }
 
-func (s *PatientService) GetPatientById(id uint) (dto.PatientDto, error) {
-	patient, err := s.patientRepository.FindByIdWithDoctor(id)
+func isDigits(value string) bool {
+	for _, r := range value {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return value != ""
+}
+
+func (s *PatientService) GetPatientById(actor *Actor, id uint) (dto.PatientDto, error) {
+	if err := s.checkAccess(actor, id); err != nil {
+		return dto.PatientDto{}, err
+	}
+
+	repo := s.patientRepository.WithContext(auditContext(actor))
+	patient, err := repo.FindByIdWithDoctor(id)
 	if err != nil {
 		return dto.PatientDto{}, err
 	}
 	return dto.FromModel(&patient), nil
 }
 
-func (s *PatientService) CreatePatient(newPatient dto.NewPatientDto) (dto.PatientDto, error) {
+func (s *PatientService) CreatePatient(actor *Actor, newPatient dto.NewPatientDto) (dto.PatientDto, error) {
+	if actor.Role == model.RoleDoctor {
+		// doctors can only create patients assigned to them
+		newPatient.DoctorID = &actor.UserID
+	}
+
 	bod, err := time.Parse(format.DateFormat, newPatient.BirthDate)
 	if err != nil {
 		zap.S().Errorf("Failed to parse BirthDate = %s, err = %+v", newPatient.BirthDate, err)
@@ -75,9 +208,10 @@ func (s *PatientService) CreatePatient(newPatient dto.NewPatientDto) (dto.Patien
 		DoctorID:  newPatient.DoctorID,
 	}
 
-	createdPatient, err := s.patientRepository.Create(patient)
+	repo := s.patientRepository.WithContext(auditContext(actor))
+	createdPatient, err := repo.Create(patient)
 	if err != nil {
-		return dto.PatientDto{}, err
+		return dto.PatientDto{}, duplicateOIB(err)
 	}
 
 	medicalRecord := model.MedicalRecord{
@@ -92,16 +226,23 @@ func (s *PatientService) CreatePatient(newPatient dto.NewPatientDto) (dto.Patien
 	createdPatient.MedicalRecordID = createdmr.ID
 	createdPatient.MedicalRecord = *createdmr
 
-	s.patientRepository.Update(createdPatient)
+	repo.Update(createdPatient)
 
 	return dto.FromModel(&createdPatient), nil
 }
 
-func (s *PatientService) UpdatePatient(id uint, patientDto dto.UpdatePatientDto) (dto.PatientDto, error) {
+func (s *PatientService) UpdatePatient(actor *Actor, id uint, patientDto dto.UpdatePatientDto) (dto.PatientDto, error) {
+	if err := s.checkAccess(actor, id); err != nil {
+		return dto.PatientDto{}, err
+	}
+
 	patient, err := s.patientRepository.FindById(id)
 	if err != nil {
 		return dto.PatientDto{}, err
 	}
+	if patient.ErasedAt != nil {
+		return dto.PatientDto{}, cerror.ErrPatientErased
+	}
 
 	patient.FirstName = patientDto.FirstName
 	patient.LastName = patientDto.LastName
@@ -117,14 +258,36 @@ func (s *PatientService) UpdatePatient(id uint, patientDto dto.UpdatePatientDto)
 	patient.Gender = patientDto.Gender
 	patient.DoctorID = patientDto.DoctorID
 
-	updatedPatient, err := s.patientRepository.Update(patient)
+	repo := s.patientRepository.WithContext(auditContext(actor))
+	updatedPatient, err := repo.Update(patient)
 	if err != nil {
-		return dto.PatientDto{}, err
+		return dto.PatientDto{}, duplicateOIB(err)
 	}
 
 	return dto.FromModel(&updatedPatient), nil
 }
 
-func (s *PatientService) DeletePatient(id uint) error {
-	return s.patientRepository.Delete(id)
+// duplicateOIB maps unique violations to cerror.ErrDuplicateOIB, the OIB is
+// the only unique patient column set from requests
+func duplicateOIB(err error) error {
+	if errors.Is(err, gorm.ErrDuplicatedKey) {
+		return cerror.ErrDuplicateOIB
+	}
+	return err
+}
+
+// DeletePatient soft deletes the patient with everything in its medical record,
+// superadmin can restore them until they are purged, see ITrashService
+func (s *PatientService) DeletePatient(actor *Actor, id uint) error {
+	patient, err := s.patientRepository.FindById(id)
+	if err != nil {
+		return err
+	}
+	if err := s.accessService.CheckPatient(actor, id); err != nil {
+		return err
+	}
+
+	return audited(s.db, actor).Transaction(func(tx *gorm.DB) error {
+		return deletePatient(tx, &patient)
+	})
 }
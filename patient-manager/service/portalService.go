@@ -0,0 +1,147 @@
+package service
+
+import (
+	"PatientManager/app"
+	"PatientManager/model"
+	"PatientManager/util/cerror"
+	"errors"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// IPortalService is used by users with role patient to access their own data,
+// everything is scoped to the patient linked to the user
+type IPortalService interface {
+	Link(actor *Actor) (*model.Patient, error)
+	GetPatient(actor *Actor) (*model.Patient, error)
+	GetRecord(actor *Actor) (*model.MedicalRecord, error)
+	GetCheckups(actor *Actor) ([]model.Checkup, error)
+	GetIllnesses(actor *Actor) ([]model.Illness, error)
+	GetPrescriptions(actor *Actor) ([]model.Prescription, error)
+}
+
+type PortalService struct {
+	db                   *gorm.DB
+	logger               *zap.SugaredLogger
+	medicalRecordService IMedicalRecordService
+	checkupService       ICheckupService
+	illnessService       IIllnessService
+	prescriptionService  IPrescriptionService
+}
+
+func NewPortalService() IPortalService {
+	var service IPortalService
+	app.Invoke(func(
+		db *gorm.DB,
+		logger *zap.SugaredLogger,
+		medicalRecordService IMedicalRecordService,
+		checkupService ICheckupService,
+		illnessService IIllnessService,
+		prescriptionService IPrescriptionService,
+	) {
+		service = &PortalService{
+			db:                   db,
+			logger:               logger,
+			medicalRecordService: medicalRecordService,
+			checkupService:       checkupService,
+			illnessService:       illnessService,
+			prescriptionService:  prescriptionService,
+		}
+	})
+
+	return service
+}
+
+// Link ties the user to the patient with the same OIB
+func (s *PortalService) Link(actor *Actor) (*model.Patient, error) {
+	if actor.Role != model.RolePatient {
+		return nil, cerror.ErrBadRole
+	}
+
+	var patient model.Patient
+	if err := s.db.Where("oib_index = ?", model.OIBIndex(actor.OIB)).First(&patient).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			s.logger.Warnf("No patient with OIB of user %s", actor.Uuid)
+		} else {
+			s.logger.Errorf("Error finding patient for user %s: %v", actor.Uuid, err)
+		}
+		return nil, err
+	}
+
+	if patient.UserID != nil {
+		if *patient.UserID == actor.UserID {
+			return s.GetPatient(actor)
+		}
+		s.logger.Warnf("Patient ID %d is already linked to user ID %d", patient.ID, *patient.UserID)
+		return nil, cerror.ErrPatientLinked
+	}
+
+	if err := audited(s.db, actor).Model(&patient).Update("user_id", actor.UserID).Error; err != nil {
+		s.logger.Errorf("Error linking patient ID %d to user %s: %v", patient.ID, actor.Uuid, err)
+		return nil, err
+	}
+
+	s.logger.Infof("Linked patient ID %d to user %s", patient.ID, actor.Uuid)
+	return s.GetPatient(actor)
+}
+
+// GetPatient returns the patient linked to the user
+func (s *PortalService) GetPatient(actor *Actor) (*model.Patient, error) {
+	var patient model.Patient
+	rez := audited(s.db, actor).
+		Preload("Doctor").
+		Preload("MedicalRecord").
+		Where("user_id = ?", actor.UserID).
+		First(&patient)
+	if rez.Error != nil {
+		if errors.Is(rez.Error, gorm.ErrRecordNotFound) {
+			s.logger.Debugf("User %s is not linked to a patient", actor.Uuid)
+			return nil, cerror.ErrPatientNotLinked
+		}
+		s.logger.Errorf("Error finding patient for user %s: %v", actor.Uuid, rez.Error)
+		return nil, rez.Error
+	}
+
+	return &patient, nil
+}
+
+func (s *PortalService) GetRecord(actor *Actor) (*model.MedicalRecord, error) {
+	patient, err := s.GetPatient(actor)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.medicalRecordService.Read(actor, patient.OIB)
+}
+
+func (s *PortalService) GetCheckups(actor *Actor) ([]model.Checkup, error) {
+	patient, err := s.GetPatient(actor)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.checkupService.GetAll(actor, patient.MedicalRecord.Uuid)
+}
+
+func (s *PortalService) GetIllnesses(actor *Actor) ([]model.Illness, error) {
+	patient, err := s.GetPatient(actor)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.illnessService.GetAllForRecord(actor, patient.MedicalRecord.Uuid)
+}
+
+func (s *PortalService) GetPrescriptions(actor *Actor) ([]model.Prescription, error) {
+	illnesses, err := s.GetIllnesses(actor)
+	if err != nil {
+		return nil, err
+	}
+
+	illnessIds := make([]uint, len(illnesses))
+	for i, illness := range illnesses {
+		illnessIds[i] = illness.ID
+	}
+	return s.prescriptionService.GetAllForIllnesses(actor, illnessIds)
+}
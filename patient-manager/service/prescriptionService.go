@@ -4,6 +4,7 @@ import (
 	"PatientManager/app"
 	"PatientManager/model"
 	"errors"
+	"slices"
 
 	"github.com/google/uuid"
 	"go.uber.org/zap"
@@ -11,36 +12,55 @@ import (
 )
 
 type IPrescriptionService interface {
-	Create(prescription *model.Prescription, medicationUuids []string) (*model.Prescription, error)
-	GetAllForIllness(illnessId uint) ([]model.Prescription, error)
-	Delete(prescriptionUuid uuid.UUID) error
+	Create(actor *Actor, prescription *model.Prescription, medicationUuids []string) (*model.Prescription, error)
+	GetAllForIllness(actor *Actor, illnessId uint) ([]model.Prescription, error)
+	GetAllForIllnesses(actor *Actor, illnessIds []uint) ([]model.Prescription, error)
+	Delete(actor *Actor, prescriptionUuid uuid.UUID) error
 }
 
 type PrescriptionService struct {
-	db     *gorm.DB
-	logger *zap.SugaredLogger
+	db            *gorm.DB
+	logger        *zap.SugaredLogger
+	accessService IAccessService
 }
 
 func NewPrescriptionService() IPrescriptionService {
 	var service IPrescriptionService
-	app.Invoke(func(db *gorm.DB, logger *zap.SugaredLogger) {
+	app.Invoke(func(db *gorm.DB, logger *zap.SugaredLogger, accessService IAccessService) {
 		service = &PrescriptionService{
-			db:     db,
-			logger: logger,
+			db:            db,
+			logger:        logger,
+			accessService: accessService,
 		}
 	})
 	return service
 }
 
-func (s *PrescriptionService) Create(prescription *model.Prescription, medicationUuids []string) (*model.Prescription, error) {
+// checkIllness returns cerror.ErrForbidden if actor can't access the
+// medical record that the illness belongs to
+func (s *PrescriptionService) checkIllness(actor *Actor, illnessId uint) error {
+	var illness model.Illness
+	if err := s.db.First(&illness, illnessId).Error; err != nil {
+		s.logger.Errorf("Error finding illness with ID %d: %v", illnessId, err)
+		return err
+	}
+	return s.accessService.CheckRecord(actor, illness.MedicalRecordID)
+}
+
+func (s *PrescriptionService) Create(actor *Actor, prescription *model.Prescription, medicationUuids []string) (*model.Prescription, error) {
+	if err := s.checkIllness(actor, prescription.IllnessID); err != nil {
+		return nil, err
+	}
 	prescription.Uuid = uuid.New()
 
-	err := s.db.Transaction(func(tx *gorm.DB) error {
+	err := audited(s.db, actor).Transaction(func(tx *gorm.DB) error {
 		if err := tx.Create(prescription).Error; err != nil {
 			s.logger.Errorf("Error creating prescription record: %v", err)
 			return err
 		}
 
+		// a medication is on a prescription only once
+		medicationUuids = slices.Compact(slices.Sorted(slices.Values(medicationUuids)))
 		var medications []model.Medication
 		if len(medicationUuids) > 0 {
 			if err := tx.Where("uuid IN ?", medicationUuids).Find(&medications).Error; err != nil {
@@ -54,12 +74,20 @@ func (s *PrescriptionService) Create(prescription *model.Prescription, medicatio
 			return errors.New("one or more medications not found")
 		}
 
-		for i := range medications {
-			medications[i].PrescriptionID = &prescription.ID
+		if len(medications) == 0 {
+			return nil
+		}
+		lines := make([]model.PrescriptionLine, 0, len(medications))
+		for _, medication := range medications {
+			lines = append(lines, model.PrescriptionLine{
+				Uuid:           uuid.New(),
+				PrescriptionID: prescription.ID,
+				MedicationID:   medication.ID,
+			})
 		}
 
-		if err := tx.Save(&medications).Error; err != nil {
-			s.logger.Errorf("Error updating medications with prescription ID: %v", err)
+		if err := tx.Create(&lines).Error; err != nil {
+			s.logger.Errorf("Error creating prescription lines: %v", err)
 			return err
 		}
 
@@ -70,32 +98,63 @@ func (s *PrescriptionService) Create(prescription *model.Prescription, medicatio
 		return nil, err
 	}
 
-	s.db.Preload("Medications").First(prescription, prescription.ID)
+	s.db.Preload("Lines.Medication").First(prescription, prescription.ID)
 	return prescription, nil
 }
 
-func (s *PrescriptionService) GetAllForIllness(illnessId uint) ([]model.Prescription, error) {
+func (s *PrescriptionService) GetAllForIllness(actor *Actor, illnessId uint) ([]model.Prescription, error) {
+	if err := s.checkIllness(actor, illnessId); err != nil {
+		return nil, err
+	}
+
 	var prescriptions []model.Prescription
-	if err := s.db.Preload("Medications").Where("illness_id = ?", illnessId).Order("issued_at desc").Find(&prescriptions).Error; err != nil {
+	if err := audited(s.db, actor).Preload("Lines.Medication").Where("illness_id = ?", illnessId).Order("issued_at desc").Find(&prescriptions).Error; err != nil {
 		s.logger.Errorf("Error fetching prescriptions for illness ID %d: %v", illnessId, err)
 		return nil, err
 	}
 	return prescriptions, nil
 }
 
-func (s *PrescriptionService) Delete(prescriptionUuid uuid.UUID) error {
-	return s.db.Transaction(func(tx *gorm.DB) error {
+// GetAllForIllnesses returns prescriptions of all the illnesses with one
+// query, newest first
+func (s *PrescriptionService) GetAllForIllnesses(actor *Actor, illnessIds []uint) ([]model.Prescription, error) {
+	if len(illnessIds) == 0 {
+		return nil, nil
+	}
+
+	var records []uint
+	if err := s.db.Model(&model.Illness{}).Where("id IN ?", illnessIds).Distinct().Pluck("medical_record_id", &records).Error; err != nil {
+		s.logger.Errorf("Error finding illnesses with IDs %v: %v", illnessIds, err)
+		return nil, err
+	}
+	for _, recordId := range records {
+		if err := s.accessService.CheckRecord(actor, recordId); err != nil {
+			return nil, err
+		}
+	}
+
+	var prescriptions []model.Prescription
+	if err := audited(s.db, actor).Preload("Lines.Medication").Where("illness_id IN ?", illnessIds).Order("issued_at desc").Find(&prescriptions).Error; err != nil {
+		s.logger.Errorf("Error fetching prescriptions for illness IDs %v: %v", illnessIds, err)
+		return nil, err
+	}
+	return prescriptions, nil
+}
+
+func (s *PrescriptionService) Delete(actor *Actor, prescriptionUuid uuid.UUID) error {
+	return audited(s.db, actor).Transaction(func(tx *gorm.DB) error {
 		var prescription model.Prescription
 		if err := tx.Where("uuid = ?", prescriptionUuid).First(&prescription).Error; err != nil {
 			s.logger.Errorf("Error finding prescription to delete: %v", err)
 			return err
 		}
 
-		if err := tx.Model(&model.Medication{}).Where("prescription_id = ?", prescription.ID).Update("prescription_id", nil).Error; err != nil {
-			s.logger.Errorf("Error disassociating medications: %v", err)
+		if err := s.checkIllness(actor, prescription.IllnessID); err != nil {
 			return err
 		}
 
+		// lines are deleted when the prescription is purged, so they come
+		// back if it's restored
 		if err := tx.Where("uuid = ?", prescriptionUuid).Delete(&model.Prescription{}).Error; err != nil {
 			s.logger.Errorf("Error deleting prescription: %v", err)
 			return err
@@ -0,0 +1,150 @@
+package service
+
+import (
+	"PatientManager/app"
+	"PatientManager/dto"
+	"PatientManager/model"
+	"context"
+	"time"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// reconcileGrace is how old pending images and objects must be before they
+// are reconciled, younger ones may belong to uploads that are in progress
+const reconcileGrace = time.Hour
+
+// IReconcileService repairs inconsistencies between image rows and bucket
+// objects. Image rows are created as pending before their objects are
+// uploaded, so every object has a row unless the row was purged:
+//   - pending images whose object exists are completed, the others removed
+//   - uploading images whose presigned upload wasn't completed are removed
+//   - previews and thumbnails that don't exist are cleared from images
+//   - objects of images that don't exist are reported
+//   - objects without an image are deleted
+type IReconcileService interface {
+	Reconcile(ctx context.Context) (*dto.StorageReportDto, error)
+}
+
+type ReconcileService struct {
+	db            *gorm.DB
+	logger        *zap.SugaredLogger
+	bucketService IbucketService
+}
+
+func NewReconcileService() IReconcileService {
+	var service IReconcileService
+	app.Invoke(func(db *gorm.DB, logger *zap.SugaredLogger, bucketService IbucketService) {
+		service = &ReconcileService{
+			db:            db,
+			logger:        logger,
+			bucketService: bucketService,
+		}
+	})
+
+	return service
+}
+
+func (s *ReconcileService) Reconcile(ctx context.Context) (*dto.StorageReportDto, error) {
+	cutoff := time.Now().Add(-reconcileGrace)
+
+	objects := make(map[string]time.Time)
+	err := s.bucketService.List(ctx, func(name string, info *FileInfo) error {
+		objects[name] = info.LastModified
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// deleted images keep their objects until they are purged
+	var images []model.Image
+	err = s.db.WithContext(ctx).Unscoped().
+		Select("id", "path", "preview_path", "thumbnail_path", "status", "created_at").
+		Find(&images).Error
+	if err != nil {
+		return nil, err
+	}
+
+	report := &dto.StorageReportDto{ObjectsScanned: len(objects), ImagesScanned: len(images)}
+	referenced := make(map[string]bool)
+	for i := range images {
+		image := &images[i]
+		_, exists := objects[image.Path]
+
+		if image.Status == model.ImagePending && image.CreatedAt.Before(cutoff) {
+			if !exists {
+				if err := s.db.WithContext(ctx).Unscoped().Delete(image).Error; err != nil {
+					return nil, err
+				}
+				report.RemovedImages = append(report.RemovedImages, image.Path)
+				continue
+			}
+			if err := s.repair(ctx, image, objects, map[string]any{"status": model.ImageReady}); err != nil {
+				return nil, err
+			}
+			report.CompletedImages = append(report.CompletedImages, image.Path)
+		} else if image.Status == model.ImageUploading && image.CreatedAt.Before(cutoff) {
+			// the object was never checked, it's deleted as an orphan
+			if err := s.db.WithContext(ctx).Unscoped().Delete(image).Error; err != nil {
+				return nil, err
+			}
+			report.RemovedImages = append(report.RemovedImages, image.Path)
+			continue
+		} else if image.Status == model.ImageReady {
+			if !exists {
+				s.logger.Warnf("Object of image %s is missing from the bucket", image.Path)
+				report.MissingObjects = append(report.MissingObjects, image.Path)
+			}
+			if image.PreviewPath != "" && !hasObject(objects, image.PreviewPath) ||
+				image.ThumbnailPath != "" && !hasObject(objects, image.ThumbnailPath) {
+				if err := s.repair(ctx, image, objects, map[string]any{}); err != nil {
+					return nil, err
+				}
+				report.RepairedImages = append(report.RepairedImages, image.Path)
+			}
+		}
+
+		for _, name := range image.ObjectNames() {
+			referenced[name] = true
+		}
+	}
+
+	// objects are listed before images are loaded and rows are created
+	// before uploads, young objects are kept anyway as a safety margin
+	for name, modified := range objects {
+		if !referenced[name] && modified.Before(cutoff) {
+			report.DeletedObjects = append(report.DeletedObjects, name)
+		}
+	}
+	if len(report.DeletedObjects) > 0 {
+		if err := s.bucketService.DeleteMany(ctx, report.DeletedObjects); err != nil {
+			return nil, err
+		}
+	}
+
+	s.logger.Infof("Reconciled %d objects with %d images: %d completed, %d removed, %d repaired, %d missing objects, %d deleted objects",
+		report.ObjectsScanned, report.ImagesScanned, len(report.CompletedImages), len(report.RemovedImages),
+		len(report.RepairedImages), len(report.MissingObjects), len(report.DeletedObjects))
+	return report, nil
+}
+
+// repair saves updates of the image together with clearing its previews and
+// thumbnails that don't exist
+func (s *ReconcileService) repair(ctx context.Context, image *model.Image, objects map[string]time.Time, updates map[string]any) error {
+	if image.PreviewPath != "" && !hasObject(objects, image.PreviewPath) {
+		image.PreviewPath = ""
+		updates["preview_path"] = ""
+	}
+	if image.ThumbnailPath != "" && !hasObject(objects, image.ThumbnailPath) {
+		image.ThumbnailPath = ""
+		updates["thumbnail_path"] = ""
+	}
+	return s.db.WithContext(ctx).Unscoped().Model(image).Updates(updates).Error
+}
+
+func hasObject(objects map[string]time.Time, name string) bool {
+	_, ok := objects[name]
+	return ok
+}
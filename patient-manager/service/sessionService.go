@@ -0,0 +1,195 @@
+package service
+
+import (
+	"PatientManager/app"
+	"PatientManager/model"
+	"PatientManager/util/auth"
+	"PatientManager/util/cerror"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// ISessionService issues and rotates refresh tokens. Every login starts a
+// session (token family), each refresh replaces the used token with a new
+// one from the same family. Using a token twice revokes the whole family.
+//
+// Revocation only affects refresh tokens, access tokens stay valid until
+// they expire.
+type ISessionService interface {
+	Start(user *model.User) (string, string, error)
+	Rotate(refreshToken string) (string, string, error)
+	Revoke(refreshToken string) error
+	RevokeAll(userID uint) error
+}
+
+type SessionService struct {
+	db     *gorm.DB
+	logger *zap.SugaredLogger
+}
+
+func NewSessionService() ISessionService {
+	var service ISessionService
+	app.Invoke(func(db *gorm.DB, logger *zap.SugaredLogger) {
+		service = &SessionService{
+			db:     db,
+			logger: logger,
+		}
+	})
+
+	return service
+}
+
+// Start starts a new session for the user, returns access and refresh tokens
+func (s *SessionService) Start(user *model.User) (string, string, error) {
+	return s.issue(s.db, user, uuid.New())
+}
+
+// Rotate exchanges a refresh token for new access and refresh tokens
+func (s *SessionService) Rotate(refreshToken string) (string, string, error) {
+	claims, err := auth.ParseRefreshToken(refreshToken)
+	if err != nil {
+		return "", "", err
+	}
+
+	stored, err := s.find(refreshToken)
+	if err != nil {
+		return "", "", err
+	}
+
+	if stored.RevokedAt != nil {
+		s.logger.Debugf("Refresh token ID %d of user ID %d is revoked", stored.ID, stored.UserID)
+		return "", "", cerror.ErrInvalidToken
+	}
+	if stored.UsedAt != nil {
+		return "", "", s.onReuse(stored)
+	}
+	if time.Now().After(stored.ExpiresAt) {
+		return "", "", cerror.ErrInvalidToken
+	}
+
+	var user model.User
+	if err := s.db.First(&user, stored.UserID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			s.logger.Warnf("User ID %d of refresh token ID %d not found", stored.UserID, stored.ID)
+			return "", "", cerror.ErrInvalidToken
+		}
+		return "", "", err
+	}
+	if user.Uuid.String() != claims.Uuid {
+		s.logger.Warnf("Refresh token ID %d doesn't belong to user %s", stored.ID, claims.Uuid)
+		return "", "", cerror.ErrInvalidToken
+	}
+
+	var accessToken, newRefreshToken string
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		rez := tx.Model(&model.RefreshToken{}).
+			Where("id = ? AND used_at IS NULL AND revoked_at IS NULL", stored.ID).
+			Update("used_at", time.Now())
+		if rez.Error != nil {
+			return rez.Error
+		}
+		if rez.RowsAffected == 0 {
+			// token was used by a concurrent request
+			return cerror.ErrTokenReused
+		}
+
+		accessToken, newRefreshToken, err = s.issue(tx, &user, stored.FamilyID)
+		return err
+	})
+	if errors.Is(err, cerror.ErrTokenReused) {
+		return "", "", s.onReuse(stored)
+	}
+	if err != nil {
+		s.logger.Errorf("Failed to rotate refresh token ID %d, err = %+v", stored.ID, err)
+		return "", "", err
+	}
+
+	return accessToken, newRefreshToken, nil
+}
+
+// Revoke ends the session that the refresh token belongs to
+func (s *SessionService) Revoke(refreshToken string) error {
+	stored, err := s.find(refreshToken)
+	if err != nil {
+		return err
+	}
+
+	if err := s.revokeFamily(stored.FamilyID); err != nil {
+		return err
+	}
+
+	s.logger.Infof("Session %s of user ID %d revoked", stored.FamilyID, stored.UserID)
+	return nil
+}
+
+// RevokeAll ends every session of the user
+func (s *SessionService) RevokeAll(userID uint) error {
+	rez := s.db.Model(&model.RefreshToken{}).
+		Where("user_id = ? AND revoked_at IS NULL", userID).
+		Update("revoked_at", time.Now())
+	if rez.Error != nil {
+		s.logger.Errorf("Failed to revoke sessions of user ID %d, err = %+v", userID, rez.Error)
+		return rez.Error
+	}
+
+	s.logger.Infof("Revoked %d refresh tokens of user ID %d", rez.RowsAffected, userID)
+	return nil
+}
+
+func (s *SessionService) issue(db *gorm.DB, user *model.User, familyID uuid.UUID) (string, string, error) {
+	accessToken, refreshToken, err := auth.GenerateTokens(user)
+	if err != nil {
+		s.logger.Errorf("Failed to generate token error = %+v", err)
+		return "", "", err
+	}
+
+	stored := model.RefreshToken{
+		UserID:    user.ID,
+		FamilyID:  familyID,
+		TokenHash: auth.HashToken(refreshToken),
+		ExpiresAt: auth.RefreshTokenExpiry(),
+	}
+	if err := db.Create(&stored).Error; err != nil {
+		s.logger.Errorf("Failed to store refresh token of user ID %d, err = %+v", user.ID, err)
+		return "", "", err
+	}
+
+	return accessToken, refreshToken, nil
+}
+
+func (s *SessionService) find(refreshToken string) (*model.RefreshToken, error) {
+	var stored model.RefreshToken
+	if err := s.db.Where("token_hash = ?", auth.HashToken(refreshToken)).First(&stored).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			s.logger.Debugf("Refresh token not found")
+			return nil, cerror.ErrInvalidToken
+		}
+		s.logger.Errorf("Failed to query refresh token, err = %+v", err)
+		return nil, err
+	}
+	return &stored, nil
+}
+
+// onReuse revokes the session of a token that was used more than once,
+// whoever holds tokens of that session has to log in again
+func (s *SessionService) onReuse(stored *model.RefreshToken) error {
+	s.logger.Warnf("Reuse of refresh token ID %d detected, revoking session %s of user ID %d", stored.ID, stored.FamilyID, stored.UserID)
+	if err := s.revokeFamily(stored.FamilyID); err != nil {
+		return err
+	}
+	return cerror.ErrTokenReused
+}
+
+func (s *SessionService) revokeFamily(familyID uuid.UUID) error {
+	err := s.db.Model(&model.RefreshToken{}).
+		Where("family_id = ? AND revoked_at IS NULL", familyID).
+		Update("revoked_at", time.Now()).Error
+	if err != nil {
+		s.logger.Errorf("Failed to revoke session %s, err = %+v", familyID, err)
+	}
+	return err
+}
@@ -0,0 +1,76 @@
+package service
+
+import (
+	"PatientManager/config"
+	"PatientManager/internal/testdb"
+	"PatientManager/model"
+	"PatientManager/util/auth"
+	"PatientManager/util/cerror"
+	"errors"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestSessionService_Rotate(t *testing.T) {
+	db := testdb.New(t)
+	config.AppConfig.RefreshKey = "refresh"
+	config.AppConfig.SigningKeys = config.SigningKeys{Dir: t.TempDir(), Algorithm: config.AlgorithmEdDSA}
+	if err := auth.LoadKeys(); err != nil {
+		t.Fatal(err)
+	}
+	service := &SessionService{db: db, logger: zap.NewNop().Sugar()}
+	user := createTestUser(t, db, model.RoleDoctor, "")
+
+	// refresh tokens by name, the token returned by a step of the test is
+	// stored under the name of the step
+	tokens := map[string]string{}
+	start := func(name string) {
+		t.Helper()
+		_, refreshToken, err := service.Start(user)
+		if err != nil {
+			t.Fatalf("Start() error = %v", err)
+		}
+		tokens[name] = refreshToken
+	}
+	start("first")
+	start("other session")
+	start("expired")
+	db.Model(&model.RefreshToken{}).Where("token_hash = ?", auth.HashToken(tokens["expired"])).
+		Update("expires_at", time.Now().Add(-time.Minute))
+
+	tests := []struct {
+		name    string
+		token   string
+		wantErr error
+	}{
+		{"second", "first", nil},
+		{"third", "second", nil},
+		{"reuse of a rotated token", "first", cerror.ErrTokenReused},
+		// reuse revokes the whole session, including the newest token
+		{"newest token after reuse", "third", cerror.ErrInvalidToken},
+		{"reuse of a revoked token", "second", cerror.ErrInvalidToken},
+		{"token of another session", "other session", nil},
+		{"expired token", "expired", cerror.ErrInvalidToken},
+		{"token that isn't stored", "not a token", cerror.ErrInvalidToken},
+	}
+
+	for _, tt := range tests {
+		token, ok := tokens[tt.token]
+		if !ok {
+			token = tt.token
+		}
+		_, refreshToken, err := service.Rotate(token)
+		if !errors.Is(err, tt.wantErr) {
+			t.Fatalf("%s: Rotate(%s) error = %v, want %v", tt.name, tt.token, err, tt.wantErr)
+		}
+		tokens[tt.name] = refreshToken
+	}
+
+	var revoked int64
+	db.Model(&model.RefreshToken{}).Where("revoked_at IS NOT NULL").Count(&revoked)
+	if revoked != 3 {
+		t.Errorf("%d refresh tokens revoked, want the 3 of the first session", revoked)
+	}
+}
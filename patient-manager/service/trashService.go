@@ -0,0 +1,353 @@
+package service
+
+import (
+	"PatientManager/app"
+	"PatientManager/config"
+	"PatientManager/dto"
+	"PatientManager/model"
+	"PatientManager/util/cerror"
+	"PatientManager/util/format"
+	"PatientManager/util/query"
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// Types of entities that can be listed and restored
+const (
+	TrashPatient      = "patient"
+	TrashCheckup      = "checkup"
+	TrashIllness      = "illness"
+	TrashPrescription = "prescription"
+)
+
+// ITrashService lists and restores soft deleted entities and purges them
+// after config.AppConfiguration.DeletedRetention. Entities are restored as
+// a unit with the children deleted by the same cascade, see deletePatient.
+// Children whose parent is still deleted are not listed, they can only be
+// restored with the parent.
+type ITrashService interface {
+	List(actor *Actor, filter dto.TrashQueryDto) (*dto.PageDto[dto.DeletedEntityDto], error)
+	Restore(actor *Actor, entityType string, entityUuid uuid.UUID) error
+	Purge(ctx context.Context, before time.Time) error
+}
+
+type TrashService struct {
+	db            *gorm.DB
+	logger        *zap.SugaredLogger
+	bucketService IbucketService
+}
+
+func NewTrashService() ITrashService {
+	var service ITrashService
+	app.Invoke(func(db *gorm.DB, logger *zap.SugaredLogger, bucketService IbucketService) {
+		service = &TrashService{
+			db:            db,
+			logger:        logger,
+			bucketService: bucketService,
+		}
+	})
+
+	return service
+}
+
+var trashSortFields = query.Fields{
+	"deletedAt": "deleted_at",
+}
+
+func (s *TrashService) List(actor *Actor, filter dto.TrashQueryDto) (*dto.PageDto[dto.DeletedEntityDto], error) {
+	db := audited(s.db, actor).Unscoped().Where("deleted_at IS NOT NULL").Session(&gorm.Session{})
+	req := filter.ToRequest()
+	liveRecords := s.db.Model(&model.MedicalRecord{}).Select("id")
+
+	switch filter.Type {
+	case TrashPatient:
+		return listDeleted(db, req, func(p *model.Patient) dto.DeletedEntityDto {
+			return deletedEntity(TrashPatient, p.Uuid, p.FirstName+" "+p.LastName, p.DeletedAt)
+		})
+	case TrashCheckup:
+		db = db.Where("medical_record_id IN (?)", liveRecords)
+		return listDeleted(db, req, func(c *model.Checkup) dto.DeletedEntityDto {
+			label := fmt.Sprintf("%s checkup on %s", c.Type, c.CheckupDate.Format(format.DateFormat))
+			return deletedEntity(TrashCheckup, c.Uuid, label, c.DeletedAt)
+		})
+	case TrashIllness:
+		db = db.Where("medical_record_id IN (?)", liveRecords)
+		return listDeleted(db, req, func(i *model.Illness) dto.DeletedEntityDto {
+			return deletedEntity(TrashIllness, i.Uuid, i.Name, i.DeletedAt)
+		})
+	case TrashPrescription:
+		db = db.Where("illness_id IN (?)", s.db.Model(&model.Illness{}).Select("id"))
+		return listDeleted(db, req, func(p *model.Prescription) dto.DeletedEntityDto {
+			label := fmt.Sprintf("prescription issued on %s", p.IssuedAt.Format(format.DateFormat))
+			return deletedEntity(TrashPrescription, p.Uuid, label, p.DeletedAt)
+		})
+	}
+	return nil, fmt.Errorf("unknown entity type %s", filter.Type)
+}
+
+// listDeleted loads a page of rows matched by db and converts them with toDto
+func listDeleted[T any](db *gorm.DB, req query.Request, toDto func(row *T) dto.DeletedEntityDto) (*dto.PageDto[dto.DeletedEntityDto], error) {
+	var rows []T
+	page, err := query.Find(db.Model(new(T)), req, trashSortFields, "-deletedAt", &rows)
+	if err != nil {
+		return nil, err
+	}
+
+	items := make([]dto.DeletedEntityDto, 0, len(rows))
+	for i := range rows {
+		items = append(items, toDto(&rows[i]))
+	}
+	return dto.NewPageDto(items, page), nil
+}
+
+func deletedEntity(entityType string, entityUuid uuid.UUID, label string, deletedAt gorm.DeletedAt) dto.DeletedEntityDto {
+	entity := dto.DeletedEntityDto{
+		Type:      entityType,
+		Uuid:      entityUuid,
+		Label:     label,
+		DeletedAt: deletedAt.Time,
+	}
+	if retention := config.AppConfig.DeletedRetention; retention > 0 {
+		purgeAt := deletedAt.Time.Add(retention)
+		entity.PurgeAt = &purgeAt
+	}
+	return entity
+}
+
+// Restore restores the deleted entity with children that were deleted with
+// it, returns cerror.ErrParentDeleted if its parent is deleted
+func (s *TrashService) Restore(actor *Actor, entityType string, entityUuid uuid.UUID) error {
+	err := audited(s.db, actor).Transaction(func(tx *gorm.DB) error {
+		switch entityType {
+		case TrashPatient:
+			return restorePatient(tx, entityUuid)
+		case TrashCheckup:
+			return restoreCheckup(tx, entityUuid)
+		case TrashIllness:
+			return restoreIllness(tx, entityUuid)
+		case TrashPrescription:
+			return restorePrescription(tx, entityUuid)
+		}
+		return fmt.Errorf("unknown entity type %s", entityType)
+	})
+	if err != nil {
+		s.logger.Errorf("Failed to restore %s %s, err = %+v", entityType, entityUuid, err)
+		return err
+	}
+
+	s.logger.Infof("Restored %s %s", entityType, entityUuid)
+	return nil
+}
+
+// findDeleted loads the deleted row with entityUuid into dest
+func findDeleted(tx *gorm.DB, entityUuid uuid.UUID, dest any) error {
+	return tx.Unscoped().Where("uuid = ? AND deleted_at IS NOT NULL", entityUuid).First(dest).Error
+}
+
+// checkParent returns cerror.ErrParentDeleted if the parent with id is deleted
+func checkParent(tx *gorm.DB, parent any, id uint) error {
+	err := tx.Select("id").First(parent, id).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return cerror.ErrParentDeleted
+	}
+	return err
+}
+
+// restore clears deleted_at of rows of value's model matched by where conditions
+// that were deleted at or after at
+func restore(tx *gorm.DB, value any, at time.Time, where string, args ...any) error {
+	return tx.Unscoped().
+		Model(value).
+		Where(where, args...).
+		Where("deleted_at >= ?", at).
+		Update("deleted_at", nil).Error
+}
+
+func restorePatient(tx *gorm.DB, patientUuid uuid.UUID) error {
+	var patient model.Patient
+	if err := findDeleted(tx, patientUuid, &patient); err != nil {
+		return err
+	}
+	at := patient.DeletedAt.Time
+	records := tx.Unscoped().Model(&model.MedicalRecord{}).Select("id").Where("patient_id = ?", patient.ID)
+
+	if err := restore(tx, &model.Patient{}, at, "id = ?", patient.ID); err != nil {
+		return err
+	}
+	if err := restore(tx, &model.MedicalRecord{}, at, "patient_id = ?", patient.ID); err != nil {
+		return err
+	}
+	if err := restore(tx, &model.PatientShare{}, at, "patient_id = ?", patient.ID); err != nil {
+		return err
+	}
+	if err := restoreCheckups(tx, at, "medical_record_id IN (?)", records); err != nil {
+		return err
+	}
+	return restoreIllnesses(tx, at, "medical_record_id IN (?)", records)
+}
+
+func restoreCheckup(tx *gorm.DB, checkupUuid uuid.UUID) error {
+	var checkup model.Checkup
+	if err := findDeleted(tx, checkupUuid, &checkup); err != nil {
+		return err
+	}
+	if err := checkParent(tx, &model.MedicalRecord{}, checkup.MedicalRecordID); err != nil {
+		return err
+	}
+	return restoreCheckups(tx, checkup.DeletedAt.Time, "id = ?", checkup.ID)
+}
+
+func restoreIllness(tx *gorm.DB, illnessUuid uuid.UUID) error {
+	var illness model.Illness
+	if err := findDeleted(tx, illnessUuid, &illness); err != nil {
+		return err
+	}
+	if err := checkParent(tx, &model.MedicalRecord{}, illness.MedicalRecordID); err != nil {
+		return err
+	}
+	return restoreIllnesses(tx, illness.DeletedAt.Time, "id = ?", illness.ID)
+}
+
+func restorePrescription(tx *gorm.DB, prescriptionUuid uuid.UUID) error {
+	var prescription model.Prescription
+	if err := findDeleted(tx, prescriptionUuid, &prescription); err != nil {
+		return err
+	}
+	if err := checkParent(tx, &model.Illness{}, prescription.IllnessID); err != nil {
+		return err
+	}
+	return restore(tx, &model.Prescription{}, prescription.DeletedAt.Time, "id = ?", prescription.ID)
+}
+
+// restoreCheckups restores checkups matched by where conditions with their images
+func restoreCheckups(tx *gorm.DB, at time.Time, where string, args ...any) error {
+	checkups := tx.Unscoped().Model(&model.Checkup{}).Select("id").Where(where, args...)
+	if err := restore(tx, &model.Image{}, at, "checkup_id IN (?)", checkups); err != nil {
+		return err
+	}
+	return restore(tx, &model.Checkup{}, at, where, args...)
+}
+
+// restoreIllnesses restores illnesses matched by where conditions with their prescriptions
+func restoreIllnesses(tx *gorm.DB, at time.Time, where string, args ...any) error {
+	illnesses := tx.Unscoped().Model(&model.Illness{}).Select("id").Where(where, args...)
+	if err := restore(tx, &model.Prescription{}, at, "illness_id IN (?)", illnesses); err != nil {
+		return err
+	}
+	return restore(tx, &model.Illness{}, at, where, args...)
+}
+
+// Purge permanently deletes entities deleted before the given time together
+// with their children and erasure requests of purged patients, data of
+// patients under legal hold is kept. Erasure certificates are kept since
+// they hold no personal data. Images are removed from the bucket after the
+// rows, objects that fail to be removed are left to IReconcileService.
+func (s *TrashService) Purge(ctx context.Context, before time.Time) error {
+	db := s.db.Unscoped().Session(&gorm.Session{})
+	expired := "deleted_at < ?"
+
+	held := db.Model(&model.Patient{}).Select("id").Where("legal_hold = ?", true)
+	heldRecords := db.Model(&model.MedicalRecord{}).Select("id").Where("patient_id IN (?)", held)
+	heldIllnesses := db.Model(&model.Illness{}).Select("id").Where("medical_record_id IN (?)", heldRecords)
+	heldCheckups := db.Model(&model.Checkup{}).Select("id").Where("medical_record_id IN (?)", heldRecords)
+
+	var patientIDs, recordIDs, checkupIDs, illnessIDs, prescriptionIDs []uint
+	err := db.Model(&model.Patient{}).
+		Where(expired+" AND legal_hold = ?", before, false).
+		Pluck("id", &patientIDs).Error
+	if err != nil {
+		return err
+	}
+	err = db.Model(&model.MedicalRecord{}).
+		Where("patient_id IN ? OR ("+expired+" AND id NOT IN (?))", patientIDs, before, heldRecords).
+		Pluck("id", &recordIDs).Error
+	if err != nil {
+		return err
+	}
+	err = db.Model(&model.Checkup{}).
+		Where("medical_record_id IN ? OR ("+expired+" AND medical_record_id NOT IN (?))", recordIDs, before, heldRecords).
+		Pluck("id", &checkupIDs).Error
+	if err != nil {
+		return err
+	}
+	err = db.Model(&model.Illness{}).
+		Where("medical_record_id IN ? OR ("+expired+" AND medical_record_id NOT IN (?))", recordIDs, before, heldRecords).
+		Pluck("id", &illnessIDs).Error
+	if err != nil {
+		return err
+	}
+	err = db.Model(&model.Prescription{}).
+		Where("illness_id IN ? OR ("+expired+" AND illness_id NOT IN (?))", illnessIDs, before, heldIllnesses).
+		Pluck("id", &prescriptionIDs).Error
+	if err != nil {
+		return err
+	}
+
+	var images []model.Image
+	err = db.Where("checkup_id IN ? OR ("+expired+" AND checkup_id NOT IN (?))", checkupIDs, before, heldCheckups).
+		Find(&images).Error
+	if err != nil {
+		return err
+	}
+
+	if len(patientIDs)+len(checkupIDs)+len(illnessIDs)+len(prescriptionIDs)+len(images) == 0 {
+		return nil
+	}
+
+	imageIDs := make([]uint, 0, len(images))
+	imagePaths := make([]string, 0, len(images))
+	for _, image := range images {
+		imageIDs = append(imageIDs, image.ID)
+		imagePaths = append(imagePaths, image.ObjectNames()...)
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		// rows are deleted bottom-up so that foreign keys stay valid
+		steps := []func() error{
+			func() error { return tx.Where("id IN ?", imageIDs).Delete(&model.Image{}).Error },
+			func() error { return tx.Where("checkup_id IN ?", checkupIDs).Delete(&model.LabResult{}).Error },
+			func() error { return tx.Where("id IN ?", checkupIDs).Delete(&model.Checkup{}).Error },
+			func() error {
+				return tx.Model(&model.Checkup{}).Where("illness_id IN ?", illnessIDs).Update("illness_id", nil).Error
+			},
+			func() error {
+				return tx.Where("prescription_id IN ?", prescriptionIDs).Delete(&model.PrescriptionLine{}).Error
+			},
+			func() error { return tx.Where("id IN ?", prescriptionIDs).Delete(&model.Prescription{}).Error },
+			func() error { return tx.Where("id IN ?", illnessIDs).Delete(&model.Illness{}).Error },
+			func() error {
+				return tx.Where("patient_id IN ? OR ("+expired+" AND patient_id NOT IN (?))", patientIDs, before, held).
+					Delete(&model.PatientShare{}).Error
+			},
+			func() error { return tx.Where("id IN ?", recordIDs).Delete(&model.MedicalRecord{}).Error },
+			func() error { return tx.Where("patient_id IN ?", patientIDs).Delete(&model.ErasureRequest{}).Error },
+			func() error { return tx.Where("patient_id IN ?", patientIDs).Delete(&model.PatientSearchToken{}).Error },
+			func() error { return tx.Where("id IN ?", patientIDs).Delete(&model.Patient{}).Error },
+		}
+		for _, step := range steps {
+			if err := step(); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if len(imagePaths) > 0 {
+		if err := s.bucketService.DeleteMany(ctx, imagePaths); err != nil {
+			s.logger.Warnf("Failed to remove objects of purged images, they are left to the reconciler: %v", err)
+		}
+	}
+
+	s.logger.Infof("Purged %d patients, %d checkups, %d illnesses, %d prescriptions and %d images deleted before %s",
+		len(patientIDs), len(checkupIDs), len(illnessIDs), len(prescriptionIDs), len(images), before.Format(time.RFC3339))
+	return nil
+}
@@ -0,0 +1,158 @@
+package service
+
+import (
+	"PatientManager/internal/testdb"
+	"PatientManager/model"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// testRecord is a patient with one row of every table that belongs to them
+type testRecord struct {
+	patient      *model.Patient
+	record       model.MedicalRecord
+	illness      model.Illness
+	prescription model.Prescription
+	checkup      model.Checkup
+	image        model.Image
+}
+
+// createTestRecord creates a patient of the doctor with a medical record,
+// an illness with a prescription and a checkup of it with an image stored
+// in the bucket, a lab result, a share with another doctor and a rejected
+// erasure request
+func createTestRecord(t *testing.T, db *gorm.DB, bucket IbucketService, doctor *model.User, oib string) *testRecord {
+	t.Helper()
+
+	r := &testRecord{patient: createTestPatient(t, db, doctor, oib)}
+	other := createTestUser(t, db, model.RoleDoctor, "")
+	medication := model.Medication{Uuid: uuid.New(), Name: "Paracetamol " + oib}
+
+	r.record = model.MedicalRecord{Uuid: uuid.New(), PatientID: r.patient.ID, DoctorID: doctor.ID}
+	create := func(value any) {
+		t.Helper()
+		if err := db.Create(value).Error; err != nil {
+			t.Fatalf("failed to create %T: %v", value, err)
+		}
+	}
+	create(&r.record)
+	if err := db.Model(r.patient).Update("medical_record_id", r.record.ID).Error; err != nil {
+		t.Fatalf("failed to link medical record: %v", err)
+	}
+
+	r.illness = model.Illness{Uuid: uuid.New(), Name: "Flu", StartDate: time.Now(), MedicalRecordID: r.record.ID}
+	create(&r.illness)
+	create(&medication)
+	r.prescription = model.Prescription{Uuid: uuid.New(), IssuedAt: time.Now(), IllnessID: r.illness.ID}
+	create(&r.prescription)
+	create(&model.PrescriptionLine{Uuid: uuid.New(), PrescriptionID: r.prescription.ID, MedicationID: medication.ID})
+
+	r.checkup = model.Checkup{
+		Uuid:            uuid.New(),
+		CheckupDate:     time.Now(),
+		Type:            model.BloodTest,
+		MedicalRecordID: r.record.ID,
+		IllnessID:       &r.illness.ID,
+	}
+	create(&r.checkup)
+	create(&model.LabResult{Uuid: uuid.New(), CheckupID: r.checkup.ID, Code: "HGB", Value: "140"})
+	r.image = model.Image{Uuid: uuid.New(), Path: "checkups/" + oib + ".png", CheckupID: r.checkup.ID}
+	create(&r.image)
+	if err := bucket.Upload(context.Background(), r.image.Path, strings.NewReader("png"), 3, "image/png"); err != nil {
+		t.Fatalf("failed to upload image: %v", err)
+	}
+
+	create(&model.PatientShare{PatientID: r.patient.ID, DoctorID: other.ID, GrantedByID: doctor.ID})
+	create(&model.ErasureRequest{
+		Uuid:          uuid.New(),
+		PatientID:     r.patient.ID,
+		Reason:        "test",
+		Status:        model.ErasureRejected,
+		RequestedByID: doctor.ID,
+		DecidedByID:   &other.ID,
+	})
+	return r
+}
+
+// countRows returns the number of rows of the record in every table,
+// including soft deleted rows
+func (r *testRecord) countRows(t *testing.T, db *gorm.DB) map[string]int64 {
+	t.Helper()
+
+	db = db.Unscoped().Session(&gorm.Session{})
+	counts := map[string]int64{}
+	count := func(table string, value any, where string, args ...any) {
+		var n int64
+		if err := db.Model(value).Where(where, args...).Count(&n).Error; err != nil {
+			t.Fatalf("failed to count %s: %v", table, err)
+		}
+		counts[table] = n
+	}
+	count("patients", &model.Patient{}, "id = ?", r.patient.ID)
+	count("patient_search_tokens", &model.PatientSearchToken{}, "patient_id = ?", r.patient.ID)
+	count("patient_shares", &model.PatientShare{}, "patient_id = ?", r.patient.ID)
+	count("medical_records", &model.MedicalRecord{}, "id = ?", r.record.ID)
+	count("illnesses", &model.Illness{}, "id = ?", r.illness.ID)
+	count("prescriptions", &model.Prescription{}, "id = ?", r.prescription.ID)
+	count("prescription_lines", &model.PrescriptionLine{}, "prescription_id = ?", r.prescription.ID)
+	count("checkups", &model.Checkup{}, "id = ?", r.checkup.ID)
+	count("lab_results", &model.LabResult{}, "checkup_id = ?", r.checkup.ID)
+	count("images", &model.Image{}, "id = ?", r.image.ID)
+	count("erasure_requests", &model.ErasureRequest{}, "patient_id = ?", r.patient.ID)
+	return counts
+}
+
+// TestTrashService_Purge runs with enforced foreign keys (see testdb.Open),
+// so rows must be purged in an order that Postgres accepts
+func TestTrashService_Purge(t *testing.T) {
+	db := testdb.New(t)
+	bucket := newMemoryBucket(nil)
+	service := &TrashService{db: db, logger: zap.NewNop().Sugar(), bucketService: bucket}
+
+	doctor := createTestUser(t, db, model.RoleDoctor, "")
+	purged := createTestRecord(t, db, bucket, doctor, "12345678903")
+	held := createTestRecord(t, db, bucket, doctor, "98765432106")
+	kept := createTestRecord(t, db, bucket, doctor, "69435151530")
+
+	if err := db.Model(held.patient).Update("legal_hold", true).Error; err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range []*testRecord{purged, held} {
+		if err := deletePatient(db, r.patient); err != nil {
+			t.Fatalf("failed to delete patient: %v", err)
+		}
+	}
+
+	if err := service.Purge(context.Background(), time.Now().Add(time.Minute)); err != nil {
+		t.Fatalf("Purge() error = %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		record *testRecord
+		kept   bool
+	}{
+		{"purged patient", purged, false},
+		{"patient under legal hold", held, true},
+		{"patient that isn't deleted", kept, true},
+	}
+
+	for _, tt := range tests {
+		for table, n := range tt.record.countRows(t, db) {
+			if (n > 0) != tt.kept {
+				t.Errorf("%s: %d rows of %s left, want kept %v", tt.name, n, table, tt.kept)
+			}
+		}
+
+		_, _, err := bucket.OpenFile(context.Background(), tt.record.image.Path)
+		if stored := err == nil; stored != tt.kept {
+			t.Errorf("%s: image stored %v, want %v", tt.name, stored, tt.kept)
+		}
+	}
+}
@@ -0,0 +1,320 @@
+package service
+
+import (
+	"PatientManager/app"
+	"PatientManager/config"
+	"PatientManager/model"
+	"PatientManager/util/auth"
+	"PatientManager/util/cerror"
+	"errors"
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+const recoveryCodeCount = 10
+
+// TotpEnrollment is returned when enrollment starts, the secret is shown to
+// the user as a QR code of URI
+type TotpEnrollment struct {
+	Secret string
+	URI    string
+}
+
+// ITwoFactorService handles TOTP two-factor authentication. When it's needed
+// login returns a challenge token instead of tokens (see Challenge) which is
+// exchanged for tokens together with a TOTP or recovery code in Verify.
+type ITwoFactorService interface {
+	Required(user *model.User) bool
+	Challenge(user *model.User) (*LoginResult, error)
+	Enroll(userID uint) (*TotpEnrollment, error)
+	EnrollWithChallenge(challengeToken string) (*TotpEnrollment, error)
+	Confirm(userID uint, code string) ([]string, error)
+	Verify(challengeToken, code, clientIP string) (*LoginResult, error)
+	Reset(actor *Actor, userUuid uuid.UUID) error
+}
+
+type TwoFactorService struct {
+	db              *gorm.DB
+	logger          *zap.SugaredLogger
+	sessionService  ISessionService
+	throttleService ILoginThrottleService
+}
+
+func NewTwoFactorService() ITwoFactorService {
+	var service ITwoFactorService
+	app.Invoke(func(
+		db *gorm.DB,
+		logger *zap.SugaredLogger,
+		sessionService ISessionService,
+		throttleService ILoginThrottleService,
+	) {
+		service = &TwoFactorService{
+			db:              db,
+			logger:          logger,
+			sessionService:  sessionService,
+			throttleService: throttleService,
+		}
+	})
+
+	return service
+}
+
+// Required returns true if the role of the user must use two-factor
+// authentication, see config.TwoFactorPolicy
+func (s *TwoFactorService) Required(user *model.User) bool {
+	return slices.Contains(config.AppConfig.TwoFactor.RequiredRoles, string(user.Role))
+}
+
+// Challenge returns a challenge token for a user whose password was checked,
+// if the user isn't enrolled yet the token can only be used for enrollment
+func (s *TwoFactorService) Challenge(user *model.User) (*LoginResult, error) {
+	audience := auth.ChallengeVerify
+	if !user.TotpEnabled {
+		audience = auth.ChallengeEnroll
+	}
+
+	token, err := auth.GenerateChallengeToken(user, audience)
+	if err != nil {
+		return nil, err
+	}
+
+	return &LoginResult{
+		ChallengeToken:     token,
+		EnrollmentRequired: !user.TotpEnabled,
+	}, nil
+}
+
+// Enroll generates a new TOTP secret, it's enabled once Confirm succeeds
+func (s *TwoFactorService) Enroll(userID uint) (*TotpEnrollment, error) {
+	var user model.User
+	if err := s.db.First(&user, userID).Error; err != nil {
+		return nil, err
+	}
+
+	if user.Role == model.RolePatient {
+		return nil, cerror.ErrBadRole
+	}
+	if user.TotpEnabled {
+		return nil, cerror.ErrTotpEnabled
+	}
+
+	secret, err := auth.GenerateTotpSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.db.Model(&user).Updates(map[string]any{
+		"totp_secret":       secret,
+		"totp_last_counter": 0,
+	}).Error
+	if err != nil {
+		s.logger.Errorf("Failed to save TOTP secret of user %s, err = %+v", user.Uuid, err)
+		return nil, err
+	}
+
+	s.logger.Infof("User %s started TOTP enrollment", user.Uuid)
+	return &TotpEnrollment{
+		Secret: secret,
+		URI:    auth.TotpURI(config.AppConfig.TwoFactor.Issuer, user.Email, secret),
+	}, nil
+}
+
+// EnrollWithChallenge starts enrollment of a user that must enroll before login
+func (s *TwoFactorService) EnrollWithChallenge(challengeToken string) (*TotpEnrollment, error) {
+	user, audience, err := s.challengeUser(challengeToken)
+	if err != nil {
+		return nil, err
+	}
+	if audience != auth.ChallengeEnroll {
+		return nil, cerror.ErrInvalidToken
+	}
+
+	return s.Enroll(user.ID)
+}
+
+// Confirm enables two-factor authentication after checking the first code
+// and returns recovery codes, they are shown to the user only once
+func (s *TwoFactorService) Confirm(userID uint, code string) ([]string, error) {
+	var user model.User
+	if err := s.db.First(&user, userID).Error; err != nil {
+		return nil, err
+	}
+
+	if user.TotpEnabled {
+		return nil, cerror.ErrTotpEnabled
+	}
+	if user.TotpSecret == "" {
+		return nil, cerror.ErrTotpNotEnrolled
+	}
+
+	counter, ok := auth.ValidateTotp(user.TotpSecret, code, user.TotpLastCounter, time.Now())
+	if !ok {
+		return nil, cerror.ErrInvalidOtp
+	}
+
+	codes, err := auth.GenerateRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&user).Updates(map[string]any{
+			"totp_enabled":      true,
+			"totp_last_counter": counter,
+		}).Error; err != nil {
+			return err
+		}
+
+		return s.replaceRecoveryCodes(tx, user.ID, codes)
+	})
+	if err != nil {
+		s.logger.Errorf("Failed to enable TOTP of user %s, err = %+v", user.Uuid, err)
+		return nil, err
+	}
+
+	s.logger.Infof("User %s enabled two-factor authentication", user.Uuid)
+	return codes, nil
+}
+
+// Verify exchanges a challenge token and a TOTP or recovery code for tokens,
+// wrong codes are throttled the same way as wrong passwords
+func (s *TwoFactorService) Verify(challengeToken, code, clientIP string) (*LoginResult, error) {
+	user, audience, err := s.challengeUser(challengeToken)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.throttleService.Check(user.Email, clientIP); err != nil {
+		return nil, err
+	}
+
+	var recoveryCodes []string
+	switch {
+	case audience == auth.ChallengeEnroll && !user.TotpEnabled:
+		recoveryCodes, err = s.Confirm(user.ID, code)
+
+	case user.TotpEnabled:
+		err = s.checkCode(user, code)
+
+	default:
+		return nil, cerror.ErrInvalidToken
+	}
+
+	if errors.Is(err, cerror.ErrInvalidOtp) {
+		if err := s.throttleService.RecordFailure(user.Email, clientIP); err != nil {
+			return nil, err
+		}
+		return nil, cerror.ErrInvalidOtp
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.throttleService.RecordSuccess(user.Email); err != nil {
+		return nil, err
+	}
+
+	accessToken, refreshToken, err := s.sessionService.Start(user)
+	if err != nil {
+		return nil, err
+	}
+
+	return &LoginResult{
+		AccessToken:   accessToken,
+		RefreshToken:  refreshToken,
+		RecoveryCodes: recoveryCodes,
+	}, nil
+}
+
+// Reset disables two-factor authentication of the user and ends their
+// sessions, used when the authenticator and recovery codes are lost
+func (s *TwoFactorService) Reset(actor *Actor, userUuid uuid.UUID) error {
+	var user model.User
+	if err := s.db.Where("uuid = ?", userUuid).First(&user).Error; err != nil {
+		return err
+	}
+
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&user).Updates(map[string]any{
+			"totp_secret":       "",
+			"totp_enabled":      false,
+			"totp_last_counter": 0,
+		}).Error; err != nil {
+			return err
+		}
+
+		return tx.Unscoped().Where("user_id = ?", user.ID).Delete(&model.RecoveryCode{}).Error
+	})
+	if err != nil {
+		s.logger.Errorf("Failed to reset TOTP of user %s, err = %+v", userUuid, err)
+		return err
+	}
+
+	s.logger.Infof("Two-factor authentication of user %s reset by %s", userUuid, actor.Uuid)
+	return s.sessionService.RevokeAll(user.ID)
+}
+
+func (s *TwoFactorService) challengeUser(challengeToken string) (*model.User, string, error) {
+	claims, audience, err := auth.ParseChallengeToken(challengeToken)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var user model.User
+	if err := s.db.Where("uuid = ?", claims.Uuid).First(&user).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, "", cerror.ErrInvalidToken
+		}
+		return nil, "", err
+	}
+	return &user, audience, nil
+}
+
+// checkCode accepts a TOTP code or an unused recovery code
+func (s *TwoFactorService) checkCode(user *model.User, code string) error {
+	if counter, ok := auth.ValidateTotp(user.TotpSecret, code, user.TotpLastCounter, time.Now()); ok {
+		// conditional update so a code can't be used by two concurrent requests
+		rez := s.db.Model(user).
+			Where("totp_last_counter < ?", counter).
+			Update("totp_last_counter", counter)
+		if rez.Error != nil {
+			return rez.Error
+		}
+		if rez.RowsAffected == 0 {
+			return cerror.ErrInvalidOtp
+		}
+		return nil
+	}
+
+	rez := s.db.Model(&model.RecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", user.ID, auth.HashToken(auth.NormalizeRecoveryCode(code))).
+		Update("used_at", time.Now())
+	if rez.Error != nil {
+		return rez.Error
+	}
+	if rez.RowsAffected == 0 {
+		return cerror.ErrInvalidOtp
+	}
+
+	s.logger.Infof("User %s logged in with a recovery code", user.Uuid)
+	return nil
+}
+
+func (s *TwoFactorService) replaceRecoveryCodes(db *gorm.DB, userID uint, codes []string) error {
+	if err := db.Unscoped().Where("user_id = ?", userID).Delete(&model.RecoveryCode{}).Error; err != nil {
+		return err
+	}
+
+	recoveryCodes := make([]model.RecoveryCode, 0, len(codes))
+	for _, code := range codes {
+		recoveryCodes = append(recoveryCodes, model.RecoveryCode{
+			UserID:   userID,
+			CodeHash: auth.HashToken(code),
+		})
+	}
+	return db.Create(&recoveryCodes).Error
+}
@@ -4,6 +4,8 @@ import (
 	"PatientManager/app"
 	"PatientManager/model"
 	"PatientManager/util/auth"
+	"PatientManager/util/cerror"
+	"errors"
 	"fmt"
 	"sort"
 	"strings"
@@ -27,8 +29,9 @@ type IUserCrudService interface {
 }
 
 type UserCrudService struct {
-	db     *gorm.DB
-	logger *zap.SugaredLogger
+	db             *gorm.DB
+	logger         *zap.SugaredLogger
+	sessionService ISessionService
 }
 
 type UserWithScore struct {
@@ -38,10 +41,11 @@ type UserWithScore struct {
 
 func NewUserCrudService() IUserCrudService {
 	var service IUserCrudService
-	app.Invoke(func(db *gorm.DB, logger *zap.SugaredLogger) {
+	app.Invoke(func(db *gorm.DB, logger *zap.SugaredLogger, sessionService ISessionService) {
 		service = &UserCrudService{
-			db:     db,
-			logger: logger,
+			db:             db,
+			logger:         logger,
+			sessionService: sessionService,
 		}
 	})
 
@@ -61,7 +65,7 @@ func (u *UserCrudService) ReadAll() ([]model.User, error) {
 // Delete implements IUserCrudService.
 func (u *UserCrudService) Delete(_uuid uuid.UUID) error {
 	var user model.User
-	rez := u.db.Preload("License").Where("uuid = ?", _uuid).First(&user)
+	rez := u.db.Where("uuid = ?", _uuid).First(&user)
 	if rez.Error != nil {
 		if rez.RowsAffected == 0 {
 			u.logger.Debugf("User with UUID %s not found", _uuid)
@@ -82,6 +86,10 @@ func (u *UserCrudService) Delete(_uuid uuid.UUID) error {
 		return saveRez.Error
 	}
 
+	if err := u.sessionService.RevokeAll(user.ID); err != nil {
+		return err
+	}
+
 	u.logger.Debugf("User with UUID %s anonymized successfully", _uuid)
 	return nil
 }
@@ -114,28 +122,50 @@ func (u *UserCrudService) Update(_uuid uuid.UUID, user *model.User) (*model.User
 		Save(userOld)
 
 	if rez.Error != nil {
-		return nil, rez.Error
+		return nil, duplicateEmail(rez.Error)
 	}
 	return userOld, nil
 }
 
 func (u *UserCrudService) Create(user *model.User, password string) (*model.User, error) {
+	if err := auth.ValidatePassword(password); err != nil {
+		return nil, err
+	}
+
 	hash, err := auth.HashPassword(password)
 	if err != nil {
 		return nil, err
 	}
 	user.PasswordHash = hash
 
+	// the OIB isn't unique in the database, existing data may have duplicates
+	var count int64
+	if err := u.db.Model(&model.User{}).Where("oib = ?", user.OIB).Count(&count).Error; err != nil {
+		return nil, err
+	}
+	if count > 0 {
+		return nil, cerror.ErrDuplicateOIB
+	}
+
 	u.logger.Infof("Creating user: %+v", user)
 
 	// Create the user
 	rez := u.db.Create(&user)
 	if rez.Error != nil {
-		return nil, rez.Error
+		return nil, duplicateEmail(rez.Error)
 	}
 	return user, nil
 }
 
+// duplicateEmail maps unique violations to cerror.ErrDuplicateEmail, uuids
+// are generated so the email is the only unique column set from requests
+func duplicateEmail(err error) error {
+	if errors.Is(err, gorm.ErrDuplicatedKey) {
+		return cerror.ErrDuplicateEmail
+	}
+	return err
+}
+
 // Gets all users except super admin
 func (u *UserCrudService) GetAllUsers() ([]model.User, error) {
 	var users []model.User
@@ -0,0 +1,469 @@
+package audit
+
+import (
+	"PatientManager/model"
+	"bytes"
+	"context"
+	"encoding/json"
+	"reflect"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+const beforeKey = "audit:before"
+
+// auditedTables maps tables holding clinical data to entity type names
+var auditedTables = map[string]string{
+	"patients":      "patient",
+	"checkups":      "checkup",
+	"illnesses":     "illness",
+	"prescriptions": "prescription",
+}
+
+type change struct {
+	Before any `json:"before,omitempty"`
+	After  any `json:"after,omitempty"`
+}
+
+// RegisterCallbacks hooks audit recording into create, update, delete and
+// query operations on audited models.
+//
+// Writes are always recorded, actor is taken from the statement context
+// (see WithActor) and left empty for system changes. Reads are recorded
+// only when the context carries an actor, so internal lookups are skipped.
+func RegisterCallbacks(db *gorm.DB) error {
+	callbacks := db.Callback()
+
+	if err := callbacks.Create().After("gorm:create").Register("audit:create", afterCreate); err != nil {
+		return err
+	}
+	if err := callbacks.Update().Before("gorm:update").Register("audit:before_update", loadBefore); err != nil {
+		return err
+	}
+	if err := callbacks.Update().After("gorm:update").Register("audit:update", afterUpdate); err != nil {
+		return err
+	}
+	if err := callbacks.Delete().Before("gorm:delete").Register("audit:before_delete", loadBefore); err != nil {
+		return err
+	}
+	if err := callbacks.Delete().After("gorm:delete").Register("audit:delete", afterDelete); err != nil {
+		return err
+	}
+	return callbacks.Query().After("gorm:query").Register("audit:read", afterQuery)
+}
+
+func afterCreate(db *gorm.DB) {
+	if db.Error != nil || !audited(db) {
+		return
+	}
+
+	var events []model.AuditEvent
+	var rows []reflect.Value
+	eachRow(db.Statement, db.Statement.ReflectValue, func(row reflect.Value) {
+		events = append(events, newEvent(db, model.AuditCreate, row, diff(db.Statement.Schema, nil, values(db, row))))
+		rows = append(rows, row)
+	})
+	save(db, events, rows)
+}
+
+// loadBefore stores rows that are about to change so that their previous
+// state can be recorded after the update or delete
+func loadBefore(db *gorm.DB) {
+	if db.Error != nil || !audited(db) {
+		return
+	}
+
+	rows, ok := loadCurrent(db)
+	if ok {
+		db.InstanceSet(beforeKey, rows)
+	}
+}
+
+func afterUpdate(db *gorm.DB) {
+	if db.Error != nil || !audited(db) {
+		return
+	}
+	before := beforeRows(db)
+	if len(before) == 0 {
+		return
+	}
+
+	ids := make([]any, 0, len(before))
+	for _, row := range before {
+		ids = append(ids, primaryKey(db, row))
+	}
+
+	after := reflect.New(reflect.SliceOf(reflect.PointerTo(db.Statement.Schema.ModelType)))
+	err := newSession(db).
+		Unscoped().
+		Model(reflect.New(db.Statement.Schema.ModelType).Interface()).
+		Where(clause.IN{Column: clause.PrimaryColumn, Values: ids}).
+		Find(after.Interface()).Error
+	if err != nil {
+		db.AddError(err)
+		return
+	}
+
+	afterByKey := map[any]reflect.Value{}
+	eachRow(db.Statement, after, func(row reflect.Value) {
+		afterByKey[primaryKey(db, row)] = row
+	})
+
+	var events []model.AuditEvent
+	var rows []reflect.Value
+	for _, row := range before {
+		afterRow, ok := afterByKey[primaryKey(db, row)]
+		if !ok {
+			continue
+		}
+		changes := diff(db.Statement.Schema, values(db, row), values(db, afterRow))
+		if len(changes) == 0 {
+			continue
+		}
+		events = append(events, newEvent(db, model.AuditUpdate, afterRow, changes))
+		rows = append(rows, afterRow)
+	}
+	save(db, events, rows)
+}
+
+func afterDelete(db *gorm.DB) {
+	if db.Error != nil || !audited(db) {
+		return
+	}
+
+	rows := beforeRows(db)
+	var events []model.AuditEvent
+	for _, row := range rows {
+		events = append(events, newEvent(db, model.AuditDelete, row, diff(db.Statement.Schema, values(db, row), nil)))
+	}
+	save(db, events, rows)
+}
+
+func afterQuery(db *gorm.DB) {
+	if db.Error != nil || !audited(db) {
+		return
+	}
+	if _, ok := ActorFromContext(db.Statement.Context); !ok {
+		return
+	}
+
+	var events []model.AuditEvent
+	var rows []reflect.Value
+	eachRow(db.Statement, db.Statement.ReflectValue, func(row reflect.Value) {
+		events = append(events, newEvent(db, model.AuditRead, row, nil))
+		rows = append(rows, row)
+	})
+	save(db, events, rows)
+}
+
+func audited(db *gorm.DB) bool {
+	if db.Statement.Schema == nil {
+		return false
+	}
+	_, ok := auditedTables[db.Statement.Schema.Table]
+	return ok
+}
+
+// newSession returns a session without audit actor, so its queries aren't recorded
+func newSession(db *gorm.DB) *gorm.DB {
+	return db.Session(&gorm.Session{NewDB: true, Context: context.Background()})
+}
+
+// loadCurrent loads rows matched by the statement using its primary key
+// or where conditions, returns false if the statement has neither
+func loadCurrent(db *gorm.DB) ([]reflect.Value, bool) {
+	stmt := db.Statement
+	query := newSession(db).Model(reflect.New(stmt.Schema.ModelType).Interface())
+	if stmt.Unscoped {
+		// restores and permanent deletes match soft deleted rows
+		query = query.Unscoped()
+	}
+	hasConditions := false
+
+	if where, ok := stmt.Clauses["WHERE"]; ok {
+		if exprs, ok := where.Expression.(clause.Where); ok && len(exprs.Exprs) > 0 {
+			query = query.Clauses(exprs)
+			hasConditions = true
+		}
+	}
+
+	rv := reflect.Indirect(stmt.ReflectValue)
+	if rv.Kind() == reflect.Struct && rv.Type() == stmt.Schema.ModelType {
+		for _, field := range stmt.Schema.PrimaryFields {
+			if value, zero := field.ValueOf(stmt.Context, rv); !zero {
+				query = query.Where(clause.Eq{Column: clause.Column{Table: clause.CurrentTable, Name: field.DBName}, Value: value})
+				hasConditions = true
+			}
+		}
+	}
+
+	if !hasConditions {
+		return nil, false
+	}
+
+	rows := reflect.New(reflect.SliceOf(reflect.PointerTo(stmt.Schema.ModelType)))
+	if err := query.Find(rows.Interface()).Error; err != nil {
+		zap.S().Errorf("Failed to load rows for audit, err = %+v", err)
+		db.AddError(err)
+		return nil, false
+	}
+
+	var current []reflect.Value
+	eachRow(stmt, rows, func(row reflect.Value) {
+		current = append(current, row)
+	})
+	return current, true
+}
+
+func beforeRows(db *gorm.DB) []reflect.Value {
+	value, ok := db.InstanceGet(beforeKey)
+	if !ok {
+		return nil
+	}
+	rows, _ := value.([]reflect.Value)
+	return rows
+}
+
+// eachRow calls fn for every model struct in rv, rv can be a struct or a slice
+func eachRow(stmt *gorm.Statement, rv reflect.Value, fn func(row reflect.Value)) {
+	rv = reflect.Indirect(rv)
+	switch rv.Kind() {
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < rv.Len(); i++ {
+			row := reflect.Indirect(rv.Index(i))
+			if row.Kind() == reflect.Struct && row.Type() == stmt.Schema.ModelType {
+				fn(row)
+			}
+		}
+
+	case reflect.Struct:
+		if rv.Type() == stmt.Schema.ModelType {
+			fn(rv)
+		}
+	}
+}
+
+func primaryKey(db *gorm.DB, row reflect.Value) any {
+	field := db.Statement.Schema.PrioritizedPrimaryField
+	if field == nil {
+		return nil
+	}
+	value, _ := field.ValueOf(db.Statement.Context, row)
+	return value
+}
+
+// values returns column values of a row keyed by column name
+func values(db *gorm.DB, row reflect.Value) map[string]any {
+	rez := map[string]any{}
+	for _, field := range db.Statement.Schema.Fields {
+		if field.DBName == "" {
+			continue
+		}
+		var value any
+		if field.Serializer != nil {
+			// serialized fields are compared as they are in the model
+			value = field.ReflectValueOf(db.Statement.Context, row).Interface()
+		} else {
+			value, _ = field.ValueOf(db.Statement.Context, row)
+		}
+		rez[field.DBName] = value
+	}
+	return rez
+}
+
+// diff returns changed columns, before or after can be nil for creates and deletes
+func diff(s *schema.Schema, before, after map[string]any) map[string]change {
+	changes := map[string]change{}
+	for _, field := range s.Fields {
+		if field.DBName == "" {
+			continue
+		}
+		oldValue, hasOld := before[field.DBName]
+		newValue, hasNew := after[field.DBName]
+
+		oldJson, _ := json.Marshal(oldValue)
+		newJson, _ := json.Marshal(newValue)
+		if hasOld && hasNew && bytes.Equal(oldJson, newJson) {
+			continue
+		}
+
+		c := change{}
+		if hasOld {
+			c.Before = oldValue
+		}
+		if hasNew {
+			c.After = newValue
+		}
+		if isEncrypted(field) {
+			c = encryptChange(field, c)
+		}
+		changes[field.DBName] = c
+	}
+	return changes
+}
+
+func newEvent(db *gorm.DB, action model.AuditAction, row reflect.Value, changes map[string]change) model.AuditEvent {
+	stmt := db.Statement
+	event := model.AuditEvent{
+		Action:     action,
+		EntityType: auditedTables[stmt.Schema.Table],
+	}
+
+	if actor, ok := ActorFromContext(stmt.Context); ok {
+		event.ActorUuid = &actor.Uuid
+		event.ClientIP = actor.ClientIP
+	}
+
+	if field := stmt.Schema.LookUpField("Uuid"); field != nil {
+		if value, zero := field.ValueOf(stmt.Context, row); !zero {
+			if entityUuid, ok := value.(uuid.UUID); ok {
+				event.EntityUuid = &entityUuid
+			}
+		}
+	}
+
+	if len(changes) > 0 {
+		if data, err := json.Marshal(changes); err == nil {
+			event.Diff = string(data)
+		} else {
+			zap.S().Errorf("Failed to marshal audit diff, err = %+v", err)
+		}
+	}
+
+	return event
+}
+
+// parentTables are tables that rows without a medical record reference
+// through the field, in order of preference
+var parentTables = []struct {
+	field string
+	table string
+}{
+	{"IllnessID", "illnesses"},
+	{"CheckupID", "checkups"},
+}
+
+// patientIDs resolves the patients that the rows belong to, in the order of
+// rows. Rows are grouped by the medical record, illness or checkup that they
+// reference, so that a statement takes one query per table on the way to the
+// patient instead of queries per row.
+func patientIDs(db *gorm.DB, rows []reflect.Value) []*uint {
+	stmt := db.Statement
+	uintField := func(row reflect.Value, name string) (uint, bool) {
+		field := stmt.Schema.LookUpField(name)
+		if field == nil {
+			return 0, false
+		}
+		value, zero := field.ValueOf(stmt.Context, row)
+		switch id := value.(type) {
+		case uint:
+			return id, !zero
+		case *uint:
+			if id != nil {
+				return *id, true
+			}
+		}
+		return 0, false
+	}
+
+	ids := make([]*uint, len(rows))
+	if stmt.Schema.Table == "patients" {
+		for i, row := range rows {
+			if id, ok := uintField(row, "ID"); ok {
+				ids[i] = &id
+			}
+		}
+		return ids
+	}
+
+	// medical records of the rows, parents maps ids of referenced illnesses
+	// and checkups to their medical records
+	recordIDs := make([]uint, len(rows))
+	parents := map[string]map[uint]uint{}
+	for i, row := range rows {
+		if id, ok := uintField(row, "MedicalRecordID"); ok {
+			recordIDs[i] = id
+			continue
+		}
+		for _, parent := range parentTables {
+			if id, ok := uintField(row, parent.field); ok {
+				if parents[parent.table] == nil {
+					parents[parent.table] = map[uint]uint{}
+				}
+				parents[parent.table][id] = 0
+				break
+			}
+		}
+	}
+	for table, records := range parents {
+		lookup(db, table, "medical_record_id", records)
+	}
+
+	patients := map[uint]uint{}
+	for i, row := range rows {
+		if recordIDs[i] == 0 {
+			for _, parent := range parentTables {
+				if id, ok := uintField(row, parent.field); ok {
+					recordIDs[i] = parents[parent.table][id]
+					break
+				}
+			}
+		}
+		if recordIDs[i] != 0 {
+			patients[recordIDs[i]] = 0
+		}
+	}
+	lookup(db, "medical_records", "patient_id", patients)
+
+	for i, recordID := range recordIDs {
+		if id := patients[recordID]; id != 0 {
+			ids[i] = &id
+		}
+	}
+	return ids
+}
+
+// lookup sets values of ids to the column of the table rows with their ids,
+// ids of missing rows are left zero
+func lookup(db *gorm.DB, table, column string, ids map[uint]uint) {
+	if len(ids) == 0 {
+		return
+	}
+	keys := make([]uint, 0, len(ids))
+	for id := range ids {
+		keys = append(keys, id)
+	}
+
+	var found []struct {
+		ID    uint
+		Value uint
+	}
+	err := newSession(db).Table(table).Select("id, "+column+" AS value").Where("id IN ?", keys).Scan(&found).Error
+	if err != nil {
+		zap.S().Errorf("Failed to load %s of %s for audit, err = %+v", column, table, err)
+		return
+	}
+	for _, row := range found {
+		ids[row.ID] = row.Value
+	}
+}
+
+// save stores events of the rows, events[i] belongs to rows[i]
+func save(db *gorm.DB, events []model.AuditEvent, rows []reflect.Value) {
+	if len(events) == 0 {
+		return
+	}
+
+	for i, id := range patientIDs(db, rows) {
+		events[i].PatientID = id
+	}
+
+	if err := newSession(db).Create(&events).Error; err != nil {
+		zap.S().Errorf("Failed to save %d audit events, err = %+v", len(events), err)
+		db.AddError(err)
+	}
+}
@@ -0,0 +1,161 @@
+package audit
+
+import (
+	"PatientManager/internal/testdb"
+	"PatientManager/model"
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// newAuditedDb returns a database of the test with audit callbacks, lookups
+// counts row queries of the callbacks by table
+func newAuditedDb(t *testing.T) (*gorm.DB, map[string]int) {
+	t.Helper()
+
+	db := testdb.New(t)
+	if err := RegisterCallbacks(db); err != nil {
+		t.Fatalf("failed to register callbacks: %v", err)
+	}
+
+	lookups := map[string]int{}
+	err := db.Callback().Row().After("gorm:row").Register("test:count", func(db *gorm.DB) {
+		lookups[db.Statement.Table]++
+	})
+	if err != nil {
+		t.Fatalf("failed to register callback: %v", err)
+	}
+	return db, lookups
+}
+
+func TestPatientIDs(t *testing.T) {
+	db, lookups := newAuditedDb(t)
+
+	// two patients with two illnesses of two prescriptions each, patientOf
+	// maps entities to their patients
+	patientOf := map[uuid.UUID]uint{}
+	var checkups []model.Checkup
+	for _, oib := range []string{"12345678903", "98765432106"} {
+		patient := model.Patient{
+			Uuid:      uuid.New(),
+			FirstName: "Ana",
+			LastName:  "Horvat",
+			OIB:       oib,
+			BirthDate: time.Date(1980, 1, 1, 0, 0, 0, 0, time.UTC),
+			Gender:    "F",
+		}
+		if err := db.Create(&patient).Error; err != nil {
+			t.Fatalf("failed to create patient: %v", err)
+		}
+		record := model.MedicalRecord{Uuid: uuid.New(), PatientID: patient.ID, DoctorID: 1}
+		if err := db.Create(&record).Error; err != nil {
+			t.Fatalf("failed to create medical record: %v", err)
+		}
+
+		for i := 0; i < 2; i++ {
+			illness := model.Illness{Uuid: uuid.New(), Name: "Flu", StartDate: time.Now(), MedicalRecordID: record.ID}
+			if err := db.Create(&illness).Error; err != nil {
+				t.Fatalf("failed to create illness: %v", err)
+			}
+			patientOf[illness.Uuid] = patient.ID
+
+			for j := 0; j < 2; j++ {
+				prescription := model.Prescription{Uuid: uuid.New(), IssuedAt: time.Now(), IllnessID: illness.ID}
+				if err := db.Create(&prescription).Error; err != nil {
+					t.Fatalf("failed to create prescription: %v", err)
+				}
+				patientOf[prescription.Uuid] = patient.ID
+			}
+
+			checkup := model.Checkup{
+				Uuid:            uuid.New(),
+				CheckupDate:     time.Now(),
+				Type:            model.GeneralPractitioner,
+				MedicalRecordID: record.ID,
+				IllnessID:       &illness.ID,
+			}
+			checkups = append(checkups, checkup)
+			patientOf[checkup.Uuid] = patient.ID
+		}
+	}
+
+	tests := []struct {
+		name    string
+		run     func(db *gorm.DB) error
+		entity  string
+		action  model.AuditAction
+		events  int
+		lookups map[string]int
+	}{
+		{
+			name: "read of prescriptions",
+			run: func(db *gorm.DB) error {
+				var prescriptions []model.Prescription
+				return db.Find(&prescriptions).Error
+			},
+			entity:  "prescription",
+			action:  model.AuditRead,
+			events:  8,
+			lookups: map[string]int{"illnesses": 1, "medical_records": 1},
+		},
+		{
+			name:    "create of checkups",
+			run:     func(db *gorm.DB) error { return db.Create(&checkups).Error },
+			entity:  "checkup",
+			action:  model.AuditCreate,
+			events:  4,
+			lookups: map[string]int{"medical_records": 1},
+		},
+		{
+			name: "update of illnesses",
+			run: func(db *gorm.DB) error {
+				return db.Model(&model.Illness{}).Where("name = ?", "Flu").Update("name", "Cold").Error
+			},
+			entity:  "illness",
+			action:  model.AuditUpdate,
+			events:  4,
+			lookups: map[string]int{"medical_records": 1},
+		},
+		{
+			name: "delete of prescriptions",
+			run: func(db *gorm.DB) error {
+				return db.Where("1 = 1").Delete(&model.Prescription{}).Error
+			},
+			entity:  "prescription",
+			action:  model.AuditDelete,
+			events:  8,
+			lookups: map[string]int{"illnesses": 1, "medical_records": 1},
+		},
+	}
+
+	ctx := WithActor(context.Background(), Actor{Uuid: uuid.New(), ClientIP: "10.0.0.1"})
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clear(lookups)
+			if err := tt.run(db.WithContext(ctx)); err != nil {
+				t.Fatal(err)
+			}
+			if fmt.Sprint(lookups) != fmt.Sprint(tt.lookups) {
+				t.Errorf("lookups = %v, want %v", lookups, tt.lookups)
+			}
+
+			var events []model.AuditEvent
+			if err := db.Where("entity_type = ? AND action = ?", tt.entity, tt.action).Find(&events).Error; err != nil {
+				t.Fatal(err)
+			}
+			if len(events) != tt.events {
+				t.Fatalf("%d events, want %d", len(events), tt.events)
+			}
+			for _, event := range events {
+				want := patientOf[*event.EntityUuid]
+				if event.PatientID == nil || *event.PatientID != want {
+					t.Errorf("%s event of %s has patient %v, want %d", event.Action, event.EntityUuid, event.PatientID, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,31 @@
+// Package audit records access to clinical data using gorm callbacks
+package audit
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+)
+
+type actorKey struct{}
+
+// Actor describes who made the request and from where
+type Actor struct {
+	Uuid     uuid.UUID
+	ClientIP string
+}
+
+// WithActor returns a context that makes gorm callbacks record actions
+// under the given actor
+func WithActor(ctx context.Context, actor Actor) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+// ActorFromContext returns actor stored by WithActor
+func ActorFromContext(ctx context.Context) (Actor, bool) {
+	if ctx == nil {
+		return Actor{}, false
+	}
+	actor, ok := ctx.Value(actorKey{}).(Actor)
+	return actor, ok
+}
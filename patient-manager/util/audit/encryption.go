@@ -0,0 +1,141 @@
+package audit
+
+import (
+	"PatientManager/model"
+	"PatientManager/util/encryption"
+	"encoding/json"
+	"strings"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
+
+// isEncrypted tells if the field is encrypted at rest, its values are
+// encrypted in diffs too
+func isEncrypted(field *schema.Field) bool {
+	return strings.EqualFold(field.TagSettings["SERIALIZER"], "encrypted")
+}
+
+func encryptChange(field *schema.Field, c change) change {
+	encrypt := func(value any) any {
+		plaintext, ok := value.(string)
+		if !ok {
+			return value
+		}
+		encrypted, err := encryption.EncryptString(plaintext, encryption.Column(field))
+		if err != nil {
+			zap.S().Errorf("Failed to encrypt audit diff of %s, err = %+v", field.DBName, err)
+			return Redacted
+		}
+		return encrypted
+	}
+	if c.Before != nil {
+		c.Before = encrypt(c.Before)
+	}
+	if c.After != nil {
+		c.After = encrypt(c.After)
+	}
+	return c
+}
+
+// DecryptDiff returns the diff of an event of entityType with encrypted
+// values decrypted, values that can't be decrypted are left as they are
+func DecryptDiff(entityType string, diff string) string {
+	if !strings.Contains(diff, `"enc:`) {
+		return diff
+	}
+	rez, _, err := mapDiff(entityType, diff, func(value, column string) (string, error) {
+		return encryption.DecryptString(value, column)
+	})
+	if err != nil {
+		zap.S().Errorf("Failed to decrypt audit diff of %s, err = %+v", entityType, err)
+		return diff
+	}
+	return rez
+}
+
+// Reencrypt encrypts values in diffs that aren't encrypted with the current
+// key with it, it bypasses model.AuditEvent hooks like Redact. Returns the
+// number of changed events.
+func Reencrypt(db *gorm.DB) (int, error) {
+	current := encryption.CurrentKeyID()
+	changed := 0
+	var events []model.AuditEvent
+	err := db.Session(&gorm.Session{NewDB: true}).
+		Where("diff LIKE ?", `%"enc:%`).
+		FindInBatches(&events, 100, func(tx *gorm.DB, batch int) error {
+			for _, event := range events {
+				diff, rotated, err := mapDiff(event.EntityType, event.Diff, func(value, column string) (string, error) {
+					if encryption.KeyIDOf(value) == current {
+						return value, nil
+					}
+					plaintext, err := encryption.DecryptString(value, column)
+					if err != nil {
+						return "", err
+					}
+					return encryption.EncryptString(plaintext, column)
+				})
+				if err != nil {
+					return err
+				}
+				if !rotated {
+					continue
+				}
+				err = db.Session(&gorm.Session{NewDB: true}).
+					Model(&model.AuditEvent{}).
+					Where("id = ?", event.ID).
+					UpdateColumn("diff", diff).Error
+				if err != nil {
+					return err
+				}
+				changed++
+			}
+			return nil
+		}).Error
+	return changed, err
+}
+
+// mapDiff replaces encrypted values of the diff with fn, which gets the
+// value and the column context it's encrypted with
+func mapDiff(entityType string, diff string, fn func(value, column string) (string, error)) (string, bool, error) {
+	var changes map[string]change
+	if err := json.Unmarshal([]byte(diff), &changes); err != nil {
+		return "", false, err
+	}
+
+	table := ""
+	for name, entity := range auditedTables {
+		if entity == entityType {
+			table = name
+		}
+	}
+
+	mapped := false
+	convert := func(value any, column string) (any, error) {
+		encrypted, ok := value.(string)
+		if !ok || !encryption.IsEncrypted(encrypted) {
+			return value, nil
+		}
+		rez, err := fn(encrypted, table+"."+column)
+		mapped = mapped || rez != encrypted
+		return rez, err
+	}
+
+	for column, c := range changes {
+		var err error
+		if c.Before, err = convert(c.Before, column); err != nil {
+			return "", false, err
+		}
+		if c.After, err = convert(c.After, column); err != nil {
+			return "", false, err
+		}
+		changes[column] = c
+	}
+	if !mapped {
+		return diff, false, nil
+	}
+
+	data, err := json.Marshal(changes)
+	return string(data), true, err
+}
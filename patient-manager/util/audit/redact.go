@@ -0,0 +1,66 @@
+package audit
+
+import (
+	"PatientManager/model"
+	"encoding/json"
+
+	"gorm.io/gorm"
+)
+
+// Redacted replaces erased values in audit diffs
+const Redacted = "[erased]"
+
+// Redact replaces values of columns in diffs of the patient's events of
+// entityType, e.g. personal data of an erased patient. It's the only change
+// ever made to audit events, so it bypasses model.AuditEvent hooks.
+// Returns the number of changed events.
+func Redact(db *gorm.DB, entityType string, patientID uint, columns []string) (int, error) {
+	var events []model.AuditEvent
+	err := db.Session(&gorm.Session{NewDB: true}).
+		Where("entity_type = ? AND patient_id = ? AND diff IS NOT NULL AND diff <> ''", entityType, patientID).
+		Find(&events).Error
+	if err != nil {
+		return 0, err
+	}
+
+	redacted := 0
+	for _, event := range events {
+		var changes map[string]change
+		if err := json.Unmarshal([]byte(event.Diff), &changes); err != nil {
+			return redacted, err
+		}
+
+		changed := false
+		for _, column := range columns {
+			c, ok := changes[column]
+			if !ok {
+				continue
+			}
+			if c.Before != nil {
+				c.Before = Redacted
+			}
+			if c.After != nil {
+				c.After = Redacted
+			}
+			changes[column] = c
+			changed = true
+		}
+		if !changed {
+			continue
+		}
+
+		data, err := json.Marshal(changes)
+		if err != nil {
+			return redacted, err
+		}
+		err = db.Session(&gorm.Session{NewDB: true}).
+			Model(&model.AuditEvent{}).
+			Where("id = ?", event.ID).
+			UpdateColumn("diff", string(data)).Error
+		if err != nil {
+			return redacted, err
+		}
+		redacted++
+	}
+	return redacted, nil
+}
@@ -0,0 +1,331 @@
+package auth
+
+import (
+	"PatientManager/config"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"go.uber.org/zap"
+)
+
+const rsaKeyBits = 2048
+
+// reloadInterval limits how often tokens of unknown keys reload the key
+// directory, see accessTokenKey
+const reloadInterval = 5 * time.Second
+
+type signingKey struct {
+	kid     string
+	path    string
+	private crypto.Signer
+	method  jwt.SigningMethod
+	created time.Time
+}
+
+// keyRing holds keys that sign and verify access tokens, sorted from the
+// oldest to the newest. Only the newest key signs.
+type keyRing struct {
+	mu   sync.RWMutex
+	keys []*signingKey
+	// reloadedAt is when unknown keys last reloaded the key directory
+	reloadedAt time.Time
+}
+
+var keys = &keyRing{}
+
+// JWK is a public key in JSON Web Key format (RFC 7517)
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// LoadKeys loads signing keys from config.SigningKeys.Dir and generates the
+// first key when there is none, it should be called after config.LoadConfig
+func LoadKeys() error {
+	if err := os.MkdirAll(config.AppConfig.SigningKeys.Dir, 0o700); err != nil {
+		return err
+	}
+	return RotateKeys()
+}
+
+// RotateKeys reloads the key directory (keys can be added by other
+// instances), generates a new signing key when the newest one is older than
+// the rotation interval and removes keys replaced more than the grace period
+// ago. It's called periodically by the server.
+func RotateKeys() error {
+	conf := config.AppConfig.SigningKeys
+
+	loaded, err := readKeys(conf.Dir)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	if len(loaded) == 0 || (conf.RotationInterval > 0 && now.Sub(loaded[len(loaded)-1].created) >= conf.RotationInterval) {
+		key, err := generateKey(conf.Dir, conf.Algorithm)
+		if err != nil {
+			return err
+		}
+		zap.S().Infof("Generated %s signing key %s", conf.Algorithm, key.kid)
+		loaded = append(loaded, key)
+	}
+
+	active, retired := retire(loaded, now, conf.GracePeriod)
+	for _, key := range retired {
+		if err := os.Remove(key.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			zap.S().Errorf("Failed to remove retired signing key %s, err = %+v", key.kid, err)
+		} else {
+			zap.S().Infof("Retired signing key %s", key.kid)
+		}
+	}
+
+	keys.mu.Lock()
+	keys.keys = active
+	keys.mu.Unlock()
+	return nil
+}
+
+// reloadKeys reads the key directory without generating or removing keys,
+// at most once per reloadInterval. Keys past the grace period are left out
+// even if their files weren't removed yet.
+func reloadKeys() error {
+	keys.mu.Lock()
+	defer keys.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(keys.reloadedAt) < reloadInterval {
+		return nil
+	}
+	keys.reloadedAt = now
+
+	loaded, err := readKeys(config.AppConfig.SigningKeys.Dir)
+	if err != nil {
+		return err
+	}
+	if len(loaded) > 0 {
+		keys.keys, _ = retire(loaded, now, config.AppConfig.SigningKeys.GracePeriod)
+	}
+	return nil
+}
+
+// retire splits keys sorted from the oldest to the newest into active and
+// retired ones, a key is retired once the grace period after its successor
+// was created has passed
+func retire(loaded []*signingKey, now time.Time, gracePeriod time.Duration) (active, retired []*signingKey) {
+	for i, key := range loaded {
+		if i < len(loaded)-1 && now.Sub(loaded[i+1].created) > gracePeriod {
+			retired = append(retired, key)
+		} else {
+			active = append(active, key)
+		}
+	}
+	return active, retired
+}
+
+// PublicKeys returns public keys of all active keys
+func PublicKeys() JWKSet {
+	keys.mu.RLock()
+	defer keys.mu.RUnlock()
+
+	set := JWKSet{Keys: make([]JWK, 0, len(keys.keys))}
+	for _, key := range keys.keys {
+		jwk := JWK{Kid: key.kid, Use: "sig", Alg: key.method.Alg()}
+		switch public := key.private.Public().(type) {
+		case *rsa.PublicKey:
+			jwk.Kty = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+
+		case ed25519.PublicKey:
+			jwk.Kty = "OKP"
+			jwk.Crv = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(public)
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+	return set
+}
+
+func currentKey() (*signingKey, error) {
+	keys.mu.RLock()
+	defer keys.mu.RUnlock()
+
+	if len(keys.keys) == 0 {
+		return nil, errors.New("no signing key loaded")
+	}
+	return keys.keys[len(keys.keys)-1], nil
+}
+
+func findKey(kid string) *signingKey {
+	keys.mu.RLock()
+	defer keys.mu.RUnlock()
+
+	for _, key := range keys.keys {
+		if key.kid == kid {
+			return key
+		}
+	}
+	return nil
+}
+
+// signAccessToken signs claims with the newest key and sets its kid header
+func signAccessToken(claims jwt.Claims) (string, error) {
+	key, err := currentKey()
+	if err != nil {
+		return "", err
+	}
+
+	token := jwt.NewWithClaims(key.method, claims)
+	token.Header["kid"] = key.kid
+	return token.SignedString(key.private)
+}
+
+// accessTokenKey is the jwt.Keyfunc of access tokens, it picks the key by
+// kid. Unknown keys may have been generated by another instance since the
+// last RotateKeys, the key directory is read again before they're rejected.
+func accessTokenKey(token *jwt.Token) (any, error) {
+	kid, _ := token.Header["kid"].(string)
+	key := findKey(kid)
+	if key == nil {
+		if err := reloadKeys(); err != nil {
+			zap.S().Errorf("Failed to reload signing keys, err = %+v", err)
+		}
+		key = findKey(kid)
+	}
+	if key == nil {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	if token.Method.Alg() != key.method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method %s for key %s", token.Method.Alg(), kid)
+	}
+	return key.private.Public(), nil
+}
+
+// readKeys loads all <kid>.pem files of the directory, a key is as old as its file
+func readKeys(dir string) ([]*signingKey, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+	if err != nil {
+		return nil, err
+	}
+
+	loaded := make([]*signingKey, 0, len(paths))
+	for _, path := range paths {
+		key, err := readKey(path)
+		if err != nil {
+			zap.S().Errorf("Skipping signing key %s, err = %+v", path, err)
+			continue
+		}
+		loaded = append(loaded, key)
+	}
+
+	sort.Slice(loaded, func(i, j int) bool {
+		if loaded[i].created.Equal(loaded[j].created) {
+			return loaded[i].kid < loaded[j].kid
+		}
+		return loaded[i].created.Before(loaded[j].created)
+	})
+	return loaded, nil
+}
+
+func readKey(path string) (*signingKey, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+
+	var private any
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		private, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	default:
+		private, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	key := &signingKey{
+		kid:     strings.TrimSuffix(filepath.Base(path), ".pem"),
+		path:    path,
+		created: info.ModTime(),
+	}
+	switch private := private.(type) {
+	case *rsa.PrivateKey:
+		key.private = private
+		key.method = jwt.SigningMethodRS256
+	case ed25519.PrivateKey:
+		key.private = private
+		key.method = jwt.SigningMethodEdDSA
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", private)
+	}
+	return key, nil
+}
+
+func generateKey(dir, algorithm string) (*signingKey, error) {
+	var private crypto.Signer
+	var err error
+	switch algorithm {
+	case config.AlgorithmEdDSA:
+		_, private, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		private, err = rsa.GenerateKey(rand.Reader, rsaKeyBits)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		return nil, err
+	}
+
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return nil, err
+	}
+	kid := time.Now().UTC().Format("20060102T150405") + "-" + hex.EncodeToString(suffix)
+
+	path := filepath.Join(dir, kid+".pem")
+	data := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		return nil, err
+	}
+
+	return readKey(path)
+}
@@ -0,0 +1,133 @@
+package auth
+
+import (
+	"PatientManager/config"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// loadTestKeys loads a key generated in a temporary directory
+func loadTestKeys(t *testing.T) {
+	t.Helper()
+
+	config.AppConfig = &config.AppConfiguration{
+		SigningKeys: config.SigningKeys{Dir: t.TempDir(), Algorithm: config.AlgorithmEdDSA, GracePeriod: time.Hour},
+	}
+	keys = &keyRing{}
+	if err := LoadKeys(); err != nil {
+		t.Fatalf("failed to load keys: %v", err)
+	}
+}
+
+// signWith signs an access token with the key whether it's loaded or not
+func signWith(t *testing.T, key *signingKey) string {
+	t.Helper()
+
+	token := jwt.NewWithClaims(key.method, &Claims{
+		Email: "doctor@test.hr",
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(accessTokenDuration)),
+		},
+	})
+	token.Header["kid"] = key.kid
+	signed, err := token.SignedString(key.private)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+// rotate adds a key to the key directory, created the given time ago, and
+// rotates keys
+func rotate(t *testing.T, age time.Duration) *signingKey {
+	t.Helper()
+
+	key, err := generateKey(config.AppConfig.SigningKeys.Dir, config.AppConfig.SigningKeys.Algorithm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	created := time.Now().Add(-age)
+	if err := os.Chtimes(key.path, created, created); err != nil {
+		t.Fatal(err)
+	}
+	if err := RotateKeys(); err != nil {
+		t.Fatalf("RotateKeys() error = %v", err)
+	}
+	return key
+}
+
+func TestParseToken(t *testing.T) {
+	tests := []struct {
+		name    string
+		token   func(t *testing.T) string
+		wantErr bool
+	}{
+		{
+			"current key",
+			func(t *testing.T) string {
+				key, _ := currentKey()
+				return signWith(t, key)
+			},
+			false,
+		},
+		{
+			"rotated out key inside the grace period",
+			func(t *testing.T) string {
+				old, _ := currentKey()
+				rotate(t, 0)
+				return signWith(t, old)
+			},
+			false,
+		},
+		{
+			"rotated out key after the grace period",
+			func(t *testing.T) string {
+				old, _ := currentKey()
+				created := time.Now().Add(-3 * time.Hour)
+				os.Chtimes(old.path, created, created)
+				rotate(t, 2*time.Hour)
+				return signWith(t, old)
+			},
+			true,
+		},
+		{
+			// the key directory is read again instead of waiting for RotateKeys
+			"key generated by another instance",
+			func(t *testing.T) string {
+				key, err := generateKey(config.AppConfig.SigningKeys.Dir, config.AppConfig.SigningKeys.Algorithm)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return signWith(t, key)
+			},
+			false,
+		},
+		{
+			"key of another installation",
+			func(t *testing.T) string {
+				key, err := generateKey(t.TempDir(), config.AppConfig.SigningKeys.Algorithm)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return signWith(t, key)
+			},
+			true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			loadTestKeys(t)
+			_, claims, err := ParseToken("Bearer " + tt.token(t))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseToken() error = %v, want error %v", err, tt.wantErr)
+			}
+			if err == nil && claims.Email != "doctor@test.hr" {
+				t.Errorf("ParseToken() email = %s, want doctor@test.hr", claims.Email)
+			}
+		})
+	}
+}
@@ -0,0 +1,95 @@
+package auth
+
+import (
+	"PatientManager/config"
+	"PatientManager/util/cerror"
+	"crypto/rand"
+	"fmt"
+	"math/big"
+	"strings"
+	"unicode"
+)
+
+const (
+	generatedPasswordLength = 16
+	upperChars              = "ABCDEFGHJKLMNPQRSTUVWXYZ"
+	lowerChars              = "abcdefghijkmnopqrstuvwxyz"
+	digitChars              = "23456789"
+	symbolChars             = "!@#$%^&*-_=+?"
+)
+
+// ValidatePassword checks the password against config.AppConfiguration.PasswordPolicy,
+// returned error wraps cerror.ErrWeakPassword and describes what is missing
+func ValidatePassword(password string) error {
+	policy := config.AppConfig.PasswordPolicy
+
+	var missing []string
+	if len([]rune(password)) < policy.MinLength {
+		missing = append(missing, fmt.Sprintf("at least %d characters", policy.MinLength))
+	}
+	if policy.RequireUpper && !strings.ContainsFunc(password, unicode.IsUpper) {
+		missing = append(missing, "an uppercase letter")
+	}
+	if policy.RequireLower && !strings.ContainsFunc(password, unicode.IsLower) {
+		missing = append(missing, "a lowercase letter")
+	}
+	if policy.RequireDigit && !strings.ContainsFunc(password, unicode.IsDigit) {
+		missing = append(missing, "a digit")
+	}
+	if policy.RequireSymbol && !strings.ContainsFunc(password, isSymbol) {
+		missing = append(missing, "a symbol")
+	}
+
+	if len(missing) > 0 {
+		return fmt.Errorf("%w, it must contain %s", cerror.ErrWeakPassword, strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// GeneratePassword returns a random password that satisfies the password policy
+func GeneratePassword() (string, error) {
+	length := max(generatedPasswordLength, config.AppConfig.PasswordPolicy.MinLength)
+
+	// one character of every class guarantees the policy is met
+	classes := []string{upperChars, lowerChars, digitChars, symbolChars}
+	all := strings.Join(classes, "")
+
+	password := make([]byte, 0, length)
+	for _, class := range classes {
+		c, err := randomChar(class)
+		if err != nil {
+			return "", err
+		}
+		password = append(password, c)
+	}
+	for len(password) < length {
+		c, err := randomChar(all)
+		if err != nil {
+			return "", err
+		}
+		password = append(password, c)
+	}
+
+	// shuffle so required characters aren't always in front
+	for i := len(password) - 1; i > 0; i-- {
+		j, err := rand.Int(rand.Reader, big.NewInt(int64(i+1)))
+		if err != nil {
+			return "", err
+		}
+		password[i], password[j.Int64()] = password[j.Int64()], password[i]
+	}
+
+	return string(password), nil
+}
+
+func randomChar(chars string) (byte, error) {
+	i, err := rand.Int(rand.Reader, big.NewInt(int64(len(chars))))
+	if err != nil {
+		return 0, err
+	}
+	return chars[i.Int64()], nil
+}
+
+func isSymbol(r rune) bool {
+	return unicode.IsPunct(r) || unicode.IsSymbol(r)
+}
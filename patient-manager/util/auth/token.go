@@ -4,9 +4,13 @@ import (
 	"PatientManager/config"
 	"PatientManager/model"
 	"PatientManager/util/cerror"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
 	"time"
 
 	"github.com/golang-jwt/jwt/v4"
+	"github.com/google/uuid"
 	"go.uber.org/zap"
 )
 
@@ -18,11 +22,21 @@ type Claims struct {
 }
 
 const (
-	accessTokenDuration  = 5 * time.Minute
-	refreshTokenDuration = 7 * 24 * time.Hour
-	deviceTokenDuration  = 30 * 24 * time.Hour
+	accessTokenDuration    = 5 * time.Minute
+	refreshTokenDuration   = 7 * 24 * time.Hour
+	deviceTokenDuration    = 30 * 24 * time.Hour
+	challengeTokenDuration = 5 * time.Minute
 )
 
+// Audiences of challenge tokens issued after password check when two-factor
+// authentication is needed
+const (
+	ChallengeVerify = "2fa"
+	ChallengeEnroll = "2fa-enroll"
+)
+
+// ParseToken validates the access token from the Authorization header, the
+// verification key is picked by the kid header (see LoadKeys)
 func ParseToken(authHeader string) (*jwt.Token, *Claims, error) {
 	// Parse token
 	if len(authHeader) <= len("Bearer ") || authHeader[:len("Bearer ")] != "Bearer " {
@@ -31,9 +45,8 @@ func ParseToken(authHeader string) (*jwt.Token, *Claims, error) {
 	}
 	tokenString := authHeader[len("Bearer "):]
 	var claims Claims
-	token, err := jwt.ParseWithClaims(tokenString, &claims, func(token *jwt.Token) (any, error) {
-		return []byte(config.AppConfig.AccessKey), nil
-	})
+	token, err := jwt.ParseWithClaims(tokenString, &claims, accessTokenKey,
+		jwt.WithValidMethods([]string{jwt.SigningMethodRS256.Alg(), jwt.SigningMethodEdDSA.Alg()}))
 	if err != nil {
 		return nil, nil, err
 	}
@@ -54,18 +67,20 @@ func GenerateTokens(user *model.User) (string, string, error) {
 			ExpiresAt: jwt.NewNumericDate(time.Now().Add(accessTokenDuration)),
 		},
 	}
-	accessToken := jwt.NewWithClaims(jwt.SigningMethodHS256, accessTokenClaims)
-	accessTokenString, err := accessToken.SignedString([]byte(config.AppConfig.AccessKey))
+	accessTokenString, err := signAccessToken(accessTokenClaims)
 	if err != nil {
 		zap.S().Debugf("Failed to generate access token err = %+v", err)
 		return "", "", err
 	}
 
+	// refresh tokens are only verified by this service so they keep the shared
+	// secret, every refresh token gets a unique ID so that its hash is unique
 	refreshTokenClaims := &Claims{
 		Email: user.Email,
 		Uuid:  user.Uuid.String(),
 		Role:  user.Role,
 		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        uuid.NewString(),
 			ExpiresAt: jwt.NewNumericDate(time.Now().Add(refreshTokenDuration)),
 		},
 	}
@@ -78,3 +93,93 @@ func GenerateTokens(user *model.User) (string, string, error) {
 
 	return accessTokenString, refreshTokenString, nil
 }
+
+// ParseRefreshToken validates the refresh token and returns its claims
+func ParseRefreshToken(tokenString string) (*Claims, error) {
+	var claims Claims
+	token, err := jwt.ParseWithClaims(tokenString, &claims, func(token *jwt.Token) (any, error) {
+		return []byte(config.AppConfig.RefreshKey), nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}))
+	if err != nil {
+		zap.S().Debugf("Failed to parse refresh token err = %+v", err)
+		return nil, cerror.ErrInvalidToken
+	}
+
+	if !token.Valid {
+		return nil, cerror.ErrInvalidToken
+	}
+
+	return &claims, nil
+}
+
+// RefreshTokenExpiry returns when a refresh token issued now expires
+func RefreshTokenExpiry() time.Time {
+	return time.Now().Add(refreshTokenDuration)
+}
+
+// HashToken returns the hex encoded SHA-256 hash of the token, tokens are
+// stored only as hashes
+func HashToken(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
+
+// RandomToken returns a random hex encoded token used for single use links
+func RandomToken() (string, error) {
+	data := make([]byte, 32)
+	if _, err := rand.Read(data); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(data), nil
+}
+
+// GenerateChallengeToken returns a short-lived token that proves the password
+// was checked, audience is ChallengeVerify or ChallengeEnroll. It's signed
+// with a separate key so it can't be used as an access or refresh token.
+func GenerateChallengeToken(user *model.User, audience string) (string, error) {
+	if user == nil {
+		return "", cerror.ErrUserIsNil
+	}
+
+	claims := &Claims{
+		Email: user.Email,
+		Uuid:  user.Uuid.String(),
+		Role:  user.Role,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        uuid.NewString(),
+			Audience:  jwt.ClaimStrings{audience},
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(challengeTokenDuration)),
+		},
+	}
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(challengeKey())
+	if err != nil {
+		zap.S().Debugf("Failed to generate challenge token err = %+v", err)
+		return "", err
+	}
+	return token, nil
+}
+
+// ParseChallengeToken validates a token from GenerateChallengeToken and
+// returns its claims and audience
+func ParseChallengeToken(tokenString string) (*Claims, string, error) {
+	var claims Claims
+	token, err := jwt.ParseWithClaims(tokenString, &claims, func(token *jwt.Token) (any, error) {
+		return challengeKey(), nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}))
+	if err != nil || !token.Valid {
+		zap.S().Debugf("Failed to parse challenge token err = %+v", err)
+		return nil, "", cerror.ErrInvalidToken
+	}
+
+	for _, audience := range []string{ChallengeVerify, ChallengeEnroll} {
+		if claims.VerifyAudience(audience, true) {
+			return &claims, audience, nil
+		}
+	}
+	return nil, "", cerror.ErrInvalidToken
+}
+
+func challengeKey() []byte {
+	key := sha256.Sum256([]byte("challenge:" + config.AppConfig.RefreshKey))
+	return key[:]
+}
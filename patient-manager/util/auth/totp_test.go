@@ -0,0 +1,69 @@
+package auth
+
+import (
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA-1 key of the test vectors of RFC 6238
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestTotpCode(t *testing.T) {
+	key, _ := totpEncoding.DecodeString(rfcSecret)
+
+	// the last 6 of the 8 digits of the RFC 6238 test vectors
+	tests := []struct {
+		time int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+	}
+
+	for _, tt := range tests {
+		if got := totpCode(key, tt.time/totpPeriod); got != tt.want {
+			t.Errorf("totpCode() at %d = %s, want %s", tt.time, got, tt.want)
+		}
+	}
+}
+
+func TestValidateTotp(t *testing.T) {
+	key, _ := totpEncoding.DecodeString(rfcSecret)
+	now := time.Unix(1111111109, 0)
+	current := now.Unix() / totpPeriod
+	code := func(counter int64) string {
+		return totpCode(key, counter)
+	}
+
+	tests := []struct {
+		name        string
+		secret      string
+		code        string
+		lastCounter int64
+		want        int64
+		wantOk      bool
+	}{
+		{"current time step", rfcSecret, code(current), 0, current, true},
+		{"previous time step", rfcSecret, code(current - 1), 0, current - 1, true},
+		{"next time step", rfcSecret, code(current + 1), 0, current + 1, true},
+		{"two time steps ago", rfcSecret, code(current - 2), 0, 0, false},
+		{"two time steps ahead", rfcSecret, code(current + 2), 0, 0, false},
+		{"replay", rfcSecret, code(current), current, 0, false},
+		{"code older than the last used one", rfcSecret, code(current - 1), current, 0, false},
+		{"code newer than the last used one", rfcSecret, code(current + 1), current, current + 1, true},
+		{"spaces in the code", rfcSecret, code(current)[:3] + " " + code(current)[3:], 0, current, true},
+		{"lower case secret", "gezdgnbvgy3tqojqgezdgnbvgy3tqojq", code(current), 0, current, true},
+		{"wrong code", rfcSecret, "000000", 0, 0, false},
+		{"short code", rfcSecret, code(current)[:5], 0, 0, false},
+		{"invalid secret", "not base32!", code(current), 0, 0, false},
+	}
+
+	for _, tt := range tests {
+		got, ok := ValidateTotp(tt.secret, tt.code, tt.lastCounter, now)
+		if got != tt.want || ok != tt.wantOk {
+			t.Errorf("%s: ValidateTotp() = %d, %v, want %d, %v", tt.name, got, ok, tt.want, tt.wantOk)
+		}
+	}
+}
@@ -13,7 +13,41 @@ var (
 	ErrBadUuid            = errors.New("failed to parse uuid")
 	ErrUnknownRole        = errors.New("unknown role")
 	ErrInvalidCredentials = errors.New("invalid email or password")
+	ErrMissingToken       = errors.New("missing token")
 	ErrInvalidTokenFormat = errors.New("invalid token format")
 	ErrUserIsNil          = errors.New("user is nil")
 	ErrBadRole            = errors.New("role is not allowed")
+	ErrMissingClaims      = errors.New("missing token claims")
+	ErrForbidden          = errors.New("access to resource is forbidden")
+	ErrPatientNotLinked   = errors.New("user is not linked to a patient")
+	ErrPatientLinked      = errors.New("patient is already linked to another user")
+	ErrAuditImmutable     = errors.New("audit events can't be changed")
+	ErrInvalidToken       = errors.New("invalid or expired token")
+	ErrTokenReused        = errors.New("refresh token was already used, session revoked")
+	ErrWeakPassword       = errors.New("password doesn't meet the password policy")
+	ErrSamePassword       = errors.New("new password must be different from the current one")
+	ErrMustChangePassword = errors.New("password change is required before login")
+	ErrTooManyAttempts    = errors.New("too many failed login attempts, try again later")
+	ErrInvalidOtp         = errors.New("invalid two-factor authentication code")
+	ErrTotpEnabled        = errors.New("two-factor authentication is already enabled")
+	ErrTotpNotEnrolled    = errors.New("two-factor authentication enrollment was not started")
+	ErrBadSort            = errors.New("unknown sort field")
+	ErrBadCursor          = errors.New("invalid page cursor")
+	ErrDuplicateOIB       = errors.New("OIB is already registered")
+	ErrDuplicateEmail     = errors.New("email is already registered")
+	ErrParentDeleted      = errors.New("parent entity is deleted, restore it first")
+	ErrLegalHold          = errors.New("patient is under legal hold")
+	ErrPatientErased      = errors.New("personal data of the patient was erased")
+	ErrErasurePending     = errors.New("erasure of the patient is already requested")
+	ErrErasureDecided     = errors.New("erasure request was already decided")
+	ErrSameApprover       = errors.New("erasure must be approved by another superadmin")
+	ErrErasureImmutable   = errors.New("erasure certificates can't be changed")
+
+	ErrInvalidDicom         = errors.New("invalid DICOM file")
+	ErrDicomCheckupType     = errors.New("DICOM files can only be added to CT, MR, X-ray and ultrasound checkups")
+	ErrImageType            = errors.New("file type is not allowed")
+	ErrImageTooLarge        = errors.New("file is too large")
+	ErrFileNotFound         = errors.New("file not found in storage")
+	ErrDicomPatientMismatch = errors.New("patient ID of the DICOM file doesn't match the checkup's patient")
+	ErrUploadMissing        = errors.New("object of the upload was not uploaded")
 )
@@ -0,0 +1,416 @@
+// Package dicom reads DICOM Part 10 files: identifiers and acquisition time
+// of the instance and pixel data of the first frame for previews. Little
+// endian transfer syntaxes are supported, compressed pixel data only as
+// baseline JPEG.
+package dicom
+
+import (
+	"PatientManager/util/cerror"
+	"bufio"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Transfer syntax UIDs
+const (
+	ImplicitVRLittleEndian         = "1.2.840.10008.1.2"
+	ExplicitVRLittleEndian         = "1.2.840.10008.1.2.1"
+	DeflatedExplicitVRLittleEndian = "1.2.840.10008.1.2.1.99"
+	ExplicitVRBigEndian            = "1.2.840.10008.1.2.2"
+	JPEGBaseline                   = "1.2.840.10008.1.2.4.50"
+)
+
+const (
+	preambleLength = 128
+	prefix         = "DICM"
+	// undefinedLength marks sequences, items and encapsulated pixel data
+	// that end with a delimitation item
+	undefinedLength = 0xffffffff
+	// maxDepth limits nesting of sequences with undefined length
+	maxDepth = 32
+)
+
+// tag is a data element tag, group in the upper and element in the lower 16 bits
+type tag uint32
+
+const (
+	tagTransferSyntax       tag = 0x00020010
+	tagSOPInstanceUID       tag = 0x00080018
+	tagStudyDate            tag = 0x00080020
+	tagAcquisitionDate      tag = 0x00080022
+	tagContentDate          tag = 0x00080023
+	tagStudyTime            tag = 0x00080030
+	tagAcquisitionTime      tag = 0x00080032
+	tagContentTime          tag = 0x00080033
+	tagModality             tag = 0x00080060
+	tagPatientID            tag = 0x00100020
+	tagStudyInstanceUID     tag = 0x0020000d
+	tagSeriesInstanceUID    tag = 0x0020000e
+	tagSamplesPerPixel      tag = 0x00280002
+	tagPhotometric          tag = 0x00280004
+	tagPlanarConfiguration  tag = 0x00280006
+	tagRows                 tag = 0x00280010
+	tagColumns              tag = 0x00280011
+	tagBitsAllocated        tag = 0x00280100
+	tagBitsStored           tag = 0x00280101
+	tagPixelRepresentation  tag = 0x00280103
+	tagWindowCenter         tag = 0x00281050
+	tagWindowWidth          tag = 0x00281051
+	tagRescaleIntercept     tag = 0x00281052
+	tagRescaleSlope         tag = 0x00281053
+	tagPixelData            tag = 0x7fe00010
+	tagItem                 tag = 0xfffee000
+	tagItemDelimitation     tag = 0xfffee00d
+	tagSequenceDelimitation tag = 0xfffee0dd
+)
+
+// kept are the top level elements whose values are read, others are skipped
+var kept = map[tag]bool{
+	tagSOPInstanceUID: true, tagStudyDate: true, tagAcquisitionDate: true,
+	tagContentDate: true, tagStudyTime: true, tagAcquisitionTime: true,
+	tagContentTime: true, tagModality: true, tagPatientID: true,
+	tagStudyInstanceUID: true, tagSeriesInstanceUID: true,
+	tagSamplesPerPixel: true, tagPhotometric: true, tagPlanarConfiguration: true,
+	tagRows: true, tagColumns: true, tagBitsAllocated: true, tagBitsStored: true,
+	tagPixelRepresentation: true, tagWindowCenter: true, tagWindowWidth: true,
+	tagRescaleIntercept: true, tagRescaleSlope: true, tagPixelData: true,
+}
+
+// longVRs have a 4 byte length preceded by 2 reserved bytes in explicit VR encoding
+var longVRs = map[string]bool{
+	"OB": true, "OD": true, "OF": true, "OL": true, "OV": true, "OW": true,
+	"SQ": true, "SV": true, "UC": true, "UN": true, "UR": true, "UT": true, "UV": true,
+}
+
+// File is a parsed DICOM file
+type File struct {
+	TransferSyntax    string
+	PatientID         string
+	StudyInstanceUID  string
+	SeriesInstanceUID string
+	SOPInstanceUID    string
+	Modality          string
+	// AcquiredAt is the acquisition, content or study date and time, the
+	// first one that is set
+	AcquiredAt *time.Time
+
+	elements map[tag][]byte
+	// fragments of encapsulated (compressed) pixel data without the basic
+	// offset table
+	fragments [][]byte
+}
+
+// Is reports whether header, the first 132 bytes of a file, has the DICM
+// prefix after the preamble
+func Is(header []byte) bool {
+	return len(header) >= preambleLength+len(prefix) &&
+		string(header[preambleLength:preambleLength+len(prefix)]) == prefix
+}
+
+// Parse reads a DICOM file of at most limit bytes, a deflated dataset is
+// inflated up to limit bytes as well. Errors wrap cerror.ErrInvalidDicom
+// unless reading fails.
+func Parse(r io.Reader, limit int64) (*File, error) {
+	input := &io.LimitedReader{R: r, N: limit}
+	reader := bufio.NewReader(input)
+	header := make([]byte, preambleLength+len(prefix))
+	if _, err := io.ReadFull(reader, header); err != nil || !Is(header) {
+		return nil, fmt.Errorf("%w: missing DICM prefix", cerror.ErrInvalidDicom)
+	}
+
+	f := &File{elements: map[tag][]byte{}}
+
+	// the file meta information group is always explicit VR little endian
+	meta := &parser{r: reader, input: input, explicit: true}
+	for {
+		group, err := reader.Peek(2)
+		if err != nil || binary.LittleEndian.Uint16(group) != 0x0002 {
+			break
+		}
+		t, _, length, err := meta.header()
+		if err != nil {
+			return nil, invalid(err)
+		}
+		value, err := meta.value(length)
+		if err != nil {
+			return nil, invalid(err)
+		}
+		if t == tagTransferSyntax {
+			f.TransferSyntax = text(value)
+		}
+	}
+
+	dataset := &parser{r: reader, input: input, explicit: true}
+	switch f.TransferSyntax {
+	case ImplicitVRLittleEndian:
+		dataset.explicit = false
+	case ExplicitVRLittleEndian, JPEGBaseline:
+	case DeflatedExplicitVRLittleEndian:
+		dataset.input = &io.LimitedReader{R: flate.NewReader(reader), N: limit}
+		dataset.r = bufio.NewReader(dataset.input)
+	case "":
+		return nil, fmt.Errorf("%w: transfer syntax is missing", cerror.ErrInvalidDicom)
+	case ExplicitVRBigEndian:
+		return nil, fmt.Errorf("%w: big endian transfer syntax is not supported", cerror.ErrInvalidDicom)
+	default:
+		// other transfer syntaxes compress the pixel data and encode the
+		// rest as explicit VR little endian
+	}
+
+	if err := dataset.read(f); err != nil {
+		return nil, invalid(err)
+	}
+
+	f.PatientID = f.first(tagPatientID)
+	f.StudyInstanceUID = f.first(tagStudyInstanceUID)
+	f.SeriesInstanceUID = f.first(tagSeriesInstanceUID)
+	f.SOPInstanceUID = f.first(tagSOPInstanceUID)
+	f.Modality = f.first(tagModality)
+	f.AcquiredAt = f.dateTime(tagAcquisitionDate, tagAcquisitionTime)
+	if f.AcquiredAt == nil {
+		f.AcquiredAt = f.dateTime(tagContentDate, tagContentTime)
+	}
+	if f.AcquiredAt == nil {
+		f.AcquiredAt = f.dateTime(tagStudyDate, tagStudyTime)
+	}
+	if f.SOPInstanceUID == "" {
+		return nil, fmt.Errorf("%w: SOP instance UID is missing", cerror.ErrInvalidDicom)
+	}
+	return f, nil
+}
+
+func invalid(err error) error {
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return fmt.Errorf("%w: file is truncated", cerror.ErrInvalidDicom)
+	}
+	return fmt.Errorf("%w: %v", cerror.ErrInvalidDicom, err)
+}
+
+// first returns the first value of a text element without padding
+func (f *File) first(t tag) string {
+	value, _, _ := strings.Cut(text(f.elements[t]), `\`)
+	return strings.TrimSpace(value)
+}
+
+// uint16 returns the value of a US element, or def if it's missing
+func (f *File) uint16(t tag, def int) int {
+	value := f.elements[t]
+	if len(value) < 2 {
+		return def
+	}
+	return int(binary.LittleEndian.Uint16(value))
+}
+
+// float returns the first value of a DS element, ok is false if it's missing
+func (f *File) float(t tag) (float64, bool) {
+	value, err := strconv.ParseFloat(f.first(t), 64)
+	return value, err == nil
+}
+
+// dateTime combines DA and TM elements, time is optional
+func (f *File) dateTime(dateTag, timeTag tag) *time.Time {
+	date := f.first(dateTag)
+	if len(date) != 8 {
+		return nil
+	}
+
+	// TM is HHMMSS.FFFFFF with optional parts, older files use HH:MM:SS
+	clock, _, _ := strings.Cut(strings.ReplaceAll(f.first(timeTag), ":", ""), ".")
+	if len(clock) > 6 || len(clock)%2 != 0 {
+		clock = ""
+	}
+	clock += strings.Repeat("0", 6-len(clock))
+
+	t, err := time.Parse("20060102150405", date+clock)
+	if err != nil {
+		return nil
+	}
+	return &t
+}
+
+func text(value []byte) string {
+	return strings.TrimRight(string(value), " \x00")
+}
+
+type parser struct {
+	r *bufio.Reader
+	// input is read by r, its limit bounds the length of values
+	input    *io.LimitedReader
+	explicit bool
+}
+
+// header reads the tag, VR and length of the next element, VR is empty in
+// implicit VR encoding and for items
+func (p *parser) header() (tag, string, uint32, error) {
+	var buf [8]byte
+	if _, err := io.ReadFull(p.r, buf[:4]); err != nil {
+		return 0, "", 0, err
+	}
+	t := tag(uint32(binary.LittleEndian.Uint16(buf[:2]))<<16 | uint32(binary.LittleEndian.Uint16(buf[2:4])))
+
+	if t>>16 == 0xfffe || !p.explicit {
+		if _, err := io.ReadFull(p.r, buf[:4]); err != nil {
+			return 0, "", 0, eof(err)
+		}
+		return t, "", binary.LittleEndian.Uint32(buf[:4]), nil
+	}
+
+	if _, err := io.ReadFull(p.r, buf[:4]); err != nil {
+		return 0, "", 0, eof(err)
+	}
+	vr := string(buf[:2])
+	if !longVRs[vr] {
+		return t, vr, uint32(binary.LittleEndian.Uint16(buf[2:4])), nil
+	}
+	if _, err := io.ReadFull(p.r, buf[:4]); err != nil {
+		return 0, "", 0, eof(err)
+	}
+	return t, vr, binary.LittleEndian.Uint32(buf[:4]), nil
+}
+
+func (p *parser) value(length uint32) ([]byte, error) {
+	if length == undefinedLength {
+		return nil, errors.New("undefined length of a value")
+	}
+	// the length comes from the file, don't allocate more than is left of it
+	if int64(length) > p.remaining() {
+		return nil, io.ErrUnexpectedEOF
+	}
+	value := make([]byte, length)
+	_, err := io.ReadFull(p.r, value)
+	return value, eof(err)
+}
+
+// remaining returns the number of bytes that can still be read
+func (p *parser) remaining() int64 {
+	return p.input.N + int64(p.r.Buffered())
+}
+
+func (p *parser) skip(length uint32) error {
+	_, err := p.r.Discard(int(length))
+	return eof(err)
+}
+
+// read reads the top level dataset until the end of the file
+func (p *parser) read(f *File) error {
+	for {
+		t, vr, length, err := p.header()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case t == tagPixelData && length == undefinedLength:
+			if f.fragments, err = p.fragments(); err != nil {
+				return err
+			}
+		case length == undefinedLength:
+			// a sequence, or an element of unknown VR encoded as one
+			if err := p.skipSequence(0); err != nil {
+				return err
+			}
+		case kept[t] && vr != "SQ":
+			if f.elements[t], err = p.value(length); err != nil {
+				return err
+			}
+		default:
+			if err := p.skip(length); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// fragments reads items of encapsulated pixel data, the first item is the
+// basic offset table
+func (p *parser) fragments() ([][]byte, error) {
+	var fragments [][]byte
+	for first := true; ; first = false {
+		t, _, length, err := p.header()
+		if err != nil {
+			return nil, eof(err)
+		}
+		switch t {
+		case tagSequenceDelimitation:
+			return fragments, nil
+		case tagItem:
+			fragment, err := p.value(length)
+			if err != nil {
+				return nil, err
+			}
+			if !first {
+				fragments = append(fragments, fragment)
+			}
+		default:
+			return nil, fmt.Errorf("unexpected element %08x in pixel data", uint32(t))
+		}
+	}
+}
+
+// skipSequence skips items of a sequence with undefined length, depth is
+// the number of sequences it's nested in
+func (p *parser) skipSequence(depth int) error {
+	if depth >= maxDepth {
+		return fmt.Errorf("sequences are nested deeper than %d", maxDepth)
+	}
+	for {
+		t, _, length, err := p.header()
+		if err != nil {
+			return eof(err)
+		}
+		switch {
+		case t == tagSequenceDelimitation:
+			return nil
+		case t == tagItem && length == undefinedLength:
+			if err := p.skipItem(depth); err != nil {
+				return err
+			}
+		case t == tagItem:
+			if err := p.skip(length); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("unexpected element %08x in sequence", uint32(t))
+		}
+	}
+}
+
+// skipItem skips elements of an item with undefined length of a sequence
+// at depth
+func (p *parser) skipItem(depth int) error {
+	for {
+		t, _, length, err := p.header()
+		if err != nil {
+			return eof(err)
+		}
+		switch {
+		case t == tagItemDelimitation:
+			return nil
+		case length == undefinedLength:
+			if err := p.skipSequence(depth + 1); err != nil {
+				return err
+			}
+		default:
+			if err := p.skip(length); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// eof reports a file that ends inside an element as truncated
+func eof(err error) error {
+	if errors.Is(err, io.EOF) {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
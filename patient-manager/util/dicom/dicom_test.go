@@ -0,0 +1,159 @@
+package dicom
+
+import (
+	"PatientManager/util/cerror"
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"image"
+	"testing"
+)
+
+// element encodes an element in explicit VR little endian
+func element(t tag, vr string, value []byte) []byte {
+	buf := binary.LittleEndian.AppendUint16(nil, uint16(t>>16))
+	buf = binary.LittleEndian.AppendUint16(buf, uint16(t))
+	buf = append(buf, vr...)
+	if longVRs[vr] {
+		buf = append(buf, 0, 0)
+		buf = binary.LittleEndian.AppendUint32(buf, uint32(len(value)))
+	} else {
+		buf = binary.LittleEndian.AppendUint16(buf, uint16(len(value)))
+	}
+	return append(buf, value...)
+}
+
+// marker encodes an item or delimitation item
+func marker(t tag, length uint32) []byte {
+	buf := binary.LittleEndian.AppendUint16(nil, uint16(t>>16))
+	buf = binary.LittleEndian.AppendUint16(buf, uint16(t))
+	return binary.LittleEndian.AppendUint32(buf, length)
+}
+
+// sequence encodes depth sequences with undefined length nested in each other
+func sequence(depth int) []byte {
+	if depth == 0 {
+		return nil
+	}
+	buf := element(0x00081115, "SQ", nil)
+	binary.LittleEndian.PutUint32(buf[len(buf)-4:], undefinedLength)
+	buf = append(buf, marker(tagItem, undefinedLength)...)
+	buf = append(buf, sequence(depth-1)...)
+	buf = append(buf, marker(tagItemDelimitation, 0)...)
+	return append(buf, marker(tagSequenceDelimitation, 0)...)
+}
+
+func uint16Value(v uint16) []byte {
+	return binary.LittleEndian.AppendUint16(nil, v)
+}
+
+// file encodes a Part 10 file with the dataset in the transfer syntax
+func file(syntax string, dataset ...[]byte) []byte {
+	buf := append(make([]byte, preambleLength), prefix...)
+	buf = append(buf, element(tagTransferSyntax, "UI", []byte(syntax+"\x00"))...)
+	return append(buf, bytes.Join(dataset, nil)...)
+}
+
+func deflate(data []byte) []byte {
+	var buf bytes.Buffer
+	w, _ := flate.NewWriter(&buf, flate.BestCompression)
+	w.Write(data)
+	w.Close()
+	return buf.Bytes()
+}
+
+var uid = element(tagSOPInstanceUID, "UI", []byte("1.2.3.4\x00"))
+
+func TestParse(t *testing.T) {
+	// a pixel data element that claims to be 4 GiB long
+	huge := element(tagPixelData, "OW", nil)
+	binary.LittleEndian.PutUint32(huge[len(huge)-4:], 0xfffffff0)
+
+	tests := []struct {
+		name    string
+		data    []byte
+		wantErr bool
+	}{
+		{"explicit VR", file(ExplicitVRLittleEndian, uid), false},
+		{"nested sequences", file(ExplicitVRLittleEndian, uid, sequence(maxDepth)), false},
+		{"sequences nested too deep", file(ExplicitVRLittleEndian, uid, sequence(maxDepth+1)), true},
+		{"value longer than the file", file(ExplicitVRLittleEndian, uid, huge), true},
+		{"deflated", file(DeflatedExplicitVRLittleEndian, deflate(uid)), false},
+		{
+			"deflated beyond the limit",
+			file(DeflatedExplicitVRLittleEndian, deflate(append(uid, element(tagPixelData, "OB", make([]byte, 1<<20))...))),
+			true,
+		},
+		{"missing prefix", uid, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := Parse(bytes.NewReader(tt.data), 1<<16)
+			if tt.wantErr {
+				if !errors.Is(err, cerror.ErrInvalidDicom) {
+					t.Errorf("Parse() error = %v, want %v", err, cerror.ErrInvalidDicom)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+			if f.SOPInstanceUID != "1.2.3.4" {
+				t.Errorf("SOP instance UID = %q, want 1.2.3.4", f.SOPInstanceUID)
+			}
+		})
+	}
+}
+
+func TestPreview(t *testing.T) {
+	monochrome := func(bitsStored uint16, pixels []byte) []byte {
+		return file(ExplicitVRLittleEndian, uid,
+			element(tagSamplesPerPixel, "US", uint16Value(1)),
+			element(tagPhotometric, "CS", []byte("MONOCHROME2 ")),
+			element(tagRows, "US", uint16Value(2)),
+			element(tagColumns, "US", uint16Value(2)),
+			element(tagBitsAllocated, "US", uint16Value(16)),
+			element(tagBitsStored, "US", uint16Value(bitsStored)),
+			element(tagPixelRepresentation, "US", uint16Value(1)),
+			element(tagPixelData, "OW", pixels),
+		)
+	}
+	pixels := []byte{0, 0, 0xff, 0x0f, 0, 0x08, 0xff, 0x07}
+
+	tests := []struct {
+		name      string
+		data      []byte
+		maxPixels int
+		want      []uint8
+		wantErr   bool
+	}{
+		{"signed 12 bits", monochrome(12, pixels), 4, []uint8{128, 127, 0, 255}, false},
+		{"no bits stored", monochrome(0, pixels), 4, nil, true},
+		{"more bits stored than allocated", monochrome(40, pixels), 4, nil, true},
+		{"too many pixels", monochrome(12, pixels), 3, nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := Parse(bytes.NewReader(tt.data), 1<<16)
+			if err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+			img, err := f.Preview(tt.maxPixels)
+			if tt.wantErr {
+				if !errors.Is(err, ErrNoPreview) {
+					t.Errorf("Preview() error = %v, want %v", err, ErrNoPreview)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Preview() error = %v", err)
+			}
+			if got := img.(*image.Gray).Pix; !bytes.Equal(got, tt.want) {
+				t.Errorf("Preview() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,149 @@
+package dicom
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"math"
+)
+
+// ErrNoPreview is returned by Preview for pixel data that can't be rendered,
+// e.g. compressed with other than baseline JPEG
+var ErrNoPreview = errors.New("preview of the DICOM pixel data is not supported")
+
+// Preview renders the first frame of up to maxPixels pixels. Monochrome
+// frames are rescaled to modality values and mapped to gray with the first
+// VOI window, or the range of the frame if there's none.
+func (f *File) Preview(maxPixels int) (image.Image, error) {
+	if len(f.fragments) > 0 {
+		if f.TransferSyntax != JPEGBaseline {
+			return nil, fmt.Errorf("%w: transfer syntax %s", ErrNoPreview, f.TransferSyntax)
+		}
+		data := bytes.Join(f.fragments, nil)
+		cfg, err := jpeg.DecodeConfig(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrNoPreview, err)
+		}
+		if cfg.Width*cfg.Height > maxPixels {
+			return nil, fmt.Errorf("%w: frame of %dx%d pixels is too large", ErrNoPreview, cfg.Width, cfg.Height)
+		}
+		// the decoder stops at the end of the first frame
+		img, err := jpeg.Decode(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrNoPreview, err)
+		}
+		return img, nil
+	}
+
+	pixels := f.elements[tagPixelData]
+	rows, columns := f.uint16(tagRows, 0), f.uint16(tagColumns, 0)
+	samples := f.uint16(tagSamplesPerPixel, 1)
+	bitsAllocated := f.uint16(tagBitsAllocated, 0)
+	bitsStored := f.uint16(tagBitsStored, bitsAllocated)
+	if len(pixels) == 0 || rows == 0 || columns == 0 {
+		return nil, fmt.Errorf("%w: pixel data is missing", ErrNoPreview)
+	}
+	if rows*columns > maxPixels {
+		return nil, fmt.Errorf("%w: frame of %dx%d pixels is too large", ErrNoPreview, columns, rows)
+	}
+	if bitsAllocated != 8 && bitsAllocated != 16 {
+		return nil, fmt.Errorf("%w: %d bits allocated", ErrNoPreview, bitsAllocated)
+	}
+	if bitsStored < 1 || bitsStored > bitsAllocated {
+		return nil, fmt.Errorf("%w: %d of %d bits stored", ErrNoPreview, bitsStored, bitsAllocated)
+	}
+	if len(pixels) < rows*columns*samples*bitsAllocated/8 {
+		return nil, fmt.Errorf("%w: pixel data is truncated", ErrNoPreview)
+	}
+
+	photometric := f.first(tagPhotometric)
+	switch {
+	case samples == 1 && (photometric == "MONOCHROME1" || photometric == "MONOCHROME2"):
+		return f.monochrome(pixels, rows, columns, bitsAllocated, bitsStored, photometric == "MONOCHROME1"), nil
+	case samples == 3 && photometric == "RGB" && bitsAllocated == 8:
+		return f.rgb(pixels, rows, columns), nil
+	}
+	return nil, fmt.Errorf("%w: photometric interpretation %s", ErrNoPreview, photometric)
+}
+
+func (f *File) monochrome(pixels []byte, rows, columns, bitsAllocated, bitsStored int, inverted bool) image.Image {
+	signed := f.uint16(tagPixelRepresentation, 0) == 1
+	slope, ok := f.float(tagRescaleSlope)
+	if !ok || slope == 0 {
+		slope = 1
+	}
+	intercept, _ := f.float(tagRescaleIntercept)
+
+	values := make([]float64, rows*columns)
+	low, high := math.Inf(1), math.Inf(-1)
+	for i := range values {
+		var raw uint32
+		if bitsAllocated == 8 {
+			raw = uint32(pixels[i])
+		} else {
+			raw = uint32(binary.LittleEndian.Uint16(pixels[2*i:]))
+		}
+
+		var stored int32
+		if signed {
+			// sign extend the stored bits
+			shift := 32 - bitsStored
+			stored = int32(raw<<shift) >> shift
+		} else {
+			stored = int32(raw & (1<<bitsStored - 1))
+		}
+
+		values[i] = float64(stored)*slope + intercept
+		low, high = math.Min(low, values[i]), math.Max(high, values[i])
+	}
+
+	// linear VOI function of PS3.3 C.11.2.1.2
+	center, hasCenter := f.float(tagWindowCenter)
+	width, hasWidth := f.float(tagWindowWidth)
+	if !hasCenter || !hasWidth || width < 1 {
+		center, width = (low+high)/2+0.5, high-low+1
+	}
+
+	img := image.NewGray(image.Rect(0, 0, columns, rows))
+	for i, value := range values {
+		var gray float64
+		switch {
+		case width <= 1:
+			if value > center-0.5 {
+				gray = 255
+			}
+		case value <= center-0.5-(width-1)/2:
+			gray = 0
+		case value > center-0.5+(width-1)/2:
+			gray = 255
+		default:
+			gray = ((value-(center-0.5))/(width-1) + 0.5) * 255
+		}
+		if inverted {
+			gray = 255 - gray
+		}
+		img.Pix[i] = uint8(math.Round(gray))
+	}
+	return img
+}
+
+func (f *File) rgb(pixels []byte, rows, columns int) image.Image {
+	planar := f.uint16(tagPlanarConfiguration, 0) == 1
+	size := rows * columns
+
+	img := image.NewRGBA(image.Rect(0, 0, columns, rows))
+	for i := 0; i < size; i++ {
+		var c color.RGBA
+		if planar {
+			c = color.RGBA{pixels[i], pixels[size+i], pixels[2*size+i], 0xff}
+		} else {
+			c = color.RGBA{pixels[3*i], pixels[3*i+1], pixels[3*i+2], 0xff}
+		}
+		img.SetRGBA(i%columns, i/columns, c)
+	}
+	return img
+}
@@ -0,0 +1,145 @@
+package encryption
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strings"
+)
+
+// valuePrefix marks encrypted column values, values without it were written
+// before encryption was enabled and are read as they are
+const valuePrefix = "enc:"
+
+var ErrDecrypt = errors.New("failed to decrypt value")
+
+// EncryptString encrypts a column value with the current key, the result is
+// enc:<kid>:<nonce and ciphertext in base64>. Context, e.g. the table and
+// column, binds the value to where it's stored so it can't be moved elsewhere.
+func EncryptString(value, context string) (string, error) {
+	kid, key, err := currentKey()
+	if err != nil {
+		return "", err
+	}
+	sealed, err := seal(key, []byte(value), []byte(kid+":"+context))
+	if err != nil {
+		return "", err
+	}
+	return ValuePrefix(kid) + base64.RawStdEncoding.EncodeToString(sealed), nil
+}
+
+// DecryptString decrypts a value of EncryptString with the same context,
+// values that aren't encrypted are returned unchanged
+func DecryptString(value, context string) (string, error) {
+	if !IsEncrypted(value) {
+		return value, nil
+	}
+	kid, encoded, ok := strings.Cut(strings.TrimPrefix(value, valuePrefix), ":")
+	if !ok {
+		return "", ErrDecrypt
+	}
+	key, err := findKey(kid)
+	if err != nil {
+		return "", err
+	}
+	sealed, err := base64.RawStdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", ErrDecrypt
+	}
+	plaintext, err := open(key, sealed, []byte(kid+":"+context))
+	if err != nil {
+		return "", err
+	}
+	return string(plaintext), nil
+}
+
+// IsEncrypted tells if the value was returned by EncryptString
+func IsEncrypted(value string) bool {
+	return strings.HasPrefix(value, valuePrefix)
+}
+
+// ValuePrefix returns the prefix of values encrypted with the key with kid,
+// e.g. to find values that aren't encrypted with it yet
+func ValuePrefix(kid string) string {
+	return valuePrefix + kid + ":"
+}
+
+// KeyIDOf returns the kid of the key that encrypted the value, empty if it
+// isn't encrypted
+func KeyIDOf(value string) string {
+	if !IsEncrypted(value) {
+		return ""
+	}
+	kid, _, _ := strings.Cut(strings.TrimPrefix(value, valuePrefix), ":")
+	return kid
+}
+
+// BlindIndex returns a keyed hash of the value that encrypted values can be
+// looked up by with an exact match
+func BlindIndex(value string) string {
+	keys.mu.RLock()
+	defer keys.mu.RUnlock()
+	mac := hmac.New(sha256.New, keys.indexKey)
+	mac.Write([]byte(value))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// WrapKey encrypts a data key with the current key
+func WrapKey(dataKey []byte) (kid string, wrapped []byte, err error) {
+	kid, key, err := currentKey()
+	if err != nil {
+		return "", nil, err
+	}
+	wrapped, err = seal(key, dataKey, []byte(kid))
+	return kid, wrapped, err
+}
+
+// UnwrapKey decrypts a data key wrapped by the key with kid
+func UnwrapKey(kid string, wrapped []byte) ([]byte, error) {
+	key, err := findKey(kid)
+	if err != nil {
+		return nil, err
+	}
+	return open(key, wrapped, []byte(kid))
+}
+
+// seal encrypts with a random nonce that is prepended to the ciphertext
+func seal(key, plaintext, additionalData []byte) ([]byte, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+func open(key, sealed, additionalData []byte) ([]byte, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return nil, ErrDecrypt
+	}
+	plaintext, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], additionalData)
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+	return plaintext, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,189 @@
+package encryption
+
+import (
+	"PatientManager/config"
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+// loadTestKeys loads keys generated in a temporary directory
+func loadTestKeys(t *testing.T) {
+	t.Helper()
+
+	config.AppConfig = &config.AppConfiguration{
+		Encryption: config.EncryptionConfig{KeyDir: t.TempDir()},
+	}
+	keys = &keyRing{}
+	if err := LoadKeys(); err != nil {
+		t.Fatalf("failed to load keys: %v", err)
+	}
+}
+
+const testContext = "patients.oib"
+
+func encrypt(t *testing.T, value string) string {
+	t.Helper()
+	encrypted, err := EncryptString(value, testContext)
+	if err != nil {
+		t.Fatalf("EncryptString() error = %v", err)
+	}
+	return encrypted
+}
+
+func TestDecryptString(t *testing.T) {
+	loadTestKeys(t)
+	encrypted := encrypt(t, "12345678903")
+	kid := CurrentKeyID()
+
+	// a character of the ciphertext changed
+	tampered := []byte(encrypted)
+	if i := len(tampered) - 10; tampered[i] == 'A' {
+		tampered[i] = 'B'
+	} else {
+		tampered[i] = 'A'
+	}
+
+	tests := []struct {
+		name    string
+		value   string
+		context string
+		want    string
+		wantErr bool
+	}{
+		{"round trip", encrypted, testContext, "12345678903", false},
+		{"other context", encrypted, "users.oib", "", true},
+		{"tampered ciphertext", string(tampered), testContext, "", true},
+		{"other key id", strings.Replace(encrypted, kid, "20200101T000000.000000-00000000", 1), testContext, "", true},
+		{"missing key id", "enc:" + strings.TrimPrefix(encrypted, ValuePrefix(kid)), testContext, "", true},
+		// values written before encryption was enabled are read as they are,
+		// until EncryptPatients encrypts them at startup
+		{"plaintext", "12345678903", testContext, "12345678903", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := DecryptString(tt.value, tt.context)
+			if (err != nil) != tt.wantErr || got != tt.want {
+				t.Errorf("DecryptString() = %q, %v, want %q, error %v", got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestDecryptStringWrongKey(t *testing.T) {
+	loadTestKeys(t)
+	encrypted := encrypt(t, "12345678903")
+
+	// the key directory of another installation has a key with the same kid
+	kid := CurrentKeyID()
+	keys.keys[kid] = bytes.Repeat([]byte{1}, keySize)
+
+	if _, err := DecryptString(encrypted, testContext); !errors.Is(err, ErrDecrypt) {
+		t.Errorf("DecryptString() error = %v, want %v", err, ErrDecrypt)
+	}
+}
+
+func TestRotateKey(t *testing.T) {
+	loadTestKeys(t)
+	old := CurrentKeyID()
+	before := encrypt(t, "Ana")
+
+	kid, err := RotateKey()
+	if err != nil {
+		t.Fatalf("RotateKey() error = %v", err)
+	}
+	if kid == old || CurrentKeyID() != kid {
+		t.Fatalf("RotateKey() = %s, current key %s, want a key other than %s", kid, CurrentKeyID(), old)
+	}
+
+	// values encrypted with the old key are read until they are re-encrypted
+	after := encrypt(t, "Ana")
+	for _, value := range []string{before, after} {
+		if got, err := DecryptString(value, testContext); err != nil || got != "Ana" {
+			t.Errorf("DecryptString(%q) = %q, %v, want Ana", value, got, err)
+		}
+	}
+	if KeyIDOf(before) != old || KeyIDOf(after) != kid {
+		t.Errorf("values are encrypted with %s and %s, want %s and %s", KeyIDOf(before), KeyIDOf(after), old, kid)
+	}
+}
+
+func TestReloadKeys(t *testing.T) {
+	loadTestKeys(t)
+	old := CurrentKeyID()
+
+	// another process rotates the key and encrypts with the new one
+	kid, err := RotateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypted := encrypt(t, "Ana")
+	keys.keys = map[string][]byte{old: keys.keys[old]}
+	keys.current = old
+
+	if got, err := DecryptString(encrypted, testContext); err != nil || got != "Ana" {
+		t.Errorf("DecryptString() of a value of an unloaded key = %q, %v, want Ana", got, err)
+	}
+	if CurrentKeyID() != kid {
+		t.Errorf("current key after reload = %s, want %s", CurrentKeyID(), kid)
+	}
+}
+
+type nopCloser struct {
+	*bytes.Reader
+}
+
+func (nopCloser) Close() error {
+	return nil
+}
+
+func TestObject(t *testing.T) {
+	loadTestKeys(t)
+	plaintext := bytes.Repeat([]byte("0123456789"), chunkSize/5)
+
+	reader, size, err := EncryptObject("checkups/1.png", bytes.NewReader(plaintext), int64(len(plaintext)))
+	if err != nil {
+		t.Fatalf("EncryptObject() error = %v", err)
+	}
+	encrypted, err := io.ReadAll(reader)
+	if err != nil || int64(len(encrypted)) != size {
+		t.Fatalf("encrypted object has %d bytes, %v, want %d", len(encrypted), err, size)
+	}
+	if _, err := RotateKey(); err != nil {
+		t.Fatal(err)
+	}
+
+	tampered := bytes.Clone(encrypted)
+	tampered[len(tampered)-1] ^= 1
+
+	tests := []struct {
+		name    string
+		object  string
+		data    []byte
+		wantErr bool
+	}{
+		{"round trip after rotation", "checkups/1.png", encrypted, false},
+		{"other object", "checkups/2.png", encrypted, true},
+		{"tampered chunk", "checkups/1.png", tampered, true},
+		{"truncated", "checkups/1.png", encrypted[:len(encrypted)-100], true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reader, _, ok, err := OpenObject(tt.object, nopCloser{bytes.NewReader(tt.data)}, int64(len(tt.data)))
+			if err == nil {
+				var got []byte
+				got, err = io.ReadAll(reader)
+				if err == nil && !bytes.Equal(got, plaintext) {
+					t.Errorf("decrypted object differs from the plaintext")
+				}
+			}
+			if !ok || (err != nil) != tt.wantErr {
+				t.Errorf("OpenObject() encrypted %v, error = %v, want error %v", ok, err, tt.wantErr)
+			}
+		})
+	}
+}
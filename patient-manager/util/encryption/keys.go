@@ -0,0 +1,262 @@
+// Package encryption encrypts personal data at rest with AES-256-GCM. Column
+// values are encrypted directly with the current key, stored objects with
+// their own data key that is encrypted (wrapped) with the current key. Keys
+// are identified by a kid stored with everything they encrypt, so that data
+// can be re-encrypted with a new key after RotateKey.
+package encryption
+
+import (
+	"PatientManager/config"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	keySize      = 32
+	indexKeyName = "index"
+	keyExtension = ".key"
+)
+
+// ReloadInterval is how often running servers call ReloadKeys, so a key
+// added by RotateKey in another process encrypts everywhere after it
+const ReloadInterval = time.Minute
+
+// keyRing holds keys by kid, only the newest (current) key encrypts. The
+// index key is used only by BlindIndex.
+type keyRing struct {
+	mu       sync.RWMutex
+	keys     map[string][]byte
+	current  string
+	indexKey []byte
+}
+
+var keys = &keyRing{}
+
+// LoadKeys loads keys from config.Encryption.KeyDir and generates the first
+// key and the index key when there are none, it should be called after
+// config.LoadConfig
+func LoadKeys() error {
+	dir := config.AppConfig.Encryption.KeyDir
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return err
+	}
+
+	indexKey, err := readKey(filepath.Join(dir, indexKeyName+keyExtension))
+	if errors.Is(err, os.ErrNotExist) {
+		indexKey, err = writeKey(dir, indexKeyName)
+		zap.S().Infof("Generated blind index key in %s", dir)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to load blind index key: %w", err)
+	}
+
+	loaded, err := readKeys(dir)
+	if err != nil {
+		return err
+	}
+	if len(loaded) == 0 {
+		kid, err := generateKey(dir)
+		if err != nil {
+			return err
+		}
+		zap.S().Infof("Generated encryption key %s", kid)
+		if loaded, err = readKeys(dir); err != nil {
+			return err
+		}
+	}
+
+	keys.mu.Lock()
+	defer keys.mu.Unlock()
+	keys.keys = loaded
+	keys.current = newest(loaded)
+	keys.indexKey = indexKey
+	return nil
+}
+
+// RotateKey generates a new key that encrypts from now on and returns its
+// kid. Existing data stays encrypted with older keys until it's re-encrypted.
+func RotateKey() (string, error) {
+	dir := config.AppConfig.Encryption.KeyDir
+	kid, err := generateKey(dir)
+	if err != nil {
+		return "", err
+	}
+	key, err := readKey(filepath.Join(dir, kid+keyExtension))
+	if err != nil {
+		return "", err
+	}
+
+	keys.mu.Lock()
+	defer keys.mu.Unlock()
+	keys.keys[kid] = key
+	keys.current = kid
+	zap.S().Infof("Generated encryption key %s", kid)
+	return kid, nil
+}
+
+// ReloadKeys loads keys added to config.Encryption.KeyDir since the keys were
+// loaded, e.g. by the rotate-keys command, the newest key encrypts from then
+// on. Keys that were removed from the directory stay loaded.
+func ReloadKeys() error {
+	loaded, err := readKeys(config.AppConfig.Encryption.KeyDir)
+	if err != nil {
+		return err
+	}
+
+	keys.mu.Lock()
+	defer keys.mu.Unlock()
+	if keys.keys == nil {
+		keys.keys = map[string][]byte{}
+	}
+	for kid, key := range loaded {
+		if _, ok := keys.keys[kid]; !ok {
+			keys.keys[kid] = key
+			zap.S().Infof("Loaded encryption key %s", kid)
+		}
+	}
+	keys.current = newest(keys.keys)
+	return nil
+}
+
+// CurrentKeyID returns the kid of the key that encrypts
+func CurrentKeyID() string {
+	keys.mu.RLock()
+	defer keys.mu.RUnlock()
+	return keys.current
+}
+
+// KeyIDs returns kids of all loaded keys from the oldest to the newest
+func KeyIDs() []string {
+	keys.mu.RLock()
+	defer keys.mu.RUnlock()
+	kids := make([]string, 0, len(keys.keys))
+	for kid := range keys.keys {
+		kids = append(kids, kid)
+	}
+	sort.Strings(kids)
+	return kids
+}
+
+// currentKey returns the key that encrypts with its kid
+func currentKey() (string, []byte, error) {
+	keys.mu.RLock()
+	defer keys.mu.RUnlock()
+	if keys.current == "" {
+		return "", nil, errors.New("encryption keys are not loaded")
+	}
+	return keys.current, keys.keys[keys.current], nil
+}
+
+// findKey returns the key with kid, the key directory is reloaded once if
+// it isn't loaded, it may have been added by another process
+func findKey(kid string) ([]byte, error) {
+	if key, ok := loadedKey(kid); ok {
+		return key, nil
+	}
+	if err := ReloadKeys(); err != nil {
+		return nil, err
+	}
+	if key, ok := loadedKey(kid); ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown encryption key %q", kid)
+}
+
+func loadedKey(kid string) ([]byte, bool) {
+	keys.mu.RLock()
+	defer keys.mu.RUnlock()
+	key, ok := keys.keys[kid]
+	return key, ok
+}
+
+// readKeys loads all <kid>.key files of the directory except the index key
+func readKeys(dir string) (map[string][]byte, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*"+keyExtension))
+	if err != nil {
+		return nil, err
+	}
+
+	loaded := make(map[string][]byte, len(paths))
+	for _, path := range paths {
+		kid := strings.TrimSuffix(filepath.Base(path), keyExtension)
+		if kid == indexKeyName {
+			continue
+		}
+		// unlike signing keys, a key that can't be read can't be skipped,
+		// data encrypted with it would be unreadable
+		key, err := readKey(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load encryption key %s: %w", path, err)
+		}
+		loaded[kid] = key
+	}
+	return loaded, nil
+}
+
+func readKey(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil {
+		return nil, err
+	}
+	if len(key) != keySize {
+		return nil, fmt.Errorf("key must be %d bytes, it has %d", keySize, len(key))
+	}
+	return key, nil
+}
+
+// generateKey writes a new key, kids start with the creation time so that
+// they sort from the oldest to the newest
+func generateKey(dir string) (string, error) {
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return "", err
+	}
+	kid := time.Now().UTC().Format("20060102T150405.000000") + "-" + hex.EncodeToString(suffix)
+	if _, err := writeKey(dir, kid); err != nil {
+		return "", err
+	}
+	return kid, nil
+}
+
+func writeKey(dir, name string) ([]byte, error) {
+	key := make([]byte, keySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	data := base64.StdEncoding.EncodeToString(key) + "\n"
+	// O_EXCL keeps existing keys from being overwritten
+	file, err := os.OpenFile(filepath.Join(dir, name+keyExtension), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := file.WriteString(data); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return key, file.Close()
+}
+
+func newest(loaded map[string][]byte) string {
+	current := ""
+	for kid := range loaded {
+		if kid > current {
+			current = kid
+		}
+	}
+	return current
+}
@@ -0,0 +1,275 @@
+package encryption
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Objects are encrypted with a random data key in chunks so that they can be
+// decrypted from any offset. An encrypted object is
+//
+//	objectMagic | kid length (1 byte) | kid | wrapped key length (2 bytes) | wrapped key | chunks
+//
+// where every chunk holds chunkSize bytes, except the last one which may be
+// shorter or empty, sealed with the data key. The nonce of a chunk is its
+// index, the additional data is the object name and whether the chunk is the
+// last one, so chunks can't be reordered, truncated or moved to another object.
+const (
+	objectMagic = "PMENC1"
+	chunkSize   = 64 << 10
+)
+
+// EncryptObject returns a reader of the encrypted object and its size, -1 if
+// the size of the plaintext is -1 (unknown)
+func EncryptObject(name string, plaintext io.Reader, size int64) (io.Reader, int64, error) {
+	dataKey := make([]byte, keySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, 0, err
+	}
+	kid, wrapped, err := WrapKey(dataKey)
+	if err != nil {
+		return nil, 0, err
+	}
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	header := make([]byte, 0, len(objectMagic)+1+len(kid)+2+len(wrapped))
+	header = append(header, objectMagic...)
+	header = append(header, byte(len(kid)))
+	header = append(header, kid...)
+	header = binary.BigEndian.AppendUint16(header, uint16(len(wrapped)))
+	header = append(header, wrapped...)
+
+	encryptedSize := int64(-1)
+	if size >= 0 {
+		encryptedSize = int64(len(header)) + size + chunkCount(size)*int64(aead.Overhead())
+	}
+
+	return &encryptingReader{
+		aead:   aead,
+		name:   name,
+		source: plaintext,
+		buf:    make([]byte, chunkSize+1),
+		out:    header,
+	}, encryptedSize, nil
+}
+
+// OpenObject returns a reader of the decrypted object and its size. Objects
+// that aren't encrypted are returned as they are with encrypted false.
+func OpenObject(name string, source io.ReadSeekCloser, size int64) (reader io.ReadSeekCloser, plaintextSize int64, encrypted bool, err error) {
+	magic := make([]byte, len(objectMagic))
+	if _, err := io.ReadFull(source, magic); err != nil || !bytes.Equal(magic, []byte(objectMagic)) {
+		if errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF) {
+			err = nil
+		}
+		if err == nil {
+			_, err = source.Seek(0, io.SeekStart)
+		}
+		return source, size, false, err
+	}
+
+	kid, err := readField(source, 1)
+	if err != nil {
+		return nil, 0, true, err
+	}
+	wrapped, err := readField(source, 2)
+	if err != nil {
+		return nil, 0, true, err
+	}
+	dataKey, err := UnwrapKey(string(kid), wrapped)
+	if err != nil {
+		return nil, 0, true, fmt.Errorf("failed to unwrap data key of %s: %w", name, err)
+	}
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, 0, true, err
+	}
+
+	headerLength := int64(len(objectMagic) + 1 + len(kid) + 2 + len(wrapped))
+	body := size - headerLength
+	if body < int64(aead.Overhead()) {
+		return nil, 0, true, fmt.Errorf("encrypted object %s is truncated", name)
+	}
+	chunks := (body + chunkSize + int64(aead.Overhead()) - 1) / int64(chunkSize+aead.Overhead())
+
+	decrypting := &decryptingReader{
+		aead:         aead,
+		name:         name,
+		source:       source,
+		headerLength: headerLength,
+		size:         body - chunks*int64(aead.Overhead()),
+		chunkIndex:   -1,
+		buf:          make([]byte, chunkSize+aead.Overhead()),
+	}
+	// an empty object is never read, its only chunk is checked here
+	if decrypting.size == 0 {
+		if err := decrypting.load(0); err != nil {
+			return nil, 0, true, err
+		}
+	}
+	return decrypting, decrypting.size, true, nil
+}
+
+func chunkCount(size int64) int64 {
+	if size == 0 {
+		return 1
+	}
+	return (size + chunkSize - 1) / chunkSize
+}
+
+func chunkNonce(aead cipher.AEAD, index int64) []byte {
+	nonce := make([]byte, aead.NonceSize())
+	binary.BigEndian.PutUint64(nonce[len(nonce)-8:], uint64(index))
+	return nonce
+}
+
+func chunkData(name string, last bool) []byte {
+	data := append([]byte(name), 0)
+	if last {
+		data = append(data, 1)
+	}
+	return data
+}
+
+// readField reads a field prefixed with its length of lengthSize bytes
+func readField(reader io.Reader, lengthSize int) ([]byte, error) {
+	prefix := make([]byte, lengthSize)
+	if _, err := io.ReadFull(reader, prefix); err != nil {
+		return nil, err
+	}
+	length := int(prefix[0])
+	if lengthSize == 2 {
+		length = int(binary.BigEndian.Uint16(prefix))
+	}
+	field := make([]byte, length)
+	_, err := io.ReadFull(reader, field)
+	return field, err
+}
+
+type encryptingReader struct {
+	aead   cipher.AEAD
+	name   string
+	source io.Reader
+	// buf holds plaintext of the next chunk and one more byte, which tells
+	// that the chunk isn't the last one
+	buf     []byte
+	pending int
+	// out holds encrypted bytes that weren't read yet
+	out   []byte
+	index int64
+	done  bool
+}
+
+func (r *encryptingReader) Read(p []byte) (int, error) {
+	for len(r.out) == 0 {
+		if r.done {
+			return 0, io.EOF
+		}
+		if err := r.seal(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, r.out)
+	r.out = r.out[n:]
+	return n, nil
+}
+
+func (r *encryptingReader) seal() error {
+	n, err := io.ReadFull(r.source, r.buf[r.pending:])
+	r.pending += n
+	last := errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+	if err != nil && !last {
+		return err
+	}
+
+	chunk := r.buf[:r.pending]
+	if !last {
+		chunk = r.buf[:chunkSize]
+	}
+	r.out = r.aead.Seal(r.out[:0], chunkNonce(r.aead, r.index), chunk, chunkData(r.name, last))
+	r.index++
+
+	if last {
+		r.done = true
+	} else {
+		r.buf[0] = r.buf[chunkSize]
+		r.pending = 1
+	}
+	return nil
+}
+
+type decryptingReader struct {
+	aead         cipher.AEAD
+	name         string
+	source       io.ReadSeekCloser
+	headerLength int64
+	size         int64
+	offset       int64
+	// chunk is the decrypted chunk with chunkIndex, -1 before the first read
+	chunk      []byte
+	chunkIndex int64
+	buf        []byte
+}
+
+func (r *decryptingReader) Read(p []byte) (int, error) {
+	if r.offset >= r.size {
+		return 0, io.EOF
+	}
+	index := r.offset / chunkSize
+	if index != r.chunkIndex {
+		if err := r.load(index); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, r.chunk[r.offset-index*chunkSize:])
+	r.offset += int64(n)
+	return n, nil
+}
+
+func (r *decryptingReader) load(index int64) error {
+	overhead := int64(r.aead.Overhead())
+	if _, err := r.source.Seek(r.headerLength+index*(chunkSize+overhead), io.SeekStart); err != nil {
+		return err
+	}
+	length := min(chunkSize, r.size-index*chunkSize) + overhead
+	if _, err := io.ReadFull(r.source, r.buf[:length]); err != nil {
+		return err
+	}
+
+	last := index == max(chunkCount(r.size)-1, 0)
+	chunk, err := r.aead.Open(r.buf[:0], chunkNonce(r.aead, index), r.buf[:length], chunkData(r.name, last))
+	if err != nil {
+		return fmt.Errorf("chunk %d of %s: %w", index, r.name, ErrDecrypt)
+	}
+	r.chunk = chunk
+	r.chunkIndex = index
+	return nil
+}
+
+func (r *decryptingReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.offset
+	case io.SeekEnd:
+		offset += r.size
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+	r.offset = offset
+	return offset, nil
+}
+
+func (r *decryptingReader) Close() error {
+	return r.source.Close()
+}
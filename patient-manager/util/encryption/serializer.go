@@ -0,0 +1,56 @@
+package encryption
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+
+	"gorm.io/gorm/schema"
+)
+
+// Serializer encrypts string fields tagged with serializer:encrypted, e.g.
+//
+//	OIB string `gorm:"type:varchar(255);serializer:encrypted"`
+//
+// Encrypted columns can't be searched or sorted in the database, columns
+// that are looked up need a BlindIndex. Values of map updates aren't
+// serialized, they have to be encrypted with EncryptString and Column.
+type Serializer struct{}
+
+func init() {
+	schema.RegisterSerializer("encrypted", Serializer{})
+}
+
+// Column returns the context that values of the field are encrypted with
+func Column(field *schema.Field) string {
+	return field.Schema.Table + "." + field.DBName
+}
+
+func (Serializer) Scan(ctx context.Context, field *schema.Field, dst reflect.Value, dbValue any) error {
+	var value string
+	switch v := dbValue.(type) {
+	case nil:
+		return nil
+	case string:
+		value = v
+	case []byte:
+		value = string(v)
+	default:
+		return fmt.Errorf("unsupported value %T of encrypted column %s", dbValue, field.DBName)
+	}
+
+	plaintext, err := DecryptString(value, Column(field))
+	if err != nil {
+		return fmt.Errorf("column %s: %w", field.DBName, err)
+	}
+	field.ReflectValueOf(ctx, dst).SetString(plaintext)
+	return nil
+}
+
+func (Serializer) Value(ctx context.Context, field *schema.Field, dst reflect.Value, fieldValue any) (any, error) {
+	value, ok := fieldValue.(string)
+	if !ok {
+		return nil, fmt.Errorf("encrypted column %s must be a string, it's %T", field.DBName, fieldValue)
+	}
+	return EncryptString(value, Column(field))
+}
@@ -0,0 +1,277 @@
+// Package fhir maps the domain model to FHIR R4 resources. Only the elements
+// that have a counterpart in the model are supported, resource IDs are UUIDs
+// of the mapped entities.
+package fhir
+
+import (
+	"PatientManager/util/format"
+	"PatientManager/util/problem"
+	"PatientManager/util/validation"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ContentType of FHIR JSON requests and responses
+const ContentType = "application/fhir+json"
+
+const (
+	// OIBSystem is the identifier system of OIBs (Croatian personal identification numbers)
+	OIBSystem = "http://fhir.cezih.hr/specifikacije/identifikatori/OIB"
+	// CheckupTypeSystem is the code system of model.CheckupType
+	CheckupTypeSystem = "urn:patient-manager:checkup-type"
+	// MedicationSystem identifies medications by their UUID, see model.Medication
+	MedicationSystem = "urn:patient-manager:medication"
+	// ATCSystem is the WHO ATC classification of medications
+	ATCSystem = "http://www.whocc.no/atc"
+
+	conditionClinicalSystem = "http://terminology.hl7.org/CodeSystem/condition-clinical"
+	actCodeSystem           = "http://terminology.hl7.org/CodeSystem/v3-ActCode"
+)
+
+// Resource types
+const (
+	TypePatient           = "Patient"
+	TypeCondition         = "Condition"
+	TypeMedicationRequest = "MedicationRequest"
+	TypeEncounter         = "Encounter"
+	TypeDiagnosticReport  = "DiagnosticReport"
+	TypeMedia             = "Media"
+)
+
+// ResourceTypes are all supported resource types in the order used by Bundles
+var ResourceTypes = []string{
+	TypePatient,
+	TypeCondition,
+	TypeMedicationRequest,
+	TypeEncounter,
+	TypeDiagnosticReport,
+	TypeMedia,
+}
+
+// Resource is implemented by pointers to all supported resources
+type Resource interface {
+	// Reference returns the relative reference to the resource, e.g. Patient/{id}
+	Reference() string
+}
+
+// New returns an empty resource of the given type that can be created, ok is
+// false for types that are only derived from other data
+func New(resourceType string) (resource Resource, ok bool) {
+	switch resourceType {
+	case TypePatient:
+		return &Patient{}, true
+	case TypeCondition:
+		return &Condition{}, true
+	case TypeMedicationRequest:
+		return &MedicationRequest{}, true
+	case TypeEncounter:
+		return &Encounter{}, true
+	}
+	return nil, false
+}
+
+// Supported reports whether resourceType is one of ResourceTypes
+func Supported(resourceType string) bool {
+	for _, t := range ResourceTypes {
+		if t == resourceType {
+			return true
+		}
+	}
+	return false
+}
+
+type Meta struct {
+	LastUpdated *time.Time `json:"lastUpdated,omitempty"`
+}
+
+type Identifier struct {
+	System string `json:"system,omitempty"`
+	Value  string `json:"value"`
+}
+
+type HumanName struct {
+	Use    string   `json:"use,omitempty"`
+	Family string   `json:"family" binding:"required,max=100"`
+	Given  []string `json:"given" binding:"required,min=1,dive,required"`
+}
+
+type Coding struct {
+	System  string `json:"system,omitempty"`
+	Code    string `json:"code,omitempty"`
+	Display string `json:"display,omitempty"`
+}
+
+type CodeableConcept struct {
+	Coding []Coding `json:"coding,omitempty"`
+	Text   string   `json:"text,omitempty"`
+}
+
+// Code returns the code of the first coding from system
+func (cc *CodeableConcept) Code(system string) string {
+	if cc == nil {
+		return ""
+	}
+	for _, coding := range cc.Coding {
+		if coding.System == system {
+			return coding.Code
+		}
+	}
+	return ""
+}
+
+type Reference struct {
+	Reference string `json:"reference,omitempty"`
+	Display   string `json:"display,omitempty"`
+}
+
+// ID returns the UUID of a relative reference to resourceType, e.g.
+// Patient/{uuid}
+func (r *Reference) ID(resourceType string) (uuid.UUID, bool) {
+	if r == nil {
+		return uuid.Nil, false
+	}
+	id, found := strings.CutPrefix(r.Reference, resourceType+"/")
+	if !found {
+		return uuid.Nil, false
+	}
+	parsed, err := uuid.Parse(id)
+	return parsed, err == nil
+}
+
+func reference(resourceType string, id uuid.UUID) Reference {
+	return Reference{Reference: resourceType + "/" + id.String()}
+}
+
+type Period struct {
+	Start string `json:"start,omitempty"`
+	End   string `json:"end,omitempty"`
+}
+
+type Attachment struct {
+	ContentType string `json:"contentType,omitempty"`
+	URL         string `json:"url,omitempty"`
+	Title       string `json:"title,omitempty"`
+}
+
+// Bundle is a searchset of resources
+type Bundle struct {
+	ResourceType string        `json:"resourceType"`
+	Type         string        `json:"type"`
+	Total        int           `json:"total"`
+	Entry        []BundleEntry `json:"entry,omitempty"`
+}
+
+type BundleEntry struct {
+	FullURL  string   `json:"fullUrl,omitempty"`
+	Resource Resource `json:"resource"`
+}
+
+// NewSearchset returns a searchset Bundle with the resources
+func NewSearchset(resources []Resource) *Bundle {
+	bundle := &Bundle{ResourceType: "Bundle", Type: "searchset", Total: len(resources)}
+	for _, resource := range resources {
+		bundle.Entry = append(bundle.Entry, BundleEntry{Resource: resource})
+	}
+	return bundle
+}
+
+// SetBase sets full URLs of the entries, base is the URL of the FHIR API
+func (b *Bundle) SetBase(base string) {
+	for i := range b.Entry {
+		b.Entry[i].FullURL = base + "/" + b.Entry[i].Resource.Reference()
+	}
+}
+
+// OperationOutcome describes errors of FHIR interactions
+type OperationOutcome struct {
+	ResourceType string  `json:"resourceType"`
+	Issue        []Issue `json:"issue"`
+}
+
+type Issue struct {
+	Severity    string   `json:"severity"`
+	Code        string   `json:"code"`
+	Diagnostics string   `json:"diagnostics,omitempty"`
+	Expression  []string `json:"expression,omitempty"`
+}
+
+// issueCodes are FHIR issue types of response statuses
+var issueCodes = map[int]string{
+	http.StatusBadRequest:       "invalid",
+	http.StatusUnauthorized:     "login",
+	http.StatusForbidden:        "forbidden",
+	http.StatusNotFound:         "not-found",
+	http.StatusMethodNotAllowed: "not-supported",
+	http.StatusConflict:         "conflict",
+	http.StatusTooManyRequests:  "throttled",
+}
+
+// OutcomeFrom describes a problem as an OperationOutcome, invalid fields
+// become separate issues
+func OutcomeFrom(p problem.Problem) OperationOutcome {
+	outcome := OperationOutcome{ResourceType: "OperationOutcome"}
+	for _, field := range p.Errors {
+		code := "value"
+		if field.Tag == "required" {
+			code = "required"
+		}
+		outcome.Issue = append(outcome.Issue, Issue{
+			Severity:    "error",
+			Code:        code,
+			Diagnostics: field.Field + " " + field.Message,
+			Expression:  []string{field.Field},
+		})
+	}
+	if len(outcome.Issue) > 0 {
+		return outcome
+	}
+
+	code, ok := issueCodes[p.Status]
+	if !ok {
+		code = "exception"
+	}
+	diagnostics := p.Detail
+	if diagnostics == "" {
+		diagnostics = p.Title
+	}
+	outcome.Issue = []Issue{{Severity: "error", Code: code, Diagnostics: diagnostics}}
+	return outcome
+}
+
+// Invalid returns a validation error of a single field, field is the path in
+// the resource, e.g. subject.reference
+func Invalid(field, tag, message string) error {
+	return &validation.BindError{
+		Detail: "validation failed",
+		Fields: []validation.FieldError{{Field: field, Tag: tag, Message: message}},
+	}
+}
+
+// ParseDateTime parses a FHIR date or dateTime, partial dates (year or year
+// and month) aren't supported
+func ParseDateTime(value string) (time.Time, error) {
+	if t, err := time.Parse(format.DateFormat, value); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid dateTime %q", value)
+	}
+	return t, nil
+}
+
+func date(t time.Time) string {
+	return t.Format(format.DateFormat)
+}
+
+func dateTime(t time.Time) string {
+	return t.Format(time.RFC3339)
+}
+
+func meta(updatedAt time.Time) *Meta {
+	return &Meta{LastUpdated: &updatedAt}
+}
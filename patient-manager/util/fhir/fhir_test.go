@@ -0,0 +1,319 @@
+package fhir
+
+import (
+	"PatientManager/model"
+	"PatientManager/util/validation"
+	"encoding/json"
+	"errors"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin/binding"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const testBase = "https://example.org/fhir"
+
+var (
+	testUpdated    = time.Date(2024, 3, 1, 9, 30, 0, 0, time.UTC)
+	patientUuid    = uuid.MustParse("5c1c8a8e-2a53-4b8f-9b8c-0f9a2f1d6e01")
+	illnessUuid    = uuid.MustParse("8d3b6f0a-6f0e-4c39-8a43-2f6d5c9e7b02")
+	prescrUuid     = uuid.MustParse("b7a1e0c4-3d2f-4e1a-9c8b-7a6f5e4d3c03")
+	lineUuid       = uuid.MustParse("c2d4e6f8-1a3b-4c5d-8e7f-9a0b1c2d3e04")
+	medicationUuid = uuid.MustParse("d1e2f3a4-b5c6-4d7e-8f9a-0b1c2d3e4f05")
+	checkupUuid    = uuid.MustParse("e9f8a7b6-c5d4-4e3f-9a2b-1c0d9e8f7a06")
+	imageUuid      = uuid.MustParse("f0e1d2c3-b4a5-4968-8776-655443322107")
+)
+
+// everythingBundle maps a patient with one of each related entity the way
+// IFhirService.Everything does
+func everythingBundle() *Bundle {
+	base := gorm.Model{ID: 1, CreatedAt: testUpdated, UpdatedAt: testUpdated}
+	endDate := time.Date(2024, 2, 20, 0, 0, 0, 0, time.UTC)
+
+	patient := &model.Patient{
+		Model:     base,
+		Uuid:      patientUuid,
+		FirstName: "Ana Marija",
+		LastName:  "Horvat",
+		OIB:       "12345678903",
+		BirthDate: time.Date(1980, 5, 17, 0, 0, 0, 0, time.UTC),
+		Gender:    "F",
+	}
+	illness := &model.Illness{
+		Model:     base,
+		Uuid:      illnessUuid,
+		Name:      "Acute bronchitis",
+		StartDate: time.Date(2024, 2, 10, 0, 0, 0, 0, time.UTC),
+		EndDate:   &endDate,
+	}
+	prescription := &model.Prescription{
+		Model:    base,
+		Uuid:     prescrUuid,
+		IssuedAt: time.Date(2024, 2, 11, 0, 0, 0, 0, time.UTC),
+		Illness:  *illness,
+	}
+	line := &model.PrescriptionLine{
+		Uuid: lineUuid,
+		Medication: model.Medication{
+			Uuid:             medicationUuid,
+			Name:             "Paracetamol",
+			ATCCode:          "N02BE01",
+			ActiveIngredient: "paracetamol",
+			Strength:         "500 mg",
+			DosageForm:       "tablet",
+		},
+	}
+	illnessID := uint(1)
+	checkup := &model.Checkup{
+		Model:       base,
+		Uuid:        checkupUuid,
+		CheckupDate: time.Date(2024, 2, 10, 8, 15, 0, 0, time.UTC),
+		Type:        model.XRayScan,
+		IllnessID:   &illnessID,
+		Illness:     *illness,
+		Images: []model.Image{{
+			Model: base,
+			Uuid:  imageUuid,
+			Path:  "checkups/chest.png",
+		}},
+	}
+
+	bundle := NewSearchset([]Resource{
+		FromPatient(patient),
+		FromIllness(illness, patientUuid),
+		FromPrescriptionLine(line, prescription, patientUuid),
+		FromCheckup(checkup, patientUuid),
+		FromCheckupReport(checkup, patientUuid),
+		FromImage(&checkup.Images[0], checkup, patientUuid),
+	})
+	bundle.SetBase(testBase)
+	return bundle
+}
+
+// rawBundle is a Bundle whose resources are decoded later by their type
+type rawBundle struct {
+	ResourceType string `json:"resourceType"`
+	Entry        []struct {
+		FullURL  string          `json:"fullUrl"`
+		Resource json.RawMessage `json:"resource"`
+	} `json:"entry"`
+}
+
+func readBundle(t *testing.T, name string) rawBundle {
+	t.Helper()
+
+	data, err := os.ReadFile("testdata/" + name)
+	if err != nil {
+		t.Fatalf("failed to read fixture: %v", err)
+	}
+	var bundle rawBundle
+	if err := json.Unmarshal(data, &bundle); err != nil {
+		t.Fatalf("failed to decode fixture: %v", err)
+	}
+	if bundle.ResourceType != "Bundle" {
+		t.Fatalf("fixture %s is a %s, not a Bundle", name, bundle.ResourceType)
+	}
+	return bundle
+}
+
+// decodeResource decodes a creatable resource of a fixture and validates it
+// like FhirController.create
+func decodeResource(t *testing.T, raw json.RawMessage) (Resource, error) {
+	t.Helper()
+
+	var header struct {
+		ResourceType string `json:"resourceType"`
+	}
+	if err := json.Unmarshal(raw, &header); err != nil {
+		t.Fatalf("failed to decode resource type: %v", err)
+	}
+	resource, ok := New(header.ResourceType)
+	if !ok {
+		t.Fatalf("%s can't be created", header.ResourceType)
+	}
+	if err := json.Unmarshal(raw, resource); err != nil {
+		t.Fatalf("failed to decode %s: %v", header.ResourceType, err)
+	}
+	return resource, binding.Validator.ValidateStruct(resource)
+}
+
+func TestMain(m *testing.M) {
+	if err := validation.Register(); err != nil {
+		panic(err)
+	}
+	os.Exit(m.Run())
+}
+
+func TestEverythingBundleMatchesFixture(t *testing.T) {
+	got, err := json.Marshal(everythingBundle())
+	if err != nil {
+		t.Fatalf("failed to encode bundle: %v", err)
+	}
+	want, err := os.ReadFile("testdata/everything.json")
+	if err != nil {
+		t.Fatalf("failed to read fixture: %v", err)
+	}
+
+	var gotValue, wantValue any
+	if err := json.Unmarshal(got, &gotValue); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(want, &wantValue); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(gotValue, wantValue) {
+		t.Errorf("bundle doesn't match testdata/everything.json, got:\n%s", got)
+	}
+}
+
+func TestEverythingBundleRoundTrip(t *testing.T) {
+	bundle := readBundle(t, "everything.json")
+
+	for _, entry := range bundle.Entry {
+		var header struct {
+			ResourceType string `json:"resourceType"`
+			ID           string `json:"id"`
+		}
+		if err := json.Unmarshal(entry.Resource, &header); err != nil {
+			t.Fatal(err)
+		}
+		if want := testBase + "/" + header.ResourceType + "/" + header.ID; entry.FullURL != want {
+			t.Errorf("fullUrl = %s, want %s", entry.FullURL, want)
+		}
+		if _, ok := New(header.ResourceType); !ok {
+			continue
+		}
+
+		t.Run(header.ResourceType, func(t *testing.T) {
+			resource, err := decodeResource(t, entry.Resource)
+			if err != nil {
+				t.Fatalf("mapped %s is invalid: %v", header.ResourceType, err)
+			}
+			encoded, err := json.Marshal(resource)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var gotValue, wantValue any
+			_ = json.Unmarshal(encoded, &gotValue)
+			_ = json.Unmarshal(entry.Resource, &wantValue)
+			if !reflect.DeepEqual(gotValue, wantValue) {
+				t.Errorf("%s changed in a round trip, got:\n%s", header.ResourceType, encoded)
+			}
+		})
+	}
+}
+
+func TestCreateBundleIsValid(t *testing.T) {
+	bundle := readBundle(t, "create-valid.json")
+
+	for i, entry := range bundle.Entry {
+		resource, err := decodeResource(t, entry.Resource)
+		if err != nil {
+			t.Errorf("entry %d: %T is invalid: %v", i, resource, err)
+		}
+	}
+
+	patient, _ := decodeResource(t, bundle.Entry[0].Resource)
+	if oib := patient.(*Patient).OIB(); oib != "12345678903" {
+		t.Errorf("OIB() = %q, want 12345678903", oib)
+	}
+	encounter, _ := decodeResource(t, bundle.Entry[3].Resource)
+	if checkupType := encounter.(*Encounter).CheckupType(); checkupType != model.XRayScan {
+		t.Errorf("CheckupType() = %q, want X-RAY", checkupType)
+	}
+}
+
+func TestCreateBundleIsInvalid(t *testing.T) {
+	bundle := readBundle(t, "create-invalid.json")
+
+	// invalid fields of each entry, in the order of the fixture
+	want := [][]string{
+		{"name", "gender", "birthDate"},
+		{"given[0]"},
+		{"onsetDateTime"},
+		{"status", "intent", "authoredOn"},
+		{"status", "type"},
+	}
+	if len(bundle.Entry) != len(want) {
+		t.Fatalf("fixture has %d entries, want %d", len(bundle.Entry), len(want))
+	}
+
+	for i, entry := range bundle.Entry {
+		_, err := decodeResource(t, entry.Resource)
+		if err == nil {
+			t.Errorf("entry %d is valid", i)
+			continue
+		}
+
+		var bindErr *validation.BindError
+		if !errors.As(validation.NewBindError(err), &bindErr) {
+			t.Fatalf("entry %d: unexpected error %v", i, err)
+		}
+		var fields []string
+		for _, field := range bindErr.Fields {
+			fields = append(fields, lastPathElement(field.Field))
+		}
+		sort.Strings(fields)
+		expected := append([]string(nil), want[i]...)
+		sort.Strings(expected)
+		if !reflect.DeepEqual(fields, expected) {
+			t.Errorf("entry %d: invalid fields = %v, want %v", i, fields, expected)
+		}
+	}
+}
+
+// lastPathElement returns the field of a path, e.g. given[0] of name[0].given[0]
+func lastPathElement(path string) string {
+	if i := strings.LastIndex(path, "."); i >= 0 {
+		return path[i+1:]
+	}
+	return path
+}
+
+func TestReferenceID(t *testing.T) {
+	tests := []struct {
+		reference *Reference
+		want      uuid.UUID
+		wantOk    bool
+	}{
+		{&Reference{Reference: "Patient/" + patientUuid.String()}, patientUuid, true},
+		{&Reference{Reference: "Condition/" + patientUuid.String()}, uuid.Nil, false},
+		{&Reference{Reference: "Patient/123"}, uuid.Nil, false},
+		{&Reference{Reference: patientUuid.String()}, uuid.Nil, false},
+		{nil, uuid.Nil, false},
+	}
+
+	for _, tt := range tests {
+		got, ok := tt.reference.ID(TypePatient)
+		if got != tt.want || ok != tt.wantOk {
+			t.Errorf("ID(%v) = %v, %v, want %v, %v", tt.reference, got, ok, tt.want, tt.wantOk)
+		}
+	}
+}
+
+func TestParseDateTime(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    time.Time
+		wantErr bool
+	}{
+		{"2024-02-10", time.Date(2024, 2, 10, 0, 0, 0, 0, time.UTC), false},
+		{"2024-02-10T08:15:00Z", time.Date(2024, 2, 10, 8, 15, 0, 0, time.UTC), false},
+		{"2024-02", time.Time{}, true},
+		{"10.02.2024.", time.Time{}, true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseDateTime(tt.value)
+		if (err != nil) != tt.wantErr || !got.Equal(tt.want) {
+			t.Errorf("ParseDateTime(%q) = %v, %v, want %v, error %v", tt.value, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,301 @@
+package fhir
+
+import (
+	"PatientManager/model"
+	"mime"
+	"path"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// ImageURL is the path under which checkup images are downloaded, Media
+// content links to it
+const ImageURL = "/api/checkup/image/"
+
+// Patient maps model.Patient, the OIB is an identifier of OIBSystem. Erased
+// patients have no identifier.
+type Patient struct {
+	ResourceType        string       `json:"resourceType" binding:"required,eq=Patient"`
+	ID                  string       `json:"id,omitempty"`
+	Meta                *Meta        `json:"meta,omitempty"`
+	Identifier          []Identifier `json:"identifier,omitempty"`
+	Name                []HumanName  `json:"name" binding:"required,min=1,dive"`
+	Gender              string       `json:"gender" binding:"required,oneof=male female"`
+	BirthDate           string       `json:"birthDate" binding:"required,datetime=2006-01-02,pastdate"`
+	GeneralPractitioner []Reference  `json:"generalPractitioner,omitempty"`
+}
+
+func (p *Patient) Reference() string {
+	return TypePatient + "/" + p.ID
+}
+
+// OIB returns the value of the OIBSystem identifier
+func (p *Patient) OIB() string {
+	for _, identifier := range p.Identifier {
+		if identifier.System == OIBSystem {
+			return identifier.Value
+		}
+	}
+	return ""
+}
+
+// ModelGender returns the gender as stored in model.Patient
+func (p *Patient) ModelGender() string {
+	if p.Gender == "female" {
+		return "F"
+	}
+	return "M"
+}
+
+// FromPatient maps a patient, its doctor is used as general practitioner if loaded
+func FromPatient(patient *model.Patient) *Patient {
+	resource := &Patient{
+		ResourceType: TypePatient,
+		ID:           patient.Uuid.String(),
+		Meta:         meta(patient.UpdatedAt),
+		Name: []HumanName{{
+			Use:    "official",
+			Family: patient.LastName,
+			Given:  strings.Fields(patient.FirstName),
+		}},
+		Gender:    "male",
+		BirthDate: date(patient.BirthDate),
+	}
+	if patient.ErasedAt == nil {
+		resource.Identifier = []Identifier{{System: OIBSystem, Value: patient.OIB}}
+	}
+	if strings.EqualFold(patient.Gender, "F") {
+		resource.Gender = "female"
+	}
+	if patient.DoctorID != nil && patient.Doctor.ID != 0 {
+		resource.GeneralPractitioner = []Reference{{
+			Display: patient.Doctor.FirstName + " " + patient.Doctor.LastName,
+		}}
+	}
+	return resource
+}
+
+// Condition maps model.Illness, the name is the text of the code
+type Condition struct {
+	ResourceType      string           `json:"resourceType" binding:"required,eq=Condition"`
+	ID                string           `json:"id,omitempty"`
+	Meta              *Meta            `json:"meta,omitempty"`
+	ClinicalStatus    *CodeableConcept `json:"clinicalStatus,omitempty"`
+	Code              CodeableConcept  `json:"code"`
+	Subject           Reference        `json:"subject"`
+	OnsetDateTime     string           `json:"onsetDateTime" binding:"required"`
+	AbatementDateTime string           `json:"abatementDateTime,omitempty"`
+	RecordedDate      string           `json:"recordedDate,omitempty"`
+}
+
+func (c *Condition) Reference() string {
+	return TypeCondition + "/" + c.ID
+}
+
+func FromIllness(illness *model.Illness, patientUuid uuid.UUID) *Condition {
+	status := "active"
+	abatement := ""
+	if illness.EndDate != nil {
+		status = "resolved"
+		abatement = date(*illness.EndDate)
+	}
+
+	return &Condition{
+		ResourceType: TypeCondition,
+		ID:           illness.Uuid.String(),
+		Meta:         meta(illness.UpdatedAt),
+		ClinicalStatus: &CodeableConcept{
+			Coding: []Coding{{System: conditionClinicalSystem, Code: status}},
+		},
+		Code:              CodeableConcept{Text: illness.Name},
+		Subject:           reference(TypePatient, patientUuid),
+		OnsetDateTime:     date(illness.StartDate),
+		AbatementDateTime: abatement,
+		RecordedDate:      date(illness.CreatedAt),
+	}
+}
+
+// MedicationRequest maps a model.PrescriptionLine, lines of the same
+// prescription share the group identifier
+type MedicationRequest struct {
+	ResourceType              string           `json:"resourceType" binding:"required,eq=MedicationRequest"`
+	ID                        string           `json:"id,omitempty"`
+	Meta                      *Meta            `json:"meta,omitempty"`
+	GroupIdentifier           *Identifier      `json:"groupIdentifier,omitempty"`
+	Status                    string           `json:"status" binding:"required,eq=active"`
+	Intent                    string           `json:"intent" binding:"required,eq=order"`
+	MedicationCodeableConcept *CodeableConcept `json:"medicationCodeableConcept"`
+	Subject                   Reference        `json:"subject"`
+	AuthoredOn                string           `json:"authoredOn" binding:"required"`
+	ReasonReference           []Reference      `json:"reasonReference,omitempty"`
+}
+
+func (m *MedicationRequest) Reference() string {
+	return TypeMedicationRequest + "/" + m.ID
+}
+
+// FromPrescriptionLine maps a line of the prescription, the line's
+// medication and the prescription's illness must be loaded. The medication
+// is coded by its UUID and by its ATC code if it has one.
+func FromPrescriptionLine(line *model.PrescriptionLine, prescription *model.Prescription, patientUuid uuid.UUID) *MedicationRequest {
+	medication := &line.Medication
+	coding := []Coding{{System: MedicationSystem, Code: medication.Uuid.String(), Display: medication.Name}}
+	if medication.ATCCode != "" {
+		coding = append(coding, Coding{System: ATCSystem, Code: medication.ATCCode, Display: medication.ActiveIngredient})
+	}
+
+	return &MedicationRequest{
+		ResourceType:    TypeMedicationRequest,
+		ID:              line.Uuid.String(),
+		Meta:            meta(prescription.UpdatedAt),
+		GroupIdentifier: &Identifier{System: "urn:ietf:rfc:3986", Value: "urn:uuid:" + prescription.Uuid.String()},
+		Status:          "active",
+		Intent:          "order",
+		MedicationCodeableConcept: &CodeableConcept{
+			Coding: coding,
+			Text:   medicationText(medication),
+		},
+		Subject:         reference(TypePatient, patientUuid),
+		AuthoredOn:      date(prescription.IssuedAt),
+		ReasonReference: []Reference{reference(TypeCondition, prescription.Illness.Uuid)},
+	}
+}
+
+// medicationText describes the medication by its name, strength and dosage
+// form, e.g. "Paracetamol 500 mg tablet"
+func medicationText(medication *model.Medication) string {
+	parts := []string{medication.Name}
+	for _, part := range []string{medication.Strength, medication.DosageForm} {
+		if part != "" {
+			parts = append(parts, part)
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+// Encounter maps model.Checkup, the checkup type is coded in CheckupTypeSystem
+type Encounter struct {
+	ResourceType    string            `json:"resourceType" binding:"required,eq=Encounter"`
+	ID              string            `json:"id,omitempty"`
+	Meta            *Meta             `json:"meta,omitempty"`
+	Status          string            `json:"status" binding:"required,eq=finished"`
+	Class           Coding            `json:"class"`
+	Type            []CodeableConcept `json:"type" binding:"required,min=1"`
+	Subject         Reference         `json:"subject"`
+	Period          Period            `json:"period"`
+	ReasonReference []Reference       `json:"reasonReference,omitempty"`
+}
+
+func (e *Encounter) Reference() string {
+	return TypeEncounter + "/" + e.ID
+}
+
+// CheckupType returns the code of the first type from CheckupTypeSystem
+func (e *Encounter) CheckupType() model.CheckupType {
+	for _, t := range e.Type {
+		if code := t.Code(CheckupTypeSystem); code != "" {
+			return model.CheckupType(code)
+		}
+	}
+	return ""
+}
+
+// FromCheckup maps a checkup, its illness is used as the reason if loaded
+func FromCheckup(checkup *model.Checkup, patientUuid uuid.UUID) *Encounter {
+	encounter := &Encounter{
+		ResourceType: TypeEncounter,
+		ID:           checkup.Uuid.String(),
+		Meta:         meta(checkup.UpdatedAt),
+		Status:       "finished",
+		Class:        Coding{System: actCodeSystem, Code: "AMB", Display: "ambulatory"},
+		Type:         []CodeableConcept{checkupType(checkup.Type)},
+		Subject:      reference(TypePatient, patientUuid),
+		Period:       Period{Start: dateTime(checkup.CheckupDate)},
+	}
+	if checkup.IllnessID != nil && checkup.Illness.ID != 0 {
+		encounter.ReasonReference = []Reference{reference(TypeCondition, checkup.Illness.Uuid)}
+	}
+	return encounter
+}
+
+// DiagnosticReport is the result of a model.Checkup, it has the same ID as
+// the checkup's Encounter and links to its images
+type DiagnosticReport struct {
+	ResourceType      string            `json:"resourceType"`
+	ID                string            `json:"id"`
+	Meta              *Meta             `json:"meta,omitempty"`
+	Status            string            `json:"status"`
+	Code              CodeableConcept   `json:"code"`
+	Subject           Reference         `json:"subject"`
+	Encounter         Reference         `json:"encounter"`
+	EffectiveDateTime string            `json:"effectiveDateTime"`
+	Media             []DiagnosticMedia `json:"media,omitempty"`
+}
+
+type DiagnosticMedia struct {
+	Link Reference `json:"link"`
+}
+
+func (d *DiagnosticReport) Reference() string {
+	return TypeDiagnosticReport + "/" + d.ID
+}
+
+// FromCheckupReport maps a checkup with its images loaded
+func FromCheckupReport(checkup *model.Checkup, patientUuid uuid.UUID) *DiagnosticReport {
+	report := &DiagnosticReport{
+		ResourceType:      TypeDiagnosticReport,
+		ID:                checkup.Uuid.String(),
+		Meta:              meta(checkup.UpdatedAt),
+		Status:            "final",
+		Code:              checkupType(checkup.Type),
+		Subject:           reference(TypePatient, patientUuid),
+		Encounter:         reference(TypeEncounter, checkup.Uuid),
+		EffectiveDateTime: dateTime(checkup.CheckupDate),
+	}
+	for _, image := range checkup.Images {
+		report.Media = append(report.Media, DiagnosticMedia{Link: reference(TypeMedia, image.Uuid)})
+	}
+	return report
+}
+
+// Media maps model.Image, the content links to the image download
+type Media struct {
+	ResourceType    string     `json:"resourceType"`
+	ID              string     `json:"id"`
+	Meta            *Meta      `json:"meta,omitempty"`
+	Status          string     `json:"status"`
+	Subject         Reference  `json:"subject"`
+	Encounter       Reference  `json:"encounter"`
+	CreatedDateTime string     `json:"createdDateTime"`
+	Content         Attachment `json:"content"`
+}
+
+func (m *Media) Reference() string {
+	return TypeMedia + "/" + m.ID
+}
+
+// FromImage maps an image of the checkup
+func FromImage(image *model.Image, checkup *model.Checkup, patientUuid uuid.UUID) *Media {
+	return &Media{
+		ResourceType:    TypeMedia,
+		ID:              image.Uuid.String(),
+		Meta:            meta(image.UpdatedAt),
+		Status:          "completed",
+		Subject:         reference(TypePatient, patientUuid),
+		Encounter:       reference(TypeEncounter, checkup.Uuid),
+		CreatedDateTime: dateTime(image.CreatedAt),
+		Content: Attachment{
+			ContentType: mime.TypeByExtension(path.Ext(image.Path)),
+			URL:         ImageURL + image.Path,
+			Title:       path.Base(image.Path),
+		},
+	}
+}
+
+func checkupType(t model.CheckupType) CodeableConcept {
+	return CodeableConcept{
+		Coding: []Coding{{System: CheckupTypeSystem, Code: string(t)}},
+		Text:   string(t),
+	}
+}
@@ -0,0 +1,303 @@
+// Package hl7 parses HL7 v2 messages (ER7 encoding), builds acknowledgements
+// and serves them over MLLP
+package hl7
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ContentType of HL7 v2 messages sent over HTTP
+const ContentType = "x-application/hl7-v2+er7"
+
+// TimestampFormat of HL7 TS values with second precision
+const TimestampFormat = "20060102150405"
+
+// Acknowledgement codes (MSA-1)
+const (
+	AckAccept = "AA"
+	AckError  = "AE"
+	AckReject = "AR"
+)
+
+// Error is a problem with the content of a message, it's reported to the
+// sender in the acknowledgement
+type Error struct {
+	// Code is AckError or AckReject
+	Code string
+	Text string
+}
+
+func (e *Error) Error() string {
+	return e.Text
+}
+
+// Errorf returns an Error acknowledged with AckError, the message was
+// understood but couldn't be applied
+func Errorf(format string, args ...any) error {
+	return &Error{Code: AckError, Text: fmt.Sprintf(format, args...)}
+}
+
+// Rejectf returns an Error acknowledged with AckReject, the message is
+// malformed or not supported
+func Rejectf(format string, args ...any) error {
+	return &Error{Code: AckReject, Text: fmt.Sprintf(format, args...)}
+}
+
+// Delimiters are the encoding characters declared in MSH-1 and MSH-2
+type Delimiters struct {
+	Field        byte
+	Component    byte
+	Repetition   byte
+	Escape       byte
+	Subcomponent byte
+}
+
+var defaultDelimiters = Delimiters{'|', '^', '~', '\\', '&'}
+
+// Message is a parsed message, it starts with the MSH segment
+type Message struct {
+	Segments   []*Segment
+	delimiters Delimiters
+}
+
+// Segment is one line of a message, fields are numbered from 1 like in the
+// standard (MSH-1 is the field separator)
+type Segment struct {
+	Name       string
+	fields     []string
+	delimiters *Delimiters
+}
+
+// Parse parses a message, segments can be separated by CR, LF or CRLF
+func Parse(raw string) (*Message, error) {
+	raw = strings.TrimSpace(strings.ReplaceAll(raw, "\r\n", "\r"))
+	raw = strings.ReplaceAll(raw, "\n", "\r")
+	if !strings.HasPrefix(raw, "MSH") || len(raw) < 8 {
+		return nil, Rejectf("message must start with an MSH segment")
+	}
+
+	d := Delimiters{
+		Field:        raw[3],
+		Component:    raw[4],
+		Repetition:   raw[5],
+		Escape:       raw[6],
+		Subcomponent: raw[7],
+	}
+	if d.Subcomponent == d.Field {
+		// MSH-2 without the subcomponent separator
+		d.Subcomponent = defaultDelimiters.Subcomponent
+	}
+
+	message := &Message{delimiters: d}
+	for _, line := range strings.Split(raw, "\r") {
+		if line == "" {
+			continue
+		}
+		if len(line) < 3 {
+			return nil, Rejectf("invalid segment %q", line)
+		}
+
+		fields := strings.Split(line, string(d.Field))
+		segment := &Segment{Name: fields[0], delimiters: &message.delimiters}
+		if segment.Name == "MSH" {
+			// MSH-1 is the field separator itself
+			segment.fields = append([]string{string(d.Field)}, fields[1:]...)
+		} else {
+			segment.fields = fields[1:]
+		}
+		message.Segments = append(message.Segments, segment)
+	}
+
+	msh := message.Segments[0]
+	if msh.Field(9) == "" {
+		return nil, Rejectf("MSH-9 message type is missing")
+	}
+	return message, nil
+}
+
+// Segment returns the first segment with the name, or nil
+func (m *Message) Segment(name string) *Segment {
+	for _, segment := range m.Segments {
+		if segment.Name == name {
+			return segment
+		}
+	}
+	return nil
+}
+
+// Type returns the message code and trigger event, e.g. ADT and A01
+func (m *Message) Type() (code, event string) {
+	msh := m.Segments[0]
+	return msh.Component(9, 1), msh.Component(9, 2)
+}
+
+// ControlID returns MSH-10
+func (m *Message) ControlID() string {
+	return m.Segments[0].Field(10)
+}
+
+// Field returns field n unescaped, with all repetitions and components
+func (s *Segment) Field(n int) string {
+	if n < 1 || n > len(s.fields) {
+		return ""
+	}
+	if s.Name == "MSH" && n <= 2 {
+		return s.fields[n-1]
+	}
+	return s.unescape(s.fields[n-1])
+}
+
+// Repetitions returns the raw repetitions of field n
+func (s *Segment) Repetitions(n int) []string {
+	if n < 1 || n > len(s.fields) || s.fields[n-1] == "" {
+		return nil
+	}
+	return strings.Split(s.fields[n-1], string(s.delimiters.Repetition))
+}
+
+// Component returns component c of the first repetition of field n, unescaped
+func (s *Segment) Component(n, c int) string {
+	repetitions := s.Repetitions(n)
+	if len(repetitions) == 0 {
+		return ""
+	}
+	return s.ComponentOf(repetitions[0], c)
+}
+
+// ComponentOf returns component c of a raw repetition, unescaped
+func (s *Segment) ComponentOf(repetition string, c int) string {
+	components := strings.Split(repetition, string(s.delimiters.Component))
+	if c < 1 || c > len(components) {
+		return ""
+	}
+	// subcomponents aren't used, only the first one is kept
+	value, _, _ := strings.Cut(components[c-1], string(s.delimiters.Subcomponent))
+	return s.unescape(value)
+}
+
+// unescape replaces escape sequences of the delimiters, other sequences
+// (formatting, hexadecimal data) are removed
+func (s *Segment) unescape(value string) string {
+	escape := s.delimiters.Escape
+	if strings.IndexByte(value, escape) < 0 {
+		return value
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		if value[i] != escape {
+			b.WriteByte(value[i])
+			continue
+		}
+		end := strings.IndexByte(value[i+1:], escape)
+		if end < 0 {
+			b.WriteString(value[i:])
+			break
+		}
+		switch value[i+1 : i+1+end] {
+		case "F":
+			b.WriteByte(s.delimiters.Field)
+		case "S":
+			b.WriteByte(s.delimiters.Component)
+		case "R":
+			b.WriteByte(s.delimiters.Repetition)
+		case "E":
+			b.WriteByte(s.delimiters.Escape)
+		case "T":
+			b.WriteByte(s.delimiters.Subcomponent)
+		}
+		i += end + 1
+	}
+	return b.String()
+}
+
+// ParseTime parses an HL7 DT or TS value, precision finer than seconds and
+// the time zone offset are ignored
+func ParseTime(value string) (time.Time, error) {
+	digits := value
+	if i := strings.IndexAny(digits, ".+-"); i >= 0 {
+		digits = digits[:i]
+	}
+
+	layouts := map[int]string{
+		8:  "20060102",
+		12: "200601021504",
+		14: TimestampFormat,
+	}
+	layout, ok := layouts[len(digits)]
+	if !ok {
+		return time.Time{}, fmt.Errorf("invalid HL7 time %q", value)
+	}
+	return time.Parse(layout, digits)
+}
+
+// Ack builds the acknowledgement of a message, message is nil if it couldn't
+// be parsed. Application and facility identify the receiver in MSH-3 and MSH-4.
+func Ack(message *Message, code, text, application, facility string) string {
+	d := defaultDelimiters
+	if message != nil {
+		d = message.delimiters
+	}
+	escape := escaper(d)
+	f := string(d.Field)
+
+	var receivingApp, receivingFacility, event, controlID, version string
+	if message != nil {
+		msh := message.Segments[0]
+		receivingApp, receivingFacility = msh.fields[2], msh.fields[3]
+		if value := msh.Field(5); value != "" {
+			application = value
+		}
+		if value := msh.Field(6); value != "" {
+			facility = value
+		}
+		_, event = message.Type()
+		controlID = message.ControlID()
+		version = msh.Component(12, 1)
+	}
+	if version == "" {
+		version = "2.5"
+	}
+
+	msh := strings.Join([]string{
+		"MSH",
+		string([]byte{d.Component, d.Repetition, d.Escape, d.Subcomponent}),
+		escape(application),
+		escape(facility),
+		receivingApp,
+		receivingFacility,
+		time.Now().Format(TimestampFormat),
+		"",
+		"ACK" + string(d.Component) + escape(event) + string(d.Component) + "ACK",
+		strings.ReplaceAll(uuid.NewString(), "-", "")[:20],
+		"P",
+		version,
+	}, f)
+	msa := strings.Join([]string{"MSA", code, escape(controlID), escape(text)}, f)
+
+	segments := []string{msh, msa}
+	if code != AckAccept {
+		// ERR-3 is the HL7 error code, 207 is an application internal error
+		// and ERR-4 the severity
+		segments = append(segments, strings.Join([]string{"ERR", "", "", "207" + string(d.Component) + escape(text), "E"}, f))
+	}
+	return strings.Join(segments, "\r") + "\r"
+}
+
+// escaper escapes delimiters in values written to a message
+func escaper(d Delimiters) func(string) string {
+	replacer := strings.NewReplacer(
+		string(d.Escape), string(d.Escape)+"E"+string(d.Escape),
+		string(d.Field), string(d.Escape)+"F"+string(d.Escape),
+		string(d.Component), string(d.Escape)+"S"+string(d.Escape),
+		string(d.Repetition), string(d.Escape)+"R"+string(d.Escape),
+		string(d.Subcomponent), string(d.Escape)+"T"+string(d.Escape),
+		"\r", " ",
+		"\n", " ",
+	)
+	return replacer.Replace
+}
@@ -0,0 +1,230 @@
+package hl7
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+const admission = "MSH|^~\\&|ADMIT|HOSP|PM|CLINIC|20240210081500||ADT^A01^ADT_A01|MSG00001|P|2.5\r" +
+	"PID|1||99999^^^MRN~12345678903^^^^OIB||Horvat^Ana^Marija||19800517|F\r" +
+	"NTE|1||Allergic to peni\\T\\cillin \\F\\ dust\\.br\\ \\S\\ 2\\E\\"
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name     string
+		raw      string
+		segments []string
+		wantErr  string
+	}{
+		{"CR separated", admission, []string{"MSH", "PID", "NTE"}, ""},
+		{"LF separated", strings.ReplaceAll(admission, "\r", "\n"), []string{"MSH", "PID", "NTE"}, ""},
+		{"CRLF separated with blank lines", strings.ReplaceAll(admission, "\r", "\r\n\r\n") + "\r\n", []string{"MSH", "PID", "NTE"}, ""},
+		{"other delimiters", "MSH#*@!%#A#B#C#D##ORU*R01#1\rOBX#1", []string{"MSH", "OBX"}, ""},
+		{"no MSH", "PID|1||12345678903", nil, "message must start with an MSH segment"},
+		{"empty", "", nil, "message must start with an MSH segment"},
+		{"short segment", "MSH|^~\\&|A|B|C|D|||ADT^A01|1\rPI", nil, `invalid segment "PI"`},
+		{"no message type", "MSH|^~\\&|A|B|C|D||||1", nil, "MSH-9 message type is missing"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			message, err := Parse(tt.raw)
+			if tt.wantErr != "" {
+				var hl7Err *Error
+				if !errors.As(err, &hl7Err) || hl7Err.Code != AckReject || hl7Err.Text != tt.wantErr {
+					t.Fatalf("Parse() error = %v, want rejection %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+
+			var names []string
+			for _, segment := range message.Segments {
+				names = append(names, segment.Name)
+			}
+			if strings.Join(names, ",") != strings.Join(tt.segments, ",") {
+				t.Errorf("segments = %v, want %v", names, tt.segments)
+			}
+		})
+	}
+}
+
+func TestMessageFields(t *testing.T) {
+	message, err := Parse(admission)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msh, pid, nte := message.Segments[0], message.Segment("PID"), message.Segment("NTE")
+
+	code, event := message.Type()
+	tests := []struct {
+		name string
+		got  string
+		want string
+	}{
+		{"message code", code, "ADT"},
+		{"trigger event", event, "A01"},
+		{"control ID", message.ControlID(), "MSG00001"},
+		{"MSH-1", msh.Field(1), "|"},
+		{"MSH-2", msh.Field(2), "^~\\&"},
+		{"whole field", pid.Field(5), "Horvat^Ana^Marija"},
+		{"component", pid.Component(5, 2), "Ana"},
+		{"component of the first repetition", pid.Component(3, 1), "99999"},
+		{"component of another repetition", pid.ComponentOf(pid.Repetitions(3)[1], 5), "OIB"},
+		{"missing component", pid.Component(5, 9), ""},
+		{"missing field", pid.Field(30), ""},
+		{"field zero", pid.Field(0), ""},
+		{"escape sequences", nte.Field(3), "Allergic to peni&cillin | dust ^ 2\\"},
+	}
+
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("%s = %q, want %q", tt.name, tt.got, tt.want)
+		}
+	}
+
+	if repetitions := pid.Repetitions(3); len(repetitions) != 2 {
+		t.Errorf("Repetitions(3) = %v, want 2 repetitions", repetitions)
+	}
+	if repetitions := pid.Repetitions(2); repetitions != nil {
+		t.Errorf("Repetitions(2) of an empty field = %v, want nil", repetitions)
+	}
+}
+
+func TestParseTime(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    time.Time
+		wantErr bool
+	}{
+		{"19800517", time.Date(1980, 5, 17, 0, 0, 0, 0, time.UTC), false},
+		{"202402100815", time.Date(2024, 2, 10, 8, 15, 0, 0, time.UTC), false},
+		{"20240210081530", time.Date(2024, 2, 10, 8, 15, 30, 0, time.UTC), false},
+		{"20240210081530.1234+0100", time.Date(2024, 2, 10, 8, 15, 30, 0, time.UTC), false},
+		{"20240210081530-0500", time.Date(2024, 2, 10, 8, 15, 30, 0, time.UTC), false},
+		{"2024021", time.Time{}, true},
+		{"20241310", time.Time{}, true},
+		{"", time.Time{}, true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseTime(tt.value)
+		if (err != nil) != tt.wantErr || !got.Equal(tt.want) {
+			t.Errorf("ParseTime(%q) = %v, %v, want %v, error %v", tt.value, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestAck(t *testing.T) {
+	message, err := Parse(admission)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		message *Message
+		code    string
+		text    string
+		// fields of the acknowledgement, by segment and field number
+		want map[string]map[int]string
+		// segments of the acknowledgement
+		segments int
+	}{
+		{
+			name:    "accepted",
+			message: message,
+			code:    AckAccept,
+			want: map[string]map[int]string{
+				"MSH": {3: "PM", 4: "CLINIC", 5: "ADMIT", 6: "HOSP", 9: "ACK^A01^ACK", 12: "2.5"},
+				"MSA": {1: "AA", 2: "MSG00001"},
+			},
+			segments: 2,
+		},
+		{
+			name:    "rejected with escaped text",
+			message: message,
+			code:    AckReject,
+			text:    "PID-3 must be a|b^c",
+			want: map[string]map[int]string{
+				"MSA": {1: "AR", 2: "MSG00001", 3: "PID-3 must be a|b^c"},
+				"ERR": {4: "E"},
+			},
+			segments: 3,
+		},
+		{
+			name: "message that can't be parsed",
+			code: AckReject,
+			text: "message must start with an MSH segment",
+			want: map[string]map[int]string{
+				"MSH": {3: "APP", 4: "FACILITY", 5: "", 9: "ACK^^ACK", 12: "2.5"},
+				"MSA": {1: "AR", 2: ""},
+			},
+			segments: 3,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ack, err := Parse(Ack(tt.message, tt.code, tt.text, "APP", "FACILITY"))
+			if err != nil {
+				t.Fatalf("acknowledgement can't be parsed: %v", err)
+			}
+			if len(ack.Segments) != tt.segments {
+				t.Errorf("acknowledgement has %d segments, want %d", len(ack.Segments), tt.segments)
+			}
+			for name, fields := range tt.want {
+				segment := ack.Segment(name)
+				if segment == nil {
+					t.Fatalf("%s segment is missing", name)
+				}
+				for n, want := range fields {
+					if got := segment.Field(n); got != want {
+						t.Errorf("%s-%d = %q, want %q", name, n, got, want)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestFrames(t *testing.T) {
+	var buf bytes.Buffer
+	buf.WriteString("noise")
+	for _, message := range []string{admission, "MSH|^~\\&|A|B|C|D||ACK|2"} {
+		if err := WriteFrame(&buf, message); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r := bufio.NewReader(&buf)
+	for _, want := range []string{admission, "MSH|^~\\&|A|B|C|D||ACK|2"} {
+		got, err := ReadFrame(r)
+		if err != nil || got != want {
+			t.Fatalf("ReadFrame() = %q, %v, want %q", got, err, want)
+		}
+	}
+	if _, err := ReadFrame(r); !errors.Is(err, io.EOF) {
+		t.Errorf("ReadFrame() after the last frame error = %v, want EOF", err)
+	}
+
+	truncated := bufio.NewReader(strings.NewReader(string(rune(startBlock)) + "MSH|"))
+	if _, err := ReadFrame(truncated); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("ReadFrame() of a truncated frame error = %v, want unexpected EOF", err)
+	}
+
+	large := bufio.NewReader(io.MultiReader(
+		strings.NewReader(string(rune(startBlock))),
+		strings.NewReader(strings.Repeat("x", MaxMessageSize+1)),
+	))
+	if _, err := ReadFrame(large); !errors.Is(err, errFrameTooLarge) {
+		t.Errorf("ReadFrame() of a large frame error = %v, want %v", err, errFrameTooLarge)
+	}
+}
@@ -0,0 +1,143 @@
+package hl7
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// MLLP frame bytes
+const (
+	startBlock     = 0x0b
+	endBlock       = 0x1c
+	carriageReturn = 0x0d
+)
+
+// MaxMessageSize limits messages received over MLLP and HTTP
+const MaxMessageSize = 1 << 20
+
+const (
+	// idleTimeout closes connections that don't send messages
+	idleTimeout  = 5 * time.Minute
+	writeTimeout = 10 * time.Second
+	acceptRetry  = time.Second
+)
+
+var errFrameTooLarge = errors.New("MLLP frame is too large")
+
+// Handler processes a message received from remoteAddr (host:port) and
+// returns its acknowledgement
+type Handler func(message, remoteAddr string) string
+
+// Serve handles MLLP connections accepted by listener until ctx is cancelled,
+// messages of a connection are handled in order
+func Serve(ctx context.Context, listener net.Listener, handler Handler) {
+	stop := context.AfterFunc(ctx, func() {
+		listener.Close()
+	})
+	defer stop()
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			zap.S().Errorf("Failed to accept MLLP connection, err = %+v", err)
+			time.Sleep(acceptRetry)
+			continue
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			serveConn(ctx, conn, handler)
+		}()
+	}
+}
+
+func serveConn(ctx context.Context, conn net.Conn, handler Handler) {
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() {
+		conn.Close()
+	})
+	defer stop()
+
+	remoteAddr := conn.RemoteAddr().String()
+	zap.S().Debugf("Accepted MLLP connection from %s", remoteAddr)
+
+	reader := bufio.NewReader(conn)
+	for {
+		_ = conn.SetReadDeadline(time.Now().Add(idleTimeout))
+		message, err := ReadFrame(reader)
+		if err != nil {
+			if !errors.Is(err, io.EOF) && ctx.Err() == nil {
+				zap.S().Warnf("Closing MLLP connection from %s, err = %+v", remoteAddr, err)
+			}
+			return
+		}
+
+		ack := handler(message, remoteAddr)
+
+		_ = conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+		if err := WriteFrame(conn, ack); err != nil {
+			zap.S().Warnf("Failed to send MLLP acknowledgement to %s, err = %+v", remoteAddr, err)
+			return
+		}
+	}
+}
+
+// ReadFrame reads the next MLLP frame, bytes before the start block are skipped
+func ReadFrame(r *bufio.Reader) (string, error) {
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return "", err
+		}
+		if b == startBlock {
+			break
+		}
+	}
+
+	var buf bytes.Buffer
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return "", io.ErrUnexpectedEOF
+			}
+			return "", err
+		}
+		if b == endBlock {
+			break
+		}
+		if buf.Len() >= MaxMessageSize {
+			return "", errFrameTooLarge
+		}
+		buf.WriteByte(b)
+	}
+
+	// the trailing carriage return isn't awaited, it's skipped with other
+	// bytes before the next start block
+	return buf.String(), nil
+}
+
+// WriteFrame writes message in an MLLP frame
+func WriteFrame(w io.Writer, message string) error {
+	frame := make([]byte, 0, len(message)+3)
+	frame = append(frame, startBlock)
+	frame = append(frame, message...)
+	frame = append(frame, endBlock, carriageReturn)
+	_, err := w.Write(frame)
+	return err
+}
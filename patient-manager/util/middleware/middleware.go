@@ -3,6 +3,7 @@ package middleware
 import (
 	"PatientManager/model"
 	"PatientManager/util/auth"
+	"PatientManager/util/cerror"
 	"net/http"
 	"slices"
 
@@ -10,6 +11,14 @@ import (
 	"go.uber.org/zap"
 )
 
+// ClaimsKey is the gin context key under which parsed token claims are stored
+const ClaimsKey = "claims"
+
+// Policy maps a route ("METHOD /full/path", as returned by gin.Context.FullPath)
+// to roles allowed to call it. An empty role list allows any authenticated user,
+// routes missing from the policy are always rejected.
+type Policy map[string][]model.UserRole
+
 var OptionsHandler gin.HandlerFunc = func(c *gin.Context) {
 	c.Status(http.StatusNoContent)
 }
@@ -18,29 +27,83 @@ var OptionsHandler gin.HandlerFunc = func(c *gin.Context) {
 // if roles are empty they it only checks for the validity of tokens
 func Protect(roles ...model.UserRole) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		authHeader := c.GetHeader("Authorization")
-		if authHeader == "" {
-			c.AbortWithStatusJSON(http.StatusUnauthorized, "Missing token")
+		claims, ok := authenticate(c)
+		if !ok {
 			return
 		}
 
-		token, claims, err := auth.ParseToken(authHeader)
-		if err != nil {
-			zap.S().Debugf("Auth failed with err = %+v", err)
-			c.AbortWithStatusJSON(http.StatusUnauthorized, "Invalid token format")
+		if len(roles) != 0 && !slices.Contains(roles, claims.Role) {
+			Abort(c, cerror.ErrForbidden)
 			return
 		}
 
-		if !token.Valid {
-			c.AbortWithStatusJSON(http.StatusUnauthorized, "Invalid token")
+		c.Next()
+	}
+}
+
+// Authorize protects every route of a group using the given policy, it works
+// the same as Protect but roles are looked up per route
+func Authorize(policy Policy) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		claims, ok := authenticate(c)
+		if !ok {
+			return
+		}
+
+		route := c.Request.Method + " " + c.FullPath()
+		roles, found := policy[route]
+		if !found {
+			zap.S().Warnf("No authorization policy for route %s, access denied", route)
+			Abort(c, cerror.ErrForbidden)
 			return
 		}
 
 		if len(roles) != 0 && !slices.Contains(roles, claims.Role) {
-			c.AbortWithStatus(http.StatusForbidden)
+			zap.S().Debugf("Role %s is not allowed on route %s", claims.Role, route)
+			Abort(c, cerror.ErrForbidden)
 			return
 		}
 
 		c.Next()
 	}
 }
+
+// GetClaims returns claims stored in the context by Protect or Authorize
+func GetClaims(c *gin.Context) (*auth.Claims, error) {
+	value, ok := c.Get(ClaimsKey)
+	if !ok {
+		return nil, cerror.ErrMissingClaims
+	}
+
+	claims, ok := value.(*auth.Claims)
+	if !ok {
+		return nil, cerror.ErrMissingClaims
+	}
+
+	return claims, nil
+}
+
+// authenticate validates the bearer token and stores its claims in the context,
+// on failure the request is aborted and false is returned
+func authenticate(c *gin.Context) (*auth.Claims, bool) {
+	authHeader := c.GetHeader("Authorization")
+	if authHeader == "" {
+		Abort(c, cerror.ErrMissingToken)
+		return nil, false
+	}
+
+	token, claims, err := auth.ParseToken(authHeader)
+	if err != nil {
+		zap.S().Debugf("Auth failed with err = %+v", err)
+		Abort(c, cerror.ErrInvalidTokenFormat)
+		return nil, false
+	}
+
+	if !token.Valid {
+		Abort(c, cerror.ErrInvalidToken)
+		return nil, false
+	}
+
+	c.Set(ClaimsKey, claims)
+	return claims, true
+}
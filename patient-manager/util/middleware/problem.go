@@ -0,0 +1,97 @@
+package middleware
+
+import (
+	"PatientManager/config"
+	"PatientManager/util/fhir"
+	"PatientManager/util/problem"
+	"fmt"
+	"net/http"
+	"regexp"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+// RequestIDKey is the gin context key under which RequestID stores the request ID
+const RequestIDKey = "requestId"
+
+const requestIDHeader = "X-Request-ID"
+
+// request IDs sent by clients are only used if they are safe to log
+var requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// RequestID uses the X-Request-ID header of the request or generates a new
+// ID, the ID is returned in the X-Request-ID response header
+func RequestID() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(requestIDHeader)
+		if !requestIDPattern.MatchString(id) {
+			id = uuid.NewString()
+		}
+		c.Set(RequestIDKey, id)
+		c.Header(requestIDHeader, id)
+		c.Next()
+	}
+}
+
+// Problems responds with a problem (see problem.From) when a handler added
+// an error to the context without writing a response, it must be used before
+// the handlers. Details of server errors are hidden in production.
+func Problems() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Next()
+		if len(c.Errors) == 0 || c.Writer.Written() {
+			return
+		}
+
+		p := lastProblem(c)
+		c.Header("Content-Type", problem.ContentType)
+		c.JSON(p.Status, p)
+	}
+}
+
+// Outcomes works like Problems but responds with a FHIR OperationOutcome,
+// it's used by the FHIR API before Authorize
+func Outcomes() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Next()
+		if len(c.Errors) == 0 || c.Writer.Written() {
+			return
+		}
+
+		p := lastProblem(c)
+		c.Header("Content-Type", fhir.ContentType)
+		c.JSON(p.Status, fhir.OutcomeFrom(p))
+	}
+}
+
+// lastProblem maps the last error of the request, server errors are logged
+func lastProblem(c *gin.Context) problem.Problem {
+	err := c.Errors.Last().Err
+	p := problem.From(err, config.AppConfig.Env != config.Prod)
+	p.Instance = c.Request.URL.Path
+	p.RequestID = c.GetString(RequestIDKey)
+
+	if p.Status >= http.StatusInternalServerError {
+		zap.S().Errorf("Request %s %s failed, request id = %s, err = %+v", c.Request.Method, p.Instance, p.RequestID, err)
+	}
+	return p
+}
+
+// Recovery turns panics into internal server errors, it must be used after Problems
+func Recovery() gin.HandlerFunc {
+	return gin.CustomRecovery(func(c *gin.Context, recovered any) {
+		Abort(c, fmt.Errorf("panic: %v", recovered))
+	})
+}
+
+// Abort stops the request with err, the response is written by Problems,
+// use problem.WithStatus for errors that aren't mapped
+func Abort(c *gin.Context, err error) {
+	if err == nil {
+		err = problem.WithStatus(http.StatusInternalServerError, nil)
+	}
+	_ = c.Error(err)
+	c.Abort()
+}
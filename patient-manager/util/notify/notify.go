@@ -0,0 +1,67 @@
+// Package notify delivers messages such as password reset tokens to users
+package notify
+
+import (
+	"PatientManager/config"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Notifier sends a message to the user with the given email address
+type Notifier interface {
+	Notify(to, subject, body string) error
+}
+
+// NewNotifier returns the notifier selected by config.AppConfiguration.Notifier
+func NewNotifier() Notifier {
+	switch config.AppConfig.Notifier {
+	case config.NotifierFile:
+		return &FileNotifier{path: config.AppConfig.NotifierFile}
+
+	case config.NotifierLog:
+		return &LogNotifier{logger: zap.S()}
+
+	default:
+		zap.S().Warnf("Unknown notifier %s, messages will be logged", config.AppConfig.Notifier)
+		return &LogNotifier{logger: zap.S()}
+	}
+}
+
+// LogNotifier writes messages to the application log, meant for local use only
+type LogNotifier struct {
+	logger *zap.SugaredLogger
+}
+
+func (n *LogNotifier) Notify(to, subject, body string) error {
+	n.logger.Infof("Notification to = %s, subject = %s\n%s", to, subject, body)
+	return nil
+}
+
+// FileNotifier appends messages to a file, meant for local use only
+type FileNotifier struct {
+	path string
+	mu   sync.Mutex
+}
+
+func (n *FileNotifier) Notify(to, subject, body string) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if err := os.MkdirAll(filepath.Dir(n.path), 0o755); err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(n.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = fmt.Fprintf(file, "Date: %s\nTo: %s\nSubject: %s\n\n%s\n\n", time.Now().Format(time.RFC3339), to, subject, body)
+	return err
+}
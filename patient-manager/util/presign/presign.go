@@ -0,0 +1,77 @@
+// Package presign signs URLs of stored objects for storage drivers that
+// can't presign them, the URLs are served by this server at Path
+package presign
+
+import (
+	"PatientManager/config"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// Path is the route prefix of signed object URLs
+const Path = "/api/storage/objects/"
+
+// ErrInvalid is returned by Verify for URLs that weren't signed by the
+// signer, were signed for another method or have expired
+var ErrInvalid = errors.New("invalid or expired object URL")
+
+// Signer signs URLs with config.StorageConfig.PresignKey, so URLs signed by
+// one instance are verified by the others that share the key
+type Signer struct {
+	key []byte
+}
+
+// NewSigner returns the signer with the configured key, or with a key derived
+// from the refresh key when PresignKey isn't set
+func NewSigner() *Signer {
+	key := config.AppConfig.Storage.PresignKey
+	if key == "" {
+		key = "presign:" + config.AppConfig.RefreshKey
+	}
+	return newSigner(key)
+}
+
+func newSigner(key string) *Signer {
+	sum := sha256.Sum256([]byte(key))
+	return &Signer{key: sum[:]}
+}
+
+// URL returns the relative URL that allows method on the object until expires
+func (s *Signer) URL(method, name string, expires time.Time) string {
+	expiresAt := strconv.FormatInt(expires.Unix(), 10)
+	query := url.Values{
+		"expires":   {expiresAt},
+		"signature": {s.sign(method, name, expiresAt)},
+	}
+	return Path + url.PathEscape(name) + "?" + query.Encode()
+}
+
+// Verify checks the expires and signature query parameters of a request
+func (s *Signer) Verify(method, name string, query url.Values) error {
+	expiresAt := query.Get("expires")
+	expires, err := strconv.ParseInt(expiresAt, 10, 64)
+	if err != nil || time.Now().Unix() > expires {
+		return ErrInvalid
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(query.Get("signature"))
+	if err != nil {
+		return ErrInvalid
+	}
+	expected, _ := base64.RawURLEncoding.DecodeString(s.sign(method, name, expiresAt))
+	if !hmac.Equal(signature, expected) {
+		return ErrInvalid
+	}
+	return nil
+}
+
+func (s *Signer) sign(method, name, expiresAt string) string {
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write([]byte(method + "\n" + name + "\n" + expiresAt))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
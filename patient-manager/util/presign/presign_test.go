@@ -0,0 +1,106 @@
+package presign
+
+import (
+	"PatientManager/config"
+	"errors"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// query returns the path and query of a signed URL
+func query(t *testing.T, signed string) (string, url.Values) {
+	t.Helper()
+
+	u, err := url.Parse(signed)
+	if err != nil {
+		t.Fatalf("invalid URL %q: %v", signed, err)
+	}
+	return u.Path, u.Query()
+}
+
+func TestSigner(t *testing.T) {
+	signer := newSigner("key")
+	expires := time.Now().Add(time.Minute)
+	name := "checkups/a b/1.png"
+
+	path, valid := query(t, signer.URL("GET", name, expires))
+	if want := Path + "checkups/a b/1.png"; path != want {
+		t.Errorf("URL() path = %q, want %q", path, want)
+	}
+
+	with := func(key, value string) url.Values {
+		values := url.Values{}
+		for k, v := range valid {
+			values[k] = v
+		}
+		values.Set(key, value)
+		return values
+	}
+	_, expired := query(t, signer.URL("GET", name, time.Now().Add(-time.Second)))
+
+	tests := []struct {
+		name    string
+		signer  *Signer
+		method  string
+		object  string
+		query   url.Values
+		wantErr error
+	}{
+		{"valid", signer, "GET", name, valid, nil},
+		{"signer with the same key", newSigner("key"), "GET", name, valid, nil},
+		{"signer with another key", newSigner("other"), "GET", name, valid, ErrInvalid},
+		{"other method", signer, "PUT", name, valid, ErrInvalid},
+		{"other object", signer, "GET", "checkups/a b/2.png", valid, ErrInvalid},
+		{"expired", signer, "GET", name, expired, ErrInvalid},
+		{"extended expiry", signer, "GET", name, with("expires", "99999999999"), ErrInvalid},
+		{"expiry that isn't a number", signer, "GET", name, with("expires", "tomorrow"), ErrInvalid},
+		{"signature that isn't base64", signer, "GET", name, with("signature", "!!"), ErrInvalid},
+		{"other signature", signer, "GET", name, with("signature", strings.Repeat("A", 43)), ErrInvalid},
+		{"no signature", signer, "GET", name, with("signature", ""), ErrInvalid},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.signer.Verify(tt.method, tt.object, tt.query); !errors.Is(err, tt.wantErr) {
+				t.Errorf("Verify() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestNewSigner(t *testing.T) {
+	tests := []struct {
+		name       string
+		presignKey string
+		refreshKey string
+		// valid tells whether URLs of the signer with presign key "presign"
+		// and refresh key "refresh" are valid
+		valid bool
+	}{
+		{"same keys", "presign", "refresh", true},
+		{"other refresh key", "presign", "other", true},
+		{"other presign key", "other", "refresh", false},
+		{"no presign key", "", "refresh", false},
+	}
+
+	config.AppConfig = &config.AppConfiguration{RefreshKey: "refresh", Storage: config.StorageConfig{PresignKey: "presign"}}
+	_, signed := query(t, NewSigner().URL("GET", "object", time.Now().Add(time.Minute)))
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config.AppConfig = &config.AppConfiguration{RefreshKey: tt.refreshKey, Storage: config.StorageConfig{PresignKey: tt.presignKey}}
+			if err := NewSigner().Verify("GET", "object", signed); (err == nil) != tt.valid {
+				t.Errorf("Verify() error = %v, want valid %v", err, tt.valid)
+			}
+		})
+	}
+
+	// without a presign key instances with the same refresh key agree
+	config.AppConfig = &config.AppConfiguration{RefreshKey: "refresh"}
+	_, signed = query(t, NewSigner().URL("GET", "object", time.Now().Add(time.Minute)))
+	if err := NewSigner().Verify("GET", "object", signed); err != nil {
+		t.Errorf("Verify() with a key derived from the refresh key error = %v", err)
+	}
+}
@@ -0,0 +1,168 @@
+package problem
+
+import (
+	"PatientManager/util/cerror"
+	"PatientManager/util/validation"
+	"errors"
+	"net/http"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// ContentType of problem responses (RFC 7807)
+const ContentType = "application/problem+json"
+
+// Problem is an RFC 7807 problem details response. Code is a stable
+// identifier of the error that clients can rely on, RequestID matches the
+// X-Request-ID response header.
+type Problem struct {
+	Type      string                  `json:"type"`
+	Title     string                  `json:"title"`
+	Status    int                     `json:"status"`
+	Detail    string                  `json:"detail,omitempty"`
+	Instance  string                  `json:"instance,omitempty"`
+	Code      string                  `json:"code"`
+	RequestID string                  `json:"requestId,omitempty"`
+	Errors    []validation.FieldError `json:"errors,omitempty"`
+}
+
+// StatusError sets the response status of an error that isn't mapped, or
+// overrides the mapped one
+type StatusError struct {
+	Status int
+	Err    error
+}
+
+func (e *StatusError) Error() string {
+	if e.Err == nil {
+		return strings.ToLower(http.StatusText(e.Status))
+	}
+	return e.Err.Error()
+}
+
+func (e *StatusError) Unwrap() error {
+	return e.Err
+}
+
+// WithStatus returns err that is responded with status, err can be nil
+func WithStatus(status int, err error) error {
+	return &StatusError{Status: status, Err: err}
+}
+
+type mapping struct {
+	err    error
+	status int
+	code   string
+}
+
+// mappings are matched in order with errors.Is
+var mappings = []mapping{
+	{gorm.ErrRecordNotFound, http.StatusNotFound, "not_found"},
+	{gorm.ErrDuplicatedKey, http.StatusConflict, "conflict"},
+
+	{cerror.ErrBadDateFormat, http.StatusBadRequest, "bad_date_format"},
+	{cerror.ErrBadDateTimeFormat, http.StatusBadRequest, "bad_date_time_format"},
+	{cerror.ErrBadTimeFormat, http.StatusBadRequest, "bad_time_format"},
+	{cerror.ErrBadUuid, http.StatusBadRequest, "bad_uuid"},
+	{cerror.ErrUnknownRole, http.StatusBadRequest, "unknown_role"},
+	{cerror.ErrBadSort, http.StatusBadRequest, "bad_sort"},
+	{cerror.ErrBadCursor, http.StatusBadRequest, "bad_cursor"},
+	{cerror.ErrWeakPassword, http.StatusBadRequest, "weak_password"},
+	{cerror.ErrSamePassword, http.StatusBadRequest, "same_password"},
+	{cerror.ErrTotpNotEnrolled, http.StatusBadRequest, "totp_not_enrolled"},
+	{cerror.ErrInvalidDicom, http.StatusBadRequest, "invalid_dicom"},
+	{cerror.ErrDicomCheckupType, http.StatusBadRequest, "dicom_checkup_type"},
+
+	{cerror.ErrMissingToken, http.StatusUnauthorized, "missing_token"},
+	{cerror.ErrInvalidTokenFormat, http.StatusUnauthorized, "invalid_token_format"},
+	{cerror.ErrMissingClaims, http.StatusUnauthorized, "missing_claims"},
+	{cerror.ErrInvalidCredentials, http.StatusUnauthorized, "invalid_credentials"},
+	{cerror.ErrInvalidToken, http.StatusUnauthorized, "invalid_token"},
+	{cerror.ErrTokenReused, http.StatusUnauthorized, "token_reused"},
+	{cerror.ErrInvalidOtp, http.StatusUnauthorized, "invalid_otp"},
+
+	{cerror.ErrForbidden, http.StatusForbidden, "forbidden"},
+	{cerror.ErrBadRole, http.StatusForbidden, "role_not_allowed"},
+	{cerror.ErrPatientNotLinked, http.StatusForbidden, "patient_not_linked"},
+	{cerror.ErrMustChangePassword, http.StatusForbidden, "must_change_password"},
+	{cerror.ErrAuditImmutable, http.StatusForbidden, "audit_immutable"},
+	{cerror.ErrSameApprover, http.StatusForbidden, "same_approver"},
+	{cerror.ErrErasureImmutable, http.StatusForbidden, "erasure_immutable"},
+
+	{cerror.ErrPatientLinked, http.StatusConflict, "patient_linked"},
+	{cerror.ErrTotpEnabled, http.StatusConflict, "totp_enabled"},
+	{cerror.ErrDuplicateOIB, http.StatusConflict, "duplicate_oib"},
+	{cerror.ErrDuplicateEmail, http.StatusConflict, "duplicate_email"},
+	{cerror.ErrParentDeleted, http.StatusConflict, "parent_deleted"},
+	{cerror.ErrLegalHold, http.StatusConflict, "legal_hold"},
+	{cerror.ErrPatientErased, http.StatusConflict, "patient_erased"},
+	{cerror.ErrErasurePending, http.StatusConflict, "erasure_pending"},
+	{cerror.ErrErasureDecided, http.StatusConflict, "erasure_decided"},
+	{cerror.ErrUploadMissing, http.StatusConflict, "upload_missing"},
+
+	{cerror.ErrFileNotFound, http.StatusNotFound, "file_not_found"},
+	{cerror.ErrImageTooLarge, http.StatusRequestEntityTooLarge, "image_too_large"},
+	{cerror.ErrImageType, http.StatusUnsupportedMediaType, "unsupported_image_type"},
+	{cerror.ErrDicomPatientMismatch, http.StatusUnprocessableEntity, "dicom_patient_mismatch"},
+
+	{cerror.ErrTooManyAttempts, http.StatusTooManyRequests, "too_many_attempts"},
+}
+
+// statusCodes are codes of errors that only have a status
+var statusCodes = map[int]string{
+	http.StatusBadRequest:            "bad_request",
+	http.StatusUnauthorized:          "unauthorized",
+	http.StatusForbidden:             "forbidden",
+	http.StatusNotFound:              "not_found",
+	http.StatusMethodNotAllowed:      "method_not_allowed",
+	http.StatusConflict:              "conflict",
+	http.StatusRequestEntityTooLarge: "payload_too_large",
+	http.StatusUnsupportedMediaType:  "unsupported_media_type",
+	http.StatusUnprocessableEntity:   "unprocessable_entity",
+	http.StatusTooManyRequests:       "too_many_requests",
+	http.StatusBadGateway:            "bad_gateway",
+	http.StatusServiceUnavailable:    "service_unavailable",
+}
+
+// From maps err to a problem. Details of server errors are only included
+// when internal is true, they can contain internal details such as queries.
+func From(err error, internal bool) Problem {
+	p := Problem{Type: "about:blank", Status: http.StatusInternalServerError, Code: "internal_error"}
+	if err != nil {
+		p.Detail = err.Error()
+	}
+
+	var bindErr *validation.BindError
+	if errors.As(err, &bindErr) {
+		p.Status, p.Code, p.Errors = http.StatusBadRequest, "validation_failed", bindErr.Fields
+	} else {
+		for _, m := range mappings {
+			if errors.Is(err, m.err) {
+				p.Status, p.Code = m.status, m.code
+				break
+			}
+		}
+	}
+
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) && statusErr.Status != p.Status {
+		p.Status, p.Code = statusErr.Status, statusCode(statusErr.Status)
+	}
+
+	if p.Status >= http.StatusInternalServerError && !internal {
+		p.Detail = ""
+	}
+	p.Title = http.StatusText(p.Status)
+	return p
+}
+
+func statusCode(status int) string {
+	if code, ok := statusCodes[status]; ok {
+		return code
+	}
+	if status >= http.StatusInternalServerError {
+		return "internal_error"
+	}
+	return strings.ReplaceAll(strings.ToLower(http.StatusText(status)), " ", "_")
+}
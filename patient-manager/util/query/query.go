@@ -0,0 +1,214 @@
+package query
+
+import (
+	"PatientManager/util/cerror"
+	"encoding/base64"
+	"encoding/json"
+	"reflect"
+	"strings"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	DefaultPageSize = 20
+	MaxPageSize     = 100
+)
+
+// Request describes a page of a list, either by page number or by a cursor
+// returned with the previous page. Sort is a comma separated list of fields,
+// fields prefixed with "-" are sorted in descending order.
+type Request struct {
+	Page     int
+	PageSize int
+	Cursor   string
+	Sort     string
+}
+
+// Page describes the returned page, NextCursor is empty on the last page
+type Page struct {
+	Total      int64
+	Page       int
+	PageSize   int
+	NextCursor string
+}
+
+// Fields maps sortable field names used by the API to columns of the model
+type Fields map[string]string
+
+type order struct {
+	column string
+	desc   bool
+}
+
+type cursor struct {
+	Sort   string            `json:"s"`
+	Values []json.RawMessage `json:"v"`
+}
+
+// Find loads a page of rows matched by db into dest (pointer to a slice of
+// models) and counts all matched rows. Rows are sorted by req.Sort, or by
+// defaultSort when it's empty, and then by id so that the order is stable
+// and cursors can continue after the last row. Sortable columns must not be
+// null. Returns cerror.ErrBadSort or cerror.ErrBadCursor for invalid requests.
+func Find(db *gorm.DB, req Request, fields Fields, defaultSort string, dest any) (*Page, error) {
+	sort := req.Sort
+	if sort == "" {
+		sort = defaultSort
+	}
+	orders, err := parseSort(sort, fields)
+	if err != nil {
+		return nil, err
+	}
+
+	pageSize := req.PageSize
+	if pageSize <= 0 {
+		pageSize = DefaultPageSize
+	}
+	pageSize = min(pageSize, MaxPageSize)
+
+	page := &Page{PageSize: pageSize}
+	if err := db.Session(&gorm.Session{}).Count(&page.Total).Error; err != nil {
+		return nil, err
+	}
+
+	query := db.Session(&gorm.Session{})
+	for _, o := range orders {
+		query = query.Order(clause.OrderByColumn{Column: column(o.column), Desc: o.desc})
+	}
+
+	if req.Cursor != "" {
+		after, err := cursorCondition(db, req.Cursor, sort, orders, dest)
+		if err != nil {
+			return nil, err
+		}
+		query = query.Where(after)
+	} else {
+		page.Page = max(req.Page, 1)
+		query = query.Offset((page.Page - 1) * pageSize)
+	}
+
+	// one more row tells if there is a next page
+	tx := query.Limit(pageSize + 1).Find(dest)
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+
+	rows := reflect.ValueOf(dest).Elem()
+	if rows.Len() > pageSize {
+		rows.SetLen(pageSize)
+		page.NextCursor, err = encodeCursor(tx, sort, orders, rows.Index(pageSize-1))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return page, nil
+}
+
+func parseSort(sort string, fields Fields) ([]order, error) {
+	var orders []order
+	hasID := false
+	for _, field := range strings.Split(sort, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+
+		desc := strings.HasPrefix(field, "-")
+		name, ok := fields[strings.TrimPrefix(field, "-")]
+		if !ok {
+			return nil, cerror.ErrBadSort
+		}
+		hasID = hasID || name == "id"
+		orders = append(orders, order{column: name, desc: desc})
+	}
+
+	if !hasID {
+		orders = append(orders, order{column: "id"})
+	}
+	return orders, nil
+}
+
+func column(name string) clause.Column {
+	return clause.Column{Table: clause.CurrentTable, Name: name}
+}
+
+// cursorCondition returns the condition that selects rows sorted after the
+// row that the cursor was created from
+func cursorCondition(db *gorm.DB, encoded, sort string, orders []order, dest any) (clause.Expression, error) {
+	data, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, cerror.ErrBadCursor
+	}
+	var c cursor
+	if err := json.Unmarshal(data, &c); err != nil || c.Sort != sort || len(c.Values) != len(orders) {
+		return nil, cerror.ErrBadCursor
+	}
+
+	stmt := &gorm.Statement{DB: db}
+	if err := stmt.Parse(dest); err != nil {
+		return nil, err
+	}
+
+	values := make([]any, len(orders))
+	for i, o := range orders {
+		field := stmt.Schema.LookUpField(o.column)
+		if field == nil {
+			return nil, cerror.ErrBadCursor
+		}
+		value := reflect.New(field.FieldType)
+		if err := json.Unmarshal(c.Values[i], value.Interface()); err != nil {
+			return nil, cerror.ErrBadCursor
+		}
+		values[i] = value.Elem().Interface()
+	}
+
+	// (a, b) after (x, y) is a > x OR (a = x AND b > y), with < for descending columns
+	var alternatives []clause.Expression
+	for i, o := range orders {
+		var and []clause.Expression
+		for j := 0; j < i; j++ {
+			and = append(and, clause.Eq{Column: column(orders[j].column), Value: values[j]})
+		}
+		if o.desc {
+			and = append(and, clause.Lt{Column: column(o.column), Value: values[i]})
+		} else {
+			and = append(and, clause.Gt{Column: column(o.column), Value: values[i]})
+		}
+		alternatives = append(alternatives, clause.And(and...))
+	}
+	return clause.Or(alternatives...), nil
+}
+
+func encodeCursor(tx *gorm.DB, sort string, orders []order, row reflect.Value) (string, error) {
+	row = reflect.Indirect(row)
+	c := cursor{Sort: sort}
+	for _, o := range orders {
+		field := tx.Statement.Schema.LookUpField(o.column)
+		if field == nil {
+			return "", cerror.ErrBadSort
+		}
+		value, _ := field.ValueOf(tx.Statement.Context, row)
+		data, err := json.Marshal(value)
+		if err != nil {
+			return "", err
+		}
+		c.Values = append(c.Values, data)
+	}
+
+	data, err := json.Marshal(c)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+// LikePrefix returns a LIKE pattern matching values that start with value,
+// use it with ESCAPE '\'
+func LikePrefix(value string) string {
+	return likeEscaper.Replace(value) + "%"
+}
@@ -0,0 +1,189 @@
+package query
+
+import (
+	"PatientManager/internal/testdb"
+	"PatientManager/util/cerror"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type item struct {
+	ID    uint
+	Name  string
+	Score int
+	Date  time.Time
+}
+
+var fields = Fields{"name": "name", "score": "score", "date": "date", "id": "id"}
+
+// newItemsDb returns a database of the test with items 1 to 7, scores repeat
+// so that the id decides the order of equal scores
+func newItemsDb(t *testing.T) *gorm.DB {
+	t.Helper()
+
+	db := testdb.Open(t, &item{})
+
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 1; i <= 7; i++ {
+		row := item{Name: fmt.Sprintf("item %c", 'a'+7-i), Score: i % 3, Date: start.AddDate(0, 0, i)}
+		if err := db.Create(&row).Error; err != nil {
+			t.Fatalf("failed to create item: %v", err)
+		}
+	}
+	return db
+}
+
+func ids(items []item) []uint {
+	var ids []uint
+	for _, item := range items {
+		ids = append(ids, item.ID)
+	}
+	return ids
+}
+
+func TestParseSort(t *testing.T) {
+	tests := []struct {
+		sort    string
+		want    []order
+		wantErr error
+	}{
+		{"", []order{{column: "id"}}, nil},
+		{"name", []order{{column: "name"}, {column: "id"}}, nil},
+		{"-score, name", []order{{column: "score", desc: true}, {column: "name"}, {column: "id"}}, nil},
+		{"-id", []order{{column: "id", desc: true}}, nil},
+		{"score,,", []order{{column: "score"}, {column: "id"}}, nil},
+		{"password", nil, cerror.ErrBadSort},
+		{"--name", nil, cerror.ErrBadSort},
+	}
+
+	for _, tt := range tests {
+		got, err := parseSort(tt.sort, fields)
+		if !errors.Is(err, tt.wantErr) || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseSort(%q) = %v, %v, want %v, %v", tt.sort, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestFind(t *testing.T) {
+	db := newItemsDb(t)
+
+	tests := []struct {
+		name     string
+		req      Request
+		want     []uint
+		wantPage int
+		wantNext bool
+	}{
+		{"default sort", Request{PageSize: 3}, []uint{7, 6, 5}, 1, true},
+		{"second page", Request{Page: 2, PageSize: 3}, []uint{4, 3, 2}, 2, true},
+		{"last page", Request{Page: 3, PageSize: 3}, []uint{1}, 3, false},
+		{"page after the last", Request{Page: 4, PageSize: 3}, nil, 4, false},
+		{"sort ascending", Request{PageSize: 4, Sort: "date"}, []uint{1, 2, 3, 4}, 1, true},
+		{"equal values sorted by id", Request{PageSize: 7, Sort: "-score"}, []uint{2, 5, 1, 4, 7, 3, 6}, 1, false},
+		{"default page size", Request{}, []uint{7, 6, 5, 4, 3, 2, 1}, 1, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var items []item
+			page, err := Find(db.Model(&item{}), tt.req, fields, "name", &items)
+			if err != nil {
+				t.Fatalf("Find() error = %v", err)
+			}
+			if !reflect.DeepEqual(ids(items), tt.want) {
+				t.Errorf("Find() = %v, want %v", ids(items), tt.want)
+			}
+			if page.Total != 7 || page.Page != tt.wantPage || (page.NextCursor != "") != tt.wantNext {
+				t.Errorf("Find() page = %+v, want page %d, next cursor %v", page, tt.wantPage, tt.wantNext)
+			}
+		})
+	}
+}
+
+func TestFindCursor(t *testing.T) {
+	db := newItemsDb(t)
+
+	tests := []struct {
+		sort string
+		want []uint
+	}{
+		{"name", []uint{7, 6, 5, 4, 3, 2, 1}},
+		{"-score", []uint{2, 5, 1, 4, 7, 3, 6}},
+		{"score,-date", []uint{6, 3, 7, 4, 1, 5, 2}},
+		{"-date", []uint{7, 6, 5, 4, 3, 2, 1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.sort, func(t *testing.T) {
+			var got []uint
+			req := Request{PageSize: 2, Sort: tt.sort}
+			for {
+				var items []item
+				page, err := Find(db.Model(&item{}), req, fields, "", &items)
+				if err != nil {
+					t.Fatalf("Find() error = %v", err)
+				}
+				got = append(got, ids(items)...)
+				if page.NextCursor == "" {
+					break
+				}
+				req.Cursor = page.NextCursor
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("pages = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFindBadRequest(t *testing.T) {
+	db := newItemsDb(t)
+
+	var items []item
+	page, err := Find(db.Model(&item{}), Request{PageSize: 2, Sort: "name"}, fields, "", &items)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		req     Request
+		wantErr error
+	}{
+		{"unknown sort field", Request{Sort: "secret"}, cerror.ErrBadSort},
+		{"cursor of another sort", Request{Sort: "-name", Cursor: page.NextCursor}, cerror.ErrBadCursor},
+		{"cursor that isn't base64", Request{Sort: "name", Cursor: "!!"}, cerror.ErrBadCursor},
+		{"cursor that isn't JSON", Request{Sort: "name", Cursor: "bm90IGpzb24"}, cerror.ErrBadCursor},
+	}
+
+	for _, tt := range tests {
+		var items []item
+		if _, err := Find(db.Model(&item{}), tt.req, fields, "", &items); !errors.Is(err, tt.wantErr) {
+			t.Errorf("%s: Find() error = %v, want %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestLikePrefix(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{"ana", "ana%"},
+		{"50%", `50\%%`},
+		{"a_b", `a\_b%`},
+		{`c:\`, `c:\\%`},
+		{"", "%"},
+	}
+
+	for _, tt := range tests {
+		if got := LikePrefix(tt.value); got != tt.want {
+			t.Errorf("LikePrefix(%q) = %q, want %q", tt.value, got, tt.want)
+		}
+	}
+}
@@ -3,40 +3,40 @@ package seed
 import (
 	"PatientManager/app"
 	"PatientManager/model"
+	"PatientManager/service"
+	"bytes"
+	_ "embed"
 
-	"github.com/google/uuid"
 	"go.uber.org/zap"
 	"gorm.io/gorm"
 )
 
+// medicationCatalog is imported when no medication has an ATC code, it also
+// completes medications seeded by name only
+//
+//go:embed medications.csv
+var medicationCatalog []byte
+
 func seedMedications() error {
 	var err error
-	app.Invoke(func(db *gorm.DB, logger *zap.SugaredLogger) {
-		medications := []string{
-			"Aspirin", "Paracetamol", "Ibuprofen", "Amoxicillin", "Lisinopril",
-			"Atorvastatin", "Metformin", "Simvastatin", "Omeprazole", "Amlodipine",
-			"Metoprolol", "Acetaminophen", "Hydrochlorothiazide", "Sertraline",
-			"Citalopram", "Zolpidem", "Furosemide", "Alprazolam", "Escitalopram",
-		}
-
+	app.Invoke(func(db *gorm.DB, logger *zap.SugaredLogger, medicationService service.IMedicationService) {
 		var count int64
-		db.Model(&model.Medication{}).Count(&count)
+		if err = db.Model(&model.Medication{}).Where("atc_code IS NOT NULL AND atc_code <> ''").Count(&count).Error; err != nil {
+			return
+		}
 		if count > 0 {
 			logger.Infoln("Medications already seeded. Skipping.")
 			return
 		}
 
 		logger.Infoln("Seeding medications...")
-		for _, name := range medications {
-			medication := model.Medication{
-				Uuid: uuid.New(),
-				Name: name,
-			}
-			if creationErr := db.Create(&medication).Error; creationErr != nil {
-				logger.Errorf("Failed to seed medication %s: %v", name, creationErr)
-				err = creationErr
-				return
-			}
+		entries, readErr := service.ReadMedicationCatalog(bytes.NewReader(medicationCatalog), service.CatalogCSV)
+		if readErr != nil {
+			err = readErr
+			return
+		}
+		if _, err = medicationService.Import(entries); err != nil {
+			return
 		}
 
 		logger.Info("Medication seeding completed successfully.")
@@ -25,7 +25,8 @@ func Insert() {
 }
 
 // CreateSuperAdmin creates a SuperAdmin user if one doesn't already exist.
-// It reads the password from the SUPERADMIN_PASSWORD environment variable.
+// It reads the password from the SUPERADMIN_PASSWORD environment variable,
+// the password must satisfy the configured password policy.
 // The function will panic if required environment variables are missing or
 // if user creation fails, as this is critical for application bootstrap.
 func createSuperAdmin() error {
@@ -52,9 +53,6 @@ func createSuperAdmin() error {
 	if password == "" {
 		return errors.New("env variable is empty")
 	}
-	if len(password) < 8 {
-		return errors.New("password must be at least 8 characters long")
-	}
 
 	dto := dto.NewUserDto{
 		FirstName: "Super",
@@ -0,0 +1,240 @@
+package validation
+
+import (
+	"PatientManager/model"
+	"PatientManager/util/format"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+)
+
+const oibLength = 11
+
+// atcPattern matches ATC codes of any level, from the anatomical main group
+// (N) to the chemical substance (N02BE01)
+var atcPattern = regexp.MustCompile(`^[A-Z]([0-9]{2}([A-Z]([A-Z]([0-9]{2})?)?)?)?$`)
+
+// FieldError describes why a single request field is invalid, Field is the
+// JSON (or query) name of the field
+type FieldError struct {
+	Field   string `json:"field"`
+	Tag     string `json:"tag"`
+	Message string `json:"message"`
+}
+
+// BindError is a request that failed to bind or validate, Fields lists
+// the invalid fields when they are known
+type BindError struct {
+	Detail string
+	Fields []FieldError
+	Err    error
+}
+
+func (e *BindError) Error() string {
+	return e.Detail
+}
+
+func (e *BindError) Unwrap() error {
+	return e.Err
+}
+
+// Register adds custom validation tags to gin's validator, it must be
+// called before the server handles requests:
+//   - oib: 11 digits with a valid ISO 7064 MOD 11,10 check digit
+//   - gender: M or F, case insensitive
+//   - pastdate: a date that is not in the future, strings must be in
+//     format.DateFormat or RFC 3339
+//   - checkuptype: one of model.CheckupTypes
+//   - atc: a WHO ATC code of any level, case insensitive
+func Register() error {
+	v, ok := binding.Validator.Engine().(*validator.Validate)
+	if !ok {
+		return errors.New("unexpected gin validator engine")
+	}
+
+	// report fields by the names clients send
+	v.RegisterTagNameFunc(func(field reflect.StructField) string {
+		for _, tag := range []string{"json", "form", "uri"} {
+			name := strings.SplitN(field.Tag.Get(tag), ",", 2)[0]
+			if name == "-" {
+				return ""
+			}
+			if name != "" {
+				return name
+			}
+		}
+		return field.Name
+	})
+
+	validations := map[string]validator.Func{
+		"oib":         validateOIB,
+		"gender":      validateGender,
+		"pastdate":    validatePastDate,
+		"checkuptype": validateCheckupType,
+		"atc":         validateATC,
+	}
+	for tag, fn := range validations {
+		if err := v.RegisterValidation(tag, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ValidOIB checks the length and the ISO 7064 MOD 11,10 check digit of an OIB
+func ValidOIB(oib string) bool {
+	if len(oib) != oibLength {
+		return false
+	}
+
+	a := 10
+	for i := 0; i < oibLength; i++ {
+		digit := int(oib[i] - '0')
+		if digit < 0 || digit > 9 {
+			return false
+		}
+		if i == oibLength-1 {
+			check := 11 - a
+			if check == 10 {
+				check = 0
+			}
+			return digit == check
+		}
+
+		a = (a + digit) % 10
+		if a == 0 {
+			a = 10
+		}
+		a = (a * 2) % 11
+	}
+	return false
+}
+
+func validateOIB(fl validator.FieldLevel) bool {
+	return ValidOIB(fl.Field().String())
+}
+
+func validateGender(fl validator.FieldLevel) bool {
+	switch strings.ToUpper(fl.Field().String()) {
+	case "M", "F":
+		return true
+	}
+	return false
+}
+
+func validatePastDate(fl validator.FieldLevel) bool {
+	field := fl.Field()
+	if t, ok := field.Interface().(time.Time); ok {
+		return !t.After(time.Now())
+	}
+	if field.Kind() != reflect.String {
+		return false
+	}
+
+	value := field.String()
+	t, err := time.Parse(format.DateFormat, value)
+	if err != nil {
+		if t, err = time.Parse(time.RFC3339, value); err != nil {
+			return false
+		}
+	}
+	return !t.After(time.Now())
+}
+
+func validateCheckupType(fl validator.FieldLevel) bool {
+	return model.CheckupType(fl.Field().String()).Valid()
+}
+
+func validateATC(fl validator.FieldLevel) bool {
+	return atcPattern.MatchString(strings.ToUpper(strings.TrimSpace(fl.Field().String())))
+}
+
+// NewBindError describes a bind error returned by gin, validation errors
+// are reported per field
+func NewBindError(err error) *BindError {
+	var validationErrors validator.ValidationErrors
+	if errors.As(err, &validationErrors) {
+		bindErr := &BindError{Detail: "validation failed", Err: err}
+		for _, fe := range validationErrors {
+			bindErr.Fields = append(bindErr.Fields, FieldError{
+				Field:   fieldName(fe),
+				Tag:     fe.Tag(),
+				Message: message(fe),
+			})
+		}
+		return bindErr
+	}
+
+	var typeError *json.UnmarshalTypeError
+	if errors.As(err, &typeError) {
+		return &BindError{
+			Detail: "validation failed",
+			Fields: []FieldError{{
+				Field:   typeError.Field,
+				Tag:     "type",
+				Message: fmt.Sprintf("must be a %s", typeError.Type),
+			}},
+			Err: err,
+		}
+	}
+
+	var parseError *time.ParseError
+	if errors.As(err, &parseError) {
+		return &BindError{Detail: fmt.Sprintf("invalid time %q", parseError.Value), Err: err}
+	}
+
+	return &BindError{Detail: "invalid request body", Err: err}
+}
+
+// fieldName returns the path of the field without the name of the top level struct
+func fieldName(fe validator.FieldError) string {
+	namespace := fe.Namespace()
+	if i := strings.Index(namespace, "."); i >= 0 {
+		return namespace[i+1:]
+	}
+	return fe.Field()
+}
+
+func message(fe validator.FieldError) string {
+	switch fe.Tag() {
+	case "required", "required_if":
+		return "is required"
+	case "oib":
+		return "must be a valid OIB"
+	case "gender":
+		return "must be M or F"
+	case "pastdate":
+		return "must be a valid date that is not in the future"
+	case "checkuptype":
+		return fmt.Sprintf("must be one of %s", strings.Join(model.CheckupTypeNames(), ", "))
+	case "atc":
+		return "must be an ATC code, e.g. N02BE01"
+	case "email":
+		return "must be a valid email address"
+	case "uuid":
+		return "must be a valid UUID"
+	case "datetime":
+		return fmt.Sprintf("must be a date in format %s", fe.Param())
+	case "eq":
+		return fmt.Sprintf("must be %s", fe.Param())
+	case "oneof":
+		return fmt.Sprintf("must be one of %s", strings.ReplaceAll(fe.Param(), " ", ", "))
+	case "len":
+		return fmt.Sprintf("must have length %s", fe.Param())
+	case "min":
+		return fmt.Sprintf("must be at least %s", fe.Param())
+	case "max":
+		return fmt.Sprintf("must be at most %s", fe.Param())
+	case "gtefield":
+		// the param is a Go field name, json names of dto fields are in lower camel case
+		return fmt.Sprintf("must not be before %s", strings.ToLower(fe.Param()[:1])+fe.Param()[1:])
+	}
+	return fmt.Sprintf("failed the %s check", fe.Tag())
+}
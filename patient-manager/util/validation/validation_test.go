@@ -0,0 +1,78 @@
+package validation
+
+import (
+	"testing"
+
+	"github.com/gin-gonic/gin/binding"
+)
+
+func TestValidOIB(t *testing.T) {
+	tests := []struct {
+		oib  string
+		want bool
+	}{
+		{"12345678903", true},
+		{"98765432106", true},
+		{"69435151530", true},
+		{"94577403194", true},
+		// the check digit is 0 when 11 - a is 10
+		{"00000000001", true},
+		{"12345678901", false},
+		{"12345678900", false},
+		{"21345678903", false},
+		{"1234567890", false},
+		{"123456789031", false},
+		{"", false},
+		{"1234567890A", false},
+		{"12345 78903", false},
+		{"-2345678903", false},
+		{"١٢٣٤٥٦٧٨٩٠٣", false},
+	}
+
+	for _, tt := range tests {
+		if got := ValidOIB(tt.oib); got != tt.want {
+			t.Errorf("ValidOIB(%q) = %v, want %v", tt.oib, got, tt.want)
+		}
+	}
+}
+
+func TestRegister(t *testing.T) {
+	if err := Register(); err != nil {
+		t.Fatal(err)
+	}
+
+	type request struct {
+		OIB    string `json:"oib" binding:"oib"`
+		Gender string `json:"gender" binding:"gender"`
+		ATC    string `json:"atc" binding:"omitempty,atc"`
+	}
+
+	tests := []struct {
+		name    string
+		request request
+		fields  []string
+	}{
+		{"valid", request{OIB: "12345678903", Gender: "f", ATC: "n02be01"}, nil},
+		{"invalid check digit", request{OIB: "12345678901", Gender: "M"}, []string{"oib"}},
+		{"invalid fields", request{OIB: "1234", Gender: "X", ATC: "N2"}, []string{"oib", "gender", "atc"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var fields []string
+			if err := binding.Validator.ValidateStruct(tt.request); err != nil {
+				for _, field := range NewBindError(err).Fields {
+					fields = append(fields, field.Field)
+				}
+			}
+			if len(fields) != len(tt.fields) {
+				t.Fatalf("invalid fields = %v, want %v", fields, tt.fields)
+			}
+			for i := range fields {
+				if fields[i] != tt.fields[i] {
+					t.Errorf("invalid fields = %v, want %v", fields, tt.fields)
+				}
+			}
+		})
+	}
+}